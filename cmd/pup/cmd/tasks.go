@@ -0,0 +1,19 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+)
+
+var tasksCmd = &cobra.Command{
+	Use:   "tasks",
+	Short: "Inspect tasks on a controller",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return cmd.Help()
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(tasksCmd)
+
+	tasksCmd.PersistentFlags().String("remote", "http://localhost:1234/", "controller to connect to")
+}
@@ -0,0 +1,113 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/pupload/pupload/internal/cli/tasks"
+
+	"github.com/spf13/cobra"
+)
+
+var tasksDLQCmd = &cobra.Command{
+	Use:   "dlq",
+	Short: "List tasks in the dead-letter queue",
+	Long: `List tasks that exhausted their retries or could not be decoded.
+
+Use the requeue, delete and purge subcommands to act on them.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		remote, err := cmd.Flags().GetString("remote")
+		if err != nil {
+			return err
+		}
+
+		letters, err := tasks.ListDeadLetters(remote)
+		if err != nil {
+			return err
+		}
+
+		if len(letters) == 0 {
+			fmt.Println("Dead-letter queue is empty")
+			return nil
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "QUEUE\tID\tTYPE\tATTEMPTS\tFAILED\tPAYLOAD\tERROR")
+		for _, l := range letters {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\t%s\t%s\n",
+				l.Queue, l.ID, l.Type, l.Attempts, l.FailedAt.Format("2006-01-02 15:04:05"), l.Summary, l.Error)
+		}
+
+		return w.Flush()
+	},
+}
+
+var tasksDLQRequeueCmd = &cobra.Command{
+	Use:   "requeue <queue> <id>",
+	Short: "Move a dead-lettered task back onto its queue",
+	Args:  cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		remote, err := cmd.Flags().GetString("remote")
+		if err != nil {
+			return err
+		}
+
+		if err := tasks.RequeueDeadLetter(remote, args[0], args[1]); err != nil {
+			return err
+		}
+
+		green.Print("✓ ")
+		fmt.Printf("requeued %s on %s\n", args[1], args[0])
+		return nil
+	},
+}
+
+var tasksDLQDeleteCmd = &cobra.Command{
+	Use:   "delete <queue> <id>",
+	Short: "Delete a single dead-lettered task",
+	Args:  cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		remote, err := cmd.Flags().GetString("remote")
+		if err != nil {
+			return err
+		}
+
+		if err := tasks.DeleteDeadLetter(remote, args[0], args[1]); err != nil {
+			return err
+		}
+
+		green.Print("✓ ")
+		fmt.Printf("deleted %s from %s\n", args[1], args[0])
+		return nil
+	},
+}
+
+var tasksDLQPurgeCmd = &cobra.Command{
+	Use:   "purge",
+	Short: "Delete every dead-lettered task",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		remote, err := cmd.Flags().GetString("remote")
+		if err != nil {
+			return err
+		}
+
+		n, err := tasks.PurgeDeadLetters(remote)
+		if err != nil {
+			return err
+		}
+
+		green.Print("✓ ")
+		fmt.Printf("purged %d tasks\n", n)
+		return nil
+	},
+}
+
+func init() {
+	tasksCmd.AddCommand(tasksDLQCmd)
+	tasksDLQCmd.AddCommand(tasksDLQRequeueCmd)
+	tasksDLQCmd.AddCommand(tasksDLQDeleteCmd)
+	tasksDLQCmd.AddCommand(tasksDLQPurgeCmd)
+}
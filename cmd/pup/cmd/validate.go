@@ -30,6 +30,11 @@ Otherwise, all flows in the project will be validated.`,
 			return fmt.Errorf("failed to load node definitions: %w", err)
 		}
 
+		tiers, err := project.GetTiers(root)
+		if err != nil {
+			return fmt.Errorf("failed to load tiers from pup.yaml: %w", err)
+		}
+
 		var flows []models.Flow
 
 		if len(args) > 0 {
@@ -55,7 +60,7 @@ Otherwise, all flows in the project will be validated.`,
 		hasErrors := false
 
 		for _, flow := range flows {
-			result := validation.Validate(flow, nodeDefs)
+			result := validation.Validate(flow, nodeDefs, tiers)
 			printValidationResult(flow.Name, result)
 
 			if result.HasError() {
@@ -11,6 +11,7 @@ import (
 	"path/filepath"
 
 	"github.com/pupload/pupload/internal/models"
+	"github.com/pupload/pupload/internal/resources"
 
 	"sigs.k8s.io/yaml"
 )
@@ -27,6 +28,8 @@ type ProjectFile struct {
 	Controllers  []ControllerDef     `yaml:"Controllers"`
 	GlobalStores []models.StoreInput `yaml:"GlobalStores"`
 
+	Tiers map[string]resources.ResourceDefinition `yaml:"Tiers,omitempty"`
+
 	Extra map[string]any `yaml:",inline"`
 }
 
@@ -228,6 +231,17 @@ func GetProjectFile(path string) (*ProjectFile, error) {
 	return &project, nil
 }
 
+// GetTiers returns the tiers available to the project: the standard set plus
+// any defined in pup.yaml.
+func GetTiers(projectRoot string) (resources.TierSet, error) {
+	project, err := GetProjectFile(projectRoot)
+	if err != nil {
+		return nil, err
+	}
+
+	return resources.Tiers(project.Tiers)
+}
+
 func InitProject(path string, projectName string) error {
 
 	file := filepath.Join(path, "pup.yaml")
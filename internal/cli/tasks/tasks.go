@@ -0,0 +1,128 @@
+package tasks
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+
+	"github.com/pupload/pupload/internal/syncplane"
+)
+
+// ListDeadLetters returns every dead letter, reading the controller's
+// listing a page at a time.
+func ListDeadLetters(controllerAddress string) ([]syncplane.DeadLetter, error) {
+	u, err := url.JoinPath(controllerAddress, "api", "v1", "tasks", "dlq")
+	if err != nil {
+		return nil, err
+	}
+
+	letters := make([]syncplane.DeadLetter, 0)
+	cursor := ""
+	for {
+		page, next, err := listDeadLetterPage(u, cursor)
+		if err != nil {
+			return nil, err
+		}
+
+		letters = append(letters, page...)
+		if next == "" {
+			return letters, nil
+		}
+		cursor = next
+	}
+}
+
+func listDeadLetterPage(u, cursor string) ([]syncplane.DeadLetter, string, error) {
+	resp, err := http.Get(u + "?" + url.Values{"cursor": {cursor}}.Encode())
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, "", fmt.Errorf("ListDeadLetters: controller returned %d: %s", resp.StatusCode, string(body))
+	}
+
+	var page struct {
+		Letters []syncplane.DeadLetter `json:"letters"`
+		Cursor  string                 `json:"cursor"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&page); err != nil {
+		return nil, "", err
+	}
+
+	return page.Letters, page.Cursor, nil
+}
+
+func RequeueDeadLetter(controllerAddress, queue, id string) error {
+	u, err := url.JoinPath(controllerAddress, "api", "v1", "tasks", "dlq", queue, id, "requeue")
+	if err != nil {
+		return err
+	}
+
+	return do(http.MethodPost, u)
+}
+
+func DeleteDeadLetter(controllerAddress, queue, id string) error {
+	u, err := url.JoinPath(controllerAddress, "api", "v1", "tasks", "dlq", queue, id)
+	if err != nil {
+		return err
+	}
+
+	return do(http.MethodDelete, u)
+}
+
+func PurgeDeadLetters(controllerAddress string) (int, error) {
+	u, err := url.JoinPath(controllerAddress, "api", "v1", "tasks", "dlq")
+	if err != nil {
+		return 0, err
+	}
+
+	req, err := http.NewRequest(http.MethodDelete, u, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return 0, fmt.Errorf("PurgeDeadLetters: controller returned %d: %s", resp.StatusCode, string(body))
+	}
+
+	var out struct {
+		Purged int `json:"purged"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return 0, err
+	}
+
+	return out.Purged, nil
+}
+
+func do(method, u string) error {
+	req, err := http.NewRequest(method, u, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("controller returned %d: %s", resp.StatusCode, string(body))
+	}
+
+	return nil
+}
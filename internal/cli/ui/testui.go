@@ -28,6 +28,7 @@ type Node struct {
 	Name     string
 	Pipeline string
 	Status   string // e.g. "IDLE", "RUNNING"
+	Progress string // latest progress the node reported, if running
 }
 
 type section int
@@ -148,11 +149,37 @@ func buildNodesFromFlowRun(fr models.FlowRun) []Node {
 			Name:     name,
 			Pipeline: "", // fill if you have pipeline info
 			Status:   string(n.Status),
+			Progress: formatProgress(n),
 		})
 	}
 	return nodes
 }
 
+// formatProgress summarises what a running node last reported, e.g.
+// "42% encode: pass 1 of 2".
+func formatProgress(n models.NodeState) string {
+	p := n.Progress
+	if p == nil || (n.Status != models.NODERUN_RUNNING && n.Status != models.NODERUN_RETRYING) {
+		return ""
+	}
+
+	parts := make([]string, 0, 2)
+	if p.Percent >= 0 {
+		parts = append(parts, fmt.Sprintf("%.0f%%", p.Percent))
+	}
+
+	switch {
+	case p.Stage != "" && p.Message != "":
+		parts = append(parts, p.Stage+": "+p.Message)
+	case p.Stage != "":
+		parts = append(parts, p.Stage)
+	case p.Message != "":
+		parts = append(parts, p.Message)
+	}
+
+	return strings.Join(parts, " ")
+}
+
 func (m model) Init() tea.Cmd {
 	// start polling if we have an ID (alt screen handles clearing)
 	if m.flowRun.ID == "" {
@@ -912,6 +939,9 @@ func (m model) View() string {
 		left := fmt.Sprintf("  %s %s (%s)", arrow, n.Name, n.Pipeline)
 		statusPlain := n.Status
 		right := statusPlain
+		if n.Progress != "" {
+			right = n.Progress + "  " + statusPlain
+		}
 
 		leftW := rw.StringWidth(left)
 		rightW := rw.StringWidth(right)
@@ -14,6 +14,7 @@ func HandleAPIRoutes(f *flows.FlowService) http.Handler {
 
 	r.Mount("/flow", handleFlowRoutes(f))
 	r.Mount("/upload", handleUploadRoutes())
+	r.Mount("/tasks", handleTaskRoutes(f))
 
 	return r
 
@@ -0,0 +1,118 @@
+package v1
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	flows "github.com/pupload/pupload/internal/controller/flows/service"
+	"github.com/pupload/pupload/internal/logging"
+	"github.com/pupload/pupload/internal/models"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+)
+
+// logPollInterval is how often a followed log checks for new output.
+const logPollInterval = 500 * time.Millisecond
+
+type nodeLogsResponse struct {
+	Records []models.LogRecord `json:"records"`
+	Cursor  string             `json:"cursor"`
+}
+
+// NodeLogsPath is where HandleNodeLogs is served. Followed logs last as long
+// as their node runs, so it is mounted apart from the API's request timeout.
+const NodeLogsPath = "/api/v1/runs/{runID}/nodes/{nodeID}/logs"
+
+// NodeLogSource is what HandleNodeLogs reads from, a FlowService outside
+// tests.
+type NodeLogSource interface {
+	NodeDone(runID, nodeID string) (bool, error)
+	NodeLogs(ctx context.Context, runID, nodeID, cursor string) ([]models.LogRecord, string, error)
+}
+
+// HandleNodeLogs returns a node's output after the cursor query parameter.
+// With follow=true it streams records as newline-delimited JSON until the node
+// is done or the client goes away.
+func HandleNodeLogs(f NodeLogSource) http.HandlerFunc {
+
+	log := logging.ForService("api")
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		runID := chi.URLParam(r, "runID")
+		nodeID := chi.URLParam(r, "nodeID")
+		cursor := r.URL.Query().Get("cursor")
+
+		if _, err := f.NodeDone(runID, nodeID); errors.Is(err, flows.ErrNodeNotFound) {
+			http.Error(w, fmt.Sprintf("run %s has no node %s", runID, nodeID), http.StatusNotFound)
+			return
+		}
+
+		if r.URL.Query().Get("follow") != "true" {
+			records, next, err := f.NodeLogs(r.Context(), runID, nodeID, cursor)
+			if err != nil {
+				log.Error("unable to read node logs", "run_id", runID, "node_id", nodeID, "err", err)
+				http.Error(w, fmt.Sprintf("unable to read node logs: %s", err), http.StatusInternalServerError)
+				return
+			}
+
+			render.JSON(w, r, nodeLogsResponse{Records: records, Cursor: next})
+			return
+		}
+
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+
+		enc := json.NewEncoder(w)
+		ticker := time.NewTicker(logPollInterval)
+		defer ticker.Stop()
+
+		for {
+			// Checked before reading, so the last read sees everything the
+			// node shipped before it finished.
+			done, _ := f.NodeDone(runID, nodeID)
+
+			for {
+				records, next, err := f.NodeLogs(r.Context(), runID, nodeID, cursor)
+				if err != nil {
+					log.Error("unable to read node logs", "run_id", runID, "node_id", nodeID, "err", err)
+					return
+				}
+
+				for _, rec := range records {
+					if err := enc.Encode(rec); err != nil {
+						return
+					}
+				}
+
+				cursor = next
+				if len(records) == 0 {
+					break
+				}
+			}
+
+			flusher.Flush()
+
+			if done {
+				return
+			}
+
+			select {
+			case <-r.Context().Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}
+}
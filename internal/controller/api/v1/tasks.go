@@ -0,0 +1,91 @@
+package v1
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	flows "github.com/pupload/pupload/internal/controller/flows/service"
+	"github.com/pupload/pupload/internal/logging"
+	"github.com/pupload/pupload/internal/syncplane"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+)
+
+// maxDeadLetterPage caps the limit of a dead letter listing.
+const maxDeadLetterPage = 1000
+
+type deadLettersResponse struct {
+	Letters []syncplane.DeadLetter `json:"letters"`
+	Cursor  string                 `json:"cursor"`
+}
+
+func handleTaskRoutes(f *flows.FlowService) http.Handler {
+
+	log := logging.ForService("api")
+
+	r := chi.NewRouter()
+
+	// Returns up to limit dead letters from the cursor query parameter, and
+	// the cursor of the next page, empty after the last.
+	r.Get("/dlq", func(w http.ResponseWriter, r *http.Request) {
+		limit := syncplane.DeadLetterPageSize
+		if raw := r.URL.Query().Get("limit"); raw != "" {
+			n, err := strconv.Atoi(raw)
+			if err != nil || n < 1 {
+				http.Error(w, fmt.Sprintf("invalid limit %q", raw), http.StatusBadRequest)
+				return
+			}
+			limit = min(n, maxDeadLetterPage)
+		}
+
+		letters, cursor, err := f.DeadLetters(r.Context(), r.URL.Query().Get("cursor"), limit)
+		if err != nil {
+			log.Error("unable to list dead letters", "err", err)
+			http.Error(w, fmt.Sprintf("unable to list dead letters: %s", err), http.StatusInternalServerError)
+			return
+		}
+
+		render.JSON(w, r, deadLettersResponse{Letters: letters, Cursor: cursor})
+	})
+
+	r.Delete("/dlq", func(w http.ResponseWriter, r *http.Request) {
+		n, err := f.PurgeDeadLetters(r.Context())
+		if err != nil {
+			log.Error("unable to purge dead letters", "err", err)
+			http.Error(w, fmt.Sprintf("unable to purge dead letters: %s", err), http.StatusInternalServerError)
+			return
+		}
+
+		render.JSON(w, r, map[string]int{"purged": n})
+	})
+
+	r.Post("/dlq/{queue}/{taskID}/requeue", func(w http.ResponseWriter, r *http.Request) {
+		queue := chi.URLParam(r, "queue")
+		taskID := chi.URLParam(r, "taskID")
+
+		if err := f.RequeueDeadLetter(r.Context(), queue, taskID); err != nil {
+			log.Error("unable to requeue dead letter", "queue", queue, "id", taskID, "err", err)
+			http.Error(w, fmt.Sprintf("unable to requeue task: %s", err), http.StatusBadRequest)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	r.Delete("/dlq/{queue}/{taskID}", func(w http.ResponseWriter, r *http.Request) {
+		queue := chi.URLParam(r, "queue")
+		taskID := chi.URLParam(r, "taskID")
+
+		if err := f.DeleteDeadLetter(r.Context(), queue, taskID); err != nil {
+			log.Error("unable to delete dead letter", "queue", queue, "id", taskID, "err", err)
+			http.Error(w, fmt.Sprintf("unable to delete task: %s", err), http.StatusBadRequest)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	return r
+}
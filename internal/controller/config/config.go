@@ -5,6 +5,10 @@ import (
 	"os"
 
 	"github.com/pupload/pupload/internal/controller/flows/repo"
+	"github.com/pupload/pupload/internal/imagepolicy"
+	"github.com/pupload/pupload/internal/resources"
+	"github.com/pupload/pupload/internal/secrets"
+	"github.com/pupload/pupload/internal/stores/hosted"
 	"github.com/pupload/pupload/internal/syncplane"
 	"github.com/pupload/pupload/internal/telemetry"
 )
@@ -16,6 +20,14 @@ type ControllerSettings struct {
 	ProjectRepo repo.ProjectRepoSettings
 	RuntimeRepo repo.RuntimeRepoSettings
 
+	ImagePolicy imagepolicy.Settings // checked at validation time when set
+
+	Secrets secrets.Settings // resolves ${secret:name} in store params
+
+	HostedStores hosted.Settings // where workers reach filesystem and memory stores
+
+	Tiers map[string]resources.ResourceDefinition // custom tiers shared with workers through the sync plane
+
 	Storage struct {
 		DataPath string
 	}
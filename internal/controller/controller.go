@@ -2,6 +2,7 @@ package controller
 
 import (
 	"context"
+	"fmt"
 	"io"
 	"log"
 	"log/slog"
@@ -12,6 +13,7 @@ import (
 	flow "github.com/pupload/pupload/internal/controller/flows/service"
 	controllerserver "github.com/pupload/pupload/internal/controller/server"
 	"github.com/pupload/pupload/internal/logging"
+	"github.com/pupload/pupload/internal/stores/hosted"
 	"github.com/pupload/pupload/internal/syncplane"
 	"github.com/pupload/pupload/internal/telemetry"
 )
@@ -39,6 +41,10 @@ func RunWithConfig(ctx context.Context, cfg *config.ControllerSettings) error {
 	}
 	defer s.Close()
 
+	if err := configureHostedStores(ctx, s, cfg.HostedStores); err != nil {
+		return err
+	}
+
 	// Services
 
 	f, err := flow.CreateFlowService(cfg, s)
@@ -94,6 +100,10 @@ func RunWithConfigSilent(ctx context.Context, cfg *config.ControllerSettings) er
 	}
 	defer s.Close()
 
+	if err := configureHostedStores(ctx, s, cfg.HostedStores); err != nil {
+		return err
+	}
+
 	// Services
 
 	f, err := flow.CreateFlowService(cfg, s)
@@ -131,3 +141,18 @@ func Run() error {
 	ctx := context.Background()
 	return RunWithConfig(ctx, cfg)
 }
+
+// configureHostedStores signs hosted store URLs with the configured key, or
+// with one shared through the sync plane, so URLs signed by one controller
+// verify on the others and survive restarts.
+func configureHostedStores(ctx context.Context, s syncplane.SyncLayer, cfg hosted.Settings) error {
+	if cfg.SigningKey == "" {
+		key, err := s.SharedKey(ctx, "hosted-stores")
+		if err != nil {
+			return fmt.Errorf("unable to load hosted store signing key: %w", err)
+		}
+		cfg.SigningKey = string(key)
+	}
+
+	return hosted.Configure(cfg)
+}
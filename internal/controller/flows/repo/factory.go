@@ -5,8 +5,7 @@ import (
 
 	"github.com/pupload/pupload/internal/controller/flows/repo/project"
 	runtime_repo "github.com/pupload/pupload/internal/controller/flows/repo/runtime"
-
-	"github.com/redis/go-redis/v9"
+	"github.com/pupload/pupload/internal/redisconn"
 )
 
 type ProjectRepoType string
@@ -51,20 +50,15 @@ type RuntimeRepoSettings struct {
 	Redis RedisSettings
 }
 
-type RedisSettings struct {
-	Address  string
-	Password string
-	DB       int
-}
+type RedisSettings = redisconn.Settings
 
 func CreateRuntimeRepo(cfg RuntimeRepoSettings) (RuntimeRepo, error) {
 	switch cfg.Type {
 	case RedisRuntimeRepo:
-		rdb := redis.NewClient(&redis.Options{
-			Addr:     cfg.Redis.Address,
-			Password: cfg.Redis.Password,
-			DB:       cfg.Redis.DB,
-		})
+		rdb, err := redisconn.NewClient(cfg.Redis)
+		if err != nil {
+			return nil, fmt.Errorf("unable to create redis client: %w", err)
+		}
 
 		return runtime_repo.CreateRedisRuntimeRepo(rdb), nil
 	}
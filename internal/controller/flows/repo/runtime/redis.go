@@ -5,6 +5,8 @@ import (
 	"context"
 	"encoding/gob"
 	"fmt"
+	"strings"
+	"sync"
 
 	"github.com/pupload/pupload/internal/controller/flows/runtime"
 
@@ -12,10 +14,10 @@ import (
 )
 
 type RedisRuntimeRepo struct {
-	client *redis.Client
+	client redis.UniversalClient
 }
 
-func CreateRedisRuntimeRepo(client *redis.Client) *RedisRuntimeRepo {
+func CreateRedisRuntimeRepo(client redis.UniversalClient) *RedisRuntimeRepo {
 	return &RedisRuntimeRepo{
 		client: client,
 	}
@@ -66,15 +68,29 @@ func (r *RedisRuntimeRepo) Close(ctx context.Context) error {
 func (r *RedisRuntimeRepo) ListRuntimeIDs() ([]string, error) {
 
 	list := make([]string, 0)
+	var mu sync.Mutex
 
-	keys, _, err := r.client.Scan(context.TODO(), 0, "flowrun:*", 1000).Result()
-	if err != nil {
-		return nil, err
+	scan := func(ctx context.Context, client redis.UniversalClient) error {
+		iter := client.Scan(ctx, 0, "flowrun:*", 1000).Iterator()
+		for iter.Next(ctx) {
+			mu.Lock()
+			list = append(list, strings.TrimPrefix(iter.Val(), "flowrun:"))
+			mu.Unlock()
+		}
+
+		return iter.Err()
 	}
 
-	for _, key := range keys {
-		id := key[8:]
-		list = append(list, id)
+	// keys are spread across shards in cluster mode, so every primary is scanned
+	if cluster, ok := r.client.(*redis.ClusterClient); ok {
+		err := cluster.ForEachMaster(context.TODO(), func(ctx context.Context, c *redis.Client) error {
+			return scan(ctx, c)
+		})
+		return list, err
+	}
+
+	if err := scan(context.TODO(), r.client); err != nil {
+		return nil, err
 	}
 
 	return list, nil
@@ -2,17 +2,50 @@ package runtime
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"time"
 
 	"github.com/pupload/pupload/internal/models"
 	"github.com/pupload/pupload/internal/syncplane"
 	"github.com/pupload/pupload/internal/telemetry"
+
+	"github.com/google/uuid"
 )
 
-func (rt *RuntimeFlow) handleExecuteNode(ctx context.Context, nodeID string, s syncplane.SyncLayer) error {
+// outputParts is how many parts are presigned for each output's multipart
+// upload. Workers size parts to fit, so with S3's 5GiB part limit this allows
+// outputs up to 80GiB.
+const outputParts = 16
+
+func (rt *RuntimeFlow) handleExecuteNode(ctx context.Context, nodeID string, s syncplane.SyncLayer) (err error) {
 	node := rt.nodes[nodeID]
+
+	// The node is moved to running before its task is queued, so no worker
+	// runs a node the state machine refused, and events from the execution
+	// find it recorded. Failing before the task is queued puts it back.
+	prev := rt.FlowRun.NodeState[nodeID]
+	waiting := len(rt.FlowRun.WaitingURLs)
+	executionID := uuid.NewString()
+
+	if err := rt.setNodeState(nodeID, models.NodeState{
+		Status:      models.NODERUN_RUNNING,
+		Logs:        prev.Logs,
+		MaxAttempts: node.NodeDef.MaxAttempts,
+		ExecutionID: executionID,
+	}); err != nil {
+		return err
+	}
+
+	defer func() {
+		if err != nil {
+			rt.FlowRun.NodeState[nodeID] = prev
+			rt.FlowRun.WaitingURLs = rt.FlowRun.WaitingURLs[:waiting]
+		}
+	}()
+
 	inputs := make(map[string]string)
+	inputInfo := make(map[string]models.ArtifactInfo)
 
 	for _, edge := range node.Inputs {
 		artifact := rt.FlowRun.Artifacts[edge.Edge]
@@ -25,9 +58,14 @@ func (rt *RuntimeFlow) handleExecuteNode(ctx context.Context, nodeID string, s s
 		}
 
 		inputs[edge.Name] = url.String()
+
+		if artifact.SHA256 != "" {
+			inputInfo[edge.Name] = artifact.ArtifactInfo
+		}
 	}
 
 	outputs := make(map[string]string)
+	multipart := make(map[string]models.MultipartUpload)
 	for _, edge := range node.Outputs {
 
 		artifact, err := rt.makeOutputArtifact(edge)
@@ -48,21 +86,84 @@ func (rt *RuntimeFlow) handleExecuteNode(ctx context.Context, nodeID string, s s
 		}
 
 		outputs[edge.Name] = url.String()
+
+		if mps, ok := store.(models.MultipartStore); ok {
+			mp, err := mps.PresignMultipart(context.TODO(), artifact.ObjectName, outputParts, 15*time.Minute)
+			if err != nil {
+				rt.log.Error("could not presign multipart upload", "err", err)
+				return err
+			}
+
+			multipart[edge.Name] = *mp
+		}
+
 		WaitingURL := models.WaitingURL{
 			Artifact: *artifact,
 			PutURL:   url.String(),
 			TTL:      time.Now().Add(15 * time.Minute),
+			NodeID:   nodeID,
 		}
 
 		rt.FlowRun.WaitingURLs = append(rt.FlowRun.WaitingURLs, WaitingURL)
 	}
 
-	err := node.executeNode(ctx, s, rt.FlowRun.ID, inputs, outputs)
+	moduleURL, err := rt.moduleURL(node.NodeDef)
 	if err != nil {
-		return nil
+		return err
 	}
 
-	return nil
+	resolved, err := rt.resolveFlags(*node.Node)
+	if err != nil {
+		return err
+	}
+	node.Node = &resolved
+
+	return node.executeNode(ctx, s, rt.FlowRun.ID, executionID, inputs, inputInfo, outputs, multipart, moduleURL)
+}
+
+// resolveFlags fills the node's flags in with the results of the nodes they
+// refer to, which are complete by the time it runs.
+func (rt *RuntimeFlow) resolveFlags(node models.Node) (models.Node, error) {
+	lookup := func(ref models.ResultRef) (json.RawMessage, bool) {
+		raw, ok := rt.FlowRun.NodeState[ref.Node].Results[ref.Name]
+		return raw, ok
+	}
+
+	flags := make([]models.NodeFlag, len(node.Flags))
+	for i, f := range node.Flags {
+		value, err := models.ExpandResults(f.Value, lookup)
+		if err != nil {
+			return node, fmt.Errorf("flag %s: %w", f.Name, err)
+		}
+
+		flags[i] = models.NodeFlag{Name: f.Name, Value: value}
+	}
+
+	node.Flags = flags
+	return node, nil
+}
+
+// moduleURL presigns the wasi module of a NodeDef that keeps it in one of the
+// flow's stores. Modules on the worker's disk need no URL.
+func (rt *RuntimeFlow) moduleURL(def models.NodeDef) (string, error) {
+	storeName, key, ok := def.ModuleStore()
+	if !ok {
+		return "", nil
+	}
+
+	store, ok := rt.stores[storeName]
+	if !ok {
+		rt.log.Error("unable to acquire module store", "store_name", storeName)
+		return "", fmt.Errorf("unable to acquire store %s for module %s", storeName, def.Module)
+	}
+
+	url, err := store.GetURL(context.TODO(), key, 1*time.Hour)
+	if err != nil {
+		rt.log.Error("unable to generate module get url", "err", err)
+		return "", err
+	}
+
+	return url.String(), nil
 }
 
 func (rt *RuntimeFlow) makeOutputArtifact(edge models.NodeEdge) (*models.Artifact, error) {
@@ -94,55 +195,139 @@ func (rt *RuntimeFlow) makeOutputArtifact(edge models.NodeEdge) (*models.Artifac
 
 }
 
-func (rt *RuntimeFlow) HandleNodeFinished(nodeID string, logs []models.LogRecord) error {
-	_, ok := rt.nodes[nodeID]
+// HandleNodeFinished marks the node complete and records what the worker
+// reported about its outputs, and its results. Events from stale or already
+// applied attempts are rejected with ErrStaleAttempt.
+func (rt *RuntimeFlow) HandleNodeFinished(nodeID string, attempt NodeAttempt, logs []models.LogRecord, outputs map[string]models.ArtifactInfo, results map[string]json.RawMessage) error {
+	node, ok := rt.nodes[nodeID]
 	if !ok {
 		return fmt.Errorf("HandleNodeFinished: node does not exist")
 	}
 
 	curr_state := rt.FlowRun.NodeState[nodeID]
-	new_logs := append(curr_state.Logs, logs...)
-	rt.FlowRun.NodeState[nodeID] = models.NodeState{Status: models.NODERUN_COMPLETE, Logs: new_logs}
+	if err := checkAttempt(curr_state, attempt); err != nil {
+		return fmt.Errorf("HandleNodeFinished: %w", err)
+	}
+
+	new_state := curr_state
+	new_state.Status = models.NODERUN_COMPLETE
+	new_state.Logs = append(curr_state.Logs, logs...)
+	new_state.Error = ""
+	new_state.Attempt = attempt.Attempt
+	new_state.AttemptID = attempt.AttemptID
+	new_state.Results = results
+	new_state.ImageDigest = attempt.ImageDigest
+
+	if err := rt.setNodeState(nodeID, new_state); err != nil {
+		return fmt.Errorf("HandleNodeFinished: %w", err)
+	}
+
+	for _, edge := range node.Outputs {
+		info, ok := outputs[edge.Name]
+		if !ok {
+			continue
+		}
+
+		for i, w := range rt.FlowRun.WaitingURLs {
+			if w.NodeID == nodeID && w.Artifact.EdgeName == edge.Edge {
+				rt.FlowRun.WaitingURLs[i].Artifact.ArtifactInfo = info
+			}
+		}
+	}
 
 	return nil
 }
 
-func (rt *RuntimeFlow) HandleNodeFailed(nodeID string, logs []models.LogRecord, err string, attempt, maxAttempt int, isFinal bool) error {
+// HandleNodeFailed records a failed attempt, moving the run to ERROR once the
+// node has no attempts left.
+func (rt *RuntimeFlow) HandleNodeFailed(nodeID string, attempt NodeAttempt, logs []models.LogRecord, err string, maxAttempt int, isFinal bool) error {
 	_, ok := rt.nodes[nodeID]
 	if !ok {
 		return fmt.Errorf("HandleNodeFailed: node does not exist")
 	}
 
 	curr_state := rt.FlowRun.NodeState[nodeID]
-	new_logs := append(curr_state.Logs, logs...)
+	if err := checkAttempt(curr_state, attempt); err != nil {
+		return fmt.Errorf("HandleNodeFailed: %w", err)
+	}
 
 	status := models.NODERUN_RETRYING
 	if isFinal {
-		rt.FlowRun.Status = models.FLOWRUN_ERROR
 		status = models.NODERUN_ERROR
 	}
 
-	rt.FlowRun.NodeState[nodeID] = models.NodeState{
+	new_state := models.NodeState{
 		Status:      status,
-		Logs:        new_logs,
+		Logs:        append(curr_state.Logs, logs...),
 		Error:       err,
-		Attempt:     attempt,
+		Attempt:     attempt.Attempt,
 		MaxAttempts: maxAttempt,
+		ExecutionID: curr_state.ExecutionID,
+		AttemptID:   attempt.AttemptID,
+		ImageDigest: attempt.ImageDigest,
+	}
+
+	if err := rt.setNodeState(nodeID, new_state); err != nil {
+		return fmt.Errorf("HandleNodeFailed: %w", err)
+	}
+
+	if isFinal {
+		if err := rt.setFlowStatus(models.FLOWRUN_ERROR); err != nil {
+			return fmt.Errorf("HandleNodeFailed: %w", err)
+		}
 	}
 
 	return nil
 
 }
 
-func (rn *RuntimeNode) executeNode(ctx context.Context, s syncplane.SyncLayer, runID string, input, output map[string]string) error {
+// HandleNodeProgress records the latest progress a running attempt reported.
+// Reports from other attempts, or older than the one recorded, are rejected
+// with ErrStaleAttempt.
+func (rt *RuntimeFlow) HandleNodeProgress(nodeID string, attempt NodeAttempt, progress models.NodeProgress) error {
+	if _, ok := rt.nodes[nodeID]; !ok {
+		return fmt.Errorf("HandleNodeProgress: node does not exist")
+	}
+
+	state := rt.FlowRun.NodeState[nodeID]
+
+	switch {
+	case state.Status != models.NODERUN_RUNNING && state.Status != models.NODERUN_RETRYING:
+		return fmt.Errorf("HandleNodeProgress: node is %s: %w", state.Status, ErrIllegalTransition)
+	case attempt.ExecutionID != state.ExecutionID:
+		return fmt.Errorf("HandleNodeProgress: execution %s is not current (%s): %w", attempt.ExecutionID, state.ExecutionID, ErrStaleAttempt)
+	case attempt.Attempt < state.Attempt,
+		// A retrying node's recorded attempt is the one that failed.
+		state.Status == models.NODERUN_RETRYING && attempt.Attempt == state.Attempt:
+		return fmt.Errorf("HandleNodeProgress: attempt %d is not running: %w", attempt.Attempt, ErrStaleAttempt)
+	}
+
+	if p := state.Progress; p != nil && (p.Attempt > attempt.Attempt || p.Attempt == attempt.Attempt && p.Time.After(progress.Time)) {
+		return fmt.Errorf("HandleNodeProgress: report is older than the recorded one: %w", ErrStaleAttempt)
+	}
+
+	progress.Attempt = attempt.Attempt
+	state.Progress = &progress
+	rt.FlowRun.NodeState[nodeID] = state
+
+	return nil
+}
+
+func (rn *RuntimeNode) executeNode(ctx context.Context, s syncplane.SyncLayer, runID, executionID string, input map[string]string, inputInfo map[string]models.ArtifactInfo, output map[string]string, multipart map[string]models.MultipartUpload, moduleURL string) error {
 	payload := syncplane.NodeExecutePayload{
 		RunID:      runID,
 		Node:       *rn.Node,
 		NodeDef:    rn.NodeDef,
 		InputURLs:  input,
 		OutputURLs: output,
+		ModuleURL:  moduleURL,
+		InputInfo:  inputInfo,
 
-		MaxAttempts: rn.NodeDef.MaxAttempts,
+		OutputMultipart: multipart,
+
+		MaxAttempts:  rn.NodeDef.MaxAttempts,
+		ExecutionID:  executionID,
+		DispatchedAt: time.Now(),
 
 		TraceParent: telemetry.InjectContext(ctx),
 	}
@@ -165,5 +350,13 @@ func (rt *RuntimeFlow) shouldNodeReady(nodeID string) {
 		}
 	}
 
-	rt.FlowRun.NodeState[nodeID] = models.NodeState{Status: models.NODERUN_READY, Logs: rt.FlowRun.NodeState[nodeID].Logs}
+	for _, ref := range node.ResultRefs() {
+		if rt.FlowRun.NodeState[ref.Node].Status != models.NODERUN_COMPLETE {
+			return
+		}
+	}
+
+	if err := rt.setNodeState(nodeID, models.NodeState{Status: models.NODERUN_READY, Logs: rt.FlowRun.NodeState[nodeID].Logs}); err != nil {
+		rt.log.Error("unable to ready node", "node_id", nodeID, "err", err)
+	}
 }
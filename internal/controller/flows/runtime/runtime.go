@@ -9,6 +9,7 @@ import (
 
 	"github.com/pupload/pupload/internal/logging"
 	"github.com/pupload/pupload/internal/models"
+	"github.com/pupload/pupload/internal/secrets"
 	"github.com/pupload/pupload/internal/stores"
 	"github.com/pupload/pupload/internal/syncplane"
 	"github.com/pupload/pupload/internal/telemetry"
@@ -21,8 +22,9 @@ type RuntimeFlow struct {
 	FlowRun  models.FlowRun
 	NodeDefs []models.NodeDef
 
-	nodes  map[string]RuntimeNode
-	stores map[string]models.Store
+	nodes   map[string]RuntimeNode
+	stores  map[string]models.Store
+	secrets *secrets.Resolver // only used to build stores, never persisted
 
 	log *slog.Logger
 
@@ -34,15 +36,16 @@ type RuntimeNode struct {
 	NodeDef models.NodeDef
 }
 
-func CreateRuntimeFlow(ctx context.Context, flow models.Flow, nodeDefs []models.NodeDef) (RuntimeFlow, error) {
+func CreateRuntimeFlow(ctx context.Context, flow models.Flow, nodeDefs []models.NodeDef, resolver *secrets.Resolver) (RuntimeFlow, error) {
 	// Unmarshal Stores
 
 	runtimeFlow := RuntimeFlow{
 		Flow:     flow,
 		NodeDefs: nodeDefs,
 
-		stores: make(map[string]models.Store),
-		nodes:  make(map[string]RuntimeNode),
+		stores:  make(map[string]models.Store),
+		nodes:   make(map[string]RuntimeNode),
+		secrets: resolver,
 
 		TraceParent: telemetry.InjectContext(ctx),
 	}
@@ -63,10 +66,11 @@ func CreateRuntimeFlow(ctx context.Context, flow models.Flow, nodeDefs []models.
 	return runtimeFlow, nil
 }
 
-func (rt *RuntimeFlow) RebuildRuntimeFlow() {
+func (rt *RuntimeFlow) RebuildRuntimeFlow(resolver *secrets.Resolver) {
 
 	rt.nodes = make(map[string]RuntimeNode)
 	rt.stores = make(map[string]models.Store)
+	rt.secrets = resolver
 
 	rt.constructLogger()
 	rt.constructStores()
@@ -119,7 +123,14 @@ func (rt *RuntimeFlow) constructRuntimeNode() error {
 
 func (rt *RuntimeFlow) constructStores() {
 	for _, storeInput := range rt.Flow.Stores {
-		store, err := stores.UnmarshalStore(storeInput)
+		// Secrets are resolved into a copy; the flow keeps the references.
+		params, err := rt.secrets.ExpandJSON(context.TODO(), storeInput.Params)
+		if err != nil {
+			rt.log.Warn("Unable to resolve store secrets", "flow", rt.Flow.Name, "store", storeInput.Name, "error", err.Error())
+			continue
+		}
+
+		store, err := stores.UnmarshalStore(models.StoreInput{Name: storeInput.Name, Type: storeInput.Type, Params: params})
 		if err != nil {
 			rt.log.Warn("Invalid store definition", "flow", rt.Flow.Name, "store", storeInput.Name, "error", err.Error())
 			continue
@@ -155,7 +166,7 @@ func (rt *RuntimeFlow) initialDatawellInput() error {
 
 		if err != nil {
 			rt.log.Error("error processing datawells", "err", err)
-			rt.FlowRun.Status = models.FLOWRUN_ERROR
+			rt.setFlowStatus(models.FLOWRUN_ERROR)
 			return err
 		}
 	}
@@ -248,7 +259,9 @@ func (rt *RuntimeFlow) Start(s syncplane.SyncLayer) error {
 	switch rt.FlowRun.Status {
 
 	case models.FLOWRUN_STOPPED:
-		rt.FlowRun.Status = models.FLOWRUN_WAITING
+		if err := rt.setFlowStatus(models.FLOWRUN_WAITING); err != nil {
+			return err
+		}
 
 	case models.FLOWRUN_COMPLETE:
 		return fmt.Errorf("runtime already complete")
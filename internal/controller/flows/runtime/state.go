@@ -0,0 +1,91 @@
+package runtime
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/pupload/pupload/internal/models"
+)
+
+var (
+	// ErrIllegalTransition is returned when an event would move a node or run
+	// into a state it can't reach from its current one.
+	ErrIllegalTransition = errors.New("illegal state transition")
+
+	// ErrStaleAttempt is returned for finished/failed events that belong to an
+	// attempt other than the node's current one, including duplicates.
+	ErrStaleAttempt = errors.New("stale attempt")
+)
+
+var flowTransitions = map[models.FlowRunStatus][]models.FlowRunStatus{
+	models.FLOWRUN_STOPPED:  {models.FLOWRUN_WAITING, models.FLOWRUN_ERROR},
+	models.FLOWRUN_WAITING:  {models.FLOWRUN_RUNNING, models.FLOWRUN_COMPLETE, models.FLOWRUN_ERROR},
+	models.FLOWRUN_RUNNING:  {models.FLOWRUN_WAITING, models.FLOWRUN_COMPLETE, models.FLOWRUN_ERROR},
+	models.FLOWRUN_COMPLETE: {},
+	models.FLOWRUN_ERROR:    {},
+}
+
+var nodeTransitions = map[models.NodeRunStatus][]models.NodeRunStatus{
+	models.NODERUN_IDLE:     {models.NODERUN_READY},
+	models.NODERUN_READY:    {models.NODERUN_RUNNING},
+	models.NODERUN_RUNNING:  {models.NODERUN_COMPLETE, models.NODERUN_RETRYING, models.NODERUN_ERROR},
+	models.NODERUN_RETRYING: {models.NODERUN_COMPLETE, models.NODERUN_RETRYING, models.NODERUN_ERROR},
+	models.NODERUN_COMPLETE: {},
+	models.NODERUN_ERROR:    {},
+}
+
+// NodeAttempt identifies the execution attempt a finished or failed event was
+// produced by.
+type NodeAttempt struct {
+	ExecutionID string
+	AttemptID   string
+	Attempt     int
+
+	ImageDigest string // what the attempt ran, empty for non-container nodes
+}
+
+// setFlowStatus moves the run to status. Setting the current status is a no-op.
+func (rt *RuntimeFlow) setFlowStatus(status models.FlowRunStatus) error {
+	from := rt.FlowRun.Status
+	if from == status {
+		return nil
+	}
+
+	if !slices.Contains(flowTransitions[from], status) {
+		return fmt.Errorf("flow run %s -> %s: %w", from, status, ErrIllegalTransition)
+	}
+
+	rt.FlowRun.Status = status
+	return nil
+}
+
+// setNodeState validates the status change and stores state for the node.
+func (rt *RuntimeFlow) setNodeState(nodeID string, state models.NodeState) error {
+	from := rt.FlowRun.NodeState[nodeID].Status
+
+	if !slices.Contains(nodeTransitions[from], state.Status) {
+		return fmt.Errorf("node %s %s -> %s: %w", nodeID, from, state.Status, ErrIllegalTransition)
+	}
+
+	rt.FlowRun.NodeState[nodeID] = state
+	return nil
+}
+
+// checkAttempt rejects events from previous executions, earlier attempts and
+// attempts that have already been applied.
+func checkAttempt(state models.NodeState, a NodeAttempt) error {
+	if a.ExecutionID != state.ExecutionID {
+		return fmt.Errorf("execution %s is not current (%s): %w", a.ExecutionID, state.ExecutionID, ErrStaleAttempt)
+	}
+
+	if a.Attempt < state.Attempt {
+		return fmt.Errorf("attempt %d is older than %d: %w", a.Attempt, state.Attempt, ErrStaleAttempt)
+	}
+
+	if a.AttemptID != "" && a.AttemptID == state.AttemptID {
+		return fmt.Errorf("attempt %s already applied: %w", a.AttemptID, ErrStaleAttempt)
+	}
+
+	return nil
+}
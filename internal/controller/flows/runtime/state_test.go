@@ -0,0 +1,284 @@
+package runtime
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/pupload/pupload/internal/logging"
+	"github.com/pupload/pupload/internal/models"
+	"github.com/pupload/pupload/internal/syncplane"
+)
+
+// helper to build a run with a single node dispatched as execution "exec-1"
+func newRunningFlow(t *testing.T) *RuntimeFlow {
+	t.Helper()
+
+	rt := &RuntimeFlow{
+		FlowRun: models.FlowRun{
+			Status: models.FLOWRUN_WAITING,
+			NodeState: map[string]models.NodeState{
+				"node-1": {Status: models.NODERUN_RUNNING, ExecutionID: "exec-1", MaxAttempts: 3},
+			},
+		},
+		nodes: map[string]RuntimeNode{"node-1": {Node: &models.Node{ID: "node-1"}}},
+		log:   logging.ForService("test"),
+	}
+
+	return rt
+}
+
+func attempt(n int) NodeAttempt {
+	return NodeAttempt{ExecutionID: "exec-1", AttemptID: fmt.Sprintf("exec-1/%d", n), Attempt: n}
+}
+
+func TestHandleNodeFinished_RejectsDuplicate(t *testing.T) {
+	rt := newRunningFlow(t)
+
+	if err := rt.HandleNodeFinished("node-1", attempt(1), []models.LogRecord{{Msg: "done"}}, nil, nil); err != nil {
+		t.Fatalf("HandleNodeFinished() error = %v", err)
+	}
+
+	err := rt.HandleNodeFinished("node-1", attempt(1), []models.LogRecord{{Msg: "done"}}, nil, nil)
+	if !errors.Is(err, ErrStaleAttempt) {
+		t.Fatalf("expected ErrStaleAttempt, got %v", err)
+	}
+
+	if n := len(rt.FlowRun.NodeState["node-1"].Logs); n != 1 {
+		t.Fatalf("expected logs to be appended once, got %d records", n)
+	}
+}
+
+func TestHandleNodeProgress(t *testing.T) {
+	rt := newRunningFlow(t)
+	now := time.Now()
+
+	if err := rt.HandleNodeProgress("node-1", attempt(1), models.NodeProgress{Percent: 40, Time: now}); err != nil {
+		t.Fatalf("HandleNodeProgress() error = %v", err)
+	}
+
+	// delivered out of order
+	err := rt.HandleNodeProgress("node-1", attempt(1), models.NodeProgress{Percent: 20, Time: now.Add(-time.Second)})
+	if !errors.Is(err, ErrStaleAttempt) {
+		t.Fatalf("expected ErrStaleAttempt, got %v", err)
+	}
+
+	if p := rt.FlowRun.NodeState["node-1"].Progress; p == nil || p.Percent != 40 || p.Attempt != 1 {
+		t.Fatalf("expected progress 40%% from attempt 1, got %+v", p)
+	}
+
+	if err := rt.HandleNodeFailed("node-1", attempt(1), nil, "boom", 3, false); err != nil {
+		t.Fatalf("HandleNodeFailed() error = %v", err)
+	}
+
+	// a late report from the failed attempt
+	err = rt.HandleNodeProgress("node-1", attempt(1), models.NodeProgress{Percent: 60, Time: now.Add(time.Second)})
+	if !errors.Is(err, ErrStaleAttempt) {
+		t.Fatalf("expected ErrStaleAttempt, got %v", err)
+	}
+
+	if err := rt.HandleNodeProgress("node-1", attempt(2), models.NodeProgress{Percent: 10, Time: now}); err != nil {
+		t.Fatalf("HandleNodeProgress() error = %v", err)
+	}
+
+	if err := rt.HandleNodeFinished("node-1", attempt(2), nil, nil, nil); err != nil {
+		t.Fatalf("HandleNodeFinished() error = %v", err)
+	}
+
+	err = rt.HandleNodeProgress("node-1", attempt(2), models.NodeProgress{Percent: 100, Time: now.Add(time.Second)})
+	if !errors.Is(err, ErrIllegalTransition) {
+		t.Fatalf("expected ErrIllegalTransition, got %v", err)
+	}
+}
+
+func TestResultRefs_GateAndResolveFlags(t *testing.T) {
+	rt := newRunningFlow(t)
+	rt.FlowRun.NodeState["node-2"] = models.NodeState{Status: models.NODERUN_IDLE}
+	rt.nodes["node-2"] = RuntimeNode{Node: &models.Node{
+		ID:    "node-2",
+		Flags: []models.NodeFlag{{Name: "lang", Value: "--lang=${results.node-1.language}"}, {Name: "pages", Value: "${results.node-1.pages}"}},
+	}}
+
+	rt.shouldNodeReady("node-2")
+	if s := rt.FlowRun.NodeState["node-2"].Status; s != models.NODERUN_IDLE {
+		t.Fatalf("expected node-2 to wait for node-1's results, got %s", s)
+	}
+
+	results := map[string]json.RawMessage{"language": json.RawMessage(`"en"`), "pages": json.RawMessage(`12`)}
+	if err := rt.HandleNodeFinished("node-1", attempt(1), nil, nil, results); err != nil {
+		t.Fatalf("HandleNodeFinished() error = %v", err)
+	}
+
+	rt.shouldNodeReady("node-2")
+	if s := rt.FlowRun.NodeState["node-2"].Status; s != models.NODERUN_READY {
+		t.Fatalf("expected node-2 to be READY, got %s", s)
+	}
+
+	node, err := rt.resolveFlags(*rt.nodes["node-2"].Node)
+	if err != nil {
+		t.Fatalf("resolveFlags() error = %v", err)
+	}
+
+	if node.Flags[0].Value != "--lang=en" || node.Flags[1].Value != "12" {
+		t.Fatalf("unexpected flags %+v", node.Flags)
+	}
+
+	if rt.nodes["node-2"].Node.Flags[0].Value != "--lang=${results.node-1.language}" {
+		t.Fatalf("resolving changed the flow's node")
+	}
+}
+
+func TestHandleNodeFailed_LateFailureAfterSuccess(t *testing.T) {
+	rt := newRunningFlow(t)
+
+	if err := rt.HandleNodeFailed("node-1", attempt(1), nil, "boom", 3, false); err != nil {
+		t.Fatalf("HandleNodeFailed() error = %v", err)
+	}
+	if err := rt.HandleNodeFinished("node-1", attempt(2), nil, nil, nil); err != nil {
+		t.Fatalf("HandleNodeFinished() error = %v", err)
+	}
+
+	// redelivered failure of attempt 1
+	err := rt.HandleNodeFailed("node-1", attempt(1), nil, "boom", 3, false)
+	if !errors.Is(err, ErrStaleAttempt) {
+		t.Fatalf("expected ErrStaleAttempt, got %v", err)
+	}
+
+	// a final failure from a later attempt can't undo completion either
+	err = rt.HandleNodeFailed("node-1", attempt(3), nil, "boom", 3, true)
+	if !errors.Is(err, ErrIllegalTransition) {
+		t.Fatalf("expected ErrIllegalTransition, got %v", err)
+	}
+
+	if rt.FlowRun.NodeState["node-1"].Status != models.NODERUN_COMPLETE {
+		t.Fatalf("expected node to stay COMPLETE, got %s", rt.FlowRun.NodeState["node-1"].Status)
+	}
+	if rt.FlowRun.Status == models.FLOWRUN_ERROR {
+		t.Fatalf("flow run was moved to ERROR by a rejected event")
+	}
+}
+
+func TestHandleNodeFinished_RejectsPreviousExecution(t *testing.T) {
+	rt := newRunningFlow(t)
+
+	err := rt.HandleNodeFinished("node-1", NodeAttempt{ExecutionID: "exec-0", AttemptID: "exec-0/1", Attempt: 1}, nil, nil, nil)
+	if !errors.Is(err, ErrStaleAttempt) {
+		t.Fatalf("expected ErrStaleAttempt, got %v", err)
+	}
+}
+
+func TestSetFlowStatus_TerminalStates(t *testing.T) {
+	rt := newRunningFlow(t)
+
+	if err := rt.setFlowStatus(models.FLOWRUN_COMPLETE); err != nil {
+		t.Fatalf("setFlowStatus(COMPLETE) error = %v", err)
+	}
+
+	for _, to := range []models.FlowRunStatus{models.FLOWRUN_ERROR, models.FLOWRUN_WAITING, models.FLOWRUN_RUNNING} {
+		if err := rt.setFlowStatus(to); !errors.Is(err, ErrIllegalTransition) {
+			t.Fatalf("COMPLETE -> %s: expected ErrIllegalTransition, got %v", to, err)
+		}
+	}
+}
+
+// existingStore reports every object as uploaded.
+type existingStore struct{}
+
+func (existingStore) PutURL(context.Context, string, time.Duration) (*url.URL, error) {
+	return &url.URL{}, nil
+}
+
+func (existingStore) GetURL(context.Context, string, time.Duration) (*url.URL, error) {
+	return &url.URL{}, nil
+}
+
+func (existingStore) DeleteObject(context.Context, string) error { return nil }
+
+func (existingStore) Exists(string) bool { return true }
+
+func TestHandleNodeFinished_RecordsOutputInfo(t *testing.T) {
+	rt := newRunningFlow(t)
+	rt.nodes["node-1"].Node.Outputs = []models.NodeEdge{{Name: "out", Edge: "frames"}}
+	rt.stores = map[string]models.Store{"s3": existingStore{}}
+	rt.FlowRun.Artifacts = make(map[string]models.Artifact)
+	rt.FlowRun.WaitingURLs = []models.WaitingURL{{
+		Artifact: models.Artifact{StoreName: "s3", ObjectName: "frames-run", EdgeName: "frames"},
+		NodeID:   "node-1",
+	}}
+
+	// The object exists once uploaded, but isn't used until the node finishes.
+	rt.updateWaiting()
+	if _, ok := rt.FlowRun.Artifacts["frames"]; ok {
+		t.Fatalf("expected the output to wait for its node to finish")
+	}
+
+	info := models.ArtifactInfo{Size: 42, SHA256: "abc123", MimeType: "image/png"}
+	if err := rt.HandleNodeFinished("node-1", attempt(1), nil, map[string]models.ArtifactInfo{"out": info}, nil); err != nil {
+		t.Fatalf("HandleNodeFinished() error = %v", err)
+	}
+
+	rt.updateWaiting()
+	if got := rt.FlowRun.Artifacts["frames"].ArtifactInfo; got != info {
+		t.Fatalf("expected recorded info %+v, got %+v", info, got)
+	}
+}
+
+// enqueueRecorder records the execute tasks queued through it, failing them
+// while fail is set.
+type enqueueRecorder struct {
+	syncplane.SyncLayer
+	fail     bool
+	payloads []syncplane.NodeExecutePayload
+}
+
+func (e *enqueueRecorder) EnqueueExecuteNode(p syncplane.NodeExecutePayload) error {
+	if e.fail {
+		return errors.New("queue unavailable")
+	}
+
+	e.payloads = append(e.payloads, p)
+	return nil
+}
+
+func TestHandleExecuteNode_RecordsBeforeQueueing(t *testing.T) {
+	rt := newRunningFlow(t)
+	s := &enqueueRecorder{}
+	ctx := context.Background()
+
+	// Not ready, so the transition is refused and nothing is queued.
+	rt.FlowRun.NodeState["node-1"] = models.NodeState{Status: models.NODERUN_IDLE}
+	if err := rt.handleExecuteNode(ctx, "node-1", s); !errors.Is(err, ErrIllegalTransition) {
+		t.Fatalf("expected ErrIllegalTransition, got %v", err)
+	}
+	if len(s.payloads) != 0 {
+		t.Fatalf("expected no task for a refused node, got %d", len(s.payloads))
+	}
+
+	ready := models.NodeState{Status: models.NODERUN_READY, Logs: []models.LogRecord{{Msg: "ready"}}}
+	rt.FlowRun.NodeState["node-1"] = ready
+
+	s.fail = true
+	if err := rt.handleExecuteNode(ctx, "node-1", s); err == nil {
+		t.Fatalf("expected the failed enqueue to be returned")
+	}
+	if got := rt.FlowRun.NodeState["node-1"]; got.Status != models.NODERUN_READY || got.ExecutionID != "" {
+		t.Fatalf("expected the node to be put back to READY, got %+v", got)
+	}
+
+	s.fail = false
+	if err := rt.handleExecuteNode(ctx, "node-1", s); err != nil {
+		t.Fatalf("handleExecuteNode() error = %v", err)
+	}
+
+	got := rt.FlowRun.NodeState["node-1"]
+	if got.Status != models.NODERUN_RUNNING || len(got.Logs) != 1 {
+		t.Fatalf("expected the node to be RUNNING with its logs, got %+v", got)
+	}
+	if len(s.payloads) != 1 || s.payloads[0].ExecutionID != got.ExecutionID {
+		t.Fatalf("expected one task for execution %s, got %+v", got.ExecutionID, s.payloads)
+	}
+}
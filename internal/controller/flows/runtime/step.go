@@ -2,28 +2,41 @@ package runtime
 
 import (
 	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
 
 	"github.com/pupload/pupload/internal/models"
 	"github.com/pupload/pupload/internal/syncplane"
 	"github.com/pupload/pupload/internal/telemetry"
 )
 
-func (rt *RuntimeFlow) Step(s syncplane.SyncLayer) {
+// Step advances the run as far as it can and reports whether anything changed.
+func (rt *RuntimeFlow) Step(s syncplane.SyncLayer) (progressed bool) {
 	ctx := telemetry.ExtractContext(context.Background(), rt.TraceParent)
 	ctx, span := telemetry.Tracer("pupload.controller").Start(ctx, "Step")
 
 	defer span.End()
 
+	before := rt.progressKey()
+	defer func() {
+		progressed = rt.progressKey() != before
+	}()
+
 	for {
 		rt.log.Info("stepFlow state", "runID", rt.FlowRun.ID, "state", rt.FlowRun.Status)
 
 		if rt.IsComplete() {
-			rt.FlowRun.Status = models.FLOWRUN_COMPLETE
+			if err := rt.setFlowStatus(models.FLOWRUN_COMPLETE); err != nil {
+				rt.log.Error("unable to complete flow", "err", err)
+			}
 			return
 		}
 
 		switch rt.FlowRun.Status {
 		case models.FLOWRUN_STOPPED:
+			return
 
 		case models.FLOWRUN_WAITING:
 
@@ -34,20 +47,24 @@ func (rt *RuntimeFlow) Step(s syncplane.SyncLayer) {
 				return
 			}
 
-			rt.FlowRun.Status = models.FLOWRUN_RUNNING
+			if err := rt.setFlowStatus(models.FLOWRUN_RUNNING); err != nil {
+				rt.log.Error("unable to start running flow", "err", err)
+				return
+			}
 
 		case models.FLOWRUN_RUNNING:
 			for _, nodeID := range rt.nodesReady() {
 				if err := rt.handleExecuteNode(ctx, nodeID, s); err != nil {
 					rt.log.Error("error executing node", "err", err, "node_id", nodeID)
-					rt.FlowRun.Status = models.FLOWRUN_ERROR
+					rt.setFlowStatus(models.FLOWRUN_ERROR)
 					return
 				}
-
-				rt.FlowRun.NodeState[nodeID] = models.NodeState{Status: models.NODERUN_RUNNING, Logs: rt.FlowRun.NodeState[nodeID].Logs}
 			}
 
-			rt.FlowRun.Status = models.FLOWRUN_WAITING
+			if err := rt.setFlowStatus(models.FLOWRUN_WAITING); err != nil {
+				rt.log.Error("unable to return flow to waiting", "err", err)
+				return
+			}
 
 		case models.FLOWRUN_COMPLETE:
 			return
@@ -60,6 +77,19 @@ func (rt *RuntimeFlow) Step(s syncplane.SyncLayer) {
 	}
 }
 
+// progressKey summarises everything a step can change.
+func (rt *RuntimeFlow) progressKey() string {
+	ids := slices.Sorted(maps.Keys(rt.FlowRun.NodeState))
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s/%d/%d", rt.FlowRun.Status, len(rt.FlowRun.Artifacts), len(rt.FlowRun.WaitingURLs))
+	for _, id := range ids {
+		fmt.Fprintf(&b, "/%s=%s", id, rt.FlowRun.NodeState[id].Status)
+	}
+
+	return b.String()
+}
+
 func (rt *RuntimeFlow) IsError() bool {
 	return rt.FlowRun.Status == models.FLOWRUN_ERROR
 }
@@ -131,6 +161,12 @@ func (rt *RuntimeFlow) checkWaitingURL(w models.WaitingURL) WaitingURLResult {
 	// 	return WaitURLExpired
 	// }
 
+	// A node's outputs are used once it finishes, which also records their
+	// checksums, rather than as soon as an attempt uploads them.
+	if w.NodeID != "" && rt.FlowRun.NodeState[w.NodeID].Status != models.NODERUN_COMPLETE {
+		return WaitNoChange
+	}
+
 	store, ok := rt.stores[w.Artifact.StoreName]
 	if !ok {
 		return WaitFailed
@@ -2,14 +2,16 @@ package service
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"time"
 
+	runtimepkg "github.com/pupload/pupload/internal/controller/flows/runtime"
 	"github.com/pupload/pupload/internal/syncplane"
 )
 
 func (f *FlowService) FlowStepHandler(ctx context.Context, payload syncplane.FlowStepPayload) error {
-	m := f.syncLayer.NewMutex(payload.RunID, 10*time.Second)
+	m := f.syncLayer.NewMutex(runtimeLockKey(payload.RunID), 10*time.Second)
 	err := m.Lock(ctx)
 
 	if err != nil {
@@ -20,17 +22,25 @@ func (f *FlowService) FlowStepHandler(ctx context.Context, payload syncplane.Flo
 
 	runtime, err := f.runtimeRepo.LoadRuntime(payload.RunID)
 	if err != nil {
-		f.log.Error("unable to get runtime flow from runtimeRepo", "runID", payload.RunID)
+		f.log.Error("unable to get runtime flow from runtimeRepo", "runID", payload.RunID, "err", err)
+		return err
 	}
 
-	runtime.RebuildRuntimeFlow()
-	runtime.Step(f.syncLayer)
+	runtime.RebuildRuntimeFlow(f.secrets)
+	progressed := runtime.Step(f.syncLayer)
 	if runtime.IsComplete() || runtime.IsError() {
 		f.HandleFlowComplete(payload.RunID)
 		return nil
 	}
 
-	f.runtimeRepo.SaveRuntime(runtime)
+	if err := f.runtimeRepo.SaveRuntime(runtime); err != nil {
+		f.log.Error("unable to save runtime flow", "run_id", payload.RunID, "err", err)
+		return err
+	}
+
+	if err := f.syncLayer.RescheduleRun(payload.RunID, !progressed); err != nil {
+		f.log.Error("unable to reschedule run", "run_id", payload.RunID, "err", err)
+	}
 
 	return nil
 }
@@ -38,8 +48,7 @@ func (f *FlowService) FlowStepHandler(ctx context.Context, payload syncplane.Flo
 func (f *FlowService) NodeFinishedHandler(ctx context.Context, payload syncplane.NodeFinishedPayload) error {
 	f.log.Info("HandleNodeFinishedTask: starting node finished task", "run_id", payload.RunID)
 
-	key := fmt.Sprintf("runtimelock:%s", payload.RunID)
-	m := f.syncLayer.NewMutex(key, 10*time.Second)
+	m := f.syncLayer.NewMutex(runtimeLockKey(payload.RunID), 10*time.Second)
 	err := m.Lock(ctx)
 	if err != nil {
 		f.log.Error("HandleNodeFinishedTask: runtime lock already in use", "run_id", payload.RunID, "err", err)
@@ -53,9 +62,15 @@ func (f *FlowService) NodeFinishedHandler(ctx context.Context, payload syncplane
 		return err
 	}
 
-	runtime.RebuildRuntimeFlow()
+	runtime.RebuildRuntimeFlow(f.secrets)
+
+	attempt := runtimepkg.NodeAttempt{ExecutionID: payload.ExecutionID, AttemptID: payload.AttemptID, Attempt: payload.Attempt, ImageDigest: payload.ImageDigest}
+	if err := runtime.HandleNodeFinished(payload.NodeID, attempt, payload.Logs, payload.Outputs, payload.Results); err != nil {
+		if isDroppedEvent(err) {
+			f.log.Warn("HandleNodeFinishedTask: dropping node finished event", "run_id", payload.RunID, "node_id", payload.NodeID, "attempt_id", payload.AttemptID, "err", err)
+			return nil
+		}
 
-	if err := runtime.HandleNodeFinished(payload.NodeID, payload.Logs); err != nil {
 		f.log.Error("HandleNodeFinishedTask: error handling node finished", "run_id", payload.RunID, "node_id", payload.NodeID, "err", err)
 		return err
 	}
@@ -66,13 +81,13 @@ func (f *FlowService) NodeFinishedHandler(ctx context.Context, payload syncplane
 		return err
 	}
 
+	f.wakeRun(payload.RunID)
 	return nil
 }
 func (f *FlowService) NodeFailedHandler(ctx context.Context, payload syncplane.NodeFailedPayload) error {
 
-	isFinalFailure := payload.Attempt >= payload.MaxAttempts
-	key := fmt.Sprintf("runtimelock:%s", payload.RunID)
-	m := f.syncLayer.NewMutex(key, 10*time.Second)
+	isFinalFailure := payload.Attempt >= payload.MaxAttempts || payload.SkipRetry
+	m := f.syncLayer.NewMutex(runtimeLockKey(payload.RunID), 10*time.Second)
 	err := m.Lock(ctx)
 	if err != nil {
 		f.log.Error("HandleNodeFinishedTask: runtime lock already in use", "run_id", payload.RunID, "err", err)
@@ -86,9 +101,15 @@ func (f *FlowService) NodeFailedHandler(ctx context.Context, payload syncplane.N
 		return err
 	}
 
-	runtime.RebuildRuntimeFlow()
+	runtime.RebuildRuntimeFlow(f.secrets)
+
+	attempt := runtimepkg.NodeAttempt{ExecutionID: payload.ExecutionID, AttemptID: payload.AttemptID, Attempt: payload.Attempt, ImageDigest: payload.ImageDigest}
+	if err := runtime.HandleNodeFailed(payload.NodeID, attempt, payload.Logs, payload.Error, payload.MaxAttempts, isFinalFailure); err != nil {
+		if isDroppedEvent(err) {
+			f.log.Warn("HandleNodeFailedTask: dropping node failed event", "run_id", payload.RunID, "node_id", payload.NodeID, "attempt_id", payload.AttemptID, "err", err)
+			return nil
+		}
 
-	if err := runtime.HandleNodeFailed(payload.NodeID, payload.Logs, payload.Error, payload.Attempt, payload.MaxAttempts, isFinalFailure); err != nil {
 		f.log.Error("HandleNodeFinishedTask: error handling node failed", "run_id", payload.RunID, "node_id", payload.NodeID, "err", err)
 		return err
 	}
@@ -99,5 +120,59 @@ func (f *FlowService) NodeFailedHandler(ctx context.Context, payload syncplane.N
 		return err
 	}
 
+	f.wakeRun(payload.RunID)
 	return nil
 }
+
+// NodeProgressHandler stores a node's latest progress. Progress is best
+// effort, so reports that can't be applied are dropped rather than retried.
+// It never waits for the runtime lock, so progress can't hold up the node
+// events that need it; a busy lock drops the report, and the next one follows
+// shortly.
+func (f *FlowService) NodeProgressHandler(ctx context.Context, payload syncplane.NodeProgressPayload) error {
+	m := f.syncLayer.NewMutex(runtimeLockKey(payload.RunID), 10*time.Second)
+	if err := m.TryLock(ctx); err != nil {
+		f.log.Debug("HandleNodeProgressTask: runtime lock in use, dropping progress", "run_id", payload.RunID, "node_id", payload.NodeID)
+		return nil
+	}
+	defer m.Unlock(ctx)
+
+	runtime, err := f.runtimeRepo.LoadRuntime(payload.RunID)
+	if err != nil {
+		f.log.Debug("HandleNodeProgressTask: run not found, dropping progress", "run_id", payload.RunID, "err", err)
+		return nil
+	}
+
+	runtime.RebuildRuntimeFlow(f.secrets)
+
+	attempt := runtimepkg.NodeAttempt{ExecutionID: payload.ExecutionID, AttemptID: payload.AttemptID, Attempt: payload.Attempt}
+	if err := runtime.HandleNodeProgress(payload.NodeID, attempt, payload.Progress); err != nil {
+		f.log.Debug("HandleNodeProgressTask: dropping node progress", "run_id", payload.RunID, "node_id", payload.NodeID, "err", err)
+		return nil
+	}
+
+	if err := f.runtimeRepo.SaveRuntime(runtime); err != nil {
+		f.log.Error("HandleNodeProgressTask: error saving runtime", "run_id", payload.RunID, "node_id", payload.NodeID, "err", err)
+	}
+
+	return nil
+}
+
+// wakeRun resets the step backoff of a run after a node event so the next
+// step, including cleanup of a finished run, follows shortly.
+func (f *FlowService) wakeRun(runID string) {
+	if err := f.syncLayer.RescheduleRun(runID, false); err != nil {
+		f.log.Error("unable to reschedule run", "run_id", runID, "err", err)
+	}
+}
+
+// All handlers that load and save a runtime must hold this lock.
+func runtimeLockKey(runID string) string {
+	return fmt.Sprintf("runtimelock:%s", runID)
+}
+
+// isDroppedEvent reports whether a node event was rejected because it is stale,
+// duplicated or out of order. Such events are acknowledged without retrying.
+func isDroppedEvent(err error) bool {
+	return errors.Is(err, runtimepkg.ErrStaleAttempt) || errors.Is(err, runtimepkg.ErrIllegalTransition)
+}
@@ -2,12 +2,17 @@ package service
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
 
 	"github.com/pupload/pupload/internal/controller/config"
 	"github.com/pupload/pupload/internal/controller/flows/repo"
 	"github.com/pupload/pupload/internal/controller/flows/runtime"
+	"github.com/pupload/pupload/internal/imagepolicy"
+	"github.com/pupload/pupload/internal/labels"
+	"github.com/pupload/pupload/internal/resources"
+	"github.com/pupload/pupload/internal/secrets"
 	"github.com/pupload/pupload/internal/syncplane"
 	"github.com/pupload/pupload/internal/telemetry"
 	"github.com/pupload/pupload/internal/validation"
@@ -22,7 +27,10 @@ type FlowService struct {
 	projectRepo repo.ProjectRepo
 	runtimeRepo repo.RuntimeRepo
 
-	syncLayer syncplane.SyncLayer
+	syncLayer   syncplane.SyncLayer
+	imagePolicy *imagepolicy.Policy
+	secrets     *secrets.Resolver
+	tiers       map[string]resources.ResourceDefinition
 
 	log *slog.Logger
 }
@@ -40,18 +48,35 @@ func CreateFlowService(cfg *config.ControllerSettings, s syncplane.SyncLayer) (*
 		return nil, err
 	}
 
+	resolver, err := secrets.New(cfg.Secrets)
+	if err != nil {
+		return nil, err
+	}
+
 	f := FlowService{
 		projectRepo: projectRepo,
 		runtimeRepo: runtimeRepo,
 
-		syncLayer: s,
+		syncLayer:   s,
+		imagePolicy: imagepolicy.New(cfg.ImagePolicy),
+		secrets:     resolver,
+		tiers:       cfg.Tiers,
 
 		log: slog,
 	}
 
+	if _, err := resources.Tiers(cfg.Tiers); err != nil {
+		return nil, err
+	}
+
+	if err := s.PublishTiers(context.Background(), cfg.Tiers); err != nil {
+		return nil, err
+	}
+
 	s.RegisterFlowStepHandler(f.FlowStepHandler)
 	s.RegisterNodeFinishedHandler(f.NodeFinishedHandler)
 	s.RegisterNodeFailedHandler(f.NodeFailedHandler)
+	s.RegisterNodeProgressHandler(f.NodeProgressHandler)
 
 	s.Start()
 
@@ -74,14 +99,38 @@ func (f *FlowService) RunFlow(flow models.Flow, nodeDefs []models.NodeDef) (mode
 		f.log.Info("node def tier", "node_def", nodeDefs[i].Name, "tier", nodeDefs[i].Tier)
 	}
 
-	res := validation.Validate(flow, nodeDefs)
+	// Workers may have added tiers since startup, so read the registry for
+	// every run.
+	tiers, err := syncplane.SharedTiers(ctx, f.syncLayer, f.tiers)
+	if err != nil {
+		return models.FlowRun{}, err
+	}
+
+	res := validation.Validate(flow, nodeDefs, tiers)
+	if f.imagePolicy.Enabled() {
+		validation.ValidateImages(res, flow, nodeDefs, f.imagePolicy)
+	}
+
+	if workers, err := f.syncLayer.ListWorkers(ctx); err == nil {
+		sets := make([]labels.Set, 0, len(workers))
+		for _, w := range workers {
+			sets = append(sets, w.Labels)
+		}
+		validation.ValidateWorkers(res, flow, nodeDefs, sets)
+	} else {
+		f.log.Warn("unable to list workers, skipping selector checks", "err", err)
+	}
 
 	if res.HasError() {
 		f.log.Warn("invalid flow", "errors", res.Errors, "warnings", res.Warnings)
 		return models.FlowRun{}, fmt.Errorf("invalid flow")
 	}
 
-	runtime, err := runtime.CreateRuntimeFlow(ctx, flow, nodeDefs)
+	if len(res.Warnings) > 0 {
+		f.log.Warn("flow has warnings", "warnings", res.Warnings)
+	}
+
+	runtime, err := runtime.CreateRuntimeFlow(ctx, flow, nodeDefs, f.secrets)
 	if err != nil {
 		return models.FlowRun{}, err
 	}
@@ -109,3 +158,51 @@ func (f *FlowService) HandleFlowComplete(runID string) error {
 	f.syncLayer.RemoveRunFromScheduler(runID)
 	return nil
 }
+
+func (f *FlowService) DeadLetters(ctx context.Context, cursor string, limit int) ([]syncplane.DeadLetter, string, error) {
+	return f.syncLayer.ListDeadLetters(ctx, cursor, limit)
+}
+
+func (f *FlowService) RequeueDeadLetter(ctx context.Context, queue, id string) error {
+	f.log.Info("requeueing dead letter", "queue", queue, "id", id)
+	return f.syncLayer.RequeueDeadLetter(ctx, queue, id)
+}
+
+func (f *FlowService) DeleteDeadLetter(ctx context.Context, queue, id string) error {
+	return f.syncLayer.DeleteDeadLetter(ctx, queue, id)
+}
+
+func (f *FlowService) PurgeDeadLetters(ctx context.Context) (int, error) {
+	return f.syncLayer.PurgeDeadLetters(ctx)
+}
+
+// NodeLogs returns a node's output after cursor, and the cursor to read on
+// from. An empty cursor reads from the start.
+func (f *FlowService) NodeLogs(ctx context.Context, runID, nodeID, cursor string) ([]models.LogRecord, string, error) {
+	return f.syncLayer.ReadNodeLogs(ctx, runID, nodeID, cursor)
+}
+
+// ErrNodeNotFound is returned for a node its run doesn't have.
+var ErrNodeNotFound = errors.New("node not found in run")
+
+// NodeDone reports whether a node will write no more output: it finished or
+// failed for good, or its run is no longer active. A run that is gone can't
+// be checked for the node, so it counts as done.
+func (f *FlowService) NodeDone(runID, nodeID string) (bool, error) {
+	runtime, err := f.runtimeRepo.LoadRuntime(runID)
+	if err != nil {
+		return true, nil
+	}
+
+	state, ok := runtime.FlowRun.NodeState[nodeID]
+	if !ok {
+		return true, ErrNodeNotFound
+	}
+
+	switch runtime.FlowRun.Status {
+	case models.FLOWRUN_COMPLETE, models.FLOWRUN_ERROR, models.FLOWRUN_STOPPED:
+		return true, nil
+	}
+
+	return state.Status == models.NODERUN_COMPLETE || state.Status == models.NODERUN_ERROR, nil
+}
@@ -8,15 +8,32 @@ import (
 	config "github.com/pupload/pupload/internal/controller/config"
 	flows "github.com/pupload/pupload/internal/controller/flows/service"
 	"github.com/pupload/pupload/internal/logging"
+	"github.com/pupload/pupload/internal/stores/hosted"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 )
 
+// apiTimeout bounds API calls, but not transfers or followed logs.
+const apiTimeout = 60 * time.Second
+
 func NewServer(config config.ControllerSettings, f *flows.FlowService) http.Handler {
 
 	log := logging.ForService("server")
 
+	r := newRouter(v1.HandleAPIRoutes(f), v1.HandleNodeLogs(f), apiTimeout)
+
+	walkFunc := func(method string, route string, handler http.Handler, middlewares ...func(http.Handler) http.Handler) error {
+		log.Info("Route", "method", method, "route", route)
+		return nil
+	}
+
+	chi.Walk(r, walkFunc)
+
+	return r
+}
+
+func newRouter(api, nodeLogs http.Handler, timeout time.Duration) chi.Router {
 	r := chi.NewRouter()
 
 	r.Use(middleware.RequestID)
@@ -24,16 +41,17 @@ func NewServer(config config.ControllerSettings, f *flows.FlowService) http.Hand
 	// r.Use(middleware.Logger)
 	r.Use(middleware.Recoverer)
 
-	r.Use(middleware.Timeout(60 * time.Second))
+	// Objects in hosted stores may take longer than API calls to transfer.
+	r.Handle(hosted.PathPrefix+"*", hosted.Handler())
 
-	r.Mount("/api/v1", v1.HandleAPIRoutes(f))
+	// Followed logs stream for as long as their node runs.
+	r.Method(http.MethodGet, v1.NodeLogsPath, nodeLogs)
 
-	walkFunc := func(method string, route string, handler http.Handler, middlewares ...func(http.Handler) http.Handler) error {
-		log.Info("Route", "method", method, "route", route)
-		return nil
-	}
+	r.Group(func(r chi.Router) {
+		r.Use(middleware.Timeout(timeout))
 
-	chi.Walk(r, walkFunc)
+		r.Mount("/api/v1", api)
+	})
 
 	return r
 }
@@ -0,0 +1,90 @@
+package controllerserver
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	v1 "github.com/pupload/pupload/internal/controller/api/v1"
+	"github.com/pupload/pupload/internal/models"
+)
+
+// tickingLogs has a node write a record every tick until it is done. The
+// cursor is the number of records read.
+type tickingLogs struct {
+	start, until time.Time
+	tick         time.Duration
+}
+
+func (l *tickingLogs) NodeDone(runID, nodeID string) (bool, error) {
+	return time.Now().After(l.until), nil
+}
+
+func (l *tickingLogs) NodeLogs(ctx context.Context, runID, nodeID, cursor string) ([]models.LogRecord, string, error) {
+	seen, _ := strconv.Atoi(cursor)
+
+	now := time.Now()
+	if now.After(l.until) {
+		now = l.until
+	}
+	written := int(now.Sub(l.start)/l.tick) + 1
+
+	var records []models.LogRecord
+	for i := seen; i < written; i++ {
+		records = append(records, models.LogRecord{Msg: "tick", Time: l.start.Add(time.Duration(i) * l.tick)})
+	}
+
+	return records, strconv.Itoa(max(seen, written)), nil
+}
+
+func TestNewRouter_FollowOutlastsAPITimeout(t *testing.T) {
+	const timeout = 200 * time.Millisecond
+
+	api := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	})
+	logs := &tickingLogs{tick: timeout / 4}
+
+	srv := httptest.NewServer(newRouter(api, v1.HandleNodeLogs(logs), timeout))
+	t.Cleanup(srv.Close)
+
+	res, err := http.Get(srv.URL + "/api/v1/flow/status")
+	if err != nil {
+		t.Fatalf("GET error = %v", err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusGatewayTimeout {
+		t.Fatalf("expected API calls to time out, got %d", res.StatusCode)
+	}
+
+	logs.start = time.Now()
+	logs.until = logs.start.Add(4 * timeout)
+
+	res, err = http.Get(srv.URL + "/api/v1/runs/run-1/nodes/node-1/logs?follow=true")
+	if err != nil {
+		t.Fatalf("GET error = %v", err)
+	}
+	defer res.Body.Close()
+
+	var last models.LogRecord
+	records := 0
+	scanner := bufio.NewScanner(res.Body)
+	for scanner.Scan() {
+		if err := json.Unmarshal(scanner.Bytes(), &last); err != nil {
+			t.Fatalf("unexpected line %q: %v", scanner.Text(), err)
+		}
+		records++
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatalf("stream ended with error = %v", err)
+	}
+
+	if records < 2 || last.Time.Sub(logs.start) <= timeout {
+		t.Fatalf("expected records past the %s timeout, got %d ending at %s", timeout, records, last.Time.Sub(logs.start))
+	}
+}
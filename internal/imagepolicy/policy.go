@@ -0,0 +1,169 @@
+package imagepolicy
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/distribution/reference"
+)
+
+// ErrImageNotAllowed is wrapped by every policy rejection.
+var ErrImageNotAllowed = errors.New("image not allowed")
+
+type Settings struct {
+	AllowedRegistries []string `json:"allowed_registries"` // all if empty
+	ImageAllowlist    []string `json:"image_allowlist"`    // all if empty
+	ImageBlocklist    []string `json:"image_blocklist"`
+}
+
+// Policy decides which container images may run. Registries are glob
+// patterns over the registry host ("*.gcr.io"). Allow and block entries are
+// glob patterns over normalized references: "ubuntu" becomes
+// "docker.io/library/ubuntu" and matches every tag, "ghcr.io/org/*:v1" matches
+// one tag of every repository under org, and an entry with a digest
+// ("repo@sha256:...") only matches references pinned to that digest.
+type Policy struct {
+	registries []string
+	allow      []string
+	block      []string
+}
+
+func New(cfg Settings) *Policy {
+	p := &Policy{
+		registries: cfg.AllowedRegistries,
+		allow:      make([]string, 0, len(cfg.ImageAllowlist)),
+		block:      make([]string, 0, len(cfg.ImageBlocklist)),
+	}
+
+	for _, pattern := range cfg.ImageAllowlist {
+		p.allow = append(p.allow, normalizePattern(pattern))
+	}
+
+	for _, pattern := range cfg.ImageBlocklist {
+		p.block = append(p.block, normalizePattern(pattern))
+	}
+
+	return p
+}
+
+// Enabled reports whether the policy restricts anything.
+func (p *Policy) Enabled() bool {
+	return p != nil && len(p.registries)+len(p.allow)+len(p.block) > 0
+}
+
+// Check returns the normalized reference for image, or an error wrapping
+// ErrImageNotAllowed if the policy rejects it.
+func (p *Policy) Check(image string) (string, error) {
+	named, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		return "", fmt.Errorf("%w: invalid reference %q: %w", ErrImageNotAllowed, image, err)
+	}
+	named = reference.TagNameOnly(named)
+
+	if p == nil {
+		return named.String(), nil
+	}
+
+	domain := reference.Domain(named)
+	if len(p.registries) > 0 && !matchAny(p.registries, domain) {
+		return "", fmt.Errorf("%w: registry %s is not in the allowed registries", ErrImageNotAllowed, domain)
+	}
+
+	if pattern, ok := p.match(p.block, named); ok {
+		return "", fmt.Errorf("%w: %s matches blocklist entry %s", ErrImageNotAllowed, named, pattern)
+	}
+
+	if len(p.allow) > 0 {
+		if _, ok := p.match(p.allow, named); !ok {
+			return "", fmt.Errorf("%w: %s does not match the image allowlist", ErrImageNotAllowed, named)
+		}
+	}
+
+	return named.String(), nil
+}
+
+// Normalize returns the reference image is compared by, like
+// docker.io/library/ubuntu:latest for ubuntu. Invalid references are returned
+// as they are.
+func Normalize(image string) string {
+	named, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		return image
+	}
+
+	return reference.TagNameOnly(named).String()
+}
+
+func (p *Policy) match(patterns []string, named reference.Named) (string, bool) {
+	name := named.Name()
+
+	var tag, digest string
+	if t, ok := named.(reference.Tagged); ok {
+		tag = t.Tag()
+	}
+	if d, ok := named.(reference.Digested); ok {
+		digest = d.Digest().String()
+	}
+
+	for _, pattern := range patterns {
+		repo, want, isDigest := splitPattern(pattern)
+		if ok, _ := path.Match(repo, name); !ok {
+			continue
+		}
+
+		switch {
+		case want == "":
+			return pattern, true
+		case isDigest:
+			if digest == want {
+				return pattern, true
+			}
+		default:
+			if ok, _ := path.Match(want, tag); ok {
+				return pattern, true
+			}
+		}
+	}
+
+	return "", false
+}
+
+func matchAny(patterns []string, s string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, s); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// splitPattern separates the repository from a tag or digest.
+func splitPattern(pattern string) (repo, want string, isDigest bool) {
+	if repo, digest, ok := strings.Cut(pattern, "@"); ok {
+		return repo, digest, true
+	}
+
+	// a colon after the last slash is a tag, before it a registry port
+	if i := strings.LastIndex(pattern, ":"); i > strings.LastIndex(pattern, "/") {
+		return pattern[:i], pattern[i+1:], false
+	}
+
+	return pattern, "", false
+}
+
+// normalizePattern applies the same defaults as reference.ParseNormalizedNamed
+// without requiring the pattern to be a valid reference.
+func normalizePattern(pattern string) string {
+	first, rest, found := strings.Cut(pattern, "/")
+	if found && (strings.ContainsAny(first, ".:") || first == "localhost") {
+		return pattern
+	}
+
+	if !found {
+		return "docker.io/library/" + pattern
+	}
+
+	return "docker.io/" + first + "/" + rest
+}
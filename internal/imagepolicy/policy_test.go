@@ -0,0 +1,69 @@
+package imagepolicy
+
+import (
+	"errors"
+	"testing"
+)
+
+const pinned = "sha256:4b1b7fc1b7f6c5a51b9df4e6f0a5c1f7c6f1d0f2a6b2e5f3a5e0f2c1d7e8a9b0"
+
+func TestPolicy_Check(t *testing.T) {
+	p := New(Settings{
+		AllowedRegistries: []string{"docker.io", "*.gcr.io", "ghcr.io"},
+		ImageAllowlist: []string{
+			"ubuntu",
+			"pupload/*",
+			"ghcr.io/acme/*:v1.*",
+			"us.gcr.io/acme/model@" + pinned,
+		},
+		ImageBlocklist: []string{"pupload/miner"},
+	})
+
+	tests := []struct {
+		image   string
+		want    string
+		allowed bool
+	}{
+		{"ubuntu", "docker.io/library/ubuntu:latest", true},
+		{"ubuntu:22.04", "docker.io/library/ubuntu:22.04", true},
+		{"docker.io/library/ubuntu@" + pinned, "docker.io/library/ubuntu@" + pinned, true},
+		{"pupload/ffmpeg:6", "docker.io/pupload/ffmpeg:6", true},
+		{"pupload/miner", "", false},
+		{"debian", "", false},
+		{"ghcr.io/acme/tool:v1.2", "ghcr.io/acme/tool:v1.2", true},
+		{"ghcr.io/acme/tool:v2.0", "", false},
+		{"us.gcr.io/acme/model@" + pinned, "us.gcr.io/acme/model@" + pinned, true},
+		{"us.gcr.io/acme/model:latest", "", false},
+		{"quay.io/pupload/ffmpeg", "", false},
+		{"Not A Reference", "", false},
+	}
+
+	for _, tt := range tests {
+		got, err := p.Check(tt.image)
+		if !tt.allowed {
+			if !errors.Is(err, ErrImageNotAllowed) {
+				t.Errorf("%s: expected ErrImageNotAllowed, got %v", tt.image, err)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: Check() error = %v", tt.image, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s: expected %s, got %s", tt.image, tt.want, got)
+		}
+	}
+}
+
+func TestPolicy_EmptyAllowsEverything(t *testing.T) {
+	p := New(Settings{})
+	if p.Enabled() {
+		t.Fatalf("expected empty policy to be disabled")
+	}
+
+	if _, err := p.Check("quay.io/anything/at:all"); err != nil {
+		t.Fatalf("Check() error = %v", err)
+	}
+}
@@ -0,0 +1,123 @@
+// Package labels matches node selectors against worker labels and names the
+// queues that carry labelled work.
+package labels
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/pupload/pupload/internal/models"
+)
+
+// Any matches a label that is present with any value.
+const Any = "*"
+
+var (
+	keyExp   = regexp.MustCompile(`^[a-z0-9]([a-z0-9._/-]*[a-z0-9])?$`)
+	valueExp = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9._-]*[A-Za-z0-9])?$`)
+)
+
+// Set is the labels a worker advertises, such as region=eu-west-1 or
+// codec.hevc=licensed.
+type Set map[string]string
+
+// Selector is the labels a node requires. Every key must be present on the
+// worker with the same value, or with any value for Any.
+type Selector map[string]string
+
+func (s Selector) Matches(l Set) bool {
+	for k, want := range s {
+		got, ok := l[k]
+		if !ok || (want != Any && got != want) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// String renders the selector canonically as sorted "k=v" pairs.
+func (s Selector) String() string {
+	pairs := make([]string, 0, len(s))
+	for _, k := range slices.Sorted(maps.Keys(s)) {
+		pairs = append(pairs, k+"="+s[k])
+	}
+
+	return strings.Join(pairs, ",")
+}
+
+// ExecutorKey is the label a worker sets to "true" for each executor it
+// allows, such as executor.process.
+func ExecutorKey(executor string) string {
+	return "executor." + executor
+}
+
+// Merge returns base with override's entries on top, so a Node can narrow or
+// replace its NodeDef's selector.
+func Merge(base, override Selector) Selector {
+	if len(base) == 0 && len(override) == 0 {
+		return nil
+	}
+
+	out := make(Selector, len(base)+len(override))
+	maps.Copy(out, base)
+	maps.Copy(out, override)
+
+	return out
+}
+
+// NodeSelector is what a worker must satisfy to run a node: the node's
+// selector merged over its definition's, plus the executor label for nodes
+// that don't run in a container.
+func NodeSelector(def models.NodeDef, node models.Node) Selector {
+	sel := Merge(def.Selector, node.Selector)
+
+	if e := def.ExecutorName(); e != models.ExecutorContainer {
+		sel = Merge(sel, Selector{ExecutorKey(e): "true"})
+	}
+
+	return sel
+}
+
+// QueueName is the queue carrying work for a tier restricted by a selector.
+// Unlabelled work stays on the tier's own queue. The selector is hashed so
+// the name stays valid as a NATS subject token.
+func QueueName(tier string, s Selector) string {
+	if len(s) == 0 {
+		return tier
+	}
+
+	sum := sha256.Sum256([]byte(s.String()))
+	return tier + "@" + hex.EncodeToString(sum[:8])
+}
+
+func validate(m map[string]string, allowAny bool) error {
+	for k, v := range m {
+		if !keyExp.MatchString(k) {
+			return fmt.Errorf("invalid label key %q", k)
+		}
+
+		if allowAny && v == Any {
+			continue
+		}
+
+		if !valueExp.MatchString(v) {
+			return fmt.Errorf("invalid value %q for label %s", v, k)
+		}
+	}
+
+	return nil
+}
+
+func (l Set) Validate() error {
+	return validate(l, false)
+}
+
+func (s Selector) Validate() error {
+	return validate(s, true)
+}
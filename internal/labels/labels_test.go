@@ -0,0 +1,91 @@
+package labels
+
+import (
+	"testing"
+
+	"github.com/pupload/pupload/internal/models"
+)
+
+func TestSelector_Matches(t *testing.T) {
+	worker := Set{"region": "eu-west-1", "codec.hevc": "licensed", "cuda": "8.6"}
+
+	tests := []struct {
+		name string
+		sel  Selector
+		want bool
+	}{
+		{"empty", nil, true},
+		{"exact", Selector{"region": "eu-west-1"}, true},
+		{"all of several", Selector{"region": "eu-west-1", "cuda": "8.6"}, true},
+		{"wrong value", Selector{"region": "us-east-1"}, false},
+		{"missing key", Selector{"pool": "acme"}, false},
+		{"any value", Selector{"codec.hevc": Any}, true},
+		{"any value missing", Selector{"codec.av1": Any}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.sel.Matches(worker); got != tt.want {
+				t.Fatalf("Matches() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQueueName(t *testing.T) {
+	if got := QueueName("c-small", nil); got != "c-small" {
+		t.Fatalf("expected unlabelled work on the tier queue, got %q", got)
+	}
+
+	a := QueueName("c-small", Selector{"region": "eu", "pool": "acme"})
+	b := QueueName("c-small", Selector{"pool": "acme", "region": "eu"})
+	if a != b {
+		t.Fatalf("queue name depends on map order: %q != %q", a, b)
+	}
+
+	if a == QueueName("c-small", Selector{"region": "eu"}) {
+		t.Fatalf("different selectors share a queue")
+	}
+	if a == QueueName("c-large", Selector{"region": "eu", "pool": "acme"}) {
+		t.Fatalf("different tiers share a queue")
+	}
+}
+
+func TestMerge(t *testing.T) {
+	got := Merge(Selector{"region": "eu", "cuda": "8.0"}, Selector{"cuda": "8.6", "pool": "acme"})
+	want := "cuda=8.6,pool=acme,region=eu"
+	if got.String() != want {
+		t.Fatalf("Merge() = %s, want %s", got, want)
+	}
+
+	if Merge(nil, nil) != nil {
+		t.Fatalf("expected nil for two empty selectors")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	if err := (Selector{"codec.hevc": Any, "topology.kubernetes.io/region": "eu-west-1"}).Validate(); err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+
+	if err := (Set{"pool": Any}).Validate(); err == nil {
+		t.Fatalf("expected workers to be unable to advertise a wildcard")
+	}
+
+	if err := (Selector{"Bad Key": "x"}).Validate(); err == nil {
+		t.Fatalf("expected invalid key to be rejected")
+	}
+}
+
+func TestNodeSelector_Executor(t *testing.T) {
+	def := models.NodeDef{Selector: map[string]string{"region": "eu"}}
+
+	if got := NodeSelector(def, models.Node{}).String(); got != "region=eu" {
+		t.Fatalf("expected container nodes to need only their selector, got %s", got)
+	}
+
+	def.Executor = models.ExecutorProcess
+	if got := NodeSelector(def, models.Node{}).String(); got != "executor.process=true,region=eu" {
+		t.Fatalf("expected process nodes to need the executor label, got %s", got)
+	}
+}
@@ -0,0 +1,113 @@
+package logging
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+// MaxOutputBytes bounds the output kept from a node; anything after it is
+// dropped.
+const MaxOutputBytes = 1 << 20
+
+// OutputBuffer collects a node's stdout and stderr, keeping the first
+// MaxOutputBytes. It is safe to write to from both streams at once.
+type OutputBuffer struct {
+	mu        sync.Mutex
+	buf       bytes.Buffer
+	truncated bool
+}
+
+func (b *OutputBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if room := MaxOutputBytes - b.buf.Len(); room < len(p) {
+		b.buf.Write(p[:max(room, 0)])
+		b.truncated = true
+		return len(p), nil
+	}
+
+	return b.buf.Write(p)
+}
+
+// Lines returns the output split into lines, noting any truncation.
+func (b *OutputBuffer) Lines() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	lines := strings.Split(strings.TrimSpace(b.buf.String()), "\n")
+	if b.truncated {
+		lines = append(lines, fmt.Sprintf("[output truncated at %d bytes]", MaxOutputBytes))
+	}
+
+	return lines
+}
+
+// MaxLineBytes caps one line passed on by a LineWriter; the rest of a longer
+// line is dropped.
+const MaxLineBytes = 16 << 10
+
+// LineWriter calls Emit with each line written to it, without its newline.
+// Flush passes on a last line that has none. It is not safe to write to from
+// several goroutines.
+type LineWriter struct {
+	Emit func(line string)
+
+	buf []byte
+}
+
+func (w *LineWriter) Write(p []byte) (int, error) {
+	n := len(p)
+
+	for len(p) > 0 {
+		i := bytes.IndexByte(p, '\n')
+		if i < 0 {
+			w.add(p)
+			break
+		}
+
+		w.add(p[:i])
+		w.Flush()
+		p = p[i+1:]
+	}
+
+	return n, nil
+}
+
+func (w *LineWriter) add(p []byte) {
+	if room := MaxLineBytes - len(w.buf); room > 0 {
+		w.buf = append(w.buf, p[:min(len(p), room)]...)
+	}
+}
+
+// Flush passes on the line written so far, if any.
+func (w *LineWriter) Flush() {
+	if len(w.buf) == 0 {
+		return
+	}
+
+	w.Emit(strings.TrimSuffix(string(w.buf), "\r"))
+	w.buf = w.buf[:0]
+}
+
+// StreamWriters returns writers for a node's stdout and stderr that collect
+// into out and, when emit isn't nil, pass on each line as it is written.
+// flush passes on the last lines once the node is done writing.
+func StreamWriters(out *OutputBuffer, emit func(stream, line string)) (stdout, stderr io.Writer, flush func()) {
+	if emit == nil {
+		return out, out, func() {}
+	}
+
+	o := &LineWriter{Emit: func(line string) { emit("stdout", line) }}
+	e := &LineWriter{Emit: func(line string) { emit("stderr", line) }}
+
+	flush = func() {
+		o.Flush()
+		e.Flush()
+	}
+
+	return io.MultiWriter(out, o), io.MultiWriter(out, e), flush
+}
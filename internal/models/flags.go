@@ -0,0 +1,108 @@
+package models
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"slices"
+	"strconv"
+)
+
+// Types a NodeFlagDef can declare. An empty type is a string.
+const (
+	FlagString = "string"
+	FlagInt    = "int"
+	FlagFloat  = "float"
+	FlagBool   = "bool"
+	FlagEnum   = "enum"
+	FlagRegex  = "regex"
+	FlagRange  = "range"
+)
+
+var FlagTypes = []string{FlagString, FlagInt, FlagFloat, FlagBool, FlagEnum, FlagRegex, FlagRange}
+
+// CheckDef reports whether the definition itself is usable: a known type with
+// the settings it needs, and a default that passes Check.
+func (d NodeFlagDef) CheckDef() error {
+	switch d.Type {
+	case "", FlagString, FlagInt, FlagFloat, FlagBool:
+	case FlagEnum:
+		if len(d.Values) == 0 {
+			return fmt.Errorf("enum flag %s has no Values", d.Name)
+		}
+	case FlagRegex:
+		if _, err := regexp.Compile(d.Pattern); err != nil || d.Pattern == "" {
+			return fmt.Errorf("regex flag %s has an invalid Pattern %q", d.Name, d.Pattern)
+		}
+	case FlagRange:
+		if d.Min == nil || d.Max == nil {
+			return fmt.Errorf("range flag %s needs Min and Max", d.Name)
+		}
+	default:
+		return fmt.Errorf("flag %s has unknown type %s, expected one of %v", d.Name, d.Type, FlagTypes)
+	}
+
+	if d.Min != nil && d.Max != nil && *d.Min > *d.Max {
+		return fmt.Errorf("flag %s has Min above Max", d.Name)
+	}
+
+	if d.Default != "" {
+		if err := d.Check(d.Default); err != nil {
+			return fmt.Errorf("default: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// Check reports whether value is a valid value of the flag.
+func (d NodeFlagDef) Check(value string) error {
+	switch d.Type {
+	case FlagInt:
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("flag %s is %q, expected an integer", d.Name, value)
+		}
+		return d.checkBounds(float64(n))
+
+	case FlagFloat, FlagRange:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+			return fmt.Errorf("flag %s is %q, expected a number", d.Name, value)
+		}
+		return d.checkBounds(f)
+
+	case FlagBool:
+		if value != "true" && value != "false" {
+			return fmt.Errorf("flag %s is %q, expected true or false", d.Name, value)
+		}
+
+	case FlagEnum:
+		if !slices.Contains(d.Values, value) {
+			return fmt.Errorf("flag %s is %q, expected one of %v", d.Name, value, d.Values)
+		}
+
+	case FlagRegex:
+		re, err := regexp.Compile(`^(?:` + d.Pattern + `)$`)
+		if err != nil {
+			return fmt.Errorf("flag %s has an invalid Pattern: %w", d.Name, err)
+		}
+		if !re.MatchString(value) {
+			return fmt.Errorf("flag %s is %q, which doesn't match %s", d.Name, value, d.Pattern)
+		}
+	}
+
+	return nil
+}
+
+func (d NodeFlagDef) checkBounds(f float64) error {
+	if d.Min != nil && f < *d.Min {
+		return fmt.Errorf("flag %s is %v, below the minimum %v", d.Name, f, *d.Min)
+	}
+
+	if d.Max != nil && f > *d.Max {
+		return fmt.Errorf("flag %s is %v, above the maximum %v", d.Name, f, *d.Max)
+	}
+
+	return nil
+}
@@ -9,4 +9,5 @@ type LogRecord struct {
 	Level  string            `json:"level"`
 	Msg    string            `json:"msg"`
 	Fields map[string]string `json:"fields"`
+	Stream string            `json:"stream,omitempty"` // stdout or stderr for a node's own output
 }
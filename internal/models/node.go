@@ -7,6 +7,10 @@ type Node struct {
 	Outputs []NodeEdge
 	Flags   []NodeFlag
 	Command string
+
+	Selector map[string]string // merged over the NodeDef's selector
+
+	Secrets []NodeSecret // resolved by the worker running the node
 }
 
 type NodeEdge struct {
@@ -18,3 +22,14 @@ type NodeFlag struct {
 	Name  string
 	Value string
 }
+
+// SecretsDirVar expands to the directory a node's file secrets are written to.
+const SecretsDirVar = "PUPLOAD_SECRETS_DIR"
+
+// NodeSecret hands a secret to a node as an environment variable or as a file
+// in ${PUPLOAD_SECRETS_DIR}, never through its command line.
+type NodeSecret struct {
+	Secret string // name in the worker's secret providers
+	Env    string // variable to set
+	File   string // file name to write
+}
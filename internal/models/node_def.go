@@ -1,10 +1,42 @@
 package models
 
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
 const (
 	DefaultTier     = "c-small"
 	DefaultAttempts = 3
 )
 
+// Executors a NodeDef can run under. The process executor runs the command
+// directly on the worker host and is meant for trusted nodes only. The wasi
+// executor runs a WebAssembly module in a sandbox with no network and only
+// the node's files.
+const (
+	ExecutorContainer = "container"
+	ExecutorProcess   = "process"
+	ExecutorWASI      = "wasi"
+)
+
+var Executors = []string{ExecutorContainer, ExecutorProcess, ExecutorWASI}
+
+// Pull policies for a NodeDef's image. Without one, images tagged latest or
+// not tagged at all are pulled Always and others IfNotPresent.
+const (
+	PullAlways       = "Always"
+	PullIfNotPresent = "IfNotPresent"
+	PullNever        = "Never"
+)
+
+var PullPolicies = []string{PullAlways, PullIfNotPresent, PullNever}
+
+// ModuleStorePrefix marks a wasi module kept in one of the flow's stores, as
+// in store://<store>/<key>.
+const ModuleStorePrefix = "store://"
+
 type NodeDef struct {
 	ID          int64
 	Publisher   string
@@ -13,16 +45,31 @@ type NodeDef struct {
 	Inputs      []NodeEdgeDef
 	Outputs     []NodeEdgeDef
 	Flags       []NodeFlagDef
+	Results     []NodeResultDef // written by the node to ${PUPLOAD_RESULTS}
 	Command     NodeCommandDef
 	Tier        string
 	MaxAttempts int
+
+	Executor string // container (default), process or wasi
+	Module   string // .wasm module for the wasi executor, a path in the worker's module dir or store://<store>/<key>
+
+	NeedsNetwork bool   // containers run without a network unless set
+	PullPolicy   string // Always, IfNotPresent or Never, see PullPolicyName
+
+	Selector map[string]string // worker labels required to run the node
 }
 
 type NodeFlagDef struct {
 	Name        string
 	Description string
 	Required    bool
-	Type        string
+	Type        string // see FlagTypes, defaults to string
+	Default     string // used when the node doesn't set the flag
+
+	Values  []string // enum: the allowed values
+	Pattern string   // regex: the value must match all of it
+	Min     *float64 // int, float, range: inclusive bounds
+	Max     *float64
 }
 
 type NodeEdgeDef struct {
@@ -30,12 +77,34 @@ type NodeEdgeDef struct {
 	Description string
 	Required    bool
 	Type        []MimeType
+
+	// Size limits in bytes, 0 for none. Workers check inputs before running
+	// the node and outputs before uploading them.
+	MinSize int64
+	MaxSize int64
 }
 
+// NodeCommandDef is the node's argv. Each element of Args is one argument,
+// with ${Name} references to flags, inputs and outputs expanded in place, so
+// values are never split or reparsed. Any other $ is kept as is, and $${ is a
+// literal ${. An argument referring to an optional flag, input or output that
+// is unset is dropped, so an optional flag goes in the same argument as its
+// option, as in --preset=${Preset}. Exec is the older form, split on spaces
+// before expansion; set one or the other.
 type NodeCommandDef struct {
 	Name        string
 	Description string
 	Exec        string
+	Args        []string
+}
+
+// Template returns the command's arguments before expansion.
+func (c NodeCommandDef) Template() []string {
+	if len(c.Args) > 0 {
+		return c.Args
+	}
+
+	return strings.Fields(c.Exec)
 }
 
 func (nd *NodeDef) Normalize() {
@@ -46,4 +115,135 @@ func (nd *NodeDef) Normalize() {
 	if nd.MaxAttempts <= 0 {
 		nd.MaxAttempts = DefaultAttempts
 	}
+
+	nd.Executor = nd.ExecutorName()
+}
+
+// ExecutorName is the executor that runs the node.
+func (nd NodeDef) ExecutorName() string {
+	if nd.Executor == "" {
+		return ExecutorContainer
+	}
+
+	return nd.Executor
+}
+
+// PullPolicyName is the pull policy for the node's image.
+func (nd NodeDef) PullPolicyName() string {
+	if nd.PullPolicy != "" {
+		return nd.PullPolicy
+	}
+
+	if strings.Contains(nd.Image, "@") {
+		return PullIfNotPresent
+	}
+
+	// A colon after the last slash starts the tag; earlier ones are a port.
+	name := nd.Image[strings.LastIndex(nd.Image, "/")+1:]
+	if _, tag, ok := strings.Cut(name, ":"); !ok || tag == "latest" {
+		return PullAlways
+	}
+
+	return PullIfNotPresent
+}
+
+// ModuleStore splits a store:// module reference. ok is false for a path on
+// the worker.
+func (nd NodeDef) ModuleStore() (store, key string, ok bool) {
+	ref, ok := strings.CutPrefix(nd.Module, ModuleStorePrefix)
+	if !ok {
+		return "", "", false
+	}
+
+	store, key, _ = strings.Cut(ref, "/")
+	return store, key, true
+}
+
+// ExpandArg replaces the ${Name} references in one command argument with
+// their values from lookup. unset lists the names lookup had no value for.
+func ExpandArg(arg string, lookup func(name string) (string, bool)) (expanded string, unset []string) {
+	var b strings.Builder
+
+	for {
+		i := strings.Index(arg, "${")
+		if i < 0 {
+			break
+		}
+
+		if i > 0 && arg[i-1] == '$' {
+			b.WriteString(arg[:i])
+			arg = arg[i+1:]
+			continue
+		}
+
+		end := strings.IndexByte(arg[i:], '}')
+		if end < 0 {
+			break
+		}
+
+		name := arg[i+2 : i+end]
+		b.WriteString(arg[:i])
+		arg = arg[i+end+1:]
+
+		v, ok := lookup(name)
+		if !ok {
+			unset = append(unset, name)
+		}
+		b.WriteString(v)
+	}
+
+	b.WriteString(arg)
+
+	return b.String(), unset
+}
+
+// ArgRefs returns the names one command argument refers to.
+func ArgRefs(arg string) []string {
+	_, refs := ExpandArg(arg, func(string) (string, bool) { return "", false })
+	return refs
+}
+
+// OptionalRef reports whether the command may refer to name while it is
+// unset, which drops the arguments referring to it: an optional flag without
+// a default, an input or output, or the secrets dir.
+func (nd NodeDef) OptionalRef(name string) bool {
+	if name == SecretsDirVar {
+		return true
+	}
+
+	if i := slices.IndexFunc(nd.Flags, func(f NodeFlagDef) bool { return f.Name == name }); i >= 0 {
+		return !nd.Flags[i].Required && nd.Flags[i].Default == ""
+	}
+
+	isEdge := func(e NodeEdgeDef) bool { return e.Name == name }
+	return slices.ContainsFunc(nd.Inputs, isEdge) || slices.ContainsFunc(nd.Outputs, isEdge)
+}
+
+// CheckCommand checks that the command only refers to the node's flags,
+// inputs and outputs and the variables the worker sets, and that no optional
+// flag is an argument of its own after an option, which would be left
+// dangling when the flag is unset.
+func (nd NodeDef) CheckCommand() error {
+	template := nd.Command.Template()
+
+	for i, arg := range template {
+		for _, name := range ArgRefs(arg) {
+			isFlag := slices.ContainsFunc(nd.Flags, func(f NodeFlagDef) bool { return f.Name == name })
+
+			if !isFlag && !nd.OptionalRef(name) && (name != ResultsVar || len(nd.Results) == 0) {
+				return fmt.Errorf("command refers to unknown ${%s}", name)
+			}
+
+			if i == 0 || !isFlag || !nd.OptionalRef(name) {
+				continue
+			}
+
+			prev := template[i-1]
+			if strings.HasPrefix(prev, "-") && !strings.Contains(prev, "=") && len(ArgRefs(prev)) == 0 {
+				return fmt.Errorf("optional flag %s must share an argument with its option, as in %s=${%s}", name, prev, name)
+			}
+		}
+	}
+
+	return nil
 }
@@ -0,0 +1,130 @@
+package models
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"slices"
+)
+
+// JSON types a NodeResultDef can declare. An empty type accepts any value.
+const (
+	ResultString  = "string"
+	ResultNumber  = "number"
+	ResultBoolean = "boolean"
+	ResultObject  = "object"
+	ResultArray   = "array"
+)
+
+var ResultTypes = []string{ResultString, ResultNumber, ResultBoolean, ResultObject, ResultArray}
+
+// ResultsVar expands to the path a node writes its results to, as one JSON
+// object keyed by result name.
+const ResultsVar = "PUPLOAD_RESULTS"
+
+// MaxResultsSize caps a node's results file; larger data belongs in an output.
+const MaxResultsSize = 64 << 10
+
+// NodeResultDef declares a small JSON value a node reports when it finishes,
+// like a detected language or a page count.
+type NodeResultDef struct {
+	Name        string
+	Description string
+	Required    bool
+	Type        string
+}
+
+// Check reports whether raw is a value of the declared type.
+func (d NodeResultDef) Check(raw json.RawMessage) error {
+	got := jsonType(raw)
+	if got == "" {
+		return fmt.Errorf("result %s is not valid JSON", d.Name)
+	}
+
+	if d.Type != "" && got != d.Type {
+		return fmt.Errorf("result %s is a %s, expected a %s", d.Name, got, d.Type)
+	}
+
+	return nil
+}
+
+func jsonType(raw json.RawMessage) string {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) == 0 || !json.Valid(raw) {
+		return ""
+	}
+
+	switch raw[0] {
+	case '"':
+		return ResultString
+	case 't', 'f':
+		return ResultBoolean
+	case '{':
+		return ResultObject
+	case '[':
+		return ResultArray
+	case 'n':
+		return "null"
+	default:
+		return ResultNumber
+	}
+}
+
+// ResultRef points at another node's result from a flag value, written as
+// ${results.<node>.<name>}.
+type ResultRef struct {
+	Node string
+	Name string
+}
+
+var resultRefPattern = regexp.MustCompile(`\$\{results\.([^.}]+)\.([^}]+)\}`)
+
+// ResultRefs returns the results the node's flags refer to, in order and
+// without duplicates.
+func (n Node) ResultRefs() []ResultRef {
+	var refs []ResultRef
+	for _, f := range n.Flags {
+		for _, m := range resultRefPattern.FindAllStringSubmatch(f.Value, -1) {
+			ref := ResultRef{Node: m[1], Name: m[2]}
+			if !slices.Contains(refs, ref) {
+				refs = append(refs, ref)
+			}
+		}
+	}
+
+	return refs
+}
+
+// HasResultRefs reports whether value refers to any result.
+func HasResultRefs(value string) bool {
+	return resultRefPattern.MatchString(value)
+}
+
+// ExpandResults replaces the result references in value with the results
+// lookup returns. Strings are inserted as is and other values as JSON.
+func ExpandResults(value string, lookup func(ResultRef) (json.RawMessage, bool)) (string, error) {
+	var err error
+
+	expanded := resultRefPattern.ReplaceAllStringFunc(value, func(m string) string {
+		sub := resultRefPattern.FindStringSubmatch(m)
+		ref := ResultRef{Node: sub[1], Name: sub[2]}
+
+		raw, ok := lookup(ref)
+		if !ok {
+			if err == nil {
+				err = fmt.Errorf("node %s has no result %s", ref.Node, ref.Name)
+			}
+			return ""
+		}
+
+		var s string
+		if json.Unmarshal(raw, &s) == nil {
+			return s
+		}
+
+		return string(bytes.TrimSpace(raw))
+	})
+
+	return expanded, err
+}
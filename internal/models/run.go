@@ -1,6 +1,7 @@
 package models
 
 import (
+	"encoding/json"
 	"time"
 )
 
@@ -31,18 +32,50 @@ type NodeState struct {
 	Error       string
 	Attempt     int
 	MaxAttempts int
+
+	ExecutionID string // set each time the node is dispatched
+	AttemptID   string // last attempt applied to this state
+
+	Progress *NodeProgress // latest the node reported, nil if it reports none
+
+	Results map[string]json.RawMessage // by result name, once the node is complete
+
+	ImageDigest string // image the last attempt ran, repo@sha256:... or the local image ID
+}
+
+// NodeProgress is what a running node last reported about its progress.
+type NodeProgress struct {
+	Percent float64            `json:"percent"` // 0 to 100, or -1 when unknown
+	Stage   string             `json:"stage,omitempty"`
+	Message string             `json:"message,omitempty"`
+	Metrics map[string]float64 `json:"metrics,omitempty"`
+
+	Attempt int       `json:"attempt"`
+	Time    time.Time `json:"time"`
 }
 
 type Artifact struct {
 	StoreName  string
 	ObjectName string
 	EdgeName   string
+
+	ArtifactInfo
+}
+
+// ArtifactInfo describes an artifact's contents. The worker that produces an
+// artifact records it so consumers can verify their download; it is empty for
+// objects uploaded straight to a store.
+type ArtifactInfo struct {
+	Size     int64
+	SHA256   string // hex
+	MimeType MimeType
 }
 
 type WaitingURL struct {
 	Artifact Artifact
 	PutURL   string
 	TTL      time.Time
+	NodeID   string // node producing the artifact, empty for flow inputs
 }
 
 type FlowRun struct {
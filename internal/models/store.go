@@ -7,6 +7,16 @@ import (
 	"time"
 )
 
+// Store types. Filesystem and memory stores are served by the controller
+// itself, for local and on-prem deployments without S3.
+const (
+	StoreS3         = "s3"
+	StoreFilesystem = "filesystem"
+	StoreMemory     = "memory"
+)
+
+var StoreTypes = []string{StoreS3, StoreFilesystem, StoreMemory}
+
 type StoreInput struct {
 	Name string
 	Type string
@@ -24,3 +34,19 @@ type Store interface {
 	DeleteObject(ctx context.Context, objectName string) error
 	Exists(objectName string) bool
 }
+
+// MultipartStore is a Store that can presign a multipart upload, so workers
+// can upload large outputs in parts and retry a failed part on its own.
+type MultipartStore interface {
+	Store
+	PresignMultipart(ctx context.Context, objectName string, parts int, expires time.Duration) (*MultipartUpload, error)
+}
+
+// MultipartUpload is a presigned multipart upload. A worker PUTs parts to
+// PartURLs in order and finishes with a POST to CompleteURL, or DELETEs
+// AbortURL when it uploads the object with a single PUT instead.
+type MultipartUpload struct {
+	PartURLs    []string
+	CompleteURL string
+	AbortURL    string
+}
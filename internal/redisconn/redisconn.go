@@ -0,0 +1,134 @@
+package redisconn
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+
+	"github.com/redis/go-redis/v9"
+)
+
+type Settings struct {
+	Address  string
+	Username string // ACL user, optional
+	Password string
+	DB       int
+
+	PoolSize   int
+	MaxRetries int
+
+	TLS      TLSSettings
+	Sentinel SentinelSettings
+	Cluster  ClusterSettings
+}
+
+type TLSSettings struct {
+	Enabled bool
+
+	CAFile     string // PEM bundle used instead of the system roots
+	CertFile   string // client certificate, optional
+	KeyFile    string
+	ServerName string
+
+	InsecureSkipVerify bool
+}
+
+// SentinelSettings enables failover through Redis Sentinel when MasterName is set.
+type SentinelSettings struct {
+	MasterName string
+	Addresses  []string
+
+	Username string
+	Password string
+}
+
+// ClusterSettings enables Redis Cluster. Addresses defaults to Address.
+type ClusterSettings struct {
+	Enabled   bool
+	Addresses []string
+}
+
+// NewClient builds a client for a single node, a Sentinel-managed primary or
+// a cluster depending on the settings.
+func NewClient(cfg Settings) (redis.UniversalClient, error) {
+	tlsConfig, err := cfg.TLS.Config()
+	if err != nil {
+		return nil, err
+	}
+
+	opts := &redis.UniversalOptions{
+		Addrs:    []string{cfg.Address},
+		Username: cfg.Username,
+		Password: cfg.Password,
+		DB:       cfg.DB,
+
+		PoolSize:   cfg.PoolSize,
+		MaxRetries: cfg.MaxRetries,
+
+		TLSConfig: tlsConfig,
+	}
+
+	switch {
+	case cfg.Sentinel.MasterName != "" && cfg.Cluster.Enabled:
+		return nil, fmt.Errorf("redis sentinel and cluster mode are mutually exclusive")
+
+	case cfg.Sentinel.MasterName != "":
+		if len(cfg.Sentinel.Addresses) == 0 {
+			return nil, fmt.Errorf("redis sentinel requires at least one sentinel address")
+		}
+
+		opts.MasterName = cfg.Sentinel.MasterName
+		opts.Addrs = cfg.Sentinel.Addresses
+		opts.SentinelUsername = cfg.Sentinel.Username
+		opts.SentinelPassword = cfg.Sentinel.Password
+
+	case cfg.Cluster.Enabled:
+		if cfg.DB != 0 {
+			return nil, fmt.Errorf("redis cluster only supports DB 0")
+		}
+
+		if len(cfg.Cluster.Addresses) > 0 {
+			opts.Addrs = cfg.Cluster.Addresses
+		}
+		opts.IsClusterMode = true
+	}
+
+	return redis.NewUniversalClient(opts), nil
+}
+
+// Config returns nil when TLS is disabled.
+func (t TLSSettings) Config() (*tls.Config, error) {
+	if !t.Enabled {
+		return nil, nil
+	}
+
+	cfg := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         t.ServerName,
+		InsecureSkipVerify: t.InsecureSkipVerify,
+	}
+
+	if t.CAFile != "" {
+		pem, err := os.ReadFile(t.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read redis CA file: %w", err)
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("redis CA file %s contains no certificates", t.CAFile)
+		}
+		cfg.RootCAs = pool
+	}
+
+	if t.CertFile != "" || t.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load redis client certificate: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+
+	return cfg, nil
+}
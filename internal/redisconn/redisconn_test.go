@@ -0,0 +1,101 @@
+package redisconn
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/redis/go-redis/v9"
+)
+
+func TestNewClient_SelectsClientKind(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  Settings
+		want string
+	}{
+		{
+			name: "single node",
+			cfg:  Settings{Address: "localhost:6379"},
+			want: "*redis.Client",
+		},
+		{
+			name: "sentinel",
+			cfg: Settings{Sentinel: SentinelSettings{
+				MasterName: "mymaster",
+				Addresses:  []string{"sentinel-0:26379", "sentinel-1:26379"},
+			}},
+			want: "*redis.Client",
+		},
+		{
+			name: "cluster",
+			cfg: Settings{Address: "cluster:6379", Cluster: ClusterSettings{
+				Enabled: true,
+			}},
+			want: "*redis.ClusterClient",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := NewClient(tt.cfg)
+			if err != nil {
+				t.Fatalf("NewClient() error = %v", err)
+			}
+			defer c.Close()
+
+			var got string
+			switch c.(type) {
+			case *redis.Client:
+				got = "*redis.Client"
+			case *redis.ClusterClient:
+				got = "*redis.ClusterClient"
+			default:
+				got = "unknown"
+			}
+
+			if got != tt.want {
+				t.Fatalf("expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestNewClient_RejectsInvalidCombinations(t *testing.T) {
+	cases := map[string]Settings{
+		"sentinel without addresses": {Sentinel: SentinelSettings{MasterName: "mymaster"}},
+		"cluster with db":            {DB: 2, Cluster: ClusterSettings{Enabled: true}},
+		"sentinel and cluster": {
+			Sentinel: SentinelSettings{MasterName: "mymaster", Addresses: []string{"s:26379"}},
+			Cluster:  ClusterSettings{Enabled: true},
+		},
+	}
+
+	for name, cfg := range cases {
+		if _, err := NewClient(cfg); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestTLSSettings_Config(t *testing.T) {
+	disabled, err := TLSSettings{}.Config()
+	if err != nil || disabled != nil {
+		t.Fatalf("expected nil config when disabled, got %v, %v", disabled, err)
+	}
+
+	cfg, err := TLSSettings{Enabled: true, ServerName: "redis.internal"}.Config()
+	if err != nil {
+		t.Fatalf("Config() error = %v", err)
+	}
+	if cfg.ServerName != "redis.internal" {
+		t.Fatalf("expected server name %q, got %q", "redis.internal", cfg.ServerName)
+	}
+
+	bad := filepath.Join(t.TempDir(), "ca.pem")
+	os.WriteFile(bad, []byte("not a certificate"), 0600)
+
+	if _, err := (TLSSettings{Enabled: true, CAFile: bad}).Config(); err == nil {
+		t.Fatalf("expected error for CA file without certificates")
+	}
+}
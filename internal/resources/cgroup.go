@@ -0,0 +1,298 @@
+package resources
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	cgroupRoot     = "/sys/fs/cgroup"
+	procSelfCgroup = "/proc/self/cgroup"
+
+	// cgroup v1 reports "no limit" as a page-aligned MaxInt64.
+	cgroupV1Unlimited = 1 << 62
+)
+
+// cgroupLimits is what the worker's own cgroup allows. Zero values mean
+// unlimited.
+type cgroupLimits struct {
+	CPUQuota    float64 // cores, from the CFS quota
+	CPUs        []int   // cpuset, nil when unrestricted
+	MemoryBytes uint64
+}
+
+// readCgroupLimits reads the limits of the calling process's cgroup. root is
+// the cgroup mount and procFile is /proc/self/cgroup; both are parameters so
+// tests can point them at a fake tree.
+func readCgroupLimits(root, procFile string) (cgroupLimits, error) {
+	data, err := os.ReadFile(procFile)
+	if err != nil {
+		return cgroupLimits{}, err
+	}
+
+	if _, err := os.Stat(filepath.Join(root, "cgroup.controllers")); err == nil {
+		return readCgroupV2(root, cgroupPaths(data)[""])
+	}
+
+	return readCgroupV1(root, cgroupPaths(data))
+}
+
+// cgroupPaths maps each controller in /proc/self/cgroup to its path. The
+// unified v2 hierarchy is stored under the empty name.
+func cgroupPaths(data []byte) map[string]string {
+	paths := make(map[string]string)
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), ":", 3)
+		if len(parts) != 3 {
+			continue
+		}
+
+		if parts[1] == "" {
+			paths[""] = parts[2]
+			continue
+		}
+
+		for _, ctrl := range strings.Split(parts[1], ",") {
+			paths[ctrl] = parts[2]
+		}
+	}
+
+	return paths
+}
+
+// cgroupDirs lists the directories from the process's cgroup up to the
+// mount, since a parent's limit also applies. A container with its own cgroup
+// namespace may see a path that doesn't exist in its mount, in which case
+// only the mount itself is used.
+func cgroupDirs(mount, path string) []string {
+	leaf := filepath.Join(mount, path)
+	if _, err := os.Stat(leaf); err != nil {
+		return []string{mount}
+	}
+
+	dirs := []string{}
+	for dir := leaf; ; dir = filepath.Dir(dir) {
+		dirs = append(dirs, dir)
+		if dir == mount || !strings.HasPrefix(dir, mount) {
+			break
+		}
+	}
+
+	return dirs
+}
+
+func readCgroupV2(root, path string) (cgroupLimits, error) {
+	var limits cgroupLimits
+
+	for _, dir := range cgroupDirs(root, path) {
+		if quota, ok := readCPUMax(filepath.Join(dir, "cpu.max")); ok {
+			limits.CPUQuota = minLimit(limits.CPUQuota, quota)
+		}
+
+		if mem, ok := readMemoryLimit(filepath.Join(dir, "memory.max")); ok {
+			limits.MemoryBytes = minLimit(limits.MemoryBytes, mem)
+		}
+
+		// The effective set already accounts for every ancestor.
+		if limits.CPUs == nil {
+			limits.CPUs = readCPUSet(filepath.Join(dir, "cpuset.cpus.effective"))
+		}
+	}
+
+	return limits, nil
+}
+
+func readCgroupV1(root string, paths map[string]string) (cgroupLimits, error) {
+	var limits cgroupLimits
+
+	if mount := cgroupV1Mount(root, "cpu"); mount != "" {
+		for _, dir := range cgroupDirs(mount, paths["cpu"]) {
+			quota, qok := readInt(filepath.Join(dir, "cpu.cfs_quota_us"))
+			period, pok := readInt(filepath.Join(dir, "cpu.cfs_period_us"))
+			if qok && pok && quota > 0 && period > 0 {
+				limits.CPUQuota = minLimit(limits.CPUQuota, float64(quota)/float64(period))
+			}
+		}
+	}
+
+	if mount := cgroupV1Mount(root, "memory"); mount != "" {
+		for _, dir := range cgroupDirs(mount, paths["memory"]) {
+			if mem, ok := readMemoryLimit(filepath.Join(dir, "memory.limit_in_bytes")); ok {
+				limits.MemoryBytes = minLimit(limits.MemoryBytes, mem)
+			}
+		}
+	}
+
+	if mount := cgroupV1Mount(root, "cpuset"); mount != "" {
+		dirs := cgroupDirs(mount, paths["cpuset"])
+		limits.CPUs = readCPUSet(filepath.Join(dirs[0], "cpuset.cpus"))
+	}
+
+	return limits, nil
+}
+
+// cgroupV1Mount finds the hierarchy carrying a controller, which may be
+// mounted on its own or joined with others such as "cpu,cpuacct".
+func cgroupV1Mount(root, controller string) string {
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		return ""
+	}
+
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+
+		for _, ctrl := range strings.Split(e.Name(), ",") {
+			if ctrl == controller {
+				return filepath.Join(root, e.Name())
+			}
+		}
+	}
+
+	return ""
+}
+
+// readCPUMax parses cgroup v2 "cpu.max", written as "<quota> <period>" or
+// "max <period>".
+func readCPUMax(path string) (float64, bool) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, false
+	}
+
+	fields := strings.Fields(string(data))
+	if len(fields) != 2 || fields[0] == "max" {
+		return 0, false
+	}
+
+	quota, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return 0, false
+	}
+
+	period, err := strconv.ParseFloat(fields[1], 64)
+	if err != nil || period <= 0 {
+		return 0, false
+	}
+
+	return quota / period, true
+}
+
+func readMemoryLimit(path string) (uint64, bool) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, false
+	}
+
+	s := strings.TrimSpace(string(data))
+	if s == "max" {
+		return 0, false
+	}
+
+	v, err := strconv.ParseUint(s, 10, 64)
+	if err != nil || v >= cgroupV1Unlimited {
+		return 0, false
+	}
+
+	return v, true
+}
+
+func readInt(path string) (int64, bool) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, false
+	}
+
+	v, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+	return v, err == nil
+}
+
+func readCPUSet(path string) []int {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+
+	cpus, err := parseCPUList(strings.TrimSpace(string(data)))
+	if err != nil || len(cpus) == 0 {
+		return nil
+	}
+
+	return cpus
+}
+
+// parseCPUList parses the kernel's list format, e.g. "0-3,8,10-11".
+func parseCPUList(s string) ([]int, error) {
+	var cpus []int
+
+	for _, part := range strings.Split(s, ",") {
+		if part == "" {
+			continue
+		}
+
+		lo, hi, isRange := strings.Cut(part, "-")
+		start, err := strconv.Atoi(lo)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cpu list %q", s)
+		}
+
+		end := start
+		if isRange {
+			if end, err = strconv.Atoi(hi); err != nil || end < start {
+				return nil, fmt.Errorf("invalid cpu list %q", s)
+			}
+		}
+
+		for c := start; c <= end; c++ {
+			cpus = append(cpus, c)
+		}
+	}
+
+	return cpus, nil
+}
+
+// minLimit treats zero as unlimited.
+func minLimit[T float64 | uint64](current, limit T) T {
+	if current == 0 || limit < current {
+		return limit
+	}
+
+	return current
+}
+
+// usableCPUs returns the CPU ids the worker may pin containers to: the
+// cpuset if there is one, trimmed to whole cores of the CFS quota.
+func usableCPUs(hostThreads int, limits cgroupLimits) []int {
+	cpus := limits.CPUs
+	if cpus == nil {
+		cpus = cpuRange(hostThreads)
+	}
+
+	if limits.CPUQuota > 0 {
+		n := max(1, int(math.Floor(limits.CPUQuota)))
+		if n < len(cpus) {
+			cpus = cpus[:n]
+		}
+	}
+
+	return cpus
+}
+
+func cpuRange(n int) []int {
+	cpus := make([]int, 0, n)
+	for i := range n {
+		cpus = append(cpus, i)
+	}
+
+	return cpus
+}
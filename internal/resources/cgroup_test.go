@@ -0,0 +1,175 @@
+package resources
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/pupload/pupload/internal/logging"
+)
+
+// writeFiles lays out a fake filesystem under a temp dir and returns it.
+func writeFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+
+	root := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatalf("MkdirAll() error = %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatalf("WriteFile() error = %v", err)
+		}
+	}
+
+	return root
+}
+
+func TestCgroupV2_NestedLimits(t *testing.T) {
+	root := writeFiles(t, map[string]string{
+		"proc/self/cgroup":                               "0::/kubepods/pod1/ctr\n",
+		"cgroup/cgroup.controllers":                      "cpuset cpu memory\n",
+		"cgroup/kubepods/pod1/memory.max":                "1073741824\n",
+		"cgroup/kubepods/pod1/cpu.max":                   "max 100000\n",
+		"cgroup/kubepods/pod1/ctr/memory.max":            "max\n",
+		"cgroup/kubepods/pod1/ctr/cpu.max":               "250000 100000\n",
+		"cgroup/kubepods/pod1/ctr/cpuset.cpus.effective": "2-5,8\n",
+	})
+
+	limits, err := readCgroupLimits(filepath.Join(root, "cgroup"), filepath.Join(root, "proc/self/cgroup"))
+	if err != nil {
+		t.Fatalf("readCgroupLimits() error = %v", err)
+	}
+
+	if limits.CPUQuota != 2.5 {
+		t.Fatalf("expected cpu quota 2.5, got %v", limits.CPUQuota)
+	}
+	if limits.MemoryBytes != 1<<30 {
+		t.Fatalf("expected the pod's 1GiB memory limit, got %d", limits.MemoryBytes)
+	}
+	if !slices.Equal(limits.CPUs, []int{2, 3, 4, 5, 8}) {
+		t.Fatalf("unexpected cpuset %v", limits.CPUs)
+	}
+
+	if cpus := usableCPUs(64, limits); !slices.Equal(cpus, []int{2, 3}) {
+		t.Fatalf("expected two whole cores from the cpuset, got %v", cpus)
+	}
+}
+
+func TestCgroupV2_Unlimited(t *testing.T) {
+	root := writeFiles(t, map[string]string{
+		"proc/self/cgroup":          "0::/\n",
+		"cgroup/cgroup.controllers": "cpu memory\n",
+		"cgroup/cpu.max":            "max 100000\n",
+		"cgroup/memory.max":         "max\n",
+	})
+
+	limits, err := readCgroupLimits(filepath.Join(root, "cgroup"), filepath.Join(root, "proc/self/cgroup"))
+	if err != nil {
+		t.Fatalf("readCgroupLimits() error = %v", err)
+	}
+
+	if limits.CPUQuota != 0 || limits.MemoryBytes != 0 || limits.CPUs != nil {
+		t.Fatalf("expected no limits, got %+v", limits)
+	}
+
+	if cpus := usableCPUs(4, limits); !slices.Equal(cpus, []int{0, 1, 2, 3}) {
+		t.Fatalf("expected every host cpu, got %v", cpus)
+	}
+}
+
+func TestCgroupV1_NamespacedPaths(t *testing.T) {
+	// The process sees host paths in /proc/self/cgroup but its own cgroup is
+	// mounted at the hierarchy root, as inside a Docker container.
+	root := writeFiles(t, map[string]string{
+		"proc/self/cgroup": "12:memory:/docker/abc\n" +
+			"4:cpu,cpuacct:/docker/abc\n" +
+			"3:cpuset:/docker/abc\n",
+		"cgroup/cpu,cpuacct/cpu.cfs_quota_us":  "150000\n",
+		"cgroup/cpu,cpuacct/cpu.cfs_period_us": "100000\n",
+		"cgroup/memory/memory.limit_in_bytes":  "536870912\n",
+		"cgroup/cpuset/cpuset.cpus":            "0-7\n",
+	})
+
+	limits, err := readCgroupLimits(filepath.Join(root, "cgroup"), filepath.Join(root, "proc/self/cgroup"))
+	if err != nil {
+		t.Fatalf("readCgroupLimits() error = %v", err)
+	}
+
+	if limits.CPUQuota != 1.5 {
+		t.Fatalf("expected cpu quota 1.5, got %v", limits.CPUQuota)
+	}
+	if limits.MemoryBytes != 512<<20 {
+		t.Fatalf("expected 512MiB memory limit, got %d", limits.MemoryBytes)
+	}
+	if cpus := usableCPUs(16, limits); !slices.Equal(cpus, []int{0}) {
+		t.Fatalf("expected a single whole core, got %v", cpus)
+	}
+}
+
+func TestCgroupV1_UnlimitedMemory(t *testing.T) {
+	root := writeFiles(t, map[string]string{
+		"proc/self/cgroup":                     "12:memory:/\n4:cpu,cpuacct:/\n",
+		"cgroup/cpu,cpuacct/cpu.cfs_quota_us":  "-1\n",
+		"cgroup/cpu,cpuacct/cpu.cfs_period_us": "100000\n",
+		"cgroup/memory/memory.limit_in_bytes":  "9223372036854771712\n",
+	})
+
+	limits, err := readCgroupLimits(filepath.Join(root, "cgroup"), filepath.Join(root, "proc/self/cgroup"))
+	if err != nil {
+		t.Fatalf("readCgroupLimits() error = %v", err)
+	}
+
+	if limits.CPUQuota != 0 || limits.MemoryBytes != 0 {
+		t.Fatalf("expected no limits, got %+v", limits)
+	}
+}
+
+func TestConfiguredCapacity(t *testing.T) {
+	log := logging.ForService("test")
+	detected := capacity{cpus: []int{2, 3, 4, 5}, memory: 4096, storage: 10240}
+
+	c, err := configuredCapacity(ResourceSettings{MaxCPU: "auto", MaxMemory: "auto", MaxStorage: "auto"}, detected, log)
+	if err != nil {
+		t.Fatalf("configuredCapacity() error = %v", err)
+	}
+	if !slices.Equal(c.cpus, detected.cpus) || c.memory != 4096 || c.storage != 10240 {
+		t.Fatalf("expected detected capacity, got %+v", c)
+	}
+
+	c, err = configuredCapacity(ResourceSettings{MaxCPU: "2", MaxMemory: "8gb", MaxStorage: "1gb"}, detected, log)
+	if err != nil {
+		t.Fatalf("configuredCapacity() error = %v", err)
+	}
+	if !slices.Equal(c.cpus, []int{2, 3}) {
+		t.Fatalf("expected the first two allowed cpus, got %v", c.cpus)
+	}
+	if c.memory != 8192 || c.storage != 1024 {
+		t.Fatalf("expected configured memory and storage, got %+v", c)
+	}
+
+	c, _ = configuredCapacity(ResourceSettings{MaxCPU: "16", MaxMemory: "auto", MaxStorage: "auto"}, detected, log)
+	if len(c.cpus) != 4 {
+		t.Fatalf("expected cpus capped at the cpuset, got %v", c.cpus)
+	}
+
+	if _, err := configuredCapacity(ResourceSettings{MaxCPU: "auto", MaxMemory: "auto", MaxStorage: "auto"}, capacity{}, log); err == nil {
+		t.Fatalf("expected an error when auto capacity can't be detected")
+	}
+}
+
+func TestParseCPUList(t *testing.T) {
+	cpus, err := parseCPUList("0-2,5,7-8")
+	if err != nil {
+		t.Fatalf("parseCPUList() error = %v", err)
+	}
+	if !slices.Equal(cpus, []int{0, 1, 2, 5, 7, 8}) {
+		t.Fatalf("unexpected cpus %v", cpus)
+	}
+
+	if _, err := parseCPUList("3-1"); err == nil {
+		t.Fatalf("expected an error for a reversed range")
+	}
+}
@@ -583,25 +583,25 @@ var StandardTierMap = map[string]ResourceDefinition{
 	"gi-large":  GI_Large,
 	"gi-xlarge": GI_XLarge,
 
-	// // Multi-GPU NVIDIA
-	// "pn-2xlarge": PN_2XLarge,
-	// "pn-4xlarge": PN_4XLarge,
-	// "pn-8xlarge": PN_8XLarge,
-
-	// // Multi-GPU AMD
-	// "pa-2xlarge": PA_2XLarge,
-	// "pa-4xlarge": PA_4XLarge,
-	// "pa-8xlarge": PA_8XLarge,
-
-	// // High-Memory NVIDIA
-	// "hn-xlarge":  HN_XLarge,
-	// "hn-2xlarge": HN_2XLarge,
-	// "hn-4xlarge": HN_4XLarge,
-	// "hn-8xlarge": HN_8XLarge,
-
-	// // High-Memory AMD
-	// "ha-xlarge":  HA_XLarge,
-	// "ha-2xlarge": HA_2XLarge,
-	// "ha-4xlarge": HA_4XLarge,
-	// "ha-8xlarge": HA_8XLarge,
+	// Multi-GPU NVIDIA
+	"pn-2xlarge": PN_2XLarge,
+	"pn-4xlarge": PN_4XLarge,
+	"pn-8xlarge": PN_8XLarge,
+
+	// Multi-GPU AMD
+	"pa-2xlarge": PA_2XLarge,
+	"pa-4xlarge": PA_4XLarge,
+	"pa-8xlarge": PA_8XLarge,
+
+	// High-Memory NVIDIA
+	"hn-xlarge":  HN_XLarge,
+	"hn-2xlarge": HN_2XLarge,
+	"hn-4xlarge": HN_4XLarge,
+	"hn-8xlarge": HN_8XLarge,
+
+	// High-Memory AMD
+	"ha-xlarge":  HA_XLarge,
+	"ha-2xlarge": HA_2XLarge,
+	"ha-4xlarge": HA_4XLarge,
+	"ha-8xlarge": HA_8XLarge,
 }
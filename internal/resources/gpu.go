@@ -2,6 +2,7 @@ package resources
 
 import (
 	"slices"
+	"strconv"
 	"strings"
 
 	"github.com/NVIDIA/go-nvml/pkg/nvml"
@@ -9,24 +10,71 @@ import (
 	"github.com/moby/moby/api/types/container"
 )
 
+// GPUInfo is one schedulable GPU unit: a whole card, or a MIG slice of one.
 type GPUInfo struct {
 	vendor string
 	memory uint64
+	id     string // device UUID (GPU-… or MIG-…), or index when the UUID is unknown
+	pci    string // bus address of the physical card
+	parent string // UUID of the physical card for MIG slices
+
+	features []string // engines such as nvenc the unit can use
+}
+
+// gpuDetector reports the GPU units present on the host. It is an interface
+// so tests can describe hardware that isn't there.
+type gpuDetector interface {
+	Detect() ([]GPUInfo, error)
+}
+
+type hostGPUDetector struct{}
+
+// getDeviceRequest asks the engine for exactly the reserved devices. All
+// GPUs in a reservation share a vendor, so one request covers them.
+// getDeviceEnv hides every GPU but the reserved ones from a host process.
+// Without a reservation CUDA sees no devices at all.
+func getDeviceEnv(gpus []GPUInfo) []string {
+	ids := make([]string, 0, len(gpus))
+	for _, g := range gpus {
+		ids = append(ids, g.id)
+	}
+
+	visible := strings.Join(ids, ",")
+	if len(gpus) > 0 && gpus[0].vendor == "amd" {
+		return []string{"ROCR_VISIBLE_DEVICES=" + visible}
+	}
+
+	return []string{"CUDA_VISIBLE_DEVICES=" + visible}
 }
 
-func getDeviceRequest(g GPUInfo) []container.DeviceRequest {
+func getDeviceRequest(gpus []GPUInfo, features []string) []container.DeviceRequest {
+	if len(gpus) == 0 {
+		return nil
+	}
+
+	ids := make([]string, 0, len(gpus))
+	for _, g := range gpus {
+		ids = append(ids, g.id)
+	}
+
+	// The video codecs are only mounted into the container when asked for.
+	caps := []string{"gpu"}
+	if slices.Contains(features, GPUFeatureNVENC) || slices.Contains(features, GPUFeatureNVDEC) {
+		caps = append(caps, "video")
+	}
+
 	var dr []container.DeviceRequest
-	if g.vendor == "nvidia" {
+	if gpus[0].vendor == "nvidia" {
 		dr = []container.DeviceRequest{{
 			Driver:       "nvidia",
-			Count:        1,
-			Capabilities: [][]string{{"all"}},
+			DeviceIDs:    ids,
+			Capabilities: [][]string{caps},
 		}}
 	}
-	if g.vendor == "amd" {
+	if gpus[0].vendor == "amd" {
 		dr = []container.DeviceRequest{{
 			Driver:       "amd",
-			Count:        1,
+			DeviceIDs:    ids,
 			Capabilities: [][]string{{"gpu"}},
 		}}
 	}
@@ -34,7 +82,7 @@ func getDeviceRequest(g GPUInfo) []container.DeviceRequest {
 	return dr
 }
 
-func detectGPUResources() ([]GPUInfo, error) {
+func (hostGPUDetector) Detect() ([]GPUInfo, error) {
 	info, err := ghw.GPU()
 	if err != nil {
 		return nil, err
@@ -42,42 +90,67 @@ func detectGPUResources() ([]GPUInfo, error) {
 
 	gpus := make([]GPUInfo, 0)
 
-	for _, g := range info.GraphicsCards {
-		gInfo := classifyGPU(g)
-		if gInfo == nil {
-			continue
+	nvidia := false
+	for _, card := range info.GraphicsCards {
+		if isNVIDIA(card) {
+			nvidia = true
 		}
+	}
 
-		gpus = append(gpus, *gInfo)
+	if nvidia {
+		if ret := nvml.Init(); ret == nvml.SUCCESS {
+			defer nvml.Shutdown()
+		} else {
+			nvidia = false
+		}
 	}
 
-	return gpus, nil
-}
+	for _, card := range info.GraphicsCards {
+		if !isNVIDIA(card) {
+			continue
+		}
 
-func classifyGPU(card *ghw.GraphicsCard) *GPUInfo {
-	driver := card.DeviceInfo.Driver
-	vendor := strings.ToLower(card.DeviceInfo.Vendor.Name)
+		units := []GPUInfo(nil)
+		if nvidia {
+			units = getNVIDIADevices(card.DeviceInfo.Address)
+		}
 
-	if driver == "nvidia" || strings.Contains(strings.ToLower(vendor), "nvidia") {
-		return &GPUInfo{
-			vendor: "nvidia",
-			memory: getNVIDIAMemory(card.DeviceInfo.Address),
+		// Without NVML we still know the card is there, just not its size.
+		if len(units) == 0 {
+			units = []GPUInfo{{
+				vendor: "nvidia",
+				id:     strconv.Itoa(card.Index),
+				pci:    card.DeviceInfo.Address,
+			}}
 		}
+
+		gpus = append(gpus, units...)
 	}
 
-	return nil
+	return gpus, nil
 }
 
-func getNVIDIAMemory(pciAddr string) uint64 {
-	ret := nvml.Init()
-	if ret != nvml.SUCCESS {
+func isNVIDIA(card *ghw.GraphicsCard) bool {
+	if card.DeviceInfo == nil {
+		return false
+	}
 
+	driver := card.DeviceInfo.Driver
+	vendor := ""
+	if card.DeviceInfo.Vendor != nil {
+		vendor = strings.ToLower(card.DeviceInfo.Vendor.Name)
 	}
-	defer nvml.Shutdown()
 
+	return driver == "nvidia" || strings.Contains(vendor, "nvidia")
+}
+
+// getNVIDIADevices returns the schedulable units of the card at pciAddr. A
+// card in MIG mode is only reachable through its slices, so those are
+// returned in its place. NVML must already be initialised.
+func getNVIDIADevices(pciAddr string) []GPUInfo {
 	count, ret := nvml.DeviceGetCount()
 	if ret != nvml.SUCCESS {
-
+		return nil
 	}
 
 	for i := 0; i < count; i++ {
@@ -98,15 +171,127 @@ func getNVIDIAMemory(pciAddr string) uint64 {
 
 		addr := string(pci.BusIdLegacy[:nullTerminator])
 
-		if strings.EqualFold(addr, pciAddr) {
-			memory, ret := device.GetMemoryInfo()
-			if ret != nvml.SUCCESS {
-				continue
-			}
+		if !strings.EqualFold(addr, pciAddr) {
+			continue
+		}
+
+		uuid, ret := device.GetUUID()
+		if ret != nvml.SUCCESS {
+			uuid = strconv.Itoa(i)
+		}
 
-			return memory.Total / (1024 * 1024)
+		if mode, _, ret := device.GetMigMode(); ret == nvml.SUCCESS && mode == nvml.DEVICE_MIG_ENABLE {
+			return getMIGDevices(device, uuid, pciAddr)
 		}
+
+		memory, ret := device.GetMemoryInfo()
+		if ret != nvml.SUCCESS {
+			continue
+		}
+
+		return []GPUInfo{{
+			vendor:   "nvidia",
+			memory:   memory.Total / (1024 * 1024),
+			id:       uuid,
+			pci:      pciAddr,
+			features: getNVIDIAFeatures(device),
+		}}
+	}
+
+	return nil
+}
+
+// getNVIDIAFeatures reports the video engines of a whole card. MIG slices
+// are left without them since most profiles carry no encoder.
+func getNVIDIAFeatures(device nvml.Device) []string {
+	var features []string
+
+	if capacity, ret := device.GetEncoderCapacity(nvml.ENCODER_QUERY_H264); ret == nvml.SUCCESS && capacity > 0 {
+		features = append(features, GPUFeatureNVENC)
+	}
+
+	if _, _, ret := device.GetDecoderUtilization(); ret == nvml.SUCCESS {
+		features = append(features, GPUFeatureNVDEC)
+	}
+
+	return features
+}
+
+func getMIGDevices(device nvml.Device, parent, pciAddr string) []GPUInfo {
+	n, ret := device.GetMaxMigDeviceCount()
+	if ret != nvml.SUCCESS {
+		return nil
+	}
+
+	units := make([]GPUInfo, 0, n)
+	for i := 0; i < n; i++ {
+		mig, ret := device.GetMigDeviceHandleByIndex(i)
+		if ret != nvml.SUCCESS {
+			// Slots without a configured instance are skipped.
+			continue
+		}
+
+		uuid, ret := mig.GetUUID()
+		if ret != nvml.SUCCESS {
+			continue
+		}
+
+		memory, ret := mig.GetMemoryInfo()
+		if ret != nvml.SUCCESS {
+			continue
+		}
+
+		units = append(units, GPUInfo{
+			vendor: "nvidia",
+			memory: memory.Total / (1024 * 1024),
+			id:     uuid,
+			pci:    pciAddr,
+			parent: parent,
+		})
+	}
+
+	return units
+}
+
+// filterAllowedGPUs keeps the units named in allowed by UUID or PCI address.
+// Naming a card also allows its MIG slices. An empty list allows everything.
+func filterAllowedGPUs(gpus []GPUInfo, allowed []string) []GPUInfo {
+	if len(allowed) == 0 {
+		return gpus
+	}
+
+	keep := make([]GPUInfo, 0, len(gpus))
+	for _, g := range gpus {
+		for _, a := range allowed {
+			if matchesGPU(g, a) {
+				keep = append(keep, g)
+				break
+			}
+		}
+	}
+
+	return keep
+}
+
+func matchesGPU(g GPUInfo, s string) bool {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return false
+	}
+
+	if strings.EqualFold(g.id, s) || (g.parent != "" && strings.EqualFold(g.parent, s)) {
+		return true
+	}
+
+	return g.pci != "" && strings.EqualFold(normalizePCI(g.pci), normalizePCI(s))
+}
+
+// normalizePCI accepts both the short "01:00.0" and full "0000:01:00.0"
+// forms of a bus address.
+func normalizePCI(addr string) string {
+	if strings.Count(addr, ":") == 1 {
+		return "0000:" + addr
 	}
 
-	return 0
+	return addr
 }
@@ -0,0 +1,121 @@
+package resources
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+type fakeGPUDetector struct {
+	gpus []GPUInfo
+	err  error
+}
+
+func (f fakeGPUDetector) Detect() ([]GPUInfo, error) {
+	return f.gpus, f.err
+}
+
+// A host with two whole 80GB cards and a third card split into two MIG
+// slices.
+var testGPUs = []GPUInfo{
+	{vendor: "nvidia", memory: 81920, id: "GPU-a", pci: "0000:01:00.0"},
+	{vendor: "nvidia", memory: 81920, id: "GPU-b", pci: "0000:02:00.0"},
+	{vendor: "nvidia", memory: 40960, id: "MIG-c1", pci: "0000:03:00.0", parent: "GPU-c"},
+	{vendor: "nvidia", memory: 40960, id: "MIG-c2", pci: "0000:03:00.0", parent: "GPU-c"},
+}
+
+func newTestGPUManager(t *testing.T, allowed ...string) *ResourceManager {
+	t.Helper()
+
+	cfg := ResourceSettings{AllowedGPUs: allowed}
+	return createResourceManager(cfg, fakeGPUDetector{gpus: testGPUs}, capacity{cpuRange(256), 1 << 20, 1 << 30})
+}
+
+func reservedIDs(t *testing.T, rm *ResourceManager, tier string) []string {
+	t.Helper()
+
+	r, err := rm.Reserve(tier)
+	if err != nil {
+		t.Fatalf("Reserve(%s) error = %v", tier, err)
+	}
+
+	res, err := rm.GenerateContainerResource(r)
+	if err != nil {
+		t.Fatalf("GenerateContainerResource() error = %v", err)
+	}
+	if len(res.DeviceRequests) != 1 {
+		t.Fatalf("expected one device request, got %+v", res.DeviceRequests)
+	}
+
+	return res.DeviceRequests[0].DeviceIDs
+}
+
+func TestGPU_MIGSlicesAreSchedulable(t *testing.T) {
+	rm := newTestGPUManager(t)
+
+	// Best fit: the 24gb tier goes to slices before whole cards.
+	first := reservedIDs(t, rm, "gn-large")
+	second := reservedIDs(t, rm, "gn-large")
+	third := reservedIDs(t, rm, "gn-large")
+
+	got := slices.Concat(first, second, third)
+	want := []string{"MIG-c1", "MIG-c2", "GPU-a"}
+	if !slices.Equal(got, want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+}
+
+func TestGPU_MultiGPUTierUsesDistinctWholeCards(t *testing.T) {
+	rm := newTestGPUManager(t)
+
+	ids := reservedIDs(t, rm, "hn-2xlarge")
+	if !slices.Equal(ids, []string{"GPU-a", "GPU-b"}) {
+		t.Fatalf("expected both whole cards, got %v", ids)
+	}
+
+	// Only MIG slices remain and those can't be combined.
+	if _, err := rm.Reserve("pn-2xlarge"); !errors.Is(err, ErrInsufficientResources) {
+		t.Fatalf("expected ErrInsufficientResources, got %v", err)
+	}
+
+	if _, ok := rm.GetValidTierMap()["hn-xlarge"]; ok {
+		t.Fatalf("hn-xlarge advertised with only MIG slices free")
+	}
+}
+
+func TestGPU_AllowedGPUs(t *testing.T) {
+	tests := []struct {
+		name    string
+		allowed []string
+		want    []string
+	}{
+		{"empty allows all", nil, []string{"GPU-a", "GPU-b", "MIG-c1", "MIG-c2"}},
+		{"by uuid", []string{"GPU-b"}, []string{"GPU-b"}},
+		{"by short pci address", []string{"01:00.0"}, []string{"GPU-a"}},
+		{"parent allows slices", []string{"gpu-c"}, []string{"MIG-c1", "MIG-c2"}},
+		{"single slice", []string{"MIG-c2"}, []string{"MIG-c2"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rm := newTestGPUManager(t, tt.allowed...)
+
+			var got []string
+			for _, g := range rm.gpus {
+				got = append(got, g.id)
+			}
+
+			if !slices.Equal(got, tt.want) {
+				t.Fatalf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestGPU_DetectionFailureLeavesNoGPUs(t *testing.T) {
+	rm := createResourceManager(ResourceSettings{}, fakeGPUDetector{err: errors.New("no nvml")}, capacity{cpuRange(4), 8192, 102400})
+
+	if _, err := rm.Reserve("gn-small"); !errors.Is(err, ErrInsufficientResources) {
+		t.Fatalf("expected ErrInsufficientResources, got %v", err)
+	}
+}
@@ -1,11 +1,17 @@
 package resources
 
 import (
+	"cmp"
+	"errors"
 	"fmt"
 	"log/slog"
+	"maps"
 	"os"
 	"regexp"
+	"slices"
 	"strconv"
+	"strings"
+	"sync"
 
 	"github.com/jaypipes/ghw"
 	"github.com/moby/moby/api/types/container"
@@ -13,19 +19,39 @@ import (
 	"github.com/shirou/gopsutil/v3/disk"
 )
 
+var ErrInsufficientResources = errors.New("insufficient resources")
+
 type ResourceManager struct {
 	MaxCPU       CPUCore
 	MaxMemoryMB  MemoryMB
 	MaxStorageMB StorageMB
 
+	mu sync.Mutex
+
 	currMemMB     MemoryMB
 	currStorageMB StorageMB
 
-	gpus []GPUInfo
+	cpus     []int // host CPU ids available to containers
+	cpuInUse map[int]bool
+
+	gpus     []GPUInfo
+	gpuInUse []bool
+
+	tiers TierSet
 
 	log *slog.Logger
 }
 
+// Reservation is the set of resources held by one container. The CPU and GPU
+// fields name specific devices so limits can pin the container to them.
+type Reservation struct {
+	Tier    string
+	CPUs    []int
+	GPUs    []int // indexes into the manager's GPU list
+	Memory  MemoryMB
+	Storage StorageMB
+}
+
 type GPUResources struct {
 	Vendor      string
 	Features    []string
@@ -38,79 +64,203 @@ type ResourceSettings struct {
 	MaxMemory  string // 1G, 512MB, etc. or auto
 	MaxStorage string // 1G, 512MB, etc. or auto
 
-	AllowedGPUs []string // pcie of allowed GPU's
+	AllowedGPUs []string // UUIDs or PCI addresses of usable GPUs; empty allows all
+	StoragePath string   // where free storage is measured; the engine's data root when set by the worker
+
+	Tiers map[string]ResourceDefinition // custom tiers, added to or replacing the standard set
 }
 
 func CreateResourceManager(cfg ResourceSettings) (*ResourceManager, error) {
+	tiers, err := Tiers(cfg.Tiers)
+	if err != nil {
+		return nil, err
+	}
+
+	log := logging.ForService("resource-manager")
+
+	c, err := configuredCapacity(cfg, detectCapacity(cfg.StoragePath, log), log)
+	if err != nil {
+		return nil, err
+	}
+
+	rm := createResourceManager(cfg, hostGPUDetector{}, c)
+	rm.tiers = tiers
+
+	return rm, nil
+}
+
+// capacity is what the worker offers to containers.
+type capacity struct {
+	cpus    []int
+	memory  MemoryMB
+	storage StorageMB
+}
+
+// detectCapacity measures what the worker can actually use: host totals
+// narrowed by its own cgroup, and free space where the engine keeps
+// containers. Anything that can't be measured is left zero.
+func detectCapacity(storagePath string, log *slog.Logger) capacity {
+	var c capacity
+
+	limits, err := readCgroupLimits(cgroupRoot, procSelfCgroup)
+	if err != nil {
+		log.Debug("no cgroup limits found", "err", err)
+	}
+
+	hostThreads := 0
+	if cpu, err := ghw.CPU(); err == nil {
+		hostThreads = int(cpu.TotalHardwareThreads)
+		c.cpus = usableCPUs(hostThreads, limits)
+	} else {
+		log.Warn("unable to detect cpus", "err", err)
+	}
+
+	var hostMem MemoryMB
+	if memory, err := ghw.Memory(); err == nil {
+		hostMem = MemoryMB(memory.TotalPhysicalBytes / (1024 * 1024))
+		c.memory = hostMem
+		if limits.MemoryBytes > 0 {
+			c.memory = min(c.memory, MemoryMB(limits.MemoryBytes/(1024*1024)))
+		}
+	} else {
+		log.Warn("unable to detect memory", "err", err)
+	}
+
+	path, err := storageDir(storagePath)
+	if err == nil {
+		if usage, err := disk.Usage(path); err == nil {
+			c.storage = StorageMB(usage.Free / (1024 * 1024))
+		} else {
+			log.Warn("unable to measure storage", "path", path, "err", err)
+		}
+	}
+
+	log.Info("detected capacity",
+		"host_cpus", hostThreads,
+		"cgroup_cpu_quota", limits.CPUQuota,
+		"cgroup_cpuset", limits.CPUs,
+		"cpus", len(c.cpus),
+		"host_memory_mb", hostMem,
+		"cgroup_memory_mb", limits.MemoryBytes/(1024*1024),
+		"memory_mb", c.memory,
+		"storage_path", path,
+		"storage_mb", c.storage,
+	)
+
+	return c
+}
 
-	var cpu CPUCore
-	var mem MemoryMB
-	var sto StorageMB
+// storageDir is where free space is measured. The engine's data root is
+// preferred, but a worker in a container often can't see it, in which case
+// the working directory is used.
+func storageDir(dataRoot string) (string, error) {
+	if dataRoot != "" {
+		if _, err := os.Stat(dataRoot); err == nil {
+			return dataRoot, nil
+		}
+	}
+
+	return os.Getwd()
+}
+
+// configuredCapacity applies the settings to what was detected. Memory and
+// storage may be set above the detected values, with a warning, but CPUs
+// can't exceed the cores the worker is allowed to pin containers to.
+func configuredCapacity(cfg ResourceSettings, detected capacity, log *slog.Logger) (capacity, error) {
+	c := detected
 
 	if cfg.MaxCPU == "auto" {
-		c, err := ghw.CPU()
-		if err != nil {
-			return nil, err
+		if len(c.cpus) == 0 {
+			return capacity{}, fmt.Errorf("unable to detect cpus, set MaxCPU explicitly")
 		}
-		cpu = CPUCore(c.TotalHardwareThreads)
 	} else {
-		tmp, err := parseCPUCore(cfg.MaxCPU)
+		n, err := parseCPUCore(cfg.MaxCPU)
 		if err != nil {
-			return nil, err
+			return capacity{}, err
 		}
 
-		cpu = tmp
+		switch {
+		case len(detected.cpus) == 0:
+			c.cpus = cpuRange(int(n))
+		case int(n) > len(detected.cpus):
+			log.Warn("configured cpus exceed what the worker may use, capping", "configured", n, "detected", len(detected.cpus))
+		default:
+			c.cpus = detected.cpus[:n]
+		}
 	}
 
 	if cfg.MaxMemory == "auto" {
-		memory, err := ghw.Memory()
-		if err != nil {
-			return nil, err
+		if c.memory == 0 {
+			return capacity{}, fmt.Errorf("unable to detect memory, set MaxMemory explicitly")
 		}
-
-		mem = MemoryMB(memory.TotalPhysicalBytes / (1024 * 1024))
 	} else {
-		tmp, err := parseMemMB(cfg.MaxMemory)
+		mem, err := parseMemMB(cfg.MaxMemory)
 		if err != nil {
-			return nil, fmt.Errorf("unable to parse max memory: %w", err)
+			return capacity{}, fmt.Errorf("unable to parse max memory: %w", err)
 		}
-		mem = tmp
+
+		if detected.memory > 0 && mem > detected.memory {
+			log.Warn("configured memory exceeds detected memory", "configured_mb", mem, "detected_mb", detected.memory)
+		}
+		c.memory = mem
 	}
 
 	if cfg.MaxStorage == "auto" {
-		dir, err := os.Getwd()
-		if err != nil {
-			return nil, err
+		if c.storage == 0 {
+			return capacity{}, fmt.Errorf("unable to detect storage, set MaxStorage explicitly")
 		}
-
-		storage, err := disk.Usage(dir)
+	} else {
+		sto, err := parseStorageMB(cfg.MaxStorage)
 		if err != nil {
-			return nil, err
+			return capacity{}, fmt.Errorf("unable to parse max storage: %w", err)
 		}
 
-		sto = StorageMB(storage.Free / (1024 * 1024))
-	} else {
-		tmp, err := parseStorageMB(cfg.MaxStorage)
-		if err != nil {
-			return nil, fmt.Errorf("unable to parse max storage: %w", err)
+		if detected.storage > 0 && sto > detected.storage {
+			log.Warn("configured storage exceeds free space", "configured_mb", sto, "detected_mb", detected.storage)
 		}
-		sto = tmp
+		c.storage = sto
 	}
 
-	gpus, err := detectGPUResources()
+	log.Info("resource capacity",
+		"cpus", len(c.cpus), "configured_cpu", cfg.MaxCPU,
+		"memory_mb", c.memory, "configured_memory", cfg.MaxMemory,
+		"storage_mb", c.storage, "configured_storage", cfg.MaxStorage,
+	)
+
+	return c, nil
+}
+
+func createResourceManager(cfg ResourceSettings, detector gpuDetector, c capacity) *ResourceManager {
+	log := logging.ForService("resource-manager")
+
+	gpus, err := detector.Detect()
 	if err != nil {
+		log.Warn("gpu detection failed, continuing without gpus", "err", err)
 		gpus = []GPUInfo{}
 	}
 
+	gpus = filterAllowedGPUs(gpus, cfg.AllowedGPUs)
+	for _, g := range gpus {
+		log.Info("gpu available", "id", g.id, "pci", g.pci, "memory_mb", g.memory, "mig", g.parent != "", "features", g.features)
+	}
+
+	return newResourceManager(c, gpus)
+}
+
+func newResourceManager(c capacity, gpus []GPUInfo) *ResourceManager {
 	return &ResourceManager{
-		MaxCPU:       cpu,
-		MaxMemoryMB:  mem,
-		MaxStorageMB: sto,
+		MaxCPU:       CPUCore(len(c.cpus)),
+		MaxMemoryMB:  c.memory,
+		MaxStorageMB: c.storage,
 
-		gpus: gpus,
+		cpus:     c.cpus,
+		cpuInUse: make(map[int]bool),
+
+		gpus:     gpus,
+		gpuInUse: make([]bool, len(gpus)),
 
 		log: logging.ForService("resource-manager"),
-	}, nil
+	}
 }
 
 func parseStorageMB(s string) (StorageMB, error) {
@@ -166,31 +316,177 @@ func parseCPUCore(s string) (CPUCore, error) {
 	return CPUCore(val), err
 }
 
-func (rm *ResourceManager) Reserve(tierName string) error {
-	resource, ok := StandardTierMap[tierName]
+// Reserve takes everything the tier needs or nothing at all, returning
+// ErrInsufficientResources when any part of it is exhausted.
+func (rm *ResourceManager) Reserve(tierName string) (*Reservation, error) {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+
+	resource, ok := rm.tiers.Get(tierName)
 	if !ok {
-		return fmt.Errorf("tier not found")
+		return nil, fmt.Errorf("tier not found")
 	}
 
-	rm.currMemMB += resource.Memory.Normalize()
-	rm.currStorageMB += resource.Storage.Normalize()
+	mem := resource.Memory.Normalize()
+	sto := resource.Storage.Normalize()
 
-	return nil
-}
+	if rm.currMemMB+mem > rm.MaxMemoryMB {
+		return nil, fmt.Errorf("%w: memory for tier %s", ErrInsufficientResources, tierName)
+	}
+
+	if rm.currStorageMB+sto > rm.MaxStorageMB {
+		return nil, fmt.Errorf("%w: storage for tier %s", ErrInsufficientResources, tierName)
+	}
 
-func (rm *ResourceManager) Release(tierName string) error {
-	resource, ok := StandardTierMap[tierName]
+	cpus := rm.freeCPUs(int(resource.CPU.Normalize()))
+	if cpus == nil {
+		return nil, fmt.Errorf("%w: cpu for tier %s", ErrInsufficientResources, tierName)
+	}
+
+	gpus, ok := rm.freeGPUs(resource.GPU)
 	if !ok {
-		return fmt.Errorf("tier not found")
+		return nil, fmt.Errorf("%w: gpu for tier %s", ErrInsufficientResources, tierName)
+	}
+
+	rm.currMemMB += mem
+	rm.currStorageMB += sto
+	for _, c := range cpus {
+		rm.cpuInUse[c] = true
+	}
+	for _, g := range gpus {
+		rm.gpuInUse[g] = true
+	}
+
+	return &Reservation{
+		Tier:    tierName,
+		CPUs:    cpus,
+		GPUs:    gpus,
+		Memory:  mem,
+		Storage: sto,
+	}, nil
+}
+
+func (rm *ResourceManager) Release(r *Reservation) error {
+	if r == nil {
+		return fmt.Errorf("no reservation to release")
 	}
 
-	rm.currMemMB -= resource.Memory.Normalize()
-	rm.currStorageMB -= resource.Storage.Normalize()
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+
+	if r.Memory > rm.currMemMB || r.Storage > rm.currStorageMB {
+		return fmt.Errorf("reservation for tier %s was already released", r.Tier)
+	}
+
+	rm.currMemMB -= r.Memory
+	rm.currStorageMB -= r.Storage
+	for _, c := range r.CPUs {
+		delete(rm.cpuInUse, c)
+	}
+	for _, g := range r.GPUs {
+		rm.gpuInUse[g] = false
+	}
+
+	// Guard against a double release handing the same devices out twice.
+	r.Memory, r.Storage, r.CPUs, r.GPUs = 0, 0, nil, nil
 
 	return nil
 }
 
+// freeCPUs returns n unused CPU ids, or nil when fewer than n are free.
+func (rm *ResourceManager) freeCPUs(n int) []int {
+	free := make([]int, 0, n)
+	for _, c := range rm.cpus {
+		if len(free) == n {
+			break
+		}
+		if !rm.cpuInUse[c] {
+			free = append(free, c)
+		}
+	}
+
+	if n == 0 || len(free) < n {
+		return nil
+	}
+
+	return free
+}
+
+// freeGPUs returns unused GPUs matching def. All devices come from a single
+// vendor so they can share one device request, and the smallest units that
+// fit are taken first so large cards stay free for tiers that need them.
+func (rm *ResourceManager) freeGPUs(def *GPUDefinition) ([]int, bool) {
+	if def == nil {
+		return nil, true
+	}
+
+	want := int(def.Count.Normalize())
+	if want <= 0 {
+		want = 1
+	}
+
+	byVendor := make(map[string][]int)
+	for i, gpu := range rm.gpus {
+		if rm.gpuInUse[i] || !gpuMatches(def, gpu) {
+			continue
+		}
+
+		// A CUDA process can only address one MIG slice, so slices are
+		// never combined into a multi-GPU reservation.
+		if want > 1 && gpu.parent != "" {
+			continue
+		}
+
+		byVendor[gpu.vendor] = append(byVendor[gpu.vendor], i)
+	}
+
+	for _, vendor := range slices.Sorted(maps.Keys(byVendor)) {
+		candidates := byVendor[vendor]
+		if len(candidates) < want {
+			continue
+		}
+
+		slices.SortStableFunc(candidates, func(a, b int) int {
+			return cmp.Compare(rm.gpus[a].memory, rm.gpus[b].memory)
+		})
+
+		return candidates[:want], true
+	}
+
+	return nil, false
+}
+
+func gpuMatches(def *GPUDefinition, gpu GPUInfo) bool {
+	vendorOK := def.Vendor == gpu.vendor || def.Vendor == "any"
+	for _, f := range def.Features {
+		if !slices.Contains(gpu.features, f) {
+			return false
+		}
+	}
+
+	return vendorOK && def.Memory.Normalize() <= GPUMemoryMB(gpu.memory)
+}
+
+// Tiers returns the tier set the manager schedules against.
+func (rm *ResourceManager) Tiers() TierSet {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+
+	return rm.tiers
+}
+
+// SetTiers replaces the tier set, typically once the sync plane's registry
+// has been merged in. Reservations already held are unaffected.
+func (rm *ResourceManager) SetTiers(tiers TierSet) {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+
+	rm.tiers = tiers
+}
+
 func (rm *ResourceManager) GetValidTierMap() map[string]int {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
 
 	validTiers := make(map[string]int)
 
@@ -198,14 +494,11 @@ func (rm *ResourceManager) GetValidTierMap() map[string]int {
 	validStorage := rm.MaxStorageMB - StorageMB(rm.currStorageMB)
 
 	i := 2
-	for name, resource := range StandardTierMap {
-		cpuValid := rm.MaxCPU >= resource.CPU.Normalize()
+	for name, resource := range rm.tiers.all() {
+		cpuValid := rm.freeCPUs(int(resource.CPU.Normalize())) != nil
 		memValid := validMem >= resource.Memory.Normalize()
 		storageValid := validStorage >= resource.Storage.Normalize()
-		gpuValid := resource.GPU == nil
-		for _, gpu := range rm.gpus {
-			gpuValid = gpuValid || ((resource.GPU.Vendor == gpu.vendor || resource.GPU.Vendor == "any") && resource.GPU.Memory.Normalize() <= GPUMemoryMB(gpu.memory))
-		}
+		_, gpuValid := rm.freeGPUs(resource.GPU)
 
 		if cpuValid && memValid && storageValid && gpuValid {
 			validTiers[name] = i
@@ -220,22 +513,66 @@ func (rm *ResourceManager) GetValidTierMap() map[string]int {
 	return validTiers
 }
 
-func (rm *ResourceManager) GenerateContainerResource(tierName string) (container.Resources, error) {
-	r, ok := StandardTierMap[tierName]
-	if !ok {
-		return container.Resources{}, fmt.Errorf("invalid tier name %s", tierName)
+// GenerateContainerResource turns a reservation into hard limits: the
+// container is pinned to its reserved cores and GPUs and may not exceed the
+// tier's memory.
+func (rm *ResourceManager) GenerateContainerResource(r *Reservation) (container.Resources, error) {
+	if r == nil {
+		return container.Resources{}, fmt.Errorf("no reservation for container")
+	}
+
+	cpuset := make([]string, 0, len(r.CPUs))
+	for _, c := range r.CPUs {
+		cpuset = append(cpuset, strconv.Itoa(c))
 	}
 
-	var dr []container.DeviceRequest = nil
-	if r.GPU != nil && len(rm.gpus) != 0 {
-		dr = getDeviceRequest(rm.gpus[0])
+	gpus := make([]GPUInfo, 0, len(r.GPUs))
+	for _, g := range r.GPUs {
+		gpus = append(gpus, rm.gpus[g])
 	}
 
+	var features []string
+	if def, ok := rm.Tiers().Get(r.Tier); ok && def.GPU != nil {
+		features = def.GPU.Features
+	}
+
+	memBytes := int64(r.Memory) * 1024 * 1024
+
 	resource := container.Resources{
-		NanoCPUs:          int64(r.CPU.Normalize()),
-		MemoryReservation: int64(r.Memory.Normalize()) * 1024 * 1024,
-		DeviceRequests:    dr,
+		NanoCPUs:          int64(len(r.CPUs)) * 1e9,
+		CpusetCpus:        strings.Join(cpuset, ","),
+		Memory:            memBytes,
+		MemoryReservation: memBytes,
+		MemorySwap:        memBytes, // equal to Memory disables swap
+		DeviceRequests:    getDeviceRequest(gpus, features),
 	}
 
 	return resource, nil
 }
+
+// ProcessLimits is a reservation expressed for a host process rather than a
+// container.
+type ProcessLimits struct {
+	CPUs        []int
+	MemoryBytes int64
+	Env         []string // makes only the reserved GPUs visible
+}
+
+// GenerateProcessLimits is GenerateContainerResource for nodes run by the
+// process executor.
+func (rm *ResourceManager) GenerateProcessLimits(r *Reservation) (ProcessLimits, error) {
+	if r == nil {
+		return ProcessLimits{}, fmt.Errorf("no reservation for process")
+	}
+
+	gpus := make([]GPUInfo, 0, len(r.GPUs))
+	for _, g := range r.GPUs {
+		gpus = append(gpus, rm.gpus[g])
+	}
+
+	return ProcessLimits{
+		CPUs:        slices.Clone(r.CPUs),
+		MemoryBytes: int64(r.Memory) * 1024 * 1024,
+		Env:         getDeviceEnv(gpus),
+	}, nil
+}
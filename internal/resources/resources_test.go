@@ -0,0 +1,129 @@
+package resources
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestReserve_PinsDistinctCores(t *testing.T) {
+	rm := newResourceManager(capacity{cpuRange(4), 8192, 102400}, nil)
+
+	a, err := rm.Reserve("c-small")
+	if err != nil {
+		t.Fatalf("Reserve() error = %v", err)
+	}
+	b, err := rm.Reserve("c-small")
+	if err != nil {
+		t.Fatalf("Reserve() error = %v", err)
+	}
+
+	seen := map[int]bool{}
+	for _, c := range append(a.CPUs, b.CPUs...) {
+		if seen[c] {
+			t.Fatalf("cpu %d reserved twice: %v %v", c, a.CPUs, b.CPUs)
+		}
+		seen[c] = true
+	}
+
+	if _, err := rm.Reserve("c-nano"); !errors.Is(err, ErrInsufficientResources) {
+		t.Fatalf("expected ErrInsufficientResources with all cores taken, got %v", err)
+	}
+
+	if err := rm.Release(a); err != nil {
+		t.Fatalf("Release() error = %v", err)
+	}
+	if _, err := rm.Reserve("c-small"); err != nil {
+		t.Fatalf("Reserve() after release error = %v", err)
+	}
+}
+
+func TestReserve_FailsAtomically(t *testing.T) {
+	// Enough memory and storage, but not enough cores.
+	rm := newResourceManager(capacity{cpuRange(1), 8192, 102400}, nil)
+
+	if _, err := rm.Reserve("c-small"); !errors.Is(err, ErrInsufficientResources) {
+		t.Fatalf("expected ErrInsufficientResources, got %v", err)
+	}
+
+	if rm.currMemMB != 0 || rm.currStorageMB != 0 || len(rm.cpuInUse) != 0 {
+		t.Fatalf("failed reserve leaked state: mem=%d storage=%d cpus=%v", rm.currMemMB, rm.currStorageMB, rm.cpuInUse)
+	}
+}
+
+func TestRelease_TwiceDoesNotFreeOthers(t *testing.T) {
+	rm := newResourceManager(capacity{cpuRange(4), 8192, 102400}, nil)
+
+	a, _ := rm.Reserve("c-small")
+	b, _ := rm.Reserve("c-small")
+
+	rm.Release(a)
+	rm.Release(a)
+
+	for _, c := range b.CPUs {
+		if !rm.cpuInUse[c] {
+			t.Fatalf("double release freed cpu %d held by another reservation", c)
+		}
+	}
+}
+
+func TestReserve_AssignsSpecificGPUs(t *testing.T) {
+	gpus := []GPUInfo{
+		{vendor: "nvidia", memory: 4096, id: "GPU-small"},
+		{vendor: "nvidia", memory: 81920, id: "GPU-0"},
+		{vendor: "nvidia", memory: 81920, id: "GPU-1"},
+	}
+	rm := newResourceManager(capacity{cpuRange(64), 1 << 20, 1 << 30}, gpus)
+
+	var ids []string
+	for range 2 {
+		r, err := rm.Reserve("gn-small")
+		if err != nil {
+			t.Fatalf("Reserve() error = %v", err)
+		}
+
+		res, err := rm.GenerateContainerResource(r)
+		if err != nil {
+			t.Fatalf("GenerateContainerResource() error = %v", err)
+		}
+		if len(res.DeviceRequests) != 1 || res.DeviceRequests[0].Count != 0 {
+			t.Fatalf("expected one request by device id, got %+v", res.DeviceRequests)
+		}
+		ids = append(ids, res.DeviceRequests[0].DeviceIDs...)
+	}
+
+	if len(ids) != 2 || ids[0] != "GPU-0" || ids[1] != "GPU-1" {
+		t.Fatalf("expected the two large gpus, got %v", ids)
+	}
+
+	// The remaining GPU is too small for the tier.
+	if _, err := rm.Reserve("gn-small"); !errors.Is(err, ErrInsufficientResources) {
+		t.Fatalf("expected ErrInsufficientResources, got %v", err)
+	}
+	if _, ok := rm.GetValidTierMap()["gn-small"]; ok {
+		t.Fatalf("gn-small still advertised with no free gpu")
+	}
+}
+
+func TestGenerateContainerResource_HardLimits(t *testing.T) {
+	rm := newResourceManager(capacity{cpuRange(4), 8192, 102400}, nil)
+
+	r, err := rm.Reserve("c-small")
+	if err != nil {
+		t.Fatalf("Reserve() error = %v", err)
+	}
+
+	res, err := rm.GenerateContainerResource(r)
+	if err != nil {
+		t.Fatalf("GenerateContainerResource() error = %v", err)
+	}
+
+	if res.NanoCPUs != 2e9 {
+		t.Fatalf("expected 2e9 nano cpus, got %d", res.NanoCPUs)
+	}
+	if res.Memory != 2048*1024*1024 {
+		t.Fatalf("expected a 2gb hard memory limit, got %d", res.Memory)
+	}
+	if res.CpusetCpus != "0,1" {
+		t.Fatalf("expected cpuset 0,1, got %q", res.CpusetCpus)
+	}
+}
@@ -0,0 +1,101 @@
+package resources
+
+import (
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+)
+
+const (
+	GPUFeatureNVENC = "nvenc" // hardware video encode
+	GPUFeatureNVDEC = "nvdec" // hardware video decode
+)
+
+var gpuVendors = []string{"nvidia", "amd", "intel", "apple", "any"}
+
+var gpuFeatures = []string{GPUFeatureNVENC, GPUFeatureNVDEC}
+
+// Tier names double as queue names and NATS subject tokens.
+var tierNameExp = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// TierSet maps tier names to the resources a node on that tier gets. A nil
+// TierSet is the standard tiers.
+type TierSet map[string]ResourceDefinition
+
+// Tiers returns the standard tiers extended by custom, where a custom tier
+// with a standard name replaces it.
+func Tiers(custom map[string]ResourceDefinition) (TierSet, error) {
+	tiers := maps.Clone(StandardTierMap)
+
+	for name, def := range custom {
+		if err := ValidateTier(name, def); err != nil {
+			return nil, err
+		}
+
+		tiers[name] = def
+	}
+
+	return tiers, nil
+}
+
+func (t TierSet) Get(name string) (ResourceDefinition, bool) {
+	if t == nil {
+		def, ok := StandardTierMap[name]
+		return def, ok
+	}
+
+	def, ok := t[name]
+	return def, ok
+}
+
+func (t TierSet) all() map[string]ResourceDefinition {
+	if t == nil {
+		return StandardTierMap
+	}
+
+	return t
+}
+
+// ValidateTier rejects definitions a worker could never schedule.
+func ValidateTier(name string, def ResourceDefinition) error {
+	if !tierNameExp.MatchString(name) {
+		return fmt.Errorf("tier %q: name must be lowercase letters, digits, '-' or '_'", name)
+	}
+
+	if def.CPU < 1 {
+		return fmt.Errorf("tier %s: CPU must be at least 1", name)
+	}
+
+	if def.Memory.Normalize() == 0 {
+		return fmt.Errorf("tier %s: invalid memory %q", name, def.Memory)
+	}
+
+	if def.Storage.Normalize() == 0 {
+		return fmt.Errorf("tier %s: invalid storage %q", name, def.Storage)
+	}
+
+	if def.GPU == nil {
+		return nil
+	}
+
+	if !slices.Contains(gpuVendors, def.GPU.Vendor) {
+		return fmt.Errorf("tier %s: unknown GPU vendor %q", name, def.GPU.Vendor)
+	}
+
+	if def.GPU.Count < 1 {
+		return fmt.Errorf("tier %s: GPU count must be at least 1", name)
+	}
+
+	if def.GPU.Memory != "" && def.GPU.Memory.Normalize() == 0 {
+		return fmt.Errorf("tier %s: invalid GPU memory %q", name, def.GPU.Memory)
+	}
+
+	for _, f := range def.GPU.Features {
+		if !slices.Contains(gpuFeatures, f) {
+			return fmt.Errorf("tier %s: unknown GPU feature %q", name, f)
+		}
+	}
+
+	return nil
+}
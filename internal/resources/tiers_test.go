@@ -0,0 +1,96 @@
+package resources
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestTiers_ExtendAndOverride(t *testing.T) {
+	tiers, err := Tiers(map[string]ResourceDefinition{
+		"tiny":    {CPU: 1, Memory: "128mb", Storage: "1gb"},
+		"c-small": {CPU: 3, Memory: "3gb", Storage: "30gb"},
+	})
+	if err != nil {
+		t.Fatalf("Tiers() error = %v", err)
+	}
+
+	if _, ok := tiers.Get("tiny"); !ok {
+		t.Fatalf("expected custom tier")
+	}
+	if def, _ := tiers.Get("c-small"); def.CPU != 3 {
+		t.Fatalf("expected c-small override, got %+v", def)
+	}
+	if _, ok := tiers.Get("c-nano"); !ok {
+		t.Fatalf("expected standard tiers to remain")
+	}
+	if StandardTierMap["c-small"].CPU != 2 {
+		t.Fatalf("override leaked into StandardTierMap")
+	}
+}
+
+func TestTiers_RejectsInvalid(t *testing.T) {
+	tests := map[string]ResourceDefinition{
+		"Bad.Name":  {CPU: 1, Memory: "1gb", Storage: "1gb"},
+		"no-cpu":    {Memory: "1gb", Storage: "1gb"},
+		"bad-mem":   {CPU: 1, Memory: "lots", Storage: "1gb"},
+		"bad-gpu":   {CPU: 1, Memory: "1gb", Storage: "1gb", GPU: &GPUDefinition{Vendor: "voodoo", Count: 1}},
+		"bad-feat":  {CPU: 1, Memory: "1gb", Storage: "1gb", GPU: &GPUDefinition{Vendor: "nvidia", Count: 1, Features: []string{"raytracing"}}},
+		"gpu-count": {CPU: 1, Memory: "1gb", Storage: "1gb", GPU: &GPUDefinition{Vendor: "nvidia"}},
+	}
+
+	for name, def := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := Tiers(map[string]ResourceDefinition{name: def}); err == nil {
+				t.Fatalf("expected tier %s to be rejected", name)
+			}
+		})
+	}
+}
+
+func TestTiers_CustomGPUFeatures(t *testing.T) {
+	gpus := []GPUInfo{
+		{vendor: "nvidia", memory: 24576, id: "GPU-plain"},
+		{vendor: "nvidia", memory: 16384, id: "GPU-enc", features: []string{GPUFeatureNVENC}},
+	}
+	rm := createResourceManager(ResourceSettings{}, fakeGPUDetector{gpus: gpus}, capacity{cpuRange(16), 1 << 16, 1 << 20})
+
+	tiers, err := Tiers(map[string]ResourceDefinition{
+		"video-encode": {
+			CPU:     12,
+			Memory:  "24gb",
+			Storage: "100gb",
+			GPU:     &GPUDefinition{Vendor: "nvidia", Count: 1, Features: []string{GPUFeatureNVENC}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Tiers() error = %v", err)
+	}
+	rm.SetTiers(tiers)
+
+	if _, ok := rm.GetValidTierMap()["video-encode"]; !ok {
+		t.Fatalf("expected video-encode to be advertised")
+	}
+
+	r, err := rm.Reserve("video-encode")
+	if err != nil {
+		t.Fatalf("Reserve() error = %v", err)
+	}
+
+	res, err := rm.GenerateContainerResource(r)
+	if err != nil {
+		t.Fatalf("GenerateContainerResource() error = %v", err)
+	}
+
+	dr := res.DeviceRequests[0]
+	if !slices.Equal(dr.DeviceIDs, []string{"GPU-enc"}) {
+		t.Fatalf("expected the encoder card, got %v", dr.DeviceIDs)
+	}
+	if !slices.Contains(dr.Capabilities[0], "video") {
+		t.Fatalf("expected video capability, got %v", dr.Capabilities)
+	}
+
+	if _, err := rm.Reserve("video-encode"); !errors.Is(err, ErrInsufficientResources) {
+		t.Fatalf("expected ErrInsufficientResources without a free encoder, got %v", err)
+	}
+}
@@ -8,9 +8,10 @@ type ResourceDefinition struct {
 }
 
 type GPUDefinition struct {
-	Vendor string // nvidia, intel, amd, or any.
-	Count  GPUCountInput
-	Memory GPUMemoryInput
+	Vendor   string // nvidia, intel, amd, or any.
+	Count    GPUCountInput
+	Memory   GPUMemoryInput
+	Features []string // required engines, e.g. nvenc
 }
 
 type CPUCoreInput int
@@ -0,0 +1,173 @@
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// envProvider reads secrets from environment variables named by the prefix
+// and the secret's name in upper case, with other characters as underscores:
+// db-password is PUPLOAD_SECRET_DB_PASSWORD.
+type envProvider struct {
+	prefix string
+}
+
+func newEnvProvider(cfg ProviderSettings) *envProvider {
+	prefix := cfg.Prefix
+	if prefix == "" {
+		prefix = "PUPLOAD_SECRET_"
+	}
+
+	return &envProvider{prefix: prefix}
+}
+
+func (p *envProvider) Lookup(_ context.Context, name string) (string, error) {
+	key := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		default:
+			return '_'
+		}
+	}, name)
+
+	value, ok := os.LookupEnv(p.prefix + key)
+	if !ok {
+		return "", ErrNotFound
+	}
+
+	return value, nil
+}
+
+// fileProvider reads each secret from a file of its name in a directory, like
+// the ones Docker and Kubernetes mount. A trailing newline is dropped.
+type fileProvider struct {
+	dir string
+}
+
+func newFileProvider(cfg ProviderSettings) (*fileProvider, error) {
+	if cfg.Dir == "" {
+		return nil, fmt.Errorf("file secret provider needs a dir")
+	}
+
+	return &fileProvider{dir: cfg.Dir}, nil
+}
+
+func (p *fileProvider) Lookup(_ context.Context, name string) (string, error) {
+	if !filepath.IsLocal(name) {
+		return "", ErrNotFound
+	}
+
+	b, err := os.ReadFile(filepath.Join(p.dir, name))
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", ErrNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSuffix(string(b), "\n"), nil
+}
+
+// httpProvider reads secrets from a Vault-compatible KV v2 engine. A name is
+// a path in the mount, optionally followed by #field; the field defaults to
+// value.
+type httpProvider struct {
+	addr     string
+	mount    string
+	tokenEnv string
+	client   *http.Client
+}
+
+func newHTTPProvider(cfg ProviderSettings) (*httpProvider, error) {
+	if cfg.URL == "" {
+		return nil, fmt.Errorf("http secret provider needs a url")
+	}
+
+	p := &httpProvider{
+		addr:     strings.TrimSuffix(cfg.URL, "/"),
+		mount:    strings.Trim(cfg.Mount, "/"),
+		tokenEnv: cfg.TokenEnv,
+		client:   &http.Client{Timeout: 10 * time.Second},
+	}
+
+	if p.mount == "" {
+		p.mount = "secret"
+	}
+
+	if p.tokenEnv == "" {
+		p.tokenEnv = "VAULT_TOKEN"
+	}
+
+	return p, nil
+}
+
+type kvResponse struct {
+	Data struct {
+		Data map[string]any `json:"data"`
+	} `json:"data"`
+}
+
+func (p *httpProvider) Lookup(ctx context.Context, name string) (string, error) {
+	path, field, ok := strings.Cut(name, "#")
+	if !ok {
+		field = "value"
+	}
+
+	segments := strings.Split(path, "/")
+	for i, s := range segments {
+		segments[i] = url.PathEscape(s)
+	}
+
+	u := fmt.Sprintf("%s/v1/%s/data/%s", p.addr, p.mount, strings.Join(segments, "/"))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return "", err
+	}
+
+	// Read on every lookup so a rotated token is picked up.
+	req.Header.Set("X-Vault-Token", os.Getenv(p.tokenEnv))
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return "", ErrNotFound
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		// The body may echo the request, so it isn't included.
+		return "", fmt.Errorf("secret server returned %d", resp.StatusCode)
+	}
+
+	var kv kvResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&kv); err != nil {
+		return "", fmt.Errorf("decoding secret server response: %w", err)
+	}
+
+	switch v := kv.Data.Data[field].(type) {
+	case nil:
+		return "", ErrNotFound
+	case string:
+		return v, nil
+	default:
+		b, err := json.Marshal(v)
+		return string(b), err
+	}
+}
@@ -0,0 +1,226 @@
+// Package secrets resolves ${secret:name} references from pluggable
+// providers at the point of use, so secret values are never written into flow
+// state, task payloads or logs.
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// ErrNotFound is returned when no provider has a secret.
+var ErrNotFound = errors.New("secret not found")
+
+const defaultCacheTTL = time.Minute
+
+var (
+	refPattern  = regexp.MustCompile(`\$\{secret:([^}]*)\}`)
+	namePattern = regexp.MustCompile(`^[A-Za-z0-9_.\-/#]+$`)
+)
+
+// Settings picks the providers secrets are looked up in, in order. Without
+// providers secrets come from PUPLOAD_SECRET_* environment variables.
+type Settings struct {
+	Providers []ProviderSettings `json:"providers"`
+	CacheTTL  string             `json:"cache_ttl"` // how long looked up values are reused, eg. 1m
+}
+
+type ProviderSettings struct {
+	Type string `json:"type"` // env, file or http
+
+	Prefix string `json:"prefix"` // env: variable prefix, defaults to PUPLOAD_SECRET_
+	Dir    string `json:"dir"`    // file: directory with one file per secret
+
+	URL      string `json:"url"`       // http: Vault-compatible server address
+	Mount    string `json:"mount"`     // http: KV v2 mount, defaults to secret
+	TokenEnv string `json:"token_env"` // http: variable holding the token, defaults to VAULT_TOKEN
+}
+
+// Provider looks secrets up by name. It returns ErrNotFound for secrets it
+// doesn't have.
+type Provider interface {
+	Lookup(ctx context.Context, name string) (string, error)
+}
+
+// Resolver looks secrets up in its providers and expands references to them.
+type Resolver struct {
+	providers []Provider
+	ttl       time.Duration
+
+	mu    sync.Mutex
+	cache map[string]cached
+}
+
+type cached struct {
+	value   string
+	expires time.Time
+}
+
+func New(cfg Settings) (*Resolver, error) {
+	r := &Resolver{ttl: defaultCacheTTL, cache: make(map[string]cached)}
+
+	if cfg.CacheTTL != "" {
+		ttl, err := time.ParseDuration(cfg.CacheTTL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid secrets cache_ttl: %w", err)
+		}
+		r.ttl = ttl
+	}
+
+	if len(cfg.Providers) == 0 {
+		cfg.Providers = []ProviderSettings{{Type: "env"}}
+	}
+
+	for _, p := range cfg.Providers {
+		provider, err := newProvider(p)
+		if err != nil {
+			return nil, err
+		}
+
+		r.providers = append(r.providers, provider)
+	}
+
+	return r, nil
+}
+
+func newProvider(cfg ProviderSettings) (Provider, error) {
+	switch cfg.Type {
+	case "env":
+		return newEnvProvider(cfg), nil
+	case "file":
+		return newFileProvider(cfg)
+	case "http":
+		return newHTTPProvider(cfg)
+	default:
+		return nil, fmt.Errorf("unknown secret provider %q, expected env, file or http", cfg.Type)
+	}
+}
+
+// ValidName reports whether name can be looked up.
+func ValidName(name string) bool {
+	return namePattern.MatchString(name)
+}
+
+// Lookup returns the secret from the first provider that has it.
+func (r *Resolver) Lookup(ctx context.Context, name string) (string, error) {
+	if !ValidName(name) {
+		return "", fmt.Errorf("invalid secret name %q", name)
+	}
+
+	if r == nil {
+		return "", fmt.Errorf("secret %s: %w: no providers configured", name, ErrNotFound)
+	}
+
+	r.mu.Lock()
+	c, ok := r.cache[name]
+	r.mu.Unlock()
+
+	if ok && time.Now().Before(c.expires) {
+		return c.value, nil
+	}
+
+	for _, p := range r.providers {
+		value, err := p.Lookup(ctx, name)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return "", fmt.Errorf("secret %s: %w", name, err)
+		}
+
+		r.mu.Lock()
+		r.cache[name] = cached{value: value, expires: time.Now().Add(r.ttl)}
+		r.mu.Unlock()
+
+		return value, nil
+	}
+
+	return "", fmt.Errorf("secret %s: %w", name, ErrNotFound)
+}
+
+// Refs returns the names of the secrets s refers to.
+func Refs(s string) []string {
+	var names []string
+	for _, m := range refPattern.FindAllStringSubmatch(s, -1) {
+		names = append(names, m[1])
+	}
+
+	return names
+}
+
+// Expand replaces the secret references in s with their values.
+func (r *Resolver) Expand(ctx context.Context, s string) (string, error) {
+	var err error
+
+	expanded := refPattern.ReplaceAllStringFunc(s, func(m string) string {
+		if err != nil {
+			return ""
+		}
+
+		var value string
+		value, err = r.Lookup(ctx, refPattern.FindStringSubmatch(m)[1])
+		return value
+	})
+
+	if err != nil {
+		return "", err
+	}
+
+	return expanded, nil
+}
+
+// ExpandJSON expands the secret references in every string of a JSON value,
+// like a store's params. Values are escaped, so they can't change its shape.
+func (r *Resolver) ExpandJSON(ctx context.Context, raw json.RawMessage) (json.RawMessage, error) {
+	if !refPattern.Match(raw) {
+		return raw, nil
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+
+	v, err := r.expandValue(ctx, v)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(v)
+}
+
+func (r *Resolver) expandValue(ctx context.Context, v any) (any, error) {
+	switch v := v.(type) {
+	case string:
+		return r.Expand(ctx, v)
+
+	case map[string]any:
+		for k, item := range v {
+			expanded, err := r.expandValue(ctx, item)
+			if err != nil {
+				return nil, err
+			}
+			v[k] = expanded
+		}
+
+	case []any:
+		for i, item := range v {
+			expanded, err := r.expandValue(ctx, item)
+			if err != nil {
+				return nil, err
+			}
+			v[i] = expanded
+		}
+	}
+
+	return v, nil
+}
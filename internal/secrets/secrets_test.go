@@ -0,0 +1,94 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResolver_Providers(t *testing.T) {
+	t.Setenv("PUPLOAD_SECRET_API_KEY", "from-env")
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "db-password"), []byte("from-file\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("TEST_VAULT_TOKEN", "root")
+	vault := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "root" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		if r.URL.Path != "/v1/kv/data/apps/s3" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		w.Write([]byte(`{"data": {"data": {"access": "from-vault", "value": "default"}}}`))
+	}))
+	defer vault.Close()
+
+	r, err := New(Settings{Providers: []ProviderSettings{
+		{Type: "env"},
+		{Type: "file", Dir: dir},
+		{Type: "http", URL: vault.URL, Mount: "kv", TokenEnv: "TEST_VAULT_TOKEN"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]string{
+		"api-key":        "from-env",
+		"db-password":    "from-file",
+		"apps/s3#access": "from-vault",
+		"apps/s3":        "default",
+	}
+
+	for name, want := range tests {
+		got, err := r.Lookup(context.Background(), name)
+		if err != nil {
+			t.Fatalf("Lookup(%s): %v", name, err)
+		}
+		if got != want {
+			t.Fatalf("Lookup(%s) = %q, expected %q", name, got, want)
+		}
+	}
+
+	if _, err := r.Lookup(context.Background(), "missing"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+
+	if _, err := r.Lookup(context.Background(), "../etc/passwd"); err == nil {
+		t.Fatalf("expected an error for a name outside the dir")
+	}
+}
+
+func TestResolver_ExpandJSON(t *testing.T) {
+	t.Setenv("PUPLOAD_SECRET_SECRET_KEY", `quo"te`)
+
+	r, err := New(Settings{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := r.ExpandJSON(context.Background(), []byte(`{"SecretKey": "${secret:secret-key}", "Port": 9000}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `{"Port":9000,"SecretKey":"quo\"te"}`
+	if string(got) != want {
+		t.Fatalf("got %s, expected %s", got, want)
+	}
+
+	var nilResolver *Resolver
+	if _, err := nilResolver.ExpandJSON(context.Background(), []byte(`{"a": "${secret:x}"}`)); err == nil {
+		t.Fatalf("expected an error without providers")
+	}
+}
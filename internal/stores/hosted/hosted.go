@@ -0,0 +1,343 @@
+// Package hosted serves stores the controller keeps itself, in memory or on
+// its filesystem, so flows run without any S3. Objects are reached through
+// URLs on the controller's HTTP server, signed like presigned S3 URLs.
+package hosted
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pupload/pupload/internal/models"
+
+	"github.com/johannesboyne/gofakes3"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// PathPrefix is where the controller serves hosted stores.
+const PathPrefix = "/stores/"
+
+// DefaultPublicURL is the controller's own address, which works when workers
+// run on the same host, like with pup dev.
+const DefaultPublicURL = "http://localhost:1234"
+
+const (
+	expiresParam   = "X-Pup-Expires"
+	signatureParam = "X-Pup-Signature"
+)
+
+// Settings configures the endpoint hosted stores are served from.
+type Settings struct {
+	PublicURL  string `json:"public_url"`  // the controller as workers reach it, defaults to DefaultPublicURL
+	SigningKey string `json:"signing_key"` // shared through the sync plane when empty
+}
+
+// Store is a bucket served by the controller.
+type Store struct {
+	path   string // under PathPrefix: <type>/<bucket>
+	bucket string
+	owner  string // what the backend was opened from, eg. its directory
+	server http.Handler
+	client *minio.Client // calls server in process
+}
+
+var registry = struct {
+	sync.Mutex
+	base   *url.URL
+	key    []byte
+	stores map[string]*Store
+}{stores: make(map[string]*Store)}
+
+func init() {
+	registry.base, _ = url.Parse(DefaultPublicURL)
+
+	registry.key = make([]byte, 32)
+	rand.Read(registry.key)
+}
+
+// Configure sets where hosted stores are reached and the key their URLs are
+// signed with. Without it, URLs point at DefaultPublicURL.
+func Configure(cfg Settings) error {
+	raw := cfg.PublicURL
+	if raw == "" {
+		raw = DefaultPublicURL
+	}
+
+	base, err := url.Parse(strings.TrimSuffix(raw, "/"))
+	if err != nil || base.Scheme == "" || base.Host == "" || base.Path != "" {
+		return fmt.Errorf("invalid hosted store public_url %q, expected a scheme and host only", cfg.PublicURL)
+	}
+
+	registry.Lock()
+	defer registry.Unlock()
+
+	registry.base = base
+	if cfg.SigningKey != "" {
+		registry.key = []byte(cfg.SigningKey)
+	}
+
+	return nil
+}
+
+// Open returns the store serving bucket for storeType, creating its backend
+// with newBackend the first time. Flows are loaded again on every step, so a
+// store is shared by every flow naming it; owner tells a second backend for
+// the same bucket apart, which is an error.
+func Open(storeType, bucket, owner string, newBackend func() (gofakes3.Backend, error)) (*Store, error) {
+	if err := gofakes3.ValidateBucketName(bucket); err != nil {
+		return nil, fmt.Errorf("invalid bucket name %q: %w", bucket, err)
+	}
+
+	path := storeType + "/" + bucket
+
+	registry.Lock()
+	defer registry.Unlock()
+
+	if s, ok := registry.stores[path]; ok {
+		if s.owner != owner {
+			return nil, fmt.Errorf("%s bucket %s is already served from %s", storeType, bucket, s.owner)
+		}
+		return s, nil
+	}
+
+	backend, err := newBackend()
+	if err != nil {
+		return nil, err
+	}
+
+	server := gofakes3.New(backend).Server()
+
+	client, err := minio.New("hosted.pupload.internal", &minio.Options{
+		Creds:        credentials.NewStaticV4("hosted", "hosted", ""),
+		Region:       "us-east-1",
+		BucketLookup: minio.BucketLookupPath,
+		Transport:    handlerTransport{server},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	exists, err := client.BucketExists(context.Background(), bucket)
+	if err != nil {
+		return nil, err
+	}
+
+	if !exists {
+		if err := client.MakeBucket(context.Background(), bucket, minio.MakeBucketOptions{}); err != nil {
+			return nil, err
+		}
+	}
+
+	s := &Store{path: path, bucket: bucket, owner: owner, server: server, client: client}
+	registry.stores[path] = s
+
+	return s, nil
+}
+
+// Handler serves the hosted stores under PathPrefix. Only requests carrying a
+// valid signature for their method, path and query are let through.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rest, ok := strings.CutPrefix(r.URL.Path, PathPrefix)
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+
+		storeType, rest, _ := strings.Cut(rest, "/")
+		bucket, _, _ := strings.Cut(rest, "/")
+
+		registry.Lock()
+		s, ok := registry.stores[storeType+"/"+bucket]
+		registry.Unlock()
+
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+
+		if err := verify(r.Method, r.URL); err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+
+		// The backend sees a plain path-style S3 request.
+		r2 := r.Clone(r.Context())
+		r2.URL.Path = "/" + rest
+		r2.URL.RawPath = ""
+
+		q := r2.URL.Query()
+		q.Del(expiresParam)
+		q.Del(signatureParam)
+		r2.URL.RawQuery = q.Encode()
+
+		s.server.ServeHTTP(w, r2)
+	})
+}
+
+func (s *Store) PutURL(ctx context.Context, objectName string, expires time.Duration) (*url.URL, error) {
+	return s.sign(http.MethodPut, objectName, expires, nil)
+}
+
+func (s *Store) GetURL(ctx context.Context, objectName string, expires time.Duration) (*url.URL, error) {
+	return s.sign(http.MethodGet, objectName, expires, nil)
+}
+
+// PresignMultipart starts a multipart upload and signs its part, complete and
+// abort requests, like s3util.PresignMultipart does for S3.
+func (s *Store) PresignMultipart(ctx context.Context, objectName string, parts int, expires time.Duration) (*models.MultipartUpload, error) {
+	core := minio.Core{Client: s.client}
+
+	uploadID, err := core.NewMultipartUpload(ctx, s.bucket, objectName, minio.PutObjectOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("unable to start multipart upload: %w", err)
+	}
+
+	mp := &models.MultipartUpload{PartURLs: make([]string, 0, parts)}
+
+	for i := 1; i <= parts; i++ {
+		u, err := s.sign(http.MethodPut, objectName, expires, url.Values{
+			"partNumber": {strconv.Itoa(i)},
+			"uploadId":   {uploadID},
+		})
+		if err != nil {
+			core.AbortMultipartUpload(ctx, s.bucket, objectName, uploadID)
+			return nil, err
+		}
+
+		mp.PartURLs = append(mp.PartURLs, u.String())
+	}
+
+	params := url.Values{"uploadId": {uploadID}}
+
+	complete, err := s.sign(http.MethodPost, objectName, expires, params)
+	if err != nil {
+		core.AbortMultipartUpload(ctx, s.bucket, objectName, uploadID)
+		return nil, err
+	}
+
+	abort, err := s.sign(http.MethodDelete, objectName, expires, params)
+	if err != nil {
+		core.AbortMultipartUpload(ctx, s.bucket, objectName, uploadID)
+		return nil, err
+	}
+
+	mp.CompleteURL = complete.String()
+	mp.AbortURL = abort.String()
+
+	return mp, nil
+}
+
+func (s *Store) DeleteObject(ctx context.Context, objectName string) error {
+	return s.client.RemoveObject(ctx, s.bucket, objectName, minio.RemoveObjectOptions{})
+}
+
+// Close does nothing; the store lives as long as the controller, since other
+// flows may use it.
+func (s *Store) Close() {
+
+}
+
+func (s *Store) Exists(objectName string) bool {
+	_, err := s.client.StatObject(context.TODO(), s.bucket, objectName, minio.GetObjectOptions{})
+	if err != nil {
+		return false
+	}
+
+	return true
+}
+
+func (s *Store) sign(method, objectName string, expires time.Duration, params url.Values) (*url.URL, error) {
+	if objectName == "" {
+		return nil, fmt.Errorf("empty object name")
+	}
+
+	registry.Lock()
+	base := registry.base
+	registry.Unlock()
+
+	q := url.Values{}
+	for k, v := range params {
+		q[k] = v
+	}
+	q.Set(expiresParam, strconv.FormatInt(time.Now().Add(expires).Unix(), 10))
+
+	u := *base
+	u.Path = PathPrefix + s.path + "/" + strings.TrimPrefix(objectName, "/")
+	q.Set(signatureParam, signature(method, u.Path, q))
+	u.RawQuery = q.Encode()
+
+	return &u, nil
+}
+
+func verify(method string, u *url.URL) error {
+	q := u.Query()
+
+	expires, err := strconv.ParseInt(q.Get(expiresParam), 10, 64)
+	if err != nil {
+		return fmt.Errorf("request is not signed")
+	}
+
+	if time.Now().Unix() > expires {
+		return fmt.Errorf("request signature has expired")
+	}
+
+	got, err := hex.DecodeString(q.Get(signatureParam))
+	if err != nil {
+		return fmt.Errorf("invalid request signature")
+	}
+
+	want, _ := hex.DecodeString(signature(method, u.Path, q))
+	if !hmac.Equal(got, want) {
+		return fmt.Errorf("invalid request signature")
+	}
+
+	return nil
+}
+
+// signature is the HMAC of the method, path and every query parameter but
+// the signature itself, so a URL can't be reused for another object, part or
+// upload.
+func signature(method, path string, q url.Values) string {
+	signed := url.Values{}
+	for k, v := range q {
+		if k != signatureParam {
+			signed[k] = v
+		}
+	}
+
+	registry.Lock()
+	key := registry.key
+	registry.Unlock()
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(method + "\n" + path + "\n" + signed.Encode()))
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// handlerTransport sends the store's own requests straight to its server.
+type handlerTransport struct {
+	h http.Handler
+}
+
+func (t handlerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	rec := httptest.NewRecorder()
+	t.h.ServeHTTP(rec, req)
+
+	res := rec.Result()
+	res.Request = req
+
+	return res, nil
+}
@@ -0,0 +1,183 @@
+package hosted
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// helper to serve a store whose backend records the requests it gets
+func newTestStore(t *testing.T) (*Store, *httptest.Server, *[]*http.Request) {
+	t.Helper()
+
+	srv := httptest.NewServer(Handler())
+	t.Cleanup(srv.Close)
+
+	if err := Configure(Settings{PublicURL: srv.URL, SigningKey: "test-key"}); err != nil {
+		t.Fatalf("Configure() error = %v", err)
+	}
+
+	var seen []*http.Request
+	s := &Store{
+		path:   "test/test-bucket",
+		bucket: "test-bucket",
+		owner:  "test",
+		server: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			seen = append(seen, r)
+		}),
+	}
+
+	registry.Lock()
+	registry.stores[s.path] = s
+	registry.Unlock()
+
+	t.Cleanup(func() {
+		registry.Lock()
+		delete(registry.stores, s.path)
+		registry.Unlock()
+	})
+
+	return s, srv, &seen
+}
+
+func do(t *testing.T, method string, u *url.URL) int {
+	t.Helper()
+
+	req, err := http.NewRequest(method, u.String(), nil)
+	if err != nil {
+		t.Fatalf("NewRequest() error = %v", err)
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s error = %v", method, u, err)
+	}
+	res.Body.Close()
+
+	return res.StatusCode
+}
+
+func TestHandler_RewritesSignedRequests(t *testing.T) {
+	s, _, seen := newTestStore(t)
+
+	u, err := s.sign(http.MethodPut, "folder/object.txt", time.Minute, url.Values{
+		"partNumber": {"2"},
+		"uploadId":   {"upload-1"},
+	})
+	if err != nil {
+		t.Fatalf("sign() error = %v", err)
+	}
+
+	if code := do(t, http.MethodPut, u); code != http.StatusOK {
+		t.Fatalf("expected signed request to pass, got %d", code)
+	}
+
+	if len(*seen) != 1 {
+		t.Fatalf("expected the backend to get 1 request, got %d", len(*seen))
+	}
+
+	r := (*seen)[0]
+	if r.URL.Path != "/test-bucket/folder/object.txt" {
+		t.Fatalf("expected a path-style path, got %q", r.URL.Path)
+	}
+
+	want := url.Values{"partNumber": {"2"}, "uploadId": {"upload-1"}}
+	if r.URL.RawQuery != want.Encode() {
+		t.Fatalf("expected query %q without the signature, got %q", want.Encode(), r.URL.RawQuery)
+	}
+}
+
+func TestHandler_RejectsBadSignatures(t *testing.T) {
+	s, _, seen := newTestStore(t)
+
+	signed := func(method string, expires time.Duration) *url.URL {
+		u, err := s.sign(method, "object.txt", expires, url.Values{"uploadId": {"upload-1"}})
+		if err != nil {
+			t.Fatalf("sign() error = %v", err)
+		}
+		return u
+	}
+
+	tamper := func(u *url.URL, f func(u *url.URL, q url.Values)) *url.URL {
+		u2 := *u
+		q := u2.Query()
+		f(&u2, q)
+		u2.RawQuery = q.Encode()
+		return &u2
+	}
+
+	cases := []struct {
+		name   string
+		method string
+		u      *url.URL
+		want   int
+	}{
+		{"expired", http.MethodGet, signed(http.MethodGet, -time.Minute), http.StatusForbidden},
+		{"extended expiry", http.MethodGet, tamper(signed(http.MethodGet, time.Minute), func(_ *url.URL, q url.Values) {
+			q.Set(expiresParam, "99999999999")
+		}), http.StatusForbidden},
+		{"other object", http.MethodGet, tamper(signed(http.MethodGet, time.Minute), func(u *url.URL, _ url.Values) {
+			u.Path = PathPrefix + "test/test-bucket/other.txt"
+		}), http.StatusForbidden},
+		{"other upload", http.MethodGet, tamper(signed(http.MethodGet, time.Minute), func(_ *url.URL, q url.Values) {
+			q.Set("uploadId", "upload-2")
+		}), http.StatusForbidden},
+		{"added param", http.MethodGet, tamper(signed(http.MethodGet, time.Minute), func(_ *url.URL, q url.Values) {
+			q.Set("partNumber", "1")
+		}), http.StatusForbidden},
+		{"bad signature", http.MethodGet, tamper(signed(http.MethodGet, time.Minute), func(_ *url.URL, q url.Values) {
+			q.Set(signatureParam, "not-hex")
+		}), http.StatusForbidden},
+		{"unsigned", http.MethodGet, tamper(signed(http.MethodGet, time.Minute), func(_ *url.URL, q url.Values) {
+			q.Del(expiresParam)
+			q.Del(signatureParam)
+		}), http.StatusForbidden},
+		{"get url used to put", http.MethodPut, signed(http.MethodGet, time.Minute), http.StatusForbidden},
+		{"put url used to delete", http.MethodDelete, signed(http.MethodPut, time.Minute), http.StatusForbidden},
+		{"unknown store", http.MethodGet, tamper(signed(http.MethodGet, time.Minute), func(u *url.URL, _ url.Values) {
+			u.Path = PathPrefix + "test/other-bucket/object.txt"
+		}), http.StatusNotFound},
+		{"outside prefix", http.MethodGet, tamper(signed(http.MethodGet, time.Minute), func(u *url.URL, _ url.Values) {
+			u.Path = "/test/test-bucket/object.txt"
+		}), http.StatusNotFound},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if code := do(t, tc.method, tc.u); code != tc.want {
+				t.Fatalf("expected %d, got %d", tc.want, code)
+			}
+		})
+	}
+
+	if len(*seen) != 0 {
+		t.Fatalf("expected no request to reach the backend, got %d", len(*seen))
+	}
+}
+
+func TestHandler_VerifiesWithSharedKey(t *testing.T) {
+	s, srv, _ := newTestStore(t)
+
+	u, err := s.GetURL(t.Context(), "object.txt", time.Minute)
+	if err != nil {
+		t.Fatalf("GetURL() error = %v", err)
+	}
+
+	// Another controller with the same key accepts the URL, one with its
+	// own key doesn't.
+	if err := Configure(Settings{PublicURL: srv.URL, SigningKey: "test-key"}); err != nil {
+		t.Fatalf("Configure() error = %v", err)
+	}
+	if code := do(t, http.MethodGet, u); code != http.StatusOK {
+		t.Fatalf("expected URL to verify with the same key, got %d", code)
+	}
+
+	if err := Configure(Settings{PublicURL: srv.URL, SigningKey: "other-key"}); err != nil {
+		t.Fatalf("Configure() error = %v", err)
+	}
+	if code := do(t, http.MethodGet, u); code != http.StatusForbidden {
+		t.Fatalf("expected URL signed with another key to fail, got %d", code)
+	}
+}
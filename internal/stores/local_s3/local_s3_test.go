@@ -5,15 +5,25 @@ import (
 	"context"
 	"io"
 	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"testing"
 	"time"
+
+	"github.com/pupload/pupload/internal/stores/hosted"
 )
 
-// helper to create a store and register cleanup
-func newTestStore(t *testing.T) *LocalS3Store {
+// helper to serve hosted stores on a test server and create a store
+func newTestStore(t *testing.T) *hosted.Store {
 	t.Helper()
 
+	srv := httptest.NewServer(hosted.Handler())
+	t.Cleanup(srv.Close)
+
+	if err := hosted.Configure(hosted.Settings{PublicURL: srv.URL}); err != nil {
+		t.Fatalf("Configure() error = %v", err)
+	}
+
 	input := LocalS3StoreInput{BucketName: "test-bucket"}
 	store, err := NewLocalS3Store(input)
 
@@ -21,24 +31,22 @@ func newTestStore(t *testing.T) *LocalS3Store {
 		t.Fatalf("NewLocalS3Store() error = %v", err)
 	}
 
-	t.Cleanup(func() {
-		store.Close()
-	})
-
 	return store
 }
 
-func TestNewLocalS3Store_InitializesCorrectly(t *testing.T) {
+func TestNewLocalS3Store_SharesBucket(t *testing.T) {
 	store := newTestStore(t)
 
-	if store.server == nil {
-		t.Fatalf("expected server to be non-nil")
+	again, err := NewLocalS3Store(LocalS3StoreInput{BucketName: "test-bucket"})
+	if err != nil {
+		t.Fatalf("NewLocalS3Store() error = %v", err)
 	}
-	if store.client == nil {
-		t.Fatalf("expected client to be non-nil")
+	if again != store {
+		t.Fatalf("expected stores for one bucket to be shared")
 	}
-	if store.bucket != "test-bucket" {
-		t.Fatalf("expected bucket %q, got %q", "test-bucket", store.bucket)
+
+	if _, err := NewLocalS3Store(LocalS3StoreInput{BucketName: "Not A Bucket"}); err == nil {
+		t.Fatalf("expected invalid bucket name to fail")
 	}
 }
 
@@ -169,17 +177,51 @@ func TestLocalS3Store_DeleteObject_MakesObjectInaccessible(t *testing.T) {
 	}
 }
 
-func TestLocalS3Store_Close_ShutsDownServer(t *testing.T) {
+func TestLocalS3Store_RejectsUnsignedRequests(t *testing.T) {
 	store := newTestStore(t)
 
-	baseURL := store.server.URL
-	store.Close()
+	ctx := context.Background()
+
+	putURL, err := store.PutURL(ctx, "signed.txt", 5*time.Minute)
+	if err != nil {
+		t.Fatalf("PutURL() error = %v", err)
+	}
+
+	tampered := []func(u *url.URL){
+		func(u *url.URL) { u.RawQuery = "" },
+		func(u *url.URL) { u.Path += "-other" },
+		func(u *url.URL) {
+			q := u.Query()
+			q.Set("X-Pup-Expires", "9999999999")
+			u.RawQuery = q.Encode()
+		},
+	}
+
+	for i, tamper := range tampered {
+		u := *putURL
+		tamper(&u)
 
-	// After Close, requests to the server should fail
-	resp, err := http.Get(baseURL + "/")
-	if err == nil {
+		req, _ := http.NewRequestWithContext(ctx, http.MethodPut, u.String(), bytes.NewReader([]byte("x")))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("PUT %d failed: %v", i, err)
+		}
 		resp.Body.Close()
-		t.Fatalf("expected error when calling closed server, got status %s", resp.Status)
+
+		if resp.StatusCode != http.StatusForbidden {
+			t.Fatalf("tampered PUT %d status = %d, want %d", i, resp.StatusCode, http.StatusForbidden)
+		}
+	}
+
+	// A PUT URL doesn't allow reading the object either.
+	resp, err := http.Get(putURL.String())
+	if err != nil {
+		t.Fatalf("GET failed: %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("GET with PUT URL status = %d, want %d", resp.StatusCode, http.StatusForbidden)
 	}
 }
 
@@ -187,7 +229,7 @@ func TestLocalS3Store_Close_ShutsDownServer(t *testing.T) {
 func assertHasSignature(t *testing.T, u *url.URL) {
 	t.Helper()
 	q := u.Query()
-	if q.Get("X-Amz-Signature") == "" {
-		t.Fatalf("presigned URL %q missing X-Amz-Signature", u.String())
+	if q.Get("X-Pup-Signature") == "" {
+		t.Fatalf("presigned URL %q missing X-Pup-Signature", u.String())
 	}
 }
@@ -7,6 +7,9 @@ import (
 	"net/url"
 	"time"
 
+	"github.com/pupload/pupload/internal/models"
+	"github.com/pupload/pupload/internal/stores/s3util"
+
 	"github.com/minio/minio-go/v7"
 	"github.com/minio/minio-go/v7/pkg/credentials"
 )
@@ -96,6 +99,10 @@ func (s *S3Store) GetURL(ctx context.Context, objectName string, expires time.Du
 	return s.client.PresignedGetObject(ctx, s.bucket, objectName, expires, url.Values{})
 }
 
+func (s *S3Store) PresignMultipart(ctx context.Context, objectName string, parts int, expires time.Duration) (*models.MultipartUpload, error) {
+	return s3util.PresignMultipart(ctx, s.client, s.bucket, objectName, parts, expires)
+}
+
 func (s *S3Store) DeleteObject(ctx context.Context, objectName string) error {
 	return s.client.RemoveObject(ctx, s.bucket, objectName, minio.RemoveObjectOptions{})
 }
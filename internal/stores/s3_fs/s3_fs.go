@@ -1,105 +1,60 @@
 package s3fs
 
 import (
-	"context"
-	"crypto/tls"
-	"net/http"
-	"net/http/httptest"
-	"net/url"
-	"path"
-	"time"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/pupload/pupload/internal/models"
+	"github.com/pupload/pupload/internal/stores/hosted"
 
 	"github.com/johannesboyne/gofakes3"
 	"github.com/johannesboyne/gofakes3/backend/s3afero"
 	"github.com/spf13/afero"
-
-	"github.com/minio/minio-go/v7"
-	"github.com/minio/minio-go/v7/pkg/credentials"
 )
 
-type FilesystemS3Store struct {
-	server *httptest.Server
-	client *minio.Client
-	bucket string
-}
-
+// FilesystemS3StoreInput are the params of a filesystem store. Objects are
+// files under MountPath; their metadata is kept under MetaPath, which
+// defaults to a .meta directory next to MountPath so it isn't listed as
+// objects.
 type FilesystemS3StoreInput struct {
 	MountPath  string
+	MetaPath   string
 	BucketName string
 }
 
-func NewLocalS3Store(input FilesystemS3StoreInput) (*FilesystemS3Store, error) {
-	fs := afero.NewOsFs()
-	fs = afero.NewBasePathFs(fs, input.MountPath)
-	metaFS := afero.NewOsFs()
-	metaFS = afero.NewBasePathFs(metaFS, path.Join(input.MountPath, "meta"))
-
-	backend, err := s3afero.SingleBucket(input.BucketName, fs, metaFS)
-	if err != nil {
-		return nil, err
+// NewFilesystemS3Store returns the filesystem store for the bucket, served by
+// the controller.
+func NewFilesystemS3Store(input FilesystemS3StoreInput) (*hosted.Store, error) {
+	if input.MountPath == "" {
+		return nil, fmt.Errorf("filesystem store needs a MountPath")
 	}
 
-	faker := gofakes3.New(backend)
-
-	ts := httptest.NewServer(faker.Server())
-
-	u, err := url.Parse(ts.URL)
+	mount, err := filepath.Abs(input.MountPath)
 	if err != nil {
 		return nil, err
 	}
 
-	transport := &http.Transport{
-		Proxy:               http.ProxyFromEnvironment,
-		MaxIdleConns:        200,
-		MaxIdleConnsPerHost: 100,
-		IdleConnTimeout:     90 * time.Second,
-		TLSHandshakeTimeout: 5 * time.Second,
-		TLSClientConfig: &tls.Config{
-			InsecureSkipVerify: false,
-		},
+	meta := input.MetaPath
+	if meta == "" {
+		meta = mount + ".meta"
 	}
 
-	minioClient, err := minio.New(u.Host, &minio.Options{
-		Creds:     credentials.NewStaticV4("ACCESS_KEY", "SECRET_KEY", ""),
-		Secure:    false,
-		Transport: transport,
-	})
-
+	meta, err = filepath.Abs(meta)
 	if err != nil {
 		return nil, err
 	}
 
-	minioClient.MakeBucket(context.TODO(), input.BucketName, minio.MakeBucketOptions{})
-
-	return &FilesystemS3Store{
-		server: ts,
-		client: minioClient,
-		bucket: input.BucketName,
-	}, nil
+	return hosted.Open(models.StoreFilesystem, input.BucketName, mount, func() (gofakes3.Backend, error) {
+		for _, dir := range []string{mount, meta} {
+			if err := os.MkdirAll(dir, 0o755); err != nil {
+				return nil, err
+			}
+		}
 
-}
+		fs := afero.NewBasePathFs(afero.NewOsFs(), mount)
+		metaFS := afero.NewBasePathFs(afero.NewOsFs(), meta)
 
-func (s *FilesystemS3Store) PutURL(ctx context.Context, objectName string, expires time.Duration) (u *url.URL, err error) {
-	return s.client.PresignedPutObject(ctx, s.bucket, objectName, expires)
-}
-
-func (s *FilesystemS3Store) GetURL(ctx context.Context, objectName string, expires time.Duration) (u *url.URL, err error) {
-	return s.client.PresignedGetObject(ctx, s.bucket, objectName, expires, url.Values{})
-}
-
-func (s *FilesystemS3Store) DeleteObject(ctx context.Context, objectName string) error {
-	return s.client.RemoveObject(ctx, s.bucket, objectName, minio.RemoveObjectOptions{})
-}
-
-func (s *FilesystemS3Store) Close() {
-	s.server.Close()
-}
-
-func (s *FilesystemS3Store) Exists(objectName string) bool {
-	_, err := s.client.StatObject(context.TODO(), s.bucket, objectName, minio.GetObjectOptions{})
-	if err != nil {
-		return false
-	}
-
-	return true
+		return s3afero.SingleBucket(input.BucketName, fs, metaFS)
+	})
 }
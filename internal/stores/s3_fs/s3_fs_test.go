@@ -0,0 +1,63 @@
+package s3fs
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pupload/pupload/internal/stores/hosted"
+)
+
+func TestFilesystemS3Store_WritesObjectsUnderMountPath(t *testing.T) {
+	srv := httptest.NewServer(hosted.Handler())
+	defer srv.Close()
+
+	if err := hosted.Configure(hosted.Settings{PublicURL: srv.URL}); err != nil {
+		t.Fatalf("Configure() error = %v", err)
+	}
+
+	dir := t.TempDir()
+	mount := filepath.Join(dir, "objects")
+
+	store, err := NewFilesystemS3Store(FilesystemS3StoreInput{MountPath: mount, BucketName: "fs-bucket"})
+	if err != nil {
+		t.Fatalf("NewFilesystemS3Store() error = %v", err)
+	}
+
+	ctx := context.Background()
+	body := []byte("on disk")
+
+	putURL, err := store.PutURL(ctx, "folder/object.txt", time.Minute)
+	if err != nil {
+		t.Fatalf("PutURL() error = %v", err)
+	}
+
+	req, _ := http.NewRequestWithContext(ctx, http.MethodPut, putURL.String(), bytes.NewReader(body))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("PUT failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("PUT status = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+
+	got, err := os.ReadFile(filepath.Join(mount, "folder", "object.txt"))
+	if err != nil || !bytes.Equal(got, body) {
+		t.Fatalf("object on disk = %q, %v; want %q", got, err, body)
+	}
+
+	if !store.Exists("folder/object.txt") {
+		t.Fatalf("expected object to exist")
+	}
+
+	// The bucket can't be served from a second directory.
+	if _, err := NewFilesystemS3Store(FilesystemS3StoreInput{MountPath: filepath.Join(dir, "other"), BucketName: "fs-bucket"}); err == nil {
+		t.Fatalf("expected a second mount path for the bucket to fail")
+	}
+}
@@ -0,0 +1,61 @@
+// Package s3util holds helpers shared by the S3 compatible stores.
+package s3util
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/pupload/pupload/internal/models"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// PresignMultipart starts a multipart upload and presigns its part, complete
+// and abort requests. The upload is left open until a worker completes or
+// aborts it; buckets should expire incomplete uploads as a backstop.
+func PresignMultipart(ctx context.Context, client *minio.Client, bucket, objectName string, parts int, expires time.Duration) (*models.MultipartUpload, error) {
+	core := minio.Core{Client: client}
+
+	uploadID, err := core.NewMultipartUpload(ctx, bucket, objectName, minio.PutObjectOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("unable to start multipart upload: %w", err)
+	}
+
+	mp := &models.MultipartUpload{PartURLs: make([]string, 0, parts)}
+
+	for i := 1; i <= parts; i++ {
+		u, err := client.Presign(ctx, http.MethodPut, bucket, objectName, expires, url.Values{
+			"partNumber": {strconv.Itoa(i)},
+			"uploadId":   {uploadID},
+		})
+		if err != nil {
+			core.AbortMultipartUpload(ctx, bucket, objectName, uploadID)
+			return nil, err
+		}
+
+		mp.PartURLs = append(mp.PartURLs, u.String())
+	}
+
+	params := url.Values{"uploadId": {uploadID}}
+
+	complete, err := client.Presign(ctx, http.MethodPost, bucket, objectName, expires, params)
+	if err != nil {
+		core.AbortMultipartUpload(ctx, bucket, objectName, uploadID)
+		return nil, err
+	}
+
+	abort, err := client.Presign(ctx, http.MethodDelete, bucket, objectName, expires, params)
+	if err != nil {
+		core.AbortMultipartUpload(ctx, bucket, objectName, uploadID)
+		return nil, err
+	}
+
+	mp.CompleteURL = complete.String()
+	mp.AbortURL = abort.String()
+
+	return mp, nil
+}
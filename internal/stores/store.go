@@ -6,17 +6,21 @@ import (
 	"fmt"
 
 	"github.com/pupload/pupload/internal/models"
+	locals3 "github.com/pupload/pupload/internal/stores/local_s3"
 	"github.com/pupload/pupload/internal/stores/s3"
+	s3fs "github.com/pupload/pupload/internal/stores/s3_fs"
+
+	"github.com/johannesboyne/gofakes3"
 )
 
 func UnmarshalStore(input models.StoreInput) (models.Store, error) {
 
 	switch input.Type {
 
-	case "s3":
+	case models.StoreS3:
 		var params s3.S3StoreInput
 		if err := decodeParams(input.Params, &params); err != nil {
-			return nil, fmt.Errorf("error decoding params for s3local, %w", err)
+			return nil, fmt.Errorf("error decoding params for s3, %w", err)
 		}
 
 		store, err := s3.NewS3Store(params)
@@ -26,11 +30,80 @@ func UnmarshalStore(input models.StoreInput) (models.Store, error) {
 
 		return store, nil
 
+	case models.StoreFilesystem:
+		var params s3fs.FilesystemS3StoreInput
+		if err := decodeParams(input.Params, &params); err != nil {
+			return nil, fmt.Errorf("error decoding params for filesystem, %w", err)
+		}
+
+		store, err := s3fs.NewFilesystemS3Store(params)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to create filesystem store: %w", err)
+		}
+
+		return store, nil
+
+	case models.StoreMemory:
+		var params locals3.LocalS3StoreInput
+		if err := decodeParams(input.Params, &params); err != nil {
+			return nil, fmt.Errorf("error decoding params for memory, %w", err)
+		}
+
+		store, err := locals3.NewLocalS3Store(params)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to create memory store: %w", err)
+		}
+
+		return store, nil
+
 	default:
 		return nil, fmt.Errorf("invalid store tpye")
 	}
 }
 
+// CheckParams reports whether a store's params decode and, for the stores the
+// controller serves, name a valid bucket, without creating the store. Params
+// may still hold ${secret:name} references.
+func CheckParams(input models.StoreInput) error {
+	var bucket string
+
+	switch input.Type {
+	case models.StoreS3:
+		var params s3.S3StoreInput
+		return decodeParams(input.Params, &params)
+
+	case models.StoreFilesystem:
+		var params s3fs.FilesystemS3StoreInput
+		if err := decodeParams(input.Params, &params); err != nil {
+			return err
+		}
+
+		if params.MountPath == "" {
+			return fmt.Errorf("MountPath is required")
+		}
+
+		bucket = params.BucketName
+
+	case models.StoreMemory:
+		var params locals3.LocalS3StoreInput
+		if err := decodeParams(input.Params, &params); err != nil {
+			return err
+		}
+
+		bucket = params.BucketName
+
+	default:
+		return fmt.Errorf("invalid store type %q", input.Type)
+	}
+
+	// The bucket is part of the URLs the controller serves.
+	if err := gofakes3.ValidateBucketName(bucket); err != nil {
+		return fmt.Errorf("invalid BucketName %q: %w", bucket, err)
+	}
+
+	return nil
+}
+
 func decodeParams(raw json.RawMessage, out any) error {
 	dec := json.NewDecoder(bytes.NewReader(raw))
 	dec.DisallowUnknownFields()
@@ -0,0 +1,40 @@
+package syncplane
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+const maxSummaryLength = 256
+
+// summarizePayload extracts the identifiers shared by all task payloads, or
+// falls back to a truncated copy of the raw payload when it can't be decoded.
+func summarizePayload(payload []byte) string {
+	var ids struct {
+		RunID  string
+		NodeID string
+		Node   struct {
+			ID string
+		}
+	}
+
+	if err := json.Unmarshal(payload, &ids); err != nil || ids.RunID == "" {
+		raw := strings.ToValidUTF8(string(payload), "?")
+		if len(raw) > maxSummaryLength {
+			raw = raw[:maxSummaryLength] + "..."
+		}
+		return raw
+	}
+
+	nodeID := ids.NodeID
+	if nodeID == "" {
+		nodeID = ids.Node.ID
+	}
+
+	if nodeID == "" {
+		return fmt.Sprintf("run_id=%s", ids.RunID)
+	}
+
+	return fmt.Sprintf("run_id=%s node_id=%s", ids.RunID, nodeID)
+}
@@ -9,7 +9,9 @@ import (
 func CreateControllerSyncLayer(cfg SyncPlaneSettings) (SyncLayer, error) {
 	switch cfg.SelectedSyncPlane {
 	case "redis":
-		return NewControllerRedisSyncLayer(cfg), nil
+		return NewControllerRedisSyncLayer(cfg)
+	case "nats":
+		return NewControllerNatsSyncLayer(cfg)
 	}
 
 	return nil, fmt.Errorf("")
@@ -19,7 +21,9 @@ func CreateWorkerSyncLayer(cfg SyncPlaneSettings, rCfg resources.ResourceSetting
 
 	switch cfg.SelectedSyncPlane {
 	case "redis":
-		return NewWorkerRedisSyncLayer(cfg, rCfg), nil
+		return NewWorkerRedisSyncLayer(cfg, rCfg)
+	case "nats":
+		return NewWorkerNatsSyncLayer(cfg, rCfg)
 	}
 	return nil, fmt.Errorf("")
 }
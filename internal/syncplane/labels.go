@@ -0,0 +1,81 @@
+package syncplane
+
+import (
+	"time"
+
+	"github.com/pupload/pupload/internal/labels"
+	"github.com/pupload/pupload/internal/models"
+)
+
+const (
+	// WorkerHeartbeat is how often workers re-register and pick up new
+	// selector queues.
+	WorkerHeartbeat = 15 * time.Second
+
+	// workerTTL is how long a worker stays registered without a heartbeat.
+	workerTTL = 4 * WorkerHeartbeat
+)
+
+// QueueSelector is a queue carrying work for a tier that only workers with
+// matching labels may run.
+type QueueSelector struct {
+	Queue    string
+	Tier     string
+	Executor string
+	Selector labels.Selector
+}
+
+// WorkerInfo is what a worker advertises about itself.
+type WorkerInfo struct {
+	ID     string
+	Labels labels.Set
+	SeenAt time.Time
+
+	Queues []string // execute queues the worker consumes
+	Images []string // normalized references of the container images it has cached
+}
+
+// TierImageTTL is how long a tier's image stays listed after a node last used
+// it.
+const TierImageTTL = 7 * 24 * time.Hour
+
+// TierImage is a container image nodes of a tier were routed with.
+type TierImage struct {
+	Tier       string
+	Image      string // as written in the NodeDef
+	PullPolicy string
+	UsedAt     time.Time
+}
+
+func tierImage(p NodeExecutePayload) (TierImage, bool) {
+	if p.NodeDef.ExecutorName() != models.ExecutorContainer || p.NodeDef.Image == "" {
+		return TierImage{}, false
+	}
+
+	return TierImage{Tier: p.NodeDef.Tier, Image: p.NodeDef.Image, PullPolicy: p.NodeDef.PullPolicyName(), UsedAt: time.Now()}, true
+}
+
+// Selector is what a worker must satisfy to run the node. Nodes that don't
+// run in a container always have one, which keeps them off the plain tier
+// queue.
+func (p NodeExecutePayload) Selector() labels.Selector {
+	return labels.NodeSelector(p.NodeDef, p.Node)
+}
+
+// ExecuteQueue is the queue a node is routed to.
+func ExecuteQueue(p NodeExecutePayload) string {
+	return labels.QueueName(p.NodeDef.Tier, p.Selector())
+}
+
+func queueSelector(p NodeExecutePayload) QueueSelector {
+	return QueueSelector{
+		Queue:    ExecuteQueue(p),
+		Tier:     p.NodeDef.Tier,
+		Executor: p.NodeDef.ExecutorName(),
+		Selector: p.Selector(),
+	}
+}
+
+func workerFresh(w WorkerInfo, now time.Time) bool {
+	return now.Sub(w.SeenAt) < workerTTL
+}
@@ -0,0 +1,17 @@
+package syncplane
+
+import (
+	"time"
+)
+
+const (
+	// NodeLogTTL is how long a node's logs are kept once written.
+	NodeLogTTL = 24 * time.Hour
+
+	// NodeLogMaxBatches caps the batches kept per node, dropping the oldest.
+	// Workers bound the size of each batch.
+	NodeLogMaxBatches = 2000
+
+	// nodeLogReadBatches is how many batches one ReadNodeLogs call returns.
+	nodeLogReadBatches = 100
+)
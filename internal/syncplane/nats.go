@@ -0,0 +1,1412 @@
+package syncplane
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pupload/pupload/internal/logging"
+	"github.com/pupload/pupload/internal/models"
+	"github.com/pupload/pupload/internal/resources"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+const (
+	natsDefaultStream  = "PUP_TASKS"
+	natsSubjectPrefix  = "pup.tasks"
+	natsDLQSuffix      = "_DLQ"
+	natsDLQPrefix      = "pup.dlq"
+	natsLogsSuffix     = "_LOGS"
+	natsLogsPrefix     = "pup.logs"
+	natsSchedBucket    = "pup_sched_active_runs"
+	natsLockBucket     = "pup_locks"
+	natsKeyBucket      = "pup_keys"
+	natsTierBucket     = "pup_tiers"
+	natsSelectorBucket = "pup_selectors"
+	natsWorkerBucket   = "pup_workers"
+	natsImageBucket    = "pup_tier_images"
+	natsControllerName = "controller"
+
+	natsHeaderTaskType = "Pup-Task-Type"
+	natsHeaderMaxRetry = "Pup-Max-Retry"
+	natsHeaderQueue    = "Pup-Queue"
+	natsHeaderError    = "Pup-Error"
+	natsHeaderAttempts = "Pup-Attempts"
+
+	// natsHeaderPriorAttempts counts the attempts made before a handed back
+	// task was republished, since its deliveries start over.
+	natsHeaderPriorAttempts = "Pup-Prior-Attempts"
+
+	natsAckWait         = 30 * time.Second
+	natsDefaultMaxRetry = 25
+	natsConcurrency     = 10
+	natsDLQMaxAge       = 7 * 24 * time.Hour
+)
+
+var ErrLockNotAcquired = errors.New("syncplane: lock already held")
+
+type natsHandler func(ctx context.Context, msg jetstream.Msg) error
+
+// NatsSync implements SyncLayer on top of NATS JetStream. Every queue
+// (worker tiers and "controller") is a subject on a single work-queue stream,
+// consumed through one durable pull consumer per queue. Tasks that give up
+// are copied to a separate dead-letter stream. The step schedule and mutexes
+// live in KV buckets.
+type NatsSync struct {
+	nc     *nats.Conn
+	js     jetstream.JetStream
+	stream jetstream.Stream
+	dlq    jetstream.Stream
+	logs   jetstream.Stream
+
+	schedKV jetstream.KeyValue
+	lockKV  jetstream.KeyValue
+	keyKV   jetstream.KeyValue
+	tierKV  jetstream.KeyValue
+
+	selectorKV jetstream.KeyValue
+	workerKV   jetstream.KeyValue
+	imageKV    jetstream.KeyValue
+
+	stepInterval  time.Duration
+	stepShards    int
+	stepBatchSize int
+	controller    bool
+
+	handlers  map[string]natsHandler
+	queues    map[string]int
+	consumers map[string]jetstream.ConsumeContext
+	started   bool
+	sem       chan struct{}
+
+	schedCancel context.CancelFunc
+
+	mu sync.Mutex
+
+	log *slog.Logger
+}
+
+func NewControllerNatsSyncLayer(cfg SyncPlaneSettings) (*NatsSync, error) {
+	n, err := newNatsSync(cfg, logging.ForService("controller-synclayer"))
+	if err != nil {
+		return nil, err
+	}
+
+	n.controller = true
+	n.queues = map[string]int{natsControllerName: 1}
+
+	return n, nil
+}
+
+// NewWorkerNatsSyncLayer starts out consuming the queue of every tier rCfg
+// allows, like the Redis sync plane, until the worker narrows them with
+// UpdateSubscribedQueues.
+func NewWorkerNatsSyncLayer(cfg SyncPlaneSettings, rCfg resources.ResourceSettings) (*NatsSync, error) {
+	rm, err := resources.CreateResourceManager(rCfg)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create resource manager: %w", err)
+	}
+
+	n, err := newNatsSync(cfg, logging.ForService("worker-synclayer"))
+	if err != nil {
+		return nil, err
+	}
+
+	n.queues = rm.GetValidTierMap()
+
+	return n, nil
+}
+
+func newNatsSync(cfg SyncPlaneSettings, log *slog.Logger) (*NatsSync, error) {
+	opts := []nats.Option{nats.Name("pupload"), nats.MaxReconnects(-1)}
+	if cfg.Nats.Credentials != "" {
+		opts = append(opts, nats.UserCredentials(cfg.Nats.Credentials))
+	}
+
+	url := cfg.Nats.URL
+	if url == "" {
+		url = nats.DefaultURL
+	}
+
+	nc, err := nats.Connect(url, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to nats: %w", err)
+	}
+
+	js, err := jetstream.New(nc)
+	if err != nil {
+		nc.Close()
+		return nil, fmt.Errorf("unable to create jetstream context: %w", err)
+	}
+
+	streamName := cfg.Nats.StreamName
+	if streamName == "" {
+		streamName = natsDefaultStream
+	}
+
+	replicas := max(cfg.Nats.Replicas, 1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	stream, err := js.CreateOrUpdateStream(ctx, jetstream.StreamConfig{
+		Name:      streamName,
+		Subjects:  []string{natsSubjectPrefix + ".>"},
+		Retention: jetstream.WorkQueuePolicy,
+		Replicas:  replicas,
+	})
+	if err != nil {
+		nc.Close()
+		return nil, fmt.Errorf("unable to create task stream: %w", err)
+	}
+
+	dlq, err := js.CreateOrUpdateStream(ctx, jetstream.StreamConfig{
+		Name:     streamName + natsDLQSuffix,
+		Subjects: []string{natsDLQPrefix + ".>"},
+		MaxAge:   natsDLQMaxAge,
+		Replicas: replicas,
+	})
+	if err != nil {
+		nc.Close()
+		return nil, fmt.Errorf("unable to create dead-letter stream: %w", err)
+	}
+
+	// One subject per node, each keeping its latest batches.
+	logs, err := js.CreateOrUpdateStream(ctx, jetstream.StreamConfig{
+		Name:              streamName + natsLogsSuffix,
+		Subjects:          []string{natsLogsPrefix + ".>"},
+		MaxMsgsPerSubject: NodeLogMaxBatches,
+		MaxAge:            NodeLogTTL,
+		Replicas:          replicas,
+	})
+	if err != nil {
+		nc.Close()
+		return nil, fmt.Errorf("unable to create log stream: %w", err)
+	}
+
+	schedKV, err := js.CreateOrUpdateKeyValue(ctx, jetstream.KeyValueConfig{
+		Bucket:   natsSchedBucket,
+		Replicas: replicas,
+	})
+	if err != nil {
+		nc.Close()
+		return nil, fmt.Errorf("unable to create scheduler bucket: %w", err)
+	}
+
+	lockKV, err := js.CreateOrUpdateKeyValue(ctx, jetstream.KeyValueConfig{
+		Bucket:   natsLockBucket,
+		Replicas: replicas,
+	})
+	if err != nil {
+		nc.Close()
+		return nil, fmt.Errorf("unable to create lock bucket: %w", err)
+	}
+
+	keyKV, err := js.CreateOrUpdateKeyValue(ctx, jetstream.KeyValueConfig{
+		Bucket:   natsKeyBucket,
+		Replicas: replicas,
+	})
+	if err != nil {
+		nc.Close()
+		return nil, fmt.Errorf("unable to create key bucket: %w", err)
+	}
+
+	tierKV, err := js.CreateOrUpdateKeyValue(ctx, jetstream.KeyValueConfig{
+		Bucket:   natsTierBucket,
+		Replicas: replicas,
+	})
+	if err != nil {
+		nc.Close()
+		return nil, fmt.Errorf("unable to create tier bucket: %w", err)
+	}
+
+	selectorKV, err := js.CreateOrUpdateKeyValue(ctx, jetstream.KeyValueConfig{
+		Bucket:   natsSelectorBucket,
+		Replicas: replicas,
+	})
+	if err != nil {
+		nc.Close()
+		return nil, fmt.Errorf("unable to create selector bucket: %w", err)
+	}
+
+	// Workers that stop heartbeating age out of the bucket on their own.
+	workerKV, err := js.CreateOrUpdateKeyValue(ctx, jetstream.KeyValueConfig{
+		Bucket:   natsWorkerBucket,
+		TTL:      workerTTL,
+		Replicas: replicas,
+	})
+	if err != nil {
+		nc.Close()
+		return nil, fmt.Errorf("unable to create worker bucket: %w", err)
+	}
+
+	imageKV, err := js.CreateOrUpdateKeyValue(ctx, jetstream.KeyValueConfig{
+		Bucket:   natsImageBucket,
+		TTL:      TierImageTTL,
+		Replicas: replicas,
+	})
+	if err != nil {
+		nc.Close()
+		return nil, fmt.Errorf("unable to create tier image bucket: %w", err)
+	}
+
+	return &NatsSync{
+		nc:     nc,
+		js:     js,
+		stream: stream,
+		dlq:    dlq,
+		logs:   logs,
+
+		schedKV: schedKV,
+		lockKV:  lockKV,
+		keyKV:   keyKV,
+		tierKV:  tierKV,
+
+		selectorKV: selectorKV,
+		workerKV:   workerKV,
+		imageKV:    imageKV,
+
+		stepInterval:  parseStepInterval(cfg.ControllerStepInterval),
+		stepShards:    stepShards(cfg),
+		stepBatchSize: stepBatchSize(cfg),
+
+		handlers:  make(map[string]natsHandler),
+		queues:    make(map[string]int),
+		consumers: make(map[string]jetstream.ConsumeContext),
+		sem:       make(chan struct{}, natsConcurrency),
+
+		log: log,
+	}, nil
+}
+
+// parseStepInterval accepts the "@every <duration>" form of a cronspec.
+// Anything else falls back to 10 seconds.
+func parseStepInterval(spec string) time.Duration {
+	d, err := time.ParseDuration(strings.TrimSpace(strings.TrimPrefix(spec, "@every")))
+	if err != nil || d <= 0 {
+		return 10 * time.Second
+	}
+
+	return d
+}
+
+func queueSubject(queue string) string {
+	return natsSubjectPrefix + "." + queue
+}
+
+// kvKey maps arbitrary identifiers onto the character set allowed for KV keys.
+func kvKey(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		case r == '-', r == '_', r == '=', r == '/':
+			return r
+		}
+		return '_'
+	}, s)
+}
+
+func (n *NatsSync) publish(ctx context.Context, queue, taskType string, payload any, maxRetry int, opts ...jetstream.PublishOpt) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	msg := nats.NewMsg(queueSubject(queue))
+	msg.Data = data
+	msg.Header.Set(natsHeaderTaskType, taskType)
+	msg.Header.Set(natsHeaderMaxRetry, strconv.Itoa(maxRetry))
+
+	_, err = n.js.PublishMsg(ctx, msg, opts...)
+	return err
+}
+
+func (n *NatsSync) handle(taskType string, h natsHandler) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.handlers[taskType] = h
+	return nil
+}
+
+func (n *NatsSync) RegisterExecuteNodeHandler(handler ExecuteNodeHandler) error {
+	return n.handle(TypeNodeExecute, func(ctx context.Context, msg jetstream.Msg) error {
+		var p NodeExecutePayload
+		if err := json.Unmarshal(msg.Data(), &p); err != nil {
+			return fmt.Errorf("ExecuteNodeHandler: Error unmarshaling payload: %w: %w", err, ErrSkipRetry)
+		}
+
+		md, err := msg.Metadata()
+		if err != nil {
+			return err
+		}
+		p.Attempt = attempts(msg, md)
+		p.AttemptID = AttemptID(p.ExecutionID, p.Attempt)
+
+		return handler(ctx, p)
+	})
+}
+
+func (n *NatsSync) EnqueueExecuteNode(payload NodeExecutePayload) error {
+	queue := ExecuteQueue(payload)
+	if queue != payload.NodeDef.Tier {
+		if err := n.publishSelector(context.TODO(), queueSelector(payload)); err != nil {
+			return err
+		}
+	}
+
+	if ti, ok := tierImage(payload); ok {
+		if err := n.publishTierImage(context.TODO(), ti); err != nil {
+			n.log.Warn("unable to record tier image", "tier", ti.Tier, "image", ti.Image, "err", err)
+		}
+	}
+
+	n.log.Debug("enqueued node def", "tier", payload.NodeDef.Tier, "queue", queue)
+	return n.publish(context.TODO(), queue, TypeNodeExecute, payload, payload.MaxAttempts-1)
+}
+
+func (n *NatsSync) RegisterNodeFinishedHandler(handler NodeFinishedHandler) error {
+	return n.handle(TypeNodeFinished, func(ctx context.Context, msg jetstream.Msg) error {
+		var p NodeFinishedPayload
+		if err := json.Unmarshal(msg.Data(), &p); err != nil {
+			return fmt.Errorf("RegisterNodeFinishedHandler: Error unmarshaling payload: %w: %w", err, ErrSkipRetry)
+		}
+		return handler(ctx, p)
+	})
+}
+
+func (n *NatsSync) EnqueueNodeFinished(payload NodeFinishedPayload) error {
+	return n.publish(context.TODO(), natsControllerName, TypeNodeFinished, payload, natsDefaultMaxRetry)
+}
+
+func (n *NatsSync) RegisterNodeFailedHandler(handler NodeFailedHandler) error {
+	return n.handle(TypeNodeFailed, func(ctx context.Context, msg jetstream.Msg) error {
+		var p NodeFailedPayload
+		if err := json.Unmarshal(msg.Data(), &p); err != nil {
+			return fmt.Errorf("RegisterNodeFailedHandler: Error unmarshaling payload: %w: %w", err, ErrSkipRetry)
+		}
+		return handler(ctx, p)
+	})
+}
+
+func (n *NatsSync) EnqueueNodeFailed(payload NodeFailedPayload) error {
+	return n.publish(context.TODO(), natsControllerName, TypeNodeFailed, payload, natsDefaultMaxRetry)
+}
+
+func (n *NatsSync) RegisterNodeProgressHandler(handler NodeProgressHandler) error {
+	return n.handle(TypeNodeProgress, func(ctx context.Context, msg jetstream.Msg) error {
+		var p NodeProgressPayload
+		if err := json.Unmarshal(msg.Data(), &p); err != nil {
+			return fmt.Errorf("RegisterNodeProgressHandler: Error unmarshaling payload: %w: %w", err, ErrSkipRetry)
+		}
+		return handler(ctx, p)
+	})
+}
+
+func (n *NatsSync) EnqueueNodeProgress(payload NodeProgressPayload) error {
+	// A newer report replaces a lost one, so none is retried.
+	return n.publish(context.TODO(), natsControllerName, TypeNodeProgress, payload, 0)
+}
+
+func (n *NatsSync) RegisterFlowStepHandler(handler FlowStepHandler) error {
+	return n.handle(TypeFlowStep, func(ctx context.Context, msg jetstream.Msg) error {
+		var p FlowStepPayload
+		if err := json.Unmarshal(msg.Data(), &p); err != nil {
+			return fmt.Errorf("RegisterFlowStepHandler: Error unmarshaling payload: %w: %w", err, ErrSkipRetry)
+		}
+		return handler(ctx, p)
+	})
+}
+
+// UpdateSubscribedQueues consumes exactly the given queues. JetStream has no
+// notion of queue weights, so the priorities are ignored.
+func (n *NatsSync) UpdateSubscribedQueues(queues map[string]int) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.queues = make(map[string]int, len(queues))
+	for q, p := range queues {
+		n.queues[q] = p
+	}
+
+	if !n.started {
+		return nil
+	}
+
+	return n.syncConsumers()
+}
+
+// syncConsumers starts and stops consumers so they match n.queues. Callers
+// must hold n.mu.
+func (n *NatsSync) syncConsumers() error {
+	for queue, cc := range n.consumers {
+		if _, ok := n.queues[queue]; !ok {
+			cc.Stop()
+			delete(n.consumers, queue)
+		}
+	}
+
+	for queue := range n.queues {
+		if _, ok := n.consumers[queue]; ok {
+			continue
+		}
+
+		cc, err := n.consume(queue)
+		if err != nil {
+			return fmt.Errorf("unable to consume queue %s: %w", queue, err)
+		}
+
+		n.consumers[queue] = cc
+	}
+
+	return nil
+}
+
+func (n *NatsSync) consume(queue string) (jetstream.ConsumeContext, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	cons, err := n.stream.CreateOrUpdateConsumer(ctx, jetstream.ConsumerConfig{
+		Durable:       "queue-" + kvKey(queue),
+		FilterSubject: queueSubject(queue),
+		AckPolicy:     jetstream.AckExplicitPolicy,
+		AckWait:       natsAckWait,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return cons.Consume(func(msg jetstream.Msg) {
+		n.sem <- struct{}{}
+		go func() {
+			defer func() { <-n.sem }()
+			n.dispatch(msg)
+		}()
+	}, jetstream.PullMaxMessages(1))
+}
+
+func (n *NatsSync) dispatch(msg jetstream.Msg) {
+	taskType := msg.Headers().Get(natsHeaderTaskType)
+
+	n.mu.Lock()
+	h, ok := n.handlers[taskType]
+	n.mu.Unlock()
+
+	if !ok {
+		n.log.Warn("no handler registered for task", "type", taskType, "subject", msg.Subject())
+		n.deadLetter(msg, fmt.Errorf("no handler registered for %s", taskType))
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// keep the message from being redelivered while the handler runs
+	go func() {
+		ticker := time.NewTicker(natsAckWait / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				msg.InProgress()
+			}
+		}
+	}()
+
+	err := h(ctx, msg)
+	if err == nil {
+		msg.Ack()
+		return
+	}
+
+	maxRetry, convErr := strconv.Atoi(msg.Headers().Get(natsHeaderMaxRetry))
+	if convErr != nil {
+		maxRetry = natsDefaultMaxRetry
+	}
+
+	md, mdErr := msg.Metadata()
+
+	// Handing back doesn't use up an attempt, so it is allowed on the last.
+	if mdErr == nil && errors.Is(err, ErrHandBack) && !errors.Is(err, ErrSkipRetry) {
+		n.handBack(msg, md)
+		return
+	}
+
+	if mdErr != nil || attempts(msg, md) > maxRetry || errors.Is(err, ErrSkipRetry) {
+		n.log.Warn("task failed, giving up", "type", taskType, "err", err)
+		n.deadLetter(msg, err)
+		return
+	}
+
+	msg.NakWithDelay(retryDelay(attempts(msg, md)))
+}
+
+// attempts is how many times the task has been delivered, counting the
+// deliveries of the copies it was handed back as before.
+func attempts(msg jetstream.Msg, md *jetstream.MsgMetadata) int {
+	prior, _ := strconv.Atoi(msg.Headers().Get(natsHeaderPriorAttempts))
+	return prior + int(md.NumDelivered)
+}
+
+// handBack republishes msg after HandBackDelay. A Nak would count the
+// delivery, so a copy carrying the attempts made so far replaces it.
+func (n *NatsSync) handBack(msg jetstream.Msg, md *jetstream.MsgMetadata) {
+	time.AfterFunc(HandBackDelay, func() {
+		again := nats.NewMsg(msg.Subject())
+		again.Data = msg.Data()
+		for k, v := range msg.Headers() {
+			again.Header[k] = v
+		}
+		again.Header.Set(natsHeaderPriorAttempts, strconv.Itoa(attempts(msg, md)-1))
+
+		if _, err := n.js.PublishMsg(context.Background(), again); err != nil {
+			n.log.Warn("unable to hand task back", "subject", msg.Subject(), "err", err)
+			msg.Nak()
+			return
+		}
+
+		msg.Ack()
+	})
+}
+
+// deadLetter copies msg onto the dead-letter stream and terminates it.
+func (n *NatsSync) deadLetter(msg jetstream.Msg, cause error) {
+	queue := strings.TrimPrefix(msg.Subject(), natsSubjectPrefix+".")
+
+	delivered := 1
+	if md, err := msg.Metadata(); err == nil {
+		delivered = attempts(msg, md)
+	}
+
+	dead := nats.NewMsg(natsDLQPrefix + "." + queue)
+	dead.Data = msg.Data()
+	dead.Header.Set(natsHeaderTaskType, msg.Headers().Get(natsHeaderTaskType))
+	dead.Header.Set(natsHeaderMaxRetry, msg.Headers().Get(natsHeaderMaxRetry))
+	dead.Header.Set(natsHeaderQueue, queue)
+	dead.Header.Set(natsHeaderError, cause.Error())
+	dead.Header.Set(natsHeaderAttempts, strconv.Itoa(delivered))
+
+	if _, err := n.js.PublishMsg(context.Background(), dead); err != nil {
+		// leave the task to be redelivered rather than lose it
+		n.log.Error("unable to dead-letter task", "queue", queue, "err", err)
+		msg.Nak()
+		return
+	}
+
+	msg.TermWithReason(cause.Error())
+}
+
+// retryDelay backs off exponentially, capped at one minute.
+func retryDelay(delivered int) time.Duration {
+	d := time.Second << min(delivered, 6)
+	return min(d, time.Minute)
+}
+
+// scheduledRun is the value stored for each run in the schedule bucket.
+// Keys are "<shard>.<run>" so a shard can be listed with a subject filter.
+type scheduledRun struct {
+	RunID string
+	Next  time.Time
+	Idle  int
+}
+
+func (n *NatsSync) scheduleKey(runID string) string {
+	return fmt.Sprintf("%d.%s", stepShard(runID, n.stepShards), kvKey(runID))
+}
+
+// StartScheduler claims due runs from every shard and publishes a flow step
+// for each until ctx is done. Every controller replica runs it; claims are
+// revision-checked updates, so a run is only claimed once per lease. Runs are
+// found in an index fed by a watch on the bucket, so polling costs nothing
+// per scheduled run.
+func (n *NatsSync) StartScheduler(ctx context.Context) {
+	index := &schedIndex{runs: make(map[string]indexedRun)}
+	ready := make(chan struct{})
+	go n.watchSchedule(ctx, index, ready)
+
+	select {
+	case <-ready:
+	case <-ctx.Done():
+		return
+	}
+
+	ticker := time.NewTicker(stepPollInterval)
+	defer ticker.Stop()
+
+	for {
+		for shard := range n.stepShards {
+			n.claimShard(ctx, index, shard)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// schedIndex mirrors the schedule bucket, by key.
+type schedIndex struct {
+	mu   sync.Mutex
+	runs map[string]indexedRun
+}
+
+type indexedRun struct {
+	key   string
+	shard int
+	run   scheduledRun
+	rev   uint64
+}
+
+// set records a revision of a run unless a later one is known.
+func (x *schedIndex) set(r indexedRun) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	if cur, ok := x.runs[r.key]; !ok || cur.rev < r.rev {
+		x.runs[r.key] = r
+	}
+}
+
+// reset replaces the index with runs.
+func (x *schedIndex) reset(runs map[string]indexedRun) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	x.runs = runs
+}
+
+func (x *schedIndex) remove(key string) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	delete(x.runs, key)
+}
+
+// due returns up to limit runs of shard that are due at now, the longest
+// overdue first.
+func (x *schedIndex) due(shard int, now time.Time, limit int) []indexedRun {
+	x.mu.Lock()
+	var due []indexedRun
+	for _, r := range x.runs {
+		if r.shard == shard && !r.run.Next.After(now) {
+			due = append(due, r)
+		}
+	}
+	x.mu.Unlock()
+
+	slices.SortFunc(due, func(a, b indexedRun) int {
+		if c := a.run.Next.Compare(b.run.Next); c != 0 {
+			return c
+		}
+		return strings.Compare(a.key, b.key)
+	})
+
+	return due[:min(len(due), limit)]
+}
+
+// watchSchedule mirrors the schedule bucket into index until ctx is done,
+// closing ready once the index first holds the bucket's current runs. A watch
+// that can't be started, or that ends, eg. on a reconnect, is started again
+// after a backoff and the index rebuilt from its current values.
+func (n *NatsSync) watchSchedule(ctx context.Context, index *schedIndex, ready chan struct{}) {
+	var once sync.Once
+	loaded := func() { once.Do(func() { close(ready) }) }
+
+	failures := 0
+	for {
+		synced, err := n.watchScheduleOnce(ctx, index, loaded)
+		if ctx.Err() != nil {
+			return
+		}
+
+		if synced {
+			failures = 0
+		}
+		failures++
+
+		n.log.Warn("step schedule watch ended, restarting", "err", err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(retryDelay(failures)):
+		}
+	}
+}
+
+// watchScheduleOnce runs a single watch of the schedule bucket, returning
+// whether it got as far as loading the current runs. The watch is an ordered
+// consumer, so after those only changes are sent.
+func (n *NatsSync) watchScheduleOnce(ctx context.Context, index *schedIndex, loaded func()) (bool, error) {
+	watcher, err := n.schedKV.WatchAll(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer watcher.Stop()
+
+	// The current runs are collected apart and swapped in whole, so runs
+	// deleted while no watch was running drop out of the index.
+	initial := make(map[string]indexedRun)
+
+	for {
+		var entry jetstream.KeyValueEntry
+		var ok bool
+
+		select {
+		case <-ctx.Done():
+			return initial == nil, ctx.Err()
+		case entry, ok = <-watcher.Updates():
+		}
+
+		if !ok {
+			return initial == nil, fmt.Errorf("watch closed")
+		}
+
+		if entry == nil {
+			// the current values have all been sent
+			index.reset(initial)
+			initial = nil
+			loaded()
+			continue
+		}
+
+		if entry.Operation() != jetstream.KeyValuePut {
+			if initial != nil {
+				delete(initial, entry.Key())
+			} else {
+				index.remove(entry.Key())
+			}
+			continue
+		}
+
+		r, ok := parseIndexedRun(entry)
+		if !ok {
+			continue
+		}
+
+		if initial != nil {
+			initial[r.key] = r
+		} else {
+			index.set(r)
+		}
+	}
+}
+
+func parseIndexedRun(entry jetstream.KeyValueEntry) (indexedRun, bool) {
+	var run scheduledRun
+	if err := json.Unmarshal(entry.Value(), &run); err != nil {
+		return indexedRun{}, false
+	}
+
+	shard, _, _ := strings.Cut(entry.Key(), ".")
+	s, err := strconv.Atoi(shard)
+	if err != nil {
+		return indexedRun{}, false
+	}
+
+	return indexedRun{key: entry.Key(), shard: s, run: run, rev: entry.Revision()}, true
+}
+
+func (n *NatsSync) claimShard(ctx context.Context, index *schedIndex, shard int) {
+	now := time.Now()
+
+	for _, due := range index.due(shard, now, n.stepBatchSize) {
+		run := due.run
+		run.Next = now.Add(stepLease(n.stepInterval))
+		value, err := json.Marshal(run)
+		if err != nil {
+			continue
+		}
+
+		rev, err := n.schedKV.Update(ctx, due.key, value, due.rev)
+		if err != nil {
+			// claimed by another replica, or rescheduled meanwhile; the
+			// watch brings the index up to date
+			continue
+		}
+
+		// Recorded now, so the next poll doesn't retry the claim before the
+		// watch catches up.
+		index.set(indexedRun{key: due.key, shard: shard, run: run, rev: rev})
+
+		msgID := fmt.Sprintf("%s:%d", run.RunID, rev)
+		if err := n.publish(ctx, natsControllerName, TypeFlowStep, FlowStepPayload{RunID: run.RunID}, natsDefaultMaxRetry, jetstream.WithMsgID(msgID)); err != nil {
+			n.log.Error("unable to publish flow step", "run_id", run.RunID, "err", err)
+		}
+	}
+}
+
+func (n *NatsSync) StopScheduler(ctx context.Context) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.schedCancel != nil {
+		n.schedCancel()
+		n.schedCancel = nil
+	}
+}
+
+func (n *NatsSync) listScheduledRuns(ctx context.Context) ([]string, error) {
+	keys, err := n.schedKV.Keys(ctx)
+	if errors.Is(err, jetstream.ErrNoKeysFound) {
+		return []string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, 0, len(keys))
+	for _, key := range keys {
+		_, id, _ := strings.Cut(key, ".")
+		ids = append(ids, id)
+	}
+
+	return ids, nil
+}
+
+func (n *NatsSync) AddRunToScheduler(run_id string) error {
+	value, err := json.Marshal(scheduledRun{RunID: run_id, Next: time.Now()})
+	if err != nil {
+		return err
+	}
+
+	_, err = n.schedKV.Put(context.TODO(), n.scheduleKey(run_id), value)
+	return err
+}
+
+func (n *NatsSync) RescheduleRun(run_id string, idle bool) error {
+	ctx := context.TODO()
+	key := n.scheduleKey(run_id)
+
+	var err error
+	for range natsLockTries {
+		// a concurrent claim bumps the revision; retry against the new one
+		if err = n.rescheduleOnce(ctx, key, idle); err == nil {
+			return nil
+		}
+	}
+
+	return err
+}
+
+func (n *NatsSync) rescheduleOnce(ctx context.Context, key string, idle bool) error {
+	entry, err := n.schedKV.Get(ctx, key)
+	if errors.Is(err, jetstream.ErrKeyNotFound) {
+		// removed from the scheduler, nothing to do
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var run scheduledRun
+	if err := json.Unmarshal(entry.Value(), &run); err != nil {
+		return err
+	}
+
+	if idle {
+		run.Idle++
+	} else {
+		run.Idle = 0
+	}
+	run.Next = time.Now().Add(stepBackoff(run.Idle, n.stepInterval))
+
+	value, err := json.Marshal(run)
+	if err != nil {
+		return err
+	}
+
+	_, err = n.schedKV.Update(ctx, key, value, entry.Revision())
+	return err
+}
+
+func (n *NatsSync) RemoveRunFromScheduler(run_id string) error {
+	return n.schedKV.Purge(context.TODO(), n.scheduleKey(run_id))
+}
+
+// ListDeadLetters reads a page of the dead-letter stream through an ordered
+// consumer starting at the cursor's sequence, so sequences left empty by
+// deletes cost nothing.
+func (n *NatsSync) ListDeadLetters(ctx context.Context, cursor string, limit int) ([]DeadLetter, string, error) {
+	if limit <= 0 {
+		limit = DeadLetterPageSize
+	}
+
+	start := uint64(1)
+	if cursor != "" {
+		seq, err := strconv.ParseUint(cursor, 10, 64)
+		if err != nil {
+			return nil, "", fmt.Errorf("invalid dead letter cursor %s", cursor)
+		}
+		start = seq
+	}
+
+	info, err := n.dlq.Info(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+
+	letters := make([]DeadLetter, 0)
+	if info.State.Msgs == 0 || start > info.State.LastSeq {
+		return letters, "", nil
+	}
+
+	cons, err := n.dlq.OrderedConsumer(ctx, jetstream.OrderedConsumerConfig{
+		DeliverPolicy:     jetstream.DeliverByStartSequencePolicy,
+		OptStartSeq:       start,
+		InactiveThreshold: 10 * time.Second,
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	batch, err := cons.FetchNoWait(limit)
+	if err != nil {
+		return nil, "", err
+	}
+
+	last := start - 1
+	for msg := range batch.Messages() {
+		md, err := msg.Metadata()
+		if err != nil {
+			return nil, "", err
+		}
+		last = md.Sequence.Stream
+
+		attempts, _ := strconv.Atoi(msg.Headers().Get(natsHeaderAttempts))
+		letters = append(letters, DeadLetter{
+			ID:       strconv.FormatUint(last, 10),
+			Queue:    msg.Headers().Get(natsHeaderQueue),
+			Type:     msg.Headers().Get(natsHeaderTaskType),
+			Summary:  summarizePayload(msg.Data()),
+			Error:    msg.Headers().Get(natsHeaderError),
+			Attempts: attempts,
+			FailedAt: md.Timestamp,
+		})
+	}
+	if err := batch.Error(); err != nil {
+		return nil, "", err
+	}
+
+	if len(letters) < limit || last >= info.State.LastSeq {
+		return letters, "", nil
+	}
+
+	return letters, strconv.FormatUint(last+1, 10), nil
+}
+
+func (n *NatsSync) getDeadLetter(ctx context.Context, queue, id string) (*jetstream.RawStreamMsg, error) {
+	seq, err := strconv.ParseUint(id, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid dead letter id %s", id)
+	}
+
+	raw, err := n.dlq.GetMsg(ctx, seq)
+	if err != nil {
+		return nil, err
+	}
+
+	if raw.Header.Get(natsHeaderQueue) != queue {
+		return nil, fmt.Errorf("dead letter %s does not belong to queue %s", id, queue)
+	}
+
+	return raw, nil
+}
+
+func (n *NatsSync) RequeueDeadLetter(ctx context.Context, queue, id string) error {
+	raw, err := n.getDeadLetter(ctx, queue, id)
+	if err != nil {
+		return err
+	}
+
+	msg := nats.NewMsg(queueSubject(queue))
+	msg.Data = raw.Data
+	msg.Header.Set(natsHeaderTaskType, raw.Header.Get(natsHeaderTaskType))
+	msg.Header.Set(natsHeaderMaxRetry, raw.Header.Get(natsHeaderMaxRetry))
+
+	if _, err := n.js.PublishMsg(ctx, msg); err != nil {
+		return err
+	}
+
+	return n.dlq.DeleteMsg(ctx, raw.Sequence)
+}
+
+func (n *NatsSync) DeleteDeadLetter(ctx context.Context, queue, id string) error {
+	raw, err := n.getDeadLetter(ctx, queue, id)
+	if err != nil {
+		return err
+	}
+
+	return n.dlq.DeleteMsg(ctx, raw.Sequence)
+}
+
+func (n *NatsSync) PurgeDeadLetters(ctx context.Context) (int, error) {
+	info, err := n.dlq.Info(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := n.dlq.Purge(ctx); err != nil {
+		return 0, err
+	}
+
+	return int(info.State.Msgs), nil
+}
+
+type NatsMutex struct {
+	kv       jetstream.KeyValue
+	key      string
+	duration time.Duration
+	rev      uint64
+}
+
+// NewMutex returns a lease-style lock stored in the lock bucket. The value
+// holds the lease deadline, so an abandoned lock can be taken over with a
+// compare-and-swap once it has expired.
+func (n *NatsSync) NewMutex(run_id string, duration time.Duration) Mutex {
+	return &NatsMutex{
+		kv:       n.lockKV,
+		key:      kvKey(fmt.Sprintf("natsmutex:%s", run_id)),
+		duration: duration,
+	}
+}
+
+const natsLockTries = 32
+
+func (m *NatsMutex) Lock(ctx context.Context) error {
+	for range natsLockTries {
+		if err := m.TryLock(ctx); !errors.Is(err, ErrLockNotAcquired) {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Duration(50+rand.IntN(200)) * time.Millisecond):
+		}
+	}
+
+	return ErrLockNotAcquired
+}
+
+func (m *NatsMutex) TryLock(ctx context.Context) error {
+	deadline := []byte(strconv.FormatInt(time.Now().Add(m.duration).UnixNano(), 10))
+
+	rev, err := m.kv.Create(ctx, m.key, deadline)
+	if err == nil {
+		m.rev = rev
+		return nil
+	}
+
+	if !errors.Is(err, jetstream.ErrKeyExists) {
+		return err
+	}
+
+	entry, err := m.kv.Get(ctx, m.key)
+	if err == nil && leaseExpired(entry.Value()) {
+		if rev, err := m.kv.Update(ctx, m.key, deadline, entry.Revision()); err == nil {
+			m.rev = rev
+			return nil
+		}
+	}
+
+	return ErrLockNotAcquired
+}
+
+func leaseExpired(value []byte) bool {
+	deadline, err := strconv.ParseInt(string(value), 10, 64)
+	if err != nil {
+		return true
+	}
+
+	return time.Now().UnixNano() > deadline
+}
+
+func (m *NatsMutex) Unlock(ctx context.Context) error {
+	return m.kv.Delete(ctx, m.key, jetstream.LastRevision(m.rev))
+}
+
+func (n *NatsSync) Start() error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.started = true
+	if err := n.syncConsumers(); err != nil {
+		return err
+	}
+
+	if n.controller {
+		ctx, cancel := context.WithCancel(context.Background())
+		n.schedCancel = cancel
+		go n.StartScheduler(ctx)
+	}
+
+	return nil
+}
+
+func (n *NatsSync) Close() error {
+	n.mu.Lock()
+	for queue, cc := range n.consumers {
+		cc.Stop()
+		delete(n.consumers, queue)
+	}
+
+	if n.schedCancel != nil {
+		n.schedCancel()
+		n.schedCancel = nil
+	}
+	n.mu.Unlock()
+
+	return n.nc.Drain()
+}
+
+func (n *NatsSync) PublishTiers(ctx context.Context, tiers map[string]resources.ResourceDefinition) error {
+	for name, def := range tiers {
+		data, err := json.Marshal(def)
+		if err != nil {
+			return fmt.Errorf("unable to encode tier %s: %w", name, err)
+		}
+
+		if n.controller {
+			_, err = n.tierKV.Put(ctx, kvKey(name), data)
+		} else if _, err = n.tierKV.Create(ctx, kvKey(name), data); errors.Is(err, jetstream.ErrKeyExists) {
+			err = nil
+		}
+
+		if err != nil {
+			return fmt.Errorf("unable to publish tier %s: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+func (n *NatsSync) SharedKey(ctx context.Context, name string) ([]byte, error) {
+	key := newSharedKey()
+
+	_, err := n.keyKV.Create(ctx, kvKey(name), key)
+	if err == nil {
+		return key, nil
+	}
+
+	if !errors.Is(err, jetstream.ErrKeyExists) {
+		return nil, fmt.Errorf("unable to store key %s: %w", name, err)
+	}
+
+	entry, err := n.keyKV.Get(ctx, kvKey(name))
+	if err != nil {
+		return nil, fmt.Errorf("unable to read key %s: %w", name, err)
+	}
+
+	return entry.Value(), nil
+}
+
+func (n *NatsSync) ListTiers(ctx context.Context) (map[string]resources.ResourceDefinition, error) {
+	tiers := make(map[string]resources.ResourceDefinition)
+
+	err := n.listValues(ctx, n.tierKV, func(name string, data []byte) {
+		var def resources.ResourceDefinition
+		if err := json.Unmarshal(data, &def); err != nil {
+			n.log.Warn("skipping undecodable tier", "tier", name, "err", err)
+			return
+		}
+
+		tiers[name] = def
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to list tiers: %w", err)
+	}
+
+	return tiers, nil
+}
+
+func (n *NatsSync) publishSelector(ctx context.Context, qs QueueSelector) error {
+	data, err := json.Marshal(qs)
+	if err != nil {
+		return err
+	}
+
+	if _, err := n.selectorKV.Put(ctx, kvKey(qs.Queue), data); err != nil {
+		return fmt.Errorf("unable to publish selector queue %s: %w", qs.Queue, err)
+	}
+
+	return nil
+}
+
+func (n *NatsSync) ListSelectors(ctx context.Context) ([]QueueSelector, error) {
+	var selectors []QueueSelector
+
+	err := n.listValues(ctx, n.selectorKV, func(key string, data []byte) {
+		var qs QueueSelector
+		if err := json.Unmarshal(data, &qs); err != nil {
+			n.log.Warn("skipping undecodable selector queue", "queue", key, "err", err)
+			return
+		}
+
+		selectors = append(selectors, qs)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to list selector queues: %w", err)
+	}
+
+	return selectors, nil
+}
+
+func (n *NatsSync) RegisterWorker(ctx context.Context, w WorkerInfo) error {
+	w.SeenAt = time.Now()
+
+	data, err := json.Marshal(w)
+	if err != nil {
+		return err
+	}
+
+	if _, err := n.workerKV.Put(ctx, kvKey(w.ID), data); err != nil {
+		return fmt.Errorf("unable to register worker: %w", err)
+	}
+
+	return nil
+}
+
+func (n *NatsSync) ListWorkers(ctx context.Context) ([]WorkerInfo, error) {
+	var workers []WorkerInfo
+	now := time.Now()
+
+	err := n.listValues(ctx, n.workerKV, func(key string, data []byte) {
+		var w WorkerInfo
+		if err := json.Unmarshal(data, &w); err == nil && workerFresh(w, now) {
+			workers = append(workers, w)
+		}
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to list workers: %w", err)
+	}
+
+	return workers, nil
+}
+
+func (n *NatsSync) publishTierImage(ctx context.Context, ti TierImage) error {
+	data, err := json.Marshal(ti)
+	if err != nil {
+		return err
+	}
+
+	_, err = n.imageKV.Put(ctx, kvKey(ti.Tier+"|"+ti.Image), data)
+	return err
+}
+
+func (n *NatsSync) ListTierImages(ctx context.Context) ([]TierImage, error) {
+	var images []TierImage
+
+	err := n.listValues(ctx, n.imageKV, func(key string, data []byte) {
+		var ti TierImage
+		if err := json.Unmarshal(data, &ti); err == nil {
+			images = append(images, ti)
+		}
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to list tier images: %w", err)
+	}
+
+	return images, nil
+}
+
+// listValues calls fn with every live entry in a bucket.
+func (n *NatsSync) listValues(ctx context.Context, kv jetstream.KeyValue, fn func(key string, data []byte)) error {
+	lister, err := kv.ListKeys(ctx)
+	if err != nil {
+		return err
+	}
+	defer lister.Stop()
+
+	for key := range lister.Keys() {
+		entry, err := kv.Get(ctx, key)
+		if err != nil {
+			continue
+		}
+
+		fn(key, entry.Value())
+	}
+
+	return nil
+}
+
+func nodeLogSubject(runID, nodeID string) string {
+	return natsLogsPrefix + "." + kvKey(runID) + "." + kvKey(nodeID)
+}
+
+func (n *NatsSync) AppendNodeLogs(ctx context.Context, runID, nodeID string, records []models.LogRecord) error {
+	if len(records) == 0 {
+		return nil
+	}
+
+	data, err := json.Marshal(records)
+	if err != nil {
+		return err
+	}
+
+	if _, err := n.js.Publish(ctx, nodeLogSubject(runID, nodeID), data); err != nil {
+		return fmt.Errorf("unable to append node logs: %w", err)
+	}
+
+	return nil
+}
+
+func (n *NatsSync) ReadNodeLogs(ctx context.Context, runID, nodeID, cursor string) ([]models.LogRecord, string, error) {
+	var after uint64
+	if cursor != "" {
+		var err error
+		if after, err = strconv.ParseUint(cursor, 10, 64); err != nil {
+			return nil, cursor, fmt.Errorf("invalid log cursor %q", cursor)
+		}
+	}
+
+	cons, err := n.logs.OrderedConsumer(ctx, jetstream.OrderedConsumerConfig{
+		FilterSubjects: []string{nodeLogSubject(runID, nodeID)},
+		DeliverPolicy:  jetstream.DeliverByStartSequencePolicy,
+		OptStartSeq:    after + 1,
+	})
+	if err != nil {
+		return nil, cursor, fmt.Errorf("unable to read node logs: %w", err)
+	}
+
+	batch, err := cons.FetchNoWait(nodeLogReadBatches)
+	if err != nil {
+		return nil, cursor, fmt.Errorf("unable to read node logs: %w", err)
+	}
+
+	var records []models.LogRecord
+	for msg := range batch.Messages() {
+		if meta, err := msg.Metadata(); err == nil {
+			cursor = strconv.FormatUint(meta.Sequence.Stream, 10)
+		}
+
+		var b []models.LogRecord
+		if err := json.Unmarshal(msg.Data(), &b); err != nil {
+			n.log.Warn("skipping undecodable node logs", "run_id", runID, "node_id", nodeID, "err", err)
+			continue
+		}
+
+		records = append(records, b...)
+	}
+
+	if err := batch.Error(); err != nil {
+		return nil, cursor, fmt.Errorf("unable to read node logs: %w", err)
+	}
+
+	return records, cursor, nil
+}
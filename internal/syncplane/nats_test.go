@@ -0,0 +1,745 @@
+package syncplane
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pupload/pupload/internal/models"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+)
+
+// helper to run an embedded JetStream server and register cleanup
+func newTestNatsServer(t *testing.T) SyncPlaneSettings {
+	t.Helper()
+
+	srv, err := server.NewServer(&server.Options{
+		Host:      "127.0.0.1",
+		Port:      -1,
+		JetStream: true,
+		StoreDir:  t.TempDir(),
+		NoLog:     true,
+		NoSigs:    true,
+	})
+	if err != nil {
+		t.Fatalf("server.NewServer() error = %v", err)
+	}
+
+	go srv.Start()
+	if !srv.ReadyForConnections(5 * time.Second) {
+		t.Fatalf("nats server not ready")
+	}
+
+	t.Cleanup(srv.Shutdown)
+
+	return SyncPlaneSettings{
+		SelectedSyncPlane:      "nats",
+		Nats:                   NatsSettings{URL: srv.ClientURL()},
+		ControllerStepInterval: "@every 100ms",
+	}
+}
+
+func newTestNatsLayers(t *testing.T) (*NatsSync, *NatsSync) {
+	t.Helper()
+
+	cfg := newTestNatsServer(t)
+
+	controller, err := NewControllerNatsSyncLayer(cfg)
+	if err != nil {
+		t.Fatalf("NewControllerNatsSyncLayer() error = %v", err)
+	}
+	t.Cleanup(func() { controller.Close() })
+
+	worker, err := NewWorkerNatsSyncLayer(cfg, testResources)
+	if err != nil {
+		t.Fatalf("NewWorkerNatsSyncLayer() error = %v", err)
+	}
+	t.Cleanup(func() { worker.Close() })
+
+	return controller, worker
+}
+
+func TestNatsSync_ExecuteNodeRoundTrip(t *testing.T) {
+	controller, worker := newTestNatsLayers(t)
+
+	got := make(chan NodeExecutePayload, 1)
+	worker.RegisterExecuteNodeHandler(func(ctx context.Context, p NodeExecutePayload) error {
+		got <- p
+		return nil
+	})
+	worker.UpdateSubscribedQueues(map[string]int{"c-small": 1})
+	if err := worker.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+
+	err := controller.EnqueueExecuteNode(NodeExecutePayload{
+		RunID:       "run-1",
+		NodeDef:     models.NodeDef{Tier: "c-small"},
+		MaxAttempts: 3,
+	})
+	if err != nil {
+		t.Fatalf("EnqueueExecuteNode() error = %v", err)
+	}
+
+	select {
+	case p := <-got:
+		if p.RunID != "run-1" {
+			t.Fatalf("expected run id %q, got %q", "run-1", p.RunID)
+		}
+		if p.Attempt != 1 {
+			t.Fatalf("expected attempt 1, got %d", p.Attempt)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for execute task")
+	}
+}
+
+// Before the worker narrows its queues, it consumes those of every tier its
+// resources allow.
+func TestNatsSync_WorkerStartsWithItsTiers(t *testing.T) {
+	controller, worker := newTestNatsLayers(t)
+
+	got := make(chan NodeExecutePayload, 1)
+	worker.RegisterExecuteNodeHandler(func(ctx context.Context, p NodeExecutePayload) error {
+		got <- p
+		return nil
+	})
+	if err := worker.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+
+	if err := controller.EnqueueExecuteNode(NodeExecutePayload{RunID: "run-1", NodeDef: models.NodeDef{Tier: "t-test"}, MaxAttempts: 1}); err != nil {
+		t.Fatalf("EnqueueExecuteNode() error = %v", err)
+	}
+
+	select {
+	case <-got:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for execute task")
+	}
+}
+
+func TestNatsSync_RetriesFailedTask(t *testing.T) {
+	controller, worker := newTestNatsLayers(t)
+
+	attempts := make(chan int, 4)
+	worker.RegisterExecuteNodeHandler(func(ctx context.Context, p NodeExecutePayload) error {
+		attempts <- p.Attempt
+		if p.Attempt == 1 {
+			return errors.New("first attempt fails")
+		}
+		return nil
+	})
+	worker.UpdateSubscribedQueues(map[string]int{"c-small": 1})
+	worker.Start()
+
+	controller.EnqueueExecuteNode(NodeExecutePayload{
+		RunID:       "run-1",
+		NodeDef:     models.NodeDef{Tier: "c-small"},
+		MaxAttempts: 3,
+	})
+
+	for want := 1; want <= 2; want++ {
+		select {
+		case got := <-attempts:
+			if got != want {
+				t.Fatalf("expected attempt %d, got %d", want, got)
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatalf("timed out waiting for attempt %d", want)
+		}
+	}
+}
+
+func TestNatsSync_HandBackKeepsAttempt(t *testing.T) {
+	controller, worker := newTestNatsLayers(t)
+
+	attempts := make(chan int, 4)
+	var calls atomic.Int32
+	worker.RegisterExecuteNodeHandler(func(ctx context.Context, p NodeExecutePayload) error {
+		attempts <- p.Attempt
+		if calls.Add(1) == 1 {
+			return ErrHandBack
+		}
+		return nil
+	})
+	worker.UpdateSubscribedQueues(map[string]int{"c-small": 1})
+	worker.Start()
+
+	controller.EnqueueExecuteNode(NodeExecutePayload{
+		RunID:       "run-1",
+		NodeDef:     models.NodeDef{Tier: "c-small"},
+		MaxAttempts: 3,
+	})
+
+	for range 2 {
+		select {
+		case got := <-attempts:
+			if got != 1 {
+				t.Fatalf("expected attempt 1, got %d", got)
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatalf("timed out waiting for handed back task")
+		}
+	}
+}
+
+func TestNatsSync_HandBackOnLastAttempt(t *testing.T) {
+	defer func(d time.Duration) { HandBackDelay = d }(HandBackDelay)
+	HandBackDelay = 50 * time.Millisecond
+
+	controller, worker := newTestNatsLayers(t)
+	testHandBackOnLastAttempt(t, controller, worker)
+
+	if letters := listAllDeadLetters(t, controller, 0); len(letters) != 0 {
+		t.Fatalf("expected no dead letters, got %+v", letters)
+	}
+}
+
+// testHandBackOnLastAttempt hands a single-attempt task back more times than
+// it has attempts, then expects it to run. Workers must serve tier t-test.
+func testHandBackOnLastAttempt(t *testing.T, controller, worker SyncLayer) {
+	t.Helper()
+
+	attempts := make(chan int, 8)
+	var calls atomic.Int32
+	worker.RegisterExecuteNodeHandler(func(ctx context.Context, p NodeExecutePayload) error {
+		attempts <- p.Attempt
+		if calls.Add(1) <= 2 {
+			return fmt.Errorf("no capacity: %w", ErrHandBack)
+		}
+		return nil
+	})
+	worker.UpdateSubscribedQueues(map[string]int{"t-test": 1})
+	worker.Start()
+
+	if err := controller.EnqueueExecuteNode(NodeExecutePayload{
+		RunID:       "run-1",
+		NodeDef:     models.NodeDef{Tier: "t-test"},
+		MaxAttempts: 1,
+	}); err != nil {
+		t.Fatalf("EnqueueExecuteNode() error = %v", err)
+	}
+
+	for range 3 {
+		select {
+		case got := <-attempts:
+			if got != 1 {
+				t.Fatalf("expected attempt 1, got %d", got)
+			}
+		case <-time.After(20 * time.Second):
+			t.Fatalf("timed out after %d deliveries", calls.Load())
+		}
+	}
+
+	select {
+	case got := <-attempts:
+		t.Fatalf("unexpected delivery of attempt %d after success", got)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func TestNatsSync_UnsubscribedTierIsNotConsumed(t *testing.T) {
+	controller, worker := newTestNatsLayers(t)
+
+	got := make(chan NodeExecutePayload, 1)
+	worker.RegisterExecuteNodeHandler(func(ctx context.Context, p NodeExecutePayload) error {
+		got <- p
+		return nil
+	})
+	worker.UpdateSubscribedQueues(map[string]int{"c-small": 1})
+	worker.Start()
+
+	controller.EnqueueExecuteNode(NodeExecutePayload{
+		RunID:       "run-1",
+		NodeDef:     models.NodeDef{Tier: "gn-large"},
+		MaxAttempts: 1,
+	})
+
+	select {
+	case <-got:
+		t.Fatalf("worker received task for a tier it is not subscribed to")
+	case <-time.After(500 * time.Millisecond):
+	}
+
+	worker.UpdateSubscribedQueues(map[string]int{"c-small": 1, "gn-large": 2})
+
+	select {
+	case <-got:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for task after subscribing")
+	}
+}
+
+func TestNatsSync_SchedulerStepsActiveRuns(t *testing.T) {
+	controller, _ := newTestNatsLayers(t)
+
+	got := make(chan string, 16)
+	controller.RegisterFlowStepHandler(func(ctx context.Context, p FlowStepPayload) error {
+		got <- p.RunID
+		return nil
+	})
+
+	if err := controller.AddRunToScheduler("run-1"); err != nil {
+		t.Fatalf("AddRunToScheduler() error = %v", err)
+	}
+	controller.Start()
+
+	select {
+	case id := <-got:
+		if id != "run-1" {
+			t.Fatalf("expected run id %q, got %q", "run-1", id)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for flow step")
+	}
+
+	if err := controller.RemoveRunFromScheduler("run-1"); err != nil {
+		t.Fatalf("RemoveRunFromScheduler() error = %v", err)
+	}
+
+	ids, err := controller.listScheduledRuns(context.Background())
+	if err != nil {
+		t.Fatalf("listScheduledRuns() error = %v", err)
+	}
+	if len(ids) != 0 {
+		t.Fatalf("expected no scheduled runs, got %v", ids)
+	}
+}
+
+// Runs added after the scheduler started reach it through its watch, and
+// claimed runs aren't stepped again before their lease is up.
+func TestNatsSync_SchedulerWatchesNewRuns(t *testing.T) {
+	controller, _ := newTestNatsLayers(t)
+
+	got := make(chan string, 16)
+	controller.RegisterFlowStepHandler(func(ctx context.Context, p FlowStepPayload) error {
+		got <- p.RunID
+		return nil
+	})
+	controller.Start()
+
+	for _, id := range []string{"run-1", "run-2"} {
+		if err := controller.AddRunToScheduler(id); err != nil {
+			t.Fatalf("AddRunToScheduler() error = %v", err)
+		}
+	}
+
+	seen := map[string]bool{}
+	for len(seen) < 2 {
+		select {
+		case id := <-got:
+			seen[id] = true
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for flow steps, got %v", seen)
+		}
+	}
+
+	select {
+	case id := <-got:
+		t.Fatalf("run %s was stepped again within its lease", id)
+	case <-time.After(3 * stepPollInterval):
+	}
+}
+
+func TestNatsSync_ScheduleWatchRebuildsIndex(t *testing.T) {
+	controller, _ := newTestNatsLayers(t)
+
+	if err := controller.AddRunToScheduler("run-1"); err != nil {
+		t.Fatalf("AddRunToScheduler() error = %v", err)
+	}
+
+	// What a watch that ended left behind: a run deleted since, which a new
+	// watch never mentions.
+	index := &schedIndex{runs: map[string]indexedRun{"0.gone": {key: "0.gone", run: scheduledRun{RunID: "gone"}}}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	loaded := make(chan struct{})
+	done := make(chan bool)
+	go func() {
+		synced, _ := controller.watchScheduleOnce(ctx, index, func() { close(loaded) })
+		done <- synced
+	}()
+
+	select {
+	case <-loaded:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for the watch to load")
+	}
+
+	index.mu.Lock()
+	_, stale := index.runs["0.gone"]
+	_, current := index.runs[controller.scheduleKey("run-1")]
+	index.mu.Unlock()
+
+	if stale || !current {
+		t.Fatalf("expected the index to be rebuilt from the bucket, got %+v", index.runs)
+	}
+
+	cancel()
+	if !<-done {
+		t.Fatalf("expected the watch to report it had loaded")
+	}
+}
+
+func TestNatsMutex_ExcludesConcurrentHolders(t *testing.T) {
+	controller, worker := newTestNatsLayers(t)
+
+	first := controller.NewMutex("run-1", 10*time.Second)
+	second := worker.NewMutex("run-1", 10*time.Second)
+
+	ctx := context.Background()
+	if err := first.Lock(ctx); err != nil {
+		t.Fatalf("first Lock() error = %v", err)
+	}
+
+	timeoutCtx, cancel := context.WithTimeout(ctx, 300*time.Millisecond)
+	defer cancel()
+	if err := second.Lock(timeoutCtx); err == nil {
+		t.Fatalf("expected second Lock() to fail while held")
+	}
+
+	start := time.Now()
+	if err := second.TryLock(ctx); !errors.Is(err, ErrLockNotAcquired) {
+		t.Fatalf("expected TryLock() to fail with ErrLockNotAcquired while held, got %v", err)
+	}
+	if waited := time.Since(start); waited > 100*time.Millisecond {
+		t.Fatalf("TryLock() waited %s for a held lock", waited)
+	}
+
+	if err := first.Unlock(ctx); err != nil {
+		t.Fatalf("Unlock() error = %v", err)
+	}
+
+	if err := second.Lock(ctx); err != nil {
+		t.Fatalf("second Lock() after unlock error = %v", err)
+	}
+}
+
+func TestNatsMutex_TakesOverExpiredLease(t *testing.T) {
+	controller, worker := newTestNatsLayers(t)
+
+	ctx := context.Background()
+	if err := controller.NewMutex("run-1", 50*time.Millisecond).Lock(ctx); err != nil {
+		t.Fatalf("Lock() error = %v", err)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	if err := worker.NewMutex("run-1", time.Second).Lock(ctx); err != nil {
+		t.Fatalf("expected expired lease to be taken over, got %v", err)
+	}
+}
+
+func TestNatsSync_DeadLettersExhaustedTasks(t *testing.T) {
+	controller, worker := newTestNatsLayers(t)
+
+	var calls atomic.Int32
+	worker.RegisterExecuteNodeHandler(func(ctx context.Context, p NodeExecutePayload) error {
+		calls.Add(1)
+		return fmt.Errorf("bad input: %w", ErrSkipRetry)
+	})
+	worker.UpdateSubscribedQueues(map[string]int{"c-small": 1})
+	worker.Start()
+
+	controller.EnqueueExecuteNode(NodeExecutePayload{
+		RunID:       "run-1",
+		Node:        models.Node{ID: "node-1"},
+		NodeDef:     models.NodeDef{Tier: "c-small"},
+		MaxAttempts: 3,
+	})
+
+	var letters []DeadLetter
+	deadline := time.Now().Add(5 * time.Second)
+	for len(letters) == 0 && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+		letters = listAllDeadLetters(t, controller, 0)
+	}
+
+	if len(letters) != 1 {
+		t.Fatalf("expected 1 dead letter, got %d", len(letters))
+	}
+
+	dl := letters[0]
+	if dl.Queue != "c-small" || dl.Type != TypeNodeExecute || dl.Attempts != 1 {
+		t.Fatalf("unexpected dead letter: %+v", dl)
+	}
+	if dl.Summary != "run_id=run-1 node_id=node-1" {
+		t.Fatalf("unexpected summary %q", dl.Summary)
+	}
+	if calls.Load() != 1 {
+		t.Fatalf("expected skip-retry task to run once, ran %d times", calls.Load())
+	}
+
+	if err := controller.RequeueDeadLetter(context.Background(), dl.Queue, dl.ID); err != nil {
+		t.Fatalf("RequeueDeadLetter() error = %v", err)
+	}
+
+	deadline = time.Now().Add(5 * time.Second)
+	for calls.Load() < 2 && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+	}
+	if calls.Load() < 2 {
+		t.Fatalf("requeued task was not redelivered")
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	n, err := controller.PurgeDeadLetters(context.Background())
+	if err != nil {
+		t.Fatalf("PurgeDeadLetters() error = %v", err)
+	}
+	if n != 1 {
+		t.Fatalf("expected to purge 1 dead letter, purged %d", n)
+	}
+}
+
+// listAllDeadLetters follows ListDeadLetters' cursor to the last page.
+func listAllDeadLetters(t *testing.T, s SyncLayer, limit int) []DeadLetter {
+	t.Helper()
+
+	var letters []DeadLetter
+	cursor := ""
+	for range 100 {
+		page, next, err := s.ListDeadLetters(context.Background(), cursor, limit)
+		if err != nil {
+			t.Fatalf("ListDeadLetters() error = %v", err)
+		}
+		if limit > 0 && len(page) > limit {
+			t.Fatalf("expected at most %d dead letters a page, got %d", limit, len(page))
+		}
+
+		letters = append(letters, page...)
+		if next == "" {
+			return letters
+		}
+		cursor = next
+	}
+
+	t.Fatalf("dead letter cursor never ran out")
+	return nil
+}
+
+func TestNatsSync_ListDeadLettersPages(t *testing.T) {
+	controller, _ := newTestNatsLayers(t)
+	ctx := context.Background()
+
+	for i := range 5 {
+		msg := nats.NewMsg(natsDLQPrefix + ".c-small")
+		msg.Data = []byte(fmt.Sprintf(`{"RunID":"run-%d"}`, i))
+		msg.Header.Set(natsHeaderQueue, "c-small")
+		if _, err := controller.js.PublishMsg(ctx, msg); err != nil {
+			t.Fatalf("PublishMsg() error = %v", err)
+		}
+	}
+
+	// Leave gaps in the stream's sequences.
+	for _, seq := range []uint64{2, 3} {
+		if err := controller.dlq.DeleteMsg(ctx, seq); err != nil {
+			t.Fatalf("DeleteMsg() error = %v", err)
+		}
+	}
+
+	letters := listAllDeadLetters(t, controller, 2)
+
+	var ids []string
+	for _, l := range letters {
+		ids = append(ids, l.ID)
+	}
+	if !slices.Equal(ids, []string{"1", "4", "5"}) {
+		t.Fatalf("expected dead letters 1, 4 and 5, got %v", ids)
+	}
+
+	if _, _, err := controller.ListDeadLetters(ctx, "not-a-sequence", 2); err == nil {
+		t.Fatalf("expected an invalid cursor to fail")
+	}
+}
+
+func TestNatsSync_RoutesLabelledNodes(t *testing.T) {
+	controller, worker := newTestNatsLayers(t)
+	ctx := context.Background()
+
+	got := make(chan NodeExecutePayload, 1)
+	worker.RegisterExecuteNodeHandler(func(ctx context.Context, p NodeExecutePayload) error {
+		got <- p
+		return nil
+	})
+	worker.UpdateSubscribedQueues(map[string]int{"c-small": 1})
+	worker.Start()
+
+	payload := NodeExecutePayload{
+		RunID:       "run-1",
+		NodeDef:     models.NodeDef{Tier: "c-small", Selector: map[string]string{"region": "eu"}},
+		Node:        models.Node{Selector: map[string]string{"pool": "acme"}},
+		MaxAttempts: 1,
+	}
+	if err := controller.EnqueueExecuteNode(payload); err != nil {
+		t.Fatalf("EnqueueExecuteNode() error = %v", err)
+	}
+
+	select {
+	case <-got:
+		t.Fatalf("labelled node delivered on the plain tier queue")
+	case <-time.After(300 * time.Millisecond):
+	}
+
+	selectors, err := worker.ListSelectors(ctx)
+	if err != nil {
+		t.Fatalf("ListSelectors() error = %v", err)
+	}
+	if len(selectors) != 1 || selectors[0].Queue != ExecuteQueue(payload) || selectors[0].Tier != "c-small" {
+		t.Fatalf("unexpected selectors %+v", selectors)
+	}
+
+	worker.UpdateSubscribedQueues(map[string]int{"c-small": 1, selectors[0].Queue: 1})
+
+	select {
+	case <-got:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for labelled node")
+	}
+}
+
+func TestNatsSync_WorkerRegistry(t *testing.T) {
+	controller, worker := newTestNatsLayers(t)
+	ctx := context.Background()
+
+	if err := worker.RegisterWorker(ctx, WorkerInfo{ID: "w-1", Labels: map[string]string{"region": "eu"}}); err != nil {
+		t.Fatalf("RegisterWorker() error = %v", err)
+	}
+
+	workers, err := controller.ListWorkers(ctx)
+	if err != nil {
+		t.Fatalf("ListWorkers() error = %v", err)
+	}
+	if len(workers) != 1 || workers[0].ID != "w-1" || workers[0].Labels["region"] != "eu" {
+		t.Fatalf("unexpected workers %+v", workers)
+	}
+}
+
+func TestNatsSync_TierImages(t *testing.T) {
+	controller, worker := newTestNatsLayers(t)
+	ctx := context.Background()
+
+	for _, nd := range []models.NodeDef{
+		{Tier: "c-small", Image: "ubuntu:24.04"},
+		{Tier: "c-small", Image: "ubuntu:24.04"},
+		{Tier: "c-large", Image: "ffmpeg"},
+		{Tier: "c-small", Executor: models.ExecutorProcess},
+	} {
+		if err := controller.EnqueueExecuteNode(NodeExecutePayload{RunID: "run-1", NodeDef: nd, MaxAttempts: 1}); err != nil {
+			t.Fatalf("EnqueueExecuteNode() error = %v", err)
+		}
+	}
+
+	images, err := worker.ListTierImages(ctx)
+	if err != nil {
+		t.Fatalf("ListTierImages() error = %v", err)
+	}
+
+	got := make(map[string]string)
+	for _, ti := range images {
+		got[ti.Tier+" "+ti.Image] = ti.PullPolicy
+	}
+
+	want := map[string]string{
+		"c-small ubuntu:24.04": models.PullIfNotPresent,
+		"c-large ffmpeg":       models.PullAlways,
+	}
+	if len(got) != len(want) {
+		t.Fatalf("unexpected tier images %+v", images)
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Fatalf("expected %s with pull policy %s, got %+v", k, v, images)
+		}
+	}
+}
+
+func TestNatsSync_NodeLogs(t *testing.T) {
+	controller, _ := newTestNatsLayers(t)
+	testNodeLogs(t, controller)
+}
+
+// testNodeLogs checks a log store reads batches back in order, resumes from
+// a cursor and keeps nodes apart.
+func testNodeLogs(t *testing.T, s SyncLayer) {
+	t.Helper()
+	ctx := context.Background()
+
+	records, cursor, err := s.ReadNodeLogs(ctx, "run-1", "node-1", "")
+	if err != nil || len(records) != 0 {
+		t.Fatalf("ReadNodeLogs() on no logs = %v, %v", records, err)
+	}
+
+	for _, msg := range []string{"one", "two"} {
+		if err := s.AppendNodeLogs(ctx, "run-1", "node-1", []models.LogRecord{{Msg: msg, Stream: "stdout"}}); err != nil {
+			t.Fatalf("AppendNodeLogs() error = %v", err)
+		}
+	}
+
+	if err := s.AppendNodeLogs(ctx, "run-1", "node-2", []models.LogRecord{{Msg: "other"}}); err != nil {
+		t.Fatalf("AppendNodeLogs() error = %v", err)
+	}
+
+	records, cursor, err = s.ReadNodeLogs(ctx, "run-1", "node-1", cursor)
+	if err != nil {
+		t.Fatalf("ReadNodeLogs() error = %v", err)
+	}
+	if len(records) != 2 || records[0].Msg != "one" || records[1].Msg != "two" || records[0].Stream != "stdout" {
+		t.Fatalf("unexpected records %+v", records)
+	}
+
+	if err := s.AppendNodeLogs(ctx, "run-1", "node-1", []models.LogRecord{{Msg: "three"}}); err != nil {
+		t.Fatalf("AppendNodeLogs() error = %v", err)
+	}
+
+	records, _, err = s.ReadNodeLogs(ctx, "run-1", "node-1", cursor)
+	if err != nil {
+		t.Fatalf("ReadNodeLogs() error = %v", err)
+	}
+	if len(records) != 1 || records[0].Msg != "three" {
+		t.Fatalf("expected only the new record after the cursor, got %+v", records)
+	}
+}
+
+func TestNatsSync_SharedKey(t *testing.T) {
+	controller, worker := newTestNatsLayers(t)
+	testSharedKey(t, controller, worker)
+}
+
+// testSharedKey checks two layers on one sync plane get the same key for a
+// name, and different keys for different names.
+func testSharedKey(t *testing.T, a, b SyncLayer) {
+	t.Helper()
+	ctx := context.Background()
+
+	first, err := a.SharedKey(ctx, "hosted-stores")
+	if err != nil {
+		t.Fatalf("SharedKey() error = %v", err)
+	}
+	if len(first) == 0 {
+		t.Fatalf("expected a key")
+	}
+
+	second, err := b.SharedKey(ctx, "hosted-stores")
+	if err != nil {
+		t.Fatalf("SharedKey() error = %v", err)
+	}
+	if string(second) != string(first) {
+		t.Fatalf("expected the stored key %q, got %q", first, second)
+	}
+
+	other, err := b.SharedKey(ctx, "other")
+	if err != nil {
+		t.Fatalf("SharedKey() error = %v", err)
+	}
+	if string(other) == string(first) {
+		t.Fatalf("expected another name to get its own key")
+	}
+}
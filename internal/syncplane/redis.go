@@ -3,12 +3,18 @@ package syncplane
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/pupload/pupload/internal/logging"
+	"github.com/pupload/pupload/internal/models"
+	"github.com/pupload/pupload/internal/redisconn"
 	"github.com/pupload/pupload/internal/resources"
 
 	"github.com/cusianovic/asynq"
@@ -18,13 +24,18 @@ import (
 )
 
 type RedisSync struct {
-	redisClient *redis.Client
+	redisClient redis.UniversalClient
 
 	asynqClient *asynq.Client
 	asynqServer *asynq.Server
+	inspector   *asynq.Inspector
 	redsync     *redsync.Redsync
 
-	scheduler *asynq.PeriodicTaskManager
+	stepInterval  time.Duration
+	stepShards    int
+	stepBatchSize int
+	controller    bool
+	schedCancel   context.CancelFunc
 
 	mux *asynq.ServeMux
 
@@ -35,15 +46,11 @@ type RedisSync struct {
 	log *slog.Logger
 }
 
-func NewControllerRedisSyncLayer(cfg SyncPlaneSettings) *RedisSync {
-	rdb := redis.NewClient(&redis.Options{
-		Addr:     cfg.Redis.Address,
-		Password: cfg.Redis.Password,
-		DB:       cfg.Redis.DB,
-
-		MaxRetries: cfg.Redis.MaxRetries,
-		PoolSize:   cfg.Redis.PoolSize,
-	})
+func NewControllerRedisSyncLayer(cfg SyncPlaneSettings) (*RedisSync, error) {
+	rdb, err := redisconn.NewClient(cfg.Redis)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create redis client: %w", err)
+	}
 
 	asynqClient := asynq.NewClientFromRedisClient(rdb)
 	asynqServer := asynq.NewServerFromRedisClient(rdb, asynq.Config{
@@ -62,45 +69,31 @@ func NewControllerRedisSyncLayer(cfg SyncPlaneSettings) *RedisSync {
 		redisClient: rdb,
 		asynqClient: asynqClient,
 		asynqServer: asynqServer,
+		inspector:   asynq.NewInspectorFromRedisClient(rdb),
 		redsync:     rs,
 
 		mux: asynq.NewServeMux(),
 
+		stepInterval:  parseStepInterval(cfg.ControllerStepInterval),
+		stepShards:    stepShards(cfg),
+		stepBatchSize: stepBatchSize(cfg),
+		controller:    true,
+
 		log: logging.ForService("controller-synclayer"),
 	}
 
-	mgr, err := asynq.NewPeriodicTaskManager(asynq.PeriodicTaskManagerOpts{
-		RedisUniversalClient:       rdb,
-		PeriodicTaskConfigProvider: newSchedulerTaskProvider(redisSync, cfg.ControllerStepInterval),
-		SyncInterval:               10 * time.Second,
-
-		SchedulerOpts: &asynq.SchedulerOpts{
-			LogLevel: asynq.FatalLevel,
-		},
-	})
-
-	redisSync.scheduler = mgr
+	return redisSync, nil
+}
 
+func NewWorkerRedisSyncLayer(cfg SyncPlaneSettings, rCfg resources.ResourceSettings) (*RedisSync, error) {
+	rdb, err := redisconn.NewClient(cfg.Redis)
 	if err != nil {
-		return nil
+		return nil, fmt.Errorf("unable to create redis client: %w", err)
 	}
 
-	return redisSync
-}
-
-func NewWorkerRedisSyncLayer(cfg SyncPlaneSettings, rCfg resources.ResourceSettings) *RedisSync {
-	rdb := redis.NewClient(&redis.Options{
-		Addr:     cfg.Redis.Address,
-		Password: cfg.Redis.Password,
-		DB:       cfg.Redis.DB,
-
-		MaxRetries: cfg.Redis.MaxRetries,
-		PoolSize:   cfg.Redis.PoolSize,
-	})
-
 	rm, err := resources.CreateResourceManager(rCfg)
 	if err != nil {
-		panic(fmt.Sprintf("unable to create resource manager: %s", err))
+		return nil, fmt.Errorf("unable to create resource manager: %w", err)
 	}
 
 	queueMap := rm.GetValidTierMap()
@@ -110,6 +103,15 @@ func NewWorkerRedisSyncLayer(cfg SyncPlaneSettings, rCfg resources.ResourceSetti
 		Concurrency: 10,
 		Queues:      queueMap,
 
+		// Handed back tasks are retried soon without using up an attempt.
+		IsFailure: func(err error) bool { return !errors.Is(err, ErrHandBack) },
+		RetryDelayFunc: func(n int, err error, t *asynq.Task) time.Duration {
+			if errors.Is(err, ErrHandBack) {
+				return HandBackDelay
+			}
+			return asynq.DefaultRetryDelayFunc(n, err, t)
+		},
+
 		LogLevel: asynq.FatalLevel,
 	})
 
@@ -120,6 +122,7 @@ func NewWorkerRedisSyncLayer(cfg SyncPlaneSettings, rCfg resources.ResourceSetti
 		redisClient: rdb,
 		asynqClient: asynqClient,
 		asynqServer: asynqServer,
+		inspector:   asynq.NewInspectorFromRedisClient(rdb),
 		redsync:     rs,
 
 		mux:                  asynq.NewServeMux(),
@@ -128,7 +131,7 @@ func NewWorkerRedisSyncLayer(cfg SyncPlaneSettings, rCfg resources.ResourceSetti
 		log: logging.ForService("worker-synclayer"),
 	}
 
-	return redisSync
+	return redisSync, nil
 }
 
 func (r *RedisSync) RegisterExecuteNodeHandler(handler ExecuteNodeHandler) error {
@@ -140,14 +143,24 @@ func (r *RedisSync) RegisterExecuteNodeHandler(handler ExecuteNodeHandler) error
 		var p NodeExecutePayload
 		err := json.Unmarshal(t.Payload(), &p)
 		if err != nil {
-			return fmt.Errorf("ExecuteNodeHandler: Error unmarshaling payload: %w", err)
+			return fmt.Errorf("ExecuteNodeHandler: Error unmarshaling payload: %w: %w", err, asynq.SkipRetry)
 		}
 
-		attempt, _ := asynq.GetRetryCount(ctx)
-		p.Attempt = attempt + 1
+		retried, _ := asynq.GetRetryCount(ctx)
+		maxRetry, _ := asynq.GetMaxRetry(ctx)
 
-		if err := handler(ctx, p); err != nil {
-			return err
+		p.Attempt = p.PriorAttempts + retried + 1
+		p.AttemptID = AttemptID(p.ExecutionID, p.Attempt)
+
+		err = handler(ctx, p)
+		if errors.Is(err, ErrHandBack) && retried >= maxRetry {
+			// asynq archives a task out of retries even when the error
+			// isn't a failure, so a copy takes its place instead.
+			return r.handBack(ctx, p, retried)
+		}
+
+		if err != nil {
+			return asynqError(err)
 		}
 
 		return nil
@@ -156,15 +169,49 @@ func (r *RedisSync) RegisterExecuteNodeHandler(handler ExecuteNodeHandler) error
 	return nil
 }
 
+// handBack re-enqueues a task handed back on its last attempt, carrying the
+// attempts made so far.
+func (r *RedisSync) handBack(ctx context.Context, p NodeExecutePayload, retried int) error {
+	p.PriorAttempts += retried
+
+	data, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+
+	task := asynq.NewTask(TypeNodeExecute, data,
+		asynq.Queue(ExecuteQueue(p)),
+		asynq.MaxRetry(max(p.MaxAttempts-1-p.PriorAttempts, 0)),
+		asynq.ProcessIn(HandBackDelay),
+	)
+
+	if _, err := r.asynqClient.EnqueueContext(ctx, task); err != nil {
+		return fmt.Errorf("unable to hand task back: %w", err)
+	}
+
+	return nil
+}
+
 func (r *RedisSync) EnqueueExecuteNode(payload NodeExecutePayload) error {
 	p, err := json.Marshal(payload)
 	if err != nil {
 		return err
 	}
 
-	queue := payload.NodeDef.Tier
+	queue := ExecuteQueue(payload)
+	if queue != payload.NodeDef.Tier {
+		if err := r.publishSelector(context.TODO(), queueSelector(payload)); err != nil {
+			return err
+		}
+	}
+
+	if ti, ok := tierImage(payload); ok {
+		if err := r.publishTierImage(context.TODO(), ti); err != nil {
+			r.log.Warn("unable to record tier image", "tier", ti.Tier, "image", ti.Image, "err", err)
+		}
+	}
 
-	r.log.Debug("enqueued node def", "tier", payload.NodeDef.Tier)
+	r.log.Debug("enqueued node def", "tier", payload.NodeDef.Tier, "queue", queue)
 
 	task := asynq.NewTask(TypeNodeExecute, p, asynq.Queue(queue), asynq.MaxRetry(payload.MaxAttempts-1))
 	if _, err := r.asynqClient.Enqueue(task); err != nil {
@@ -183,9 +230,9 @@ func (r *RedisSync) RegisterNodeFinishedHandler(handler NodeFinishedHandler) err
 		var p NodeFinishedPayload
 		err := json.Unmarshal(t.Payload(), &p)
 		if err != nil {
-			return fmt.Errorf("RegisterExecuteNodeHandler: Error unmarshaling payload: %w", err)
+			return fmt.Errorf("RegisterExecuteNodeHandler: Error unmarshaling payload: %w: %w", err, asynq.SkipRetry)
 		}
-		return handler(ctx, p)
+		return asynqError(handler(ctx, p))
 	})
 
 	return nil
@@ -214,9 +261,9 @@ func (r *RedisSync) RegisterNodeFailedHandler(handler NodeFailedHandler) error {
 		var p NodeFailedPayload
 		err := json.Unmarshal(t.Payload(), &p)
 		if err != nil {
-			return fmt.Errorf("RegisterNodeFailedHandler: Error unmarshaling payload: %w", err)
+			return fmt.Errorf("RegisterNodeFailedHandler: Error unmarshaling payload: %w: %w", err, asynq.SkipRetry)
 		}
-		return handler(ctx, p)
+		return asynqError(handler(ctx, p))
 	})
 
 	return nil
@@ -236,6 +283,38 @@ func (r *RedisSync) EnqueueNodeFailed(payload NodeFailedPayload) error {
 	return nil
 }
 
+func (r *RedisSync) RegisterNodeProgressHandler(handler NodeProgressHandler) error {
+	if r.mux == nil {
+		return fmt.Errorf("cannot register handler: mux not initalized")
+	}
+
+	r.mux.HandleFunc(TypeNodeProgress, func(ctx context.Context, t *asynq.Task) error {
+		var p NodeProgressPayload
+		err := json.Unmarshal(t.Payload(), &p)
+		if err != nil {
+			return fmt.Errorf("RegisterNodeProgressHandler: Error unmarshaling payload: %w: %w", err, asynq.SkipRetry)
+		}
+		return asynqError(handler(ctx, p))
+	})
+
+	return nil
+}
+
+func (r *RedisSync) EnqueueNodeProgress(payload NodeProgressPayload) error {
+	p, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	// A newer report replaces a lost one, so none is retried.
+	task := asynq.NewTask(TypeNodeProgress, p, asynq.Queue("controller"), asynq.MaxRetry(0))
+	if _, err := r.asynqClient.Enqueue(task); err != nil {
+		return err
+	}
+
+	return nil
+}
+
 func (r *RedisSync) UpdateSubscribedQueues(queues map[string]int) error {
 	r.mu.Lock()
 	defer r.mu.Unlock()
@@ -244,7 +323,7 @@ func (r *RedisSync) UpdateSubscribedQueues(queues map[string]int) error {
 
 }
 
-const schedulerRunKey = "pup:sched:active_runs"
+const legacySchedulerRunKey = "pup:sched:active_runs"
 
 func (r *RedisSync) RegisterFlowStepHandler(handler FlowStepHandler) error {
 	if r.mux == nil {
@@ -255,81 +334,275 @@ func (r *RedisSync) RegisterFlowStepHandler(handler FlowStepHandler) error {
 		var p FlowStepPayload
 		err := json.Unmarshal(t.Payload(), &p)
 		if err != nil {
-			return fmt.Errorf("RegisterExecuteNodeHandler: Error unmarshaling payload: %w", err)
+			return fmt.Errorf("RegisterExecuteNodeHandler: Error unmarshaling payload: %w: %w", err, asynq.SkipRetry)
 		}
-		return handler(ctx, p)
+		return asynqError(handler(ctx, p))
 	})
 
 	return nil
 }
 
-const SchedulerElectionInterval = 30 * time.Second
+// claimDueRuns pops up to ARGV[3] runs due at ARGV[1] and leases them until
+// ARGV[2].
+var claimDueRuns = redis.NewScript(`
+local ids = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[1], 'LIMIT', 0, ARGV[3])
+for _, id in ipairs(ids) do
+	redis.call('ZADD', KEYS[1], 'XX', ARGV[2], id)
+end
+return ids
+`)
+
+// rescheduleRun sets the next step of a still scheduled run. Idle steps
+// double the delay from ARGV[3] up to ARGV[4]; any other step resets it.
+var rescheduleRun = redis.NewScript(`
+if not redis.call('ZSCORE', KEYS[1], ARGV[1]) then
+	return 0
+end
+local idle = 0
+if ARGV[2] == '1' then
+	idle = redis.call('HINCRBY', KEYS[2], ARGV[1], 1)
+else
+	redis.call('HDEL', KEYS[2], ARGV[1])
+end
+local delay = math.min(tonumber(ARGV[3]) * math.pow(2, math.min(idle, 16)), tonumber(ARGV[4]))
+redis.call('ZADD', KEYS[1], 'XX', tonumber(ARGV[5]) + delay, ARGV[1])
+return 1
+`)
+
+// Shard keys share a hash tag so each shard's keys stay on one cluster slot.
+func schedulerShardKeys(shard int) (due, idle string) {
+	return fmt.Sprintf("pup:sched:{%d}:due", shard), fmt.Sprintf("pup:sched:{%d}:idle", shard)
+}
 
+// StartScheduler claims due runs from every shard and enqueues a flow step for
+// each until ctx is done. Every controller replica runs it.
 func (r *RedisSync) StartScheduler(ctx context.Context) {
+	if err := r.migrateLegacySchedule(ctx); err != nil {
+		r.log.Warn("unable to migrate legacy scheduled runs", "err", err)
+	}
 
-	mutex := r.redsync.NewMutex("asynq:periodic:leader", redsync.WithExpiry(SchedulerElectionInterval), redsync.WithTries(1))
-
-	ticker := time.NewTicker(SchedulerElectionInterval / 2)
+	ticker := time.NewTicker(stepPollInterval)
 	defer ticker.Stop()
 
-	leader := false
+	for {
+		for shard := range r.stepShards {
+			for {
+				n, err := r.claimShard(ctx, shard)
+				if err != nil {
+					if ctx.Err() == nil {
+						r.log.Error("unable to claim due runs", "shard", shard, "err", err)
+					}
+					break
+				}
+
+				// a full batch means the shard likely has more due runs
+				if n < r.stepBatchSize {
+					break
+				}
+			}
+		}
 
-	tryBecomeLeader := func(ctx context.Context) {
-		if leader {
+		select {
+		case <-ctx.Done():
 			return
+		case <-ticker.C:
 		}
+	}
+}
 
-		if err := mutex.LockContext(ctx); err != nil {
-			// couldn't get the lock now; will retry on next tick
-			return
+func (r *RedisSync) claimShard(ctx context.Context, shard int) (int, error) {
+	due, _ := schedulerShardKeys(shard)
+	now := time.Now()
+
+	ids, err := claimDueRuns.Run(ctx, r.redisClient, []string{due},
+		now.UnixMilli(), now.Add(stepLease(r.stepInterval)).UnixMilli(), r.stepBatchSize).StringSlice()
+	if err != nil {
+		return 0, err
+	}
+
+	for _, id := range ids {
+		task, err := newFlowStepTask(id)
+		if err != nil {
+			continue
 		}
 
-		if err := r.scheduler.Start(); err != nil {
-			// failed to start manager; release lock and don't mark leader
-			mutex.UnlockContext(ctx)
-			return
+		if _, err := r.asynqClient.EnqueueContext(ctx, task); err != nil && !errors.Is(err, asynq.ErrTaskIDConflict) {
+			r.log.Error("unable to enqueue flow step", "run_id", id, "err", err)
 		}
+	}
+
+	return len(ids), nil
+}
+
+// migrateLegacySchedule moves runs from the set used before the schedule was
+// sharded.
+func (r *RedisSync) migrateLegacySchedule(ctx context.Context) error {
+	ids, err := r.redisClient.SMembers(ctx, legacySchedulerRunKey).Result()
+	if err != nil || len(ids) == 0 {
+		return err
+	}
 
-		leader = true
+	for _, id := range ids {
+		if err := r.AddRunToScheduler(id); err != nil {
+			return err
+		}
 	}
 
-	tryBecomeLeader(ctx)
+	return r.redisClient.Del(ctx, legacySchedulerRunKey).Err()
+}
 
-	for {
-		select {
-		case <-ctx.Done():
-			r.scheduler.Shutdown()
-			mutex.UnlockContext(ctx)
-			return
+func (r *RedisSync) StopScheduler(ctx context.Context) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
 
-		case <-ticker.C:
+	if r.schedCancel != nil {
+		r.schedCancel()
+		r.schedCancel = nil
+	}
+}
+
+func (r *RedisSync) AddRunToScheduler(run_id string) error {
+	ctx := context.TODO()
+	due, idle := schedulerShardKeys(stepShard(run_id, r.stepShards))
+
+	if err := r.redisClient.HDel(ctx, idle, run_id).Err(); err != nil {
+		return err
+	}
+
+	return r.redisClient.ZAdd(ctx, due, redis.Z{Score: float64(time.Now().UnixMilli()), Member: run_id}).Err()
+}
+
+func (r *RedisSync) RescheduleRun(run_id string, idle bool) error {
+	due, idleKey := schedulerShardKeys(stepShard(run_id, r.stepShards))
+
+	isIdle := "0"
+	if idle {
+		isIdle = "1"
+	}
+
+	return rescheduleRun.Run(context.TODO(), r.redisClient, []string{due, idleKey},
+		run_id, isIdle, stepMinInterval.Milliseconds(), r.stepInterval.Milliseconds(), time.Now().UnixMilli()).Err()
+}
+
+func (r *RedisSync) RemoveRunFromScheduler(run_id string) error {
+	ctx := context.TODO()
+	due, idle := schedulerShardKeys(stepShard(run_id, r.stepShards))
+
+	if err := r.redisClient.ZRem(ctx, due, run_id).Err(); err != nil {
+		return err
+	}
+
+	return r.redisClient.HDel(ctx, idle, run_id).Err()
+}
+
+// asynqError marks errors wrapping ErrSkipRetry so asynq archives the task
+// immediately.
+func asynqError(err error) error {
+	if errors.Is(err, ErrSkipRetry) {
+		return fmt.Errorf("%w: %w", err, asynq.SkipRetry)
+	}
+
+	return err
+}
+
+// ListDeadLetters returns a page of one queue's archived tasks at a time.
+// The cursor is "<queue>:<page>", with queues in name order; pages of a queue
+// hold limit tasks, so the cursor only suits the limit it was returned for.
+func (r *RedisSync) ListDeadLetters(ctx context.Context, cursor string, limit int) ([]DeadLetter, string, error) {
+	if limit <= 0 {
+		limit = DeadLetterPageSize
+	}
+
+	from, page := "", 1
+	if cursor != "" {
+		i := strings.LastIndex(cursor, ":")
+		if i < 0 {
+			return nil, "", fmt.Errorf("invalid dead letter cursor %s", cursor)
 		}
 
-		if !leader {
-			tryBecomeLeader(ctx)
-			continue
+		n, err := strconv.Atoi(cursor[i+1:])
+		if err != nil || n < 1 {
+			return nil, "", fmt.Errorf("invalid dead letter cursor %s", cursor)
+		}
+		from, page = cursor[:i], n
+	}
+
+	queues, err := r.inspector.Queues()
+	if err != nil {
+		return nil, "", err
+	}
+	slices.Sort(queues)
 
+	start, _ := slices.BinarySearch(queues, from)
+	for i := start; i < len(queues); i++ {
+		queue := queues[i]
+		if queue != from {
+			page = 1
 		}
 
-		ok, err := mutex.ExtendContext(ctx)
-		if err != nil || !ok {
-			r.scheduler.Shutdown()
-			mutex.UnlockContext(ctx)
-			leader = false
+		tasks, err := r.inspector.ListArchivedTasks(queue, asynq.PageSize(limit), asynq.Page(page))
+		if err != nil {
+			return nil, "", err
+		}
+		if len(tasks) == 0 {
+			continue
 		}
 
+		letters := appendDeadLetters(make([]DeadLetter, 0, len(tasks)), tasks)
+
+		switch {
+		case len(tasks) == limit:
+			return letters, fmt.Sprintf("%s:%d", queue, page+1), nil
+		case i+1 < len(queues):
+			return letters, queues[i+1] + ":1", nil
+		default:
+			return letters, "", nil
+		}
 	}
+
+	return make([]DeadLetter, 0), "", nil
 }
-func (r *RedisSync) StopScheduler(ctx context.Context) {
-	r.scheduler.Shutdown()
+
+func appendDeadLetters(letters []DeadLetter, tasks []*asynq.TaskInfo) []DeadLetter {
+	for _, t := range tasks {
+		letters = append(letters, DeadLetter{
+			ID:       t.ID,
+			Queue:    t.Queue,
+			Type:     t.Type,
+			Summary:  summarizePayload(t.Payload),
+			Error:    t.LastErr,
+			Attempts: t.Retried + 1,
+			FailedAt: t.LastFailedAt,
+		})
+	}
+
+	return letters
 }
 
-func (r *RedisSync) AddRunToScheduler(run_id string) error {
-	return r.redisClient.SAdd(context.TODO(), schedulerRunKey, run_id).Err()
+func (r *RedisSync) RequeueDeadLetter(ctx context.Context, queue, id string) error {
+	return r.inspector.RunTask(queue, id)
 }
 
-func (r *RedisSync) RemoveRunFromScheduler(run_id string) error {
-	return r.redisClient.SRem(context.TODO(), schedulerRunKey, run_id).Err()
+func (r *RedisSync) DeleteDeadLetter(ctx context.Context, queue, id string) error {
+	return r.inspector.DeleteTask(queue, id)
+}
+
+func (r *RedisSync) PurgeDeadLetters(ctx context.Context) (int, error) {
+	queues, err := r.inspector.Queues()
+	if err != nil {
+		return 0, err
+	}
+
+	total := 0
+	for _, queue := range queues {
+		n, err := r.inspector.DeleteAllArchivedTasks(queue)
+		if err != nil {
+			return total, err
+		}
+		total += n
+	}
+
+	return total, nil
 }
 
 type RedisMutex struct {
@@ -348,6 +621,10 @@ func (mutex *RedisMutex) Lock(ctx context.Context) error {
 	return mutex.mutex.LockContext(ctx)
 }
 
+func (mutex *RedisMutex) TryLock(ctx context.Context) error {
+	return mutex.mutex.TryLockContext(ctx)
+}
+
 func (mutex *RedisMutex) Unlock(ctx context.Context) error {
 	_, err := mutex.mutex.UnlockContext(ctx)
 	return err
@@ -359,70 +636,284 @@ func (r *RedisSync) Start() error {
 		r.asynqServer.Start(r.mux)
 	}()
 
-	go func() {
-		if r.scheduler != nil {
-			r.StartScheduler(context.Background())
-		}
-	}()
+	if r.controller {
+		ctx, cancel := context.WithCancel(context.Background())
+
+		r.mu.Lock()
+		r.schedCancel = cancel
+		r.mu.Unlock()
+
+		go r.StartScheduler(ctx)
+	}
 
 	return nil
 }
 
 func (r *RedisSync) Close() error {
 
+	r.StopScheduler(context.Background())
 	r.asynqServer.Shutdown()
-	if r.scheduler != nil {
-		r.scheduler.Shutdown()
+
+	return nil
+}
+
+func newFlowStepTask(runID string) (*asynq.Task, error) {
+	payload, err := json.Marshal(FlowStepPayload{
+		RunID: runID,
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return asynq.NewTask(TypeFlowStep, payload, asynq.TaskID(runID), asynq.Queue("controller")), nil
+}
+
+const sharedKeysKey = "pup:keys"
+
+func (r *RedisSync) SharedKey(ctx context.Context, name string) ([]byte, error) {
+	if err := r.redisClient.HSetNX(ctx, sharedKeysKey, name, newSharedKey()).Err(); err != nil {
+		return nil, fmt.Errorf("unable to store key %s: %w", name, err)
+	}
+
+	key, err := r.redisClient.HGet(ctx, sharedKeysKey, name).Bytes()
+	if err != nil {
+		return nil, fmt.Errorf("unable to read key %s: %w", name, err)
+	}
+
+	return key, nil
+}
+
+const tierRegistryKey = "pup:tiers"
+
+func (r *RedisSync) PublishTiers(ctx context.Context, tiers map[string]resources.ResourceDefinition) error {
+	if len(tiers) == 0 {
+		return nil
+	}
+
+	pipe := r.redisClient.TxPipeline()
+	for name, def := range tiers {
+		data, err := json.Marshal(def)
+		if err != nil {
+			return fmt.Errorf("unable to encode tier %s: %w", name, err)
+		}
+
+		if r.controller {
+			pipe.HSet(ctx, tierRegistryKey, name, data)
+		} else {
+			pipe.HSetNX(ctx, tierRegistryKey, name, data)
+		}
+	}
+
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("unable to publish tiers: %w", err)
 	}
 
 	return nil
 }
 
-func (r *RedisSync) listScheduledTasks() ([]string, error) {
-	return r.redisClient.SMembers(context.TODO(), schedulerRunKey).Result()
+func (r *RedisSync) ListTiers(ctx context.Context) (map[string]resources.ResourceDefinition, error) {
+	raw, err := r.redisClient.HGetAll(ctx, tierRegistryKey).Result()
+	if err != nil {
+		return nil, fmt.Errorf("unable to list tiers: %w", err)
+	}
+
+	tiers := make(map[string]resources.ResourceDefinition, len(raw))
+	for name, data := range raw {
+		var def resources.ResourceDefinition
+		if err := json.Unmarshal([]byte(data), &def); err != nil {
+			r.log.Warn("skipping undecodable tier", "tier", name, "err", err)
+			continue
+		}
+
+		tiers[name] = def
+	}
+
+	return tiers, nil
 }
 
-type schedulerTaskProvider struct {
-	sync     *RedisSync
-	cronspec string
+const (
+	selectorRegistryKey = "pup:selectors"
+	tierImageKey        = "pup:tier_images"
+	workerRegistryKey   = "pup:workers"
+)
+
+func (r *RedisSync) publishSelector(ctx context.Context, qs QueueSelector) error {
+	data, err := json.Marshal(qs)
+	if err != nil {
+		return err
+	}
+
+	if err := r.redisClient.HSet(ctx, selectorRegistryKey, qs.Queue, data).Err(); err != nil {
+		return fmt.Errorf("unable to publish selector queue %s: %w", qs.Queue, err)
+	}
+
+	return nil
 }
 
-func newSchedulerTaskProvider(sync *RedisSync, cronspec string) *schedulerTaskProvider {
-	return &schedulerTaskProvider{
-		sync:     sync,
-		cronspec: cronspec,
+func (r *RedisSync) ListSelectors(ctx context.Context) ([]QueueSelector, error) {
+	raw, err := r.redisClient.HGetAll(ctx, selectorRegistryKey).Result()
+	if err != nil {
+		return nil, fmt.Errorf("unable to list selector queues: %w", err)
 	}
+
+	selectors := make([]QueueSelector, 0, len(raw))
+	for queue, data := range raw {
+		var qs QueueSelector
+		if err := json.Unmarshal([]byte(data), &qs); err != nil {
+			r.log.Warn("skipping undecodable selector queue", "queue", queue, "err", err)
+			continue
+		}
+
+		selectors = append(selectors, qs)
+	}
+
+	return selectors, nil
 }
 
-func (p *schedulerTaskProvider) GetConfigs() ([]*asynq.PeriodicTaskConfig, error) {
-	var configs []*asynq.PeriodicTaskConfig
+func (r *RedisSync) RegisterWorker(ctx context.Context, w WorkerInfo) error {
+	w.SeenAt = time.Now()
 
-	ids, err := p.sync.listScheduledTasks()
+	data, err := json.Marshal(w)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	for _, id := range ids {
+	if err := r.redisClient.HSet(ctx, workerRegistryKey, w.ID, data).Err(); err != nil {
+		return fmt.Errorf("unable to register worker: %w", err)
+	}
 
-		task, err := newFlowStepTask(id)
-		if err != nil {
+	return nil
+}
+
+// ListWorkers returns the workers seen recently and drops the rest.
+func (r *RedisSync) ListWorkers(ctx context.Context) ([]WorkerInfo, error) {
+	raw, err := r.redisClient.HGetAll(ctx, workerRegistryKey).Result()
+	if err != nil {
+		return nil, fmt.Errorf("unable to list workers: %w", err)
+	}
+
+	now := time.Now()
+	workers := make([]WorkerInfo, 0, len(raw))
+	var stale []string
+
+	for id, data := range raw {
+		var w WorkerInfo
+		if err := json.Unmarshal([]byte(data), &w); err != nil || !workerFresh(w, now) {
+			stale = append(stale, id)
 			continue
 		}
 
-		configs = append(configs, &asynq.PeriodicTaskConfig{Task: task, Cronspec: p.cronspec})
+		workers = append(workers, w)
+	}
+
+	if len(stale) > 0 {
+		r.redisClient.HDel(ctx, workerRegistryKey, stale...)
 	}
 
-	return configs, nil
+	return workers, nil
 }
 
-func newFlowStepTask(runID string) (*asynq.Task, error) {
-	payload, err := json.Marshal(FlowStepPayload{
-		RunID: runID,
+func nodeLogKey(runID, nodeID string) string {
+	return fmt.Sprintf("pup:logs:%s:%s", runID, nodeID)
+}
+
+func (r *RedisSync) AppendNodeLogs(ctx context.Context, runID, nodeID string, records []models.LogRecord) error {
+	if len(records) == 0 {
+		return nil
+	}
+
+	data, err := json.Marshal(records)
+	if err != nil {
+		return err
+	}
+
+	key := nodeLogKey(runID, nodeID)
+
+	pipe := r.redisClient.TxPipeline()
+	pipe.XAdd(ctx, &redis.XAddArgs{
+		Stream: key,
+		MaxLen: NodeLogMaxBatches,
+		Values: map[string]any{"records": data},
 	})
+	pipe.Expire(ctx, key, NodeLogTTL)
 
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("unable to append node logs: %w", err)
+	}
+
+	return nil
+}
+
+func (r *RedisSync) ReadNodeLogs(ctx context.Context, runID, nodeID, cursor string) ([]models.LogRecord, string, error) {
+	if cursor == "" {
+		cursor = "0"
+	}
+
+	res, err := r.redisClient.XRead(ctx, &redis.XReadArgs{
+		Streams: []string{nodeLogKey(runID, nodeID), cursor},
+		Count:   nodeLogReadBatches,
+		Block:   -1,
+	}).Result()
+	if errors.Is(err, redis.Nil) {
+		return nil, cursor, nil
+	}
 	if err != nil {
-		return nil, err
+		return nil, cursor, fmt.Errorf("unable to read node logs: %w", err)
 	}
 
-	return asynq.NewTask(TypeFlowStep, payload, asynq.TaskID(runID), asynq.Queue("controller")), nil
+	var records []models.LogRecord
+	for _, stream := range res {
+		for _, msg := range stream.Messages {
+			cursor = msg.ID
+
+			data, _ := msg.Values["records"].(string)
+
+			var batch []models.LogRecord
+			if err := json.Unmarshal([]byte(data), &batch); err != nil {
+				r.log.Warn("skipping undecodable node logs", "run_id", runID, "node_id", nodeID, "err", err)
+				continue
+			}
+
+			records = append(records, batch...)
+		}
+	}
+
+	return records, cursor, nil
+}
+
+func (r *RedisSync) publishTierImage(ctx context.Context, ti TierImage) error {
+	data, err := json.Marshal(ti)
+	if err != nil {
+		return err
+	}
+
+	return r.redisClient.HSet(ctx, tierImageKey, ti.Tier+"|"+ti.Image, data).Err()
+}
+
+// ListTierImages returns the images used recently and drops the rest.
+func (r *RedisSync) ListTierImages(ctx context.Context) ([]TierImage, error) {
+	raw, err := r.redisClient.HGetAll(ctx, tierImageKey).Result()
+	if err != nil {
+		return nil, fmt.Errorf("unable to list tier images: %w", err)
+	}
+
+	images := make([]TierImage, 0, len(raw))
+	var stale []string
+
+	for key, data := range raw {
+		var ti TierImage
+		if err := json.Unmarshal([]byte(data), &ti); err != nil || time.Since(ti.UsedAt) > TierImageTTL {
+			stale = append(stale, key)
+			continue
+		}
+
+		images = append(images, ti)
+	}
+
+	if len(stale) > 0 {
+		r.redisClient.HDel(ctx, tierImageKey, stale...)
+	}
+
+	return images, nil
 }
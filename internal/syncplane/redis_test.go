@@ -0,0 +1,343 @@
+package syncplane
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pupload/pupload/internal/models"
+	"github.com/pupload/pupload/internal/resources"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/cusianovic/asynq"
+)
+
+// testResources gives test workers a tier small enough for any machine.
+var testResources = resources.ResourceSettings{
+	MaxCPU: "auto", MaxMemory: "auto", MaxStorage: "auto",
+	Tiers: map[string]resources.ResourceDefinition{"t-test": {CPU: 1, Memory: "64mb", Storage: "64mb"}},
+}
+
+// helper to run a controller sync layer against miniredis and register cleanup
+func newTestRedisController(t *testing.T) (*RedisSync, *miniredis.Miniredis) {
+	t.Helper()
+
+	mr := miniredis.RunT(t)
+
+	r, err := NewControllerRedisSyncLayer(SyncPlaneSettings{
+		SelectedSyncPlane:      "redis",
+		Redis:                  RedisSettings{Address: mr.Addr()},
+		ControllerStepInterval: "@every 10s",
+		StepShards:             4,
+		StepBatchSize:          2,
+	})
+	if err != nil {
+		t.Fatalf("NewControllerRedisSyncLayer() error = %v", err)
+	}
+	t.Cleanup(func() { r.Close() })
+
+	return r, mr
+}
+
+// helper to run a worker sync layer against the same miniredis
+func newTestRedisWorker(t *testing.T, mr *miniredis.Miniredis) *RedisSync {
+	t.Helper()
+
+	r, err := NewWorkerRedisSyncLayer(SyncPlaneSettings{
+		SelectedSyncPlane: "redis",
+		Redis:             RedisSettings{Address: mr.Addr()},
+	}, testResources)
+	if err != nil {
+		t.Fatalf("NewWorkerRedisSyncLayer() error = %v", err)
+	}
+	t.Cleanup(func() { r.Close() })
+
+	return r
+}
+
+func stepScore(t *testing.T, r *RedisSync, runID string) time.Time {
+	t.Helper()
+
+	due, _ := schedulerShardKeys(stepShard(runID, r.stepShards))
+	score, err := r.redisClient.ZScore(context.Background(), due, runID).Result()
+	if err != nil {
+		t.Fatalf("ZScore() error = %v", err)
+	}
+
+	return time.UnixMilli(int64(score))
+}
+
+func TestRedisSync_ClaimsDueRunsOnce(t *testing.T) {
+	r, _ := newTestRedisController(t)
+	ctx := context.Background()
+
+	ids := []string{"run-1", "run-2", "run-3", "run-4", "run-5"}
+	for _, id := range ids {
+		if err := r.AddRunToScheduler(id); err != nil {
+			t.Fatalf("AddRunToScheduler() error = %v", err)
+		}
+	}
+
+	claimed := 0
+	for shard := range r.stepShards {
+		for {
+			n, err := r.claimShard(ctx, shard)
+			if err != nil {
+				t.Fatalf("claimShard() error = %v", err)
+			}
+			claimed += n
+			if n < r.stepBatchSize {
+				break
+			}
+		}
+	}
+
+	if claimed != len(ids) {
+		t.Fatalf("expected %d claimed runs, got %d", len(ids), claimed)
+	}
+
+	for shard := range r.stepShards {
+		if n, _ := r.claimShard(ctx, shard); n != 0 {
+			t.Fatalf("shard %d: leased runs were claimed again", shard)
+		}
+	}
+}
+
+func TestRedisSync_RescheduleBacksOffWhileIdle(t *testing.T) {
+	r, _ := newTestRedisController(t)
+
+	r.AddRunToScheduler("run-1")
+
+	var prev time.Duration
+	for i := 1; i <= 3; i++ {
+		if err := r.RescheduleRun("run-1", true); err != nil {
+			t.Fatalf("RescheduleRun() error = %v", err)
+		}
+
+		delay := time.Until(stepScore(t, r, "run-1"))
+		if delay <= prev {
+			t.Fatalf("idle step %d: expected delay above %v, got %v", i, prev, delay)
+		}
+		prev = delay
+	}
+
+	r.RescheduleRun("run-1", false)
+	if delay := time.Until(stepScore(t, r, "run-1")); delay > stepMinInterval {
+		t.Fatalf("expected backoff to reset, got %v", delay)
+	}
+
+	if err := r.RemoveRunFromScheduler("run-1"); err != nil {
+		t.Fatalf("RemoveRunFromScheduler() error = %v", err)
+	}
+	r.RescheduleRun("run-1", false)
+
+	due, _ := schedulerShardKeys(stepShard("run-1", r.stepShards))
+	if n, _ := r.redisClient.ZCard(context.Background(), due).Result(); n != 0 {
+		t.Fatalf("reschedule resurrected a removed run")
+	}
+}
+
+func TestRedisSync_MigratesLegacySchedule(t *testing.T) {
+	r, _ := newTestRedisController(t)
+	ctx := context.Background()
+
+	r.redisClient.SAdd(ctx, legacySchedulerRunKey, "run-1", "run-2")
+
+	if err := r.migrateLegacySchedule(ctx); err != nil {
+		t.Fatalf("migrateLegacySchedule() error = %v", err)
+	}
+
+	for _, id := range []string{"run-1", "run-2"} {
+		stepScore(t, r, id)
+	}
+
+	if n, _ := r.redisClient.Exists(ctx, legacySchedulerRunKey).Result(); n != 0 {
+		t.Fatalf("expected legacy set to be removed")
+	}
+}
+
+func TestRedisSync_TierRegistry(t *testing.T) {
+	controller, mr := newTestRedisController(t)
+	ctx := context.Background()
+
+	worker, err := NewWorkerRedisSyncLayer(SyncPlaneSettings{
+		SelectedSyncPlane: "redis",
+		Redis:             RedisSettings{Address: mr.Addr()},
+	}, resources.ResourceSettings{MaxCPU: "2", MaxMemory: "1gb", MaxStorage: "1gb"})
+	if err != nil {
+		t.Fatalf("NewWorkerRedisSyncLayer() error = %v", err)
+	}
+	t.Cleanup(func() { worker.Close() })
+
+	tiny := resources.ResourceDefinition{CPU: 1, Memory: "256mb", Storage: "1gb"}
+	bigger := resources.ResourceDefinition{CPU: 2, Memory: "512mb", Storage: "1gb"}
+	encode := resources.ResourceDefinition{CPU: 12, Memory: "24gb", Storage: "100gb"}
+
+	if err := controller.PublishTiers(ctx, map[string]resources.ResourceDefinition{"tiny": tiny}); err != nil {
+		t.Fatalf("controller PublishTiers() error = %v", err)
+	}
+
+	// The worker can add a tier but not redefine the controller's.
+	local := map[string]resources.ResourceDefinition{"tiny": bigger, "video-encode": encode}
+	if err := worker.PublishTiers(ctx, local); err != nil {
+		t.Fatalf("worker PublishTiers() error = %v", err)
+	}
+
+	tiers, err := SharedTiers(ctx, worker, local)
+	if err != nil {
+		t.Fatalf("SharedTiers() error = %v", err)
+	}
+
+	if got, _ := tiers.Get("tiny"); got.CPU != tiny.CPU {
+		t.Fatalf("expected the controller's tiny tier, got %+v", got)
+	}
+	if _, ok := tiers.Get("video-encode"); !ok {
+		t.Fatalf("expected the worker's video-encode tier")
+	}
+	if _, ok := tiers.Get("c-small"); !ok {
+		t.Fatalf("expected standard tiers to remain")
+	}
+
+	// A later controller start redefines its tier.
+	if err := controller.PublishTiers(ctx, map[string]resources.ResourceDefinition{"tiny": bigger}); err != nil {
+		t.Fatalf("controller PublishTiers() error = %v", err)
+	}
+	shared, _ := controller.ListTiers(ctx)
+	if shared["tiny"].CPU != bigger.CPU {
+		t.Fatalf("expected controller to overwrite tiny, got %+v", shared["tiny"])
+	}
+}
+
+func TestRedisSync_NodeLogs(t *testing.T) {
+	r, mr := newTestRedisController(t)
+	testNodeLogs(t, r)
+
+	if ttl := mr.TTL(nodeLogKey("run-1", "node-1")); ttl != NodeLogTTL {
+		t.Fatalf("expected logs to expire after %s, got %s", NodeLogTTL, ttl)
+	}
+}
+
+func TestRedisSync_SharedKey(t *testing.T) {
+	controller, mr := newTestRedisController(t)
+	testSharedKey(t, controller, newTestRedisWorker(t, mr))
+}
+
+func TestRedisSync_HandBackOnLastAttempt(t *testing.T) {
+	defer func(d time.Duration) { HandBackDelay = d }(HandBackDelay)
+	HandBackDelay = 50 * time.Millisecond
+
+	controller, mr := newTestRedisController(t)
+	worker := newTestRedisWorker(t, mr)
+
+	testHandBackOnLastAttempt(t, controller, worker)
+
+	if letters := listAllDeadLetters(t, controller, 0); len(letters) != 0 {
+		t.Fatalf("expected no dead letters, got %+v", letters)
+	}
+}
+
+func TestRedisSync_ListDeadLettersPages(t *testing.T) {
+	controller, _ := newTestRedisController(t)
+
+	// Three archived tasks in one queue and one in another, so pages run
+	// past the end of a queue into the next.
+	for i, queue := range []string{"a-queue", "a-queue", "a-queue", "b-queue"} {
+		task := asynq.NewTask(TypeNodeExecute, []byte(fmt.Sprintf(`{"RunID":"run-%d"}`, i)), asynq.Queue(queue))
+		info, err := controller.asynqClient.Enqueue(task)
+		if err != nil {
+			t.Fatalf("Enqueue() error = %v", err)
+		}
+		if err := controller.inspector.ArchiveTask(queue, info.ID); err != nil {
+			t.Fatalf("ArchiveTask() error = %v", err)
+		}
+	}
+
+	letters := listAllDeadLetters(t, controller, 2)
+	if len(letters) != 4 {
+		t.Fatalf("expected 4 dead letters, got %d", len(letters))
+	}
+
+	queues := map[string]int{}
+	for _, l := range letters {
+		queues[l.Queue]++
+	}
+	if queues["a-queue"] != 3 || queues["b-queue"] != 1 {
+		t.Fatalf("unexpected dead letters per queue %v", queues)
+	}
+
+	if _, _, err := controller.ListDeadLetters(context.Background(), "a-queue", 2); err == nil {
+		t.Fatalf("expected a cursor without a page to fail")
+	}
+}
+
+func TestRedisSync_DeadLettersExhaustedTasks(t *testing.T) {
+	controller, mr := newTestRedisController(t)
+	worker := newTestRedisWorker(t, mr)
+
+	var calls atomic.Int32
+	worker.RegisterExecuteNodeHandler(func(ctx context.Context, p NodeExecutePayload) error {
+		calls.Add(1)
+		return fmt.Errorf("bad input: %w", ErrSkipRetry)
+	})
+	worker.UpdateSubscribedQueues(map[string]int{"t-test": 1})
+	worker.Start()
+
+	for _, id := range []string{"node-1", "node-2"} {
+		controller.EnqueueExecuteNode(NodeExecutePayload{
+			RunID:       "run-1",
+			Node:        models.Node{ID: id},
+			NodeDef:     models.NodeDef{Tier: "t-test"},
+			MaxAttempts: 3,
+		})
+	}
+
+	var letters []DeadLetter
+	deadline := time.Now().Add(10 * time.Second)
+	for len(letters) < 2 && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+		// A page at a time, so listing has to go past the first page.
+		letters = listAllDeadLetters(t, controller, 1)
+	}
+
+	if len(letters) != 2 {
+		t.Fatalf("expected 2 dead letters, got %d", len(letters))
+	}
+
+	slices.SortFunc(letters, func(a, b DeadLetter) int { return strings.Compare(a.Summary, b.Summary) })
+
+	dl := letters[0]
+	if dl.Queue != "t-test" || dl.Type != TypeNodeExecute || dl.Attempts != 1 {
+		t.Fatalf("unexpected dead letter: %+v", dl)
+	}
+	if dl.Summary != "run_id=run-1 node_id=node-1" {
+		t.Fatalf("unexpected summary %q", dl.Summary)
+	}
+	if calls.Load() != 2 {
+		t.Fatalf("expected skip-retry tasks to run once each, ran %d times", calls.Load())
+	}
+
+	if err := controller.RequeueDeadLetter(context.Background(), dl.Queue, dl.ID); err != nil {
+		t.Fatalf("RequeueDeadLetter() error = %v", err)
+	}
+
+	deadline = time.Now().Add(10 * time.Second)
+	for calls.Load() < 3 && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+	}
+	if calls.Load() < 3 {
+		t.Fatalf("requeued task was not redelivered")
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	n, err := controller.PurgeDeadLetters(context.Background())
+	if err != nil {
+		t.Fatalf("PurgeDeadLetters() error = %v", err)
+	}
+	if n != 2 {
+		t.Fatalf("expected to purge 2 dead letters, purged %d", n)
+	}
+}
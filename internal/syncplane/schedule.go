@@ -0,0 +1,50 @@
+package syncplane
+
+import (
+	"hash/fnv"
+	"time"
+)
+
+// Runs are stepped from a time-ordered schedule split into shards. Every
+// controller replica polls every shard and claims runs whose next step is due,
+// pushing their next step out by stepLease so a crashed claimer only delays a
+// run rather than losing it. Handlers reschedule runs once stepped.
+const (
+	defaultStepShards    = 16
+	defaultStepBatchSize = 100
+
+	stepMinInterval  = time.Second
+	stepPollInterval = 500 * time.Millisecond
+	stepMinLease     = 30 * time.Second
+)
+
+func stepShards(cfg SyncPlaneSettings) int {
+	if cfg.StepShards <= 0 {
+		return defaultStepShards
+	}
+	return cfg.StepShards
+}
+
+func stepBatchSize(cfg SyncPlaneSettings) int {
+	if cfg.StepBatchSize <= 0 {
+		return defaultStepBatchSize
+	}
+	return cfg.StepBatchSize
+}
+
+func stepShard(runID string, shards int) int {
+	h := fnv.New32a()
+	h.Write([]byte(runID))
+	return int(h.Sum32() % uint32(shards))
+}
+
+// stepBackoff doubles the wait for every consecutive idle step, capped at the
+// configured step interval.
+func stepBackoff(idle int, interval time.Duration) time.Duration {
+	d := stepMinInterval << min(idle, 16)
+	return min(d, interval)
+}
+
+func stepLease(interval time.Duration) time.Duration {
+	return max(interval, stepMinLease)
+}
@@ -2,7 +2,14 @@ package syncplane
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"time"
+
+	"github.com/pupload/pupload/internal/models"
+	"github.com/pupload/pupload/internal/redisconn"
+	"github.com/pupload/pupload/internal/resources"
 )
 
 type SyncLayer interface {
@@ -15,25 +22,100 @@ type SyncLayer interface {
 	RegisterNodeFailedHandler(handler NodeFailedHandler) error
 	EnqueueNodeFailed(payload NodeFailedPayload) error
 
+	// Progress is best effort: it isn't retried and may arrive out of order.
+	RegisterNodeProgressHandler(handler NodeProgressHandler) error
+	EnqueueNodeProgress(payload NodeProgressPayload) error
+
 	UpdateSubscribedQueues(queues map[string]int) error
 
 	RegisterFlowStepHandler(handler FlowStepHandler) error
 	StartScheduler(ctx context.Context)
 	StopScheduler(ctx context.Context)
 	AddRunToScheduler(run_id string) error
+	RescheduleRun(run_id string, idle bool) error
 	RemoveRunFromScheduler(run_id string) error
 
 	NewMutex(run_id string, duration time.Duration) Mutex
 
+	// ListDeadLetters returns up to limit dead letters from cursor, or from
+	// the start when it is empty, and the cursor the next page starts at,
+	// empty after the last page.
+	ListDeadLetters(ctx context.Context, cursor string, limit int) ([]DeadLetter, string, error)
+	RequeueDeadLetter(ctx context.Context, queue, id string) error
+	DeleteDeadLetter(ctx context.Context, queue, id string) error
+	PurgeDeadLetters(ctx context.Context) (int, error)
+
+	// PublishTiers adds custom tiers to the shared registry. Controllers
+	// overwrite existing definitions; workers only add tiers nobody has
+	// defined yet.
+	PublishTiers(ctx context.Context, tiers map[string]resources.ResourceDefinition) error
+	ListTiers(ctx context.Context) (map[string]resources.ResourceDefinition, error)
+
+	// ListSelectors returns the labelled queues nodes have been routed to,
+	// so workers can subscribe to those their labels satisfy.
+	ListSelectors(ctx context.Context) ([]QueueSelector, error)
+
+	// RegisterWorker advertises a worker until WorkerHeartbeat has passed a
+	// few times without another call.
+	RegisterWorker(ctx context.Context, w WorkerInfo) error
+	ListWorkers(ctx context.Context) ([]WorkerInfo, error)
+
+	// ListTierImages returns the container images nodes were routed to in
+	// the last TierImageTTL, so workers can pull them ahead of time.
+	ListTierImages(ctx context.Context) ([]TierImage, error)
+
+	// SharedKey returns the secret stored under name, storing a new random
+	// one first if there is none, so every controller on the sync plane signs
+	// with the same key, across restarts too.
+	SharedKey(ctx context.Context, name string) ([]byte, error)
+
+	// AppendNodeLogs stores a batch of a node's log records while it runs.
+	// Only the latest NodeLogMaxBatches batches of a node are kept, for about
+	// NodeLogTTL.
+	AppendNodeLogs(ctx context.Context, runID, nodeID string, records []models.LogRecord) error
+	// ReadNodeLogs returns the records stored after cursor, "" for the
+	// start, and the cursor to read on from.
+	ReadNodeLogs(ctx context.Context, runID, nodeID, cursor string) ([]models.LogRecord, string, error)
+
 	Start() error
 	Close() error
 }
 
 type Mutex interface {
 	Lock(ctx context.Context) error
+	// TryLock takes the lock only if it is free, without waiting for it.
+	TryLock(ctx context.Context) error
 	Unlock(ctx context.Context) error
 }
 
+// ErrSkipRetry can be wrapped by handlers to send a task straight to the
+// dead-letter queue instead of retrying it.
+var ErrSkipRetry = errors.New("syncplane: skip retry")
+
+// ErrHandBack can be wrapped by execute handlers to leave a task to another
+// worker. It is redelivered after HandBackDelay without counting as an
+// attempt, on its last attempt too.
+var ErrHandBack = errors.New("syncplane: hand back")
+
+// HandBackDelay is how long a handed back task waits before redelivery. A
+// variable so tests can shorten it.
+var HandBackDelay = 5 * time.Second
+
+// DeadLetterPageSize is how many dead letters ListDeadLetters returns when
+// given no limit.
+const DeadLetterPageSize = 100
+
+// DeadLetter is a task that exhausted its retries or could not be processed.
+type DeadLetter struct {
+	ID       string
+	Queue    string
+	Type     string
+	Summary  string
+	Error    string
+	Attempts int
+	FailedAt time.Time
+}
+
 type Task struct {
 	Task    string
 	Payload []byte
@@ -43,17 +125,28 @@ type SyncPlaneSettings struct {
 	SelectedSyncPlane string
 
 	Redis RedisSettings
+	Nats  NatsSettings
 
-	ControllerStepInterval string // time inbetween a flowruns attemped steps, written as cronspec. eg. @every 10s
+	ControllerStepInterval string // longest time an idle flowrun waits between steps, written as cronspec. eg. @every 10s
+
+	StepShards    int // number of schedule shards, defaults to 16
+	StepBatchSize int // runs claimed from a shard per poll, defaults to 100
 }
 
-type RedisSettings struct {
-	Address  string
-	Password string
-	DB       int
+type RedisSettings = redisconn.Settings
+
+type NatsSettings struct {
+	URL         string
+	Credentials string // path to a .creds file, optional
+
+	StreamName string // defaults to PUP_TASKS
+	Replicas   int
+}
 
-	PoolSize   int
-	MaxRetries int
+// newSharedKey returns a random key for SharedKey, hex encoded.
+func newSharedKey() []byte {
+	key := make([]byte, 32)
+	rand.Read(key)
 
-	// TODO: add redis TLS support
+	return []byte(hex.EncodeToString(key))
 }
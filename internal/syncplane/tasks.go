@@ -2,6 +2,9 @@ package syncplane
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
+	"time"
 
 	"github.com/pupload/pupload/internal/models"
 )
@@ -11,6 +14,7 @@ const (
 	TypeNodeExecute     = "node:execute"
 	TypeNodeFinished    = "node:finished"
 	TypeNodeFailed      = "node:failed"
+	TypeNodeProgress    = "node:progress"
 	TypeControllerClean = "controller:clean"
 )
 
@@ -21,10 +25,23 @@ type NodeExecutePayload struct {
 	Node       models.Node
 	InputURLs  map[string]string
 	OutputURLs map[string]string
+	ModuleURL  string // presigned GET for a wasi module kept in a store
+
+	InputInfo map[string]models.ArtifactInfo // recorded for inputs produced by other nodes, to verify downloads
+
+	OutputMultipart map[string]models.MultipartUpload // for outputs whose store supports it
 
 	MaxAttempts int
 	Attempt     int
 
+	ExecutionID  string    // assigned by the controller each time the node is dispatched
+	AttemptID    string    // assigned by the sync plane on each delivery
+	DispatchedAt time.Time // when the controller dispatched the node
+
+	// PriorAttempts is set by the Redis sync plane on copies of a task
+	// handed back on its last attempt, which start a fresh retry count.
+	PriorAttempts int `json:",omitempty"`
+
 	TraceParent string
 }
 
@@ -39,6 +56,15 @@ type NodeFinishedPayload struct {
 	NodeID string
 	Logs   []models.LogRecord
 
+	Outputs map[string]models.ArtifactInfo // by output name
+	Results map[string]json.RawMessage     // by result name
+
+	ImageDigest string // container nodes only
+
+	ExecutionID string
+	AttemptID   string
+	Attempt     int
+
 	TraceParent string
 }
 
@@ -50,6 +76,28 @@ type NodeFailedPayload struct {
 	MaxAttempts int
 	Error       string
 	Logs        []models.LogRecord
+	SkipRetry   bool // the worker won't retry, so this failure is final
+	ImageDigest string
+
+	ExecutionID string
+	AttemptID   string
 
 	TraceParent string
 }
+
+type NodeProgressHandler func(ctx context.Context, payload NodeProgressPayload) error
+type NodeProgressPayload struct {
+	RunID    string
+	NodeID   string
+	Progress models.NodeProgress
+
+	ExecutionID string
+	AttemptID   string
+	Attempt     int
+}
+
+// AttemptID identifies a single delivery of an execution. Retries share an
+// execution ID but each gets its own attempt ID.
+func AttemptID(executionID string, attempt int) string {
+	return fmt.Sprintf("%s/%d", executionID, attempt)
+}
@@ -0,0 +1,42 @@
+package syncplane
+
+import (
+	"context"
+	"maps"
+	"reflect"
+
+	"github.com/pupload/pupload/internal/logging"
+	"github.com/pupload/pupload/internal/resources"
+)
+
+// SharedTiers returns the standard tiers extended by local and then by the
+// registry, so a tier name means the same thing to the controller and every
+// worker. Registry entries that fail validation are skipped.
+func SharedTiers(ctx context.Context, s SyncLayer, local map[string]resources.ResourceDefinition) (resources.TierSet, error) {
+	log := logging.ForService("tiers")
+
+	shared, err := s.ListTiers(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	merged := maps.Clone(local)
+	if merged == nil {
+		merged = make(map[string]resources.ResourceDefinition, len(shared))
+	}
+
+	for name, def := range shared {
+		if err := resources.ValidateTier(name, def); err != nil {
+			log.Warn("ignoring invalid shared tier", "tier", name, "err", err)
+			continue
+		}
+
+		if l, ok := local[name]; ok && !reflect.DeepEqual(l, def) {
+			log.Warn("local tier differs from the shared definition, using the shared one", "tier", name)
+		}
+
+		merged[name] = def
+	}
+
+	return resources.Tiers(merged)
+}
@@ -8,14 +8,26 @@ const (
 
 // Node Codes (NODE_###)
 const (
-	ErrNodeDefNotFound   = "NODE_001"
-	ErrNodeMissingInput  = "NODE_002"
-	ErrNodeMissingOutput = "NODE_003"
-	ErrNodeInvalidTier   = "NODE_004"
-	ErrNodeMissingFlag   = "NODE_005"
-	ErrNodeUnknownFlag   = "NODE_006"
-	ErrNodeDuplicateID   = "NODE_007"
-	ErrNodeMissingID     = "NODE_008"
+	ErrNodeDefNotFound     = "NODE_001"
+	ErrNodeMissingInput    = "NODE_002"
+	ErrNodeMissingOutput   = "NODE_003"
+	ErrNodeInvalidTier     = "NODE_004"
+	ErrNodeMissingFlag     = "NODE_005"
+	ErrNodeUnknownFlag     = "NODE_006"
+	ErrNodeDuplicateID     = "NODE_007"
+	ErrNodeMissingID       = "NODE_008"
+	ErrNodeImageDenied     = "NODE_009"
+	ErrNodeBadSelector     = "NODE_010"
+	ErrNodeInvalidExecutor = "NODE_011"
+	ErrNodeInvalidModule   = "NODE_012"
+	ErrNodeInvalidSize     = "NODE_013"
+	ErrNodeInvalidResult   = "NODE_014"
+	ErrNodeBadResultRef    = "NODE_015"
+	ErrNodeInvalidSecret   = "NODE_016"
+	ErrNodeSecretInFlag    = "NODE_017"
+	ErrNodeInvalidFlag     = "NODE_018"
+	ErrNodeInvalidCommand  = "NODE_019"
+	ErrNodePullPolicy      = "NODE_020"
 )
 
 // Def Codes (DEF_###)
@@ -58,4 +70,6 @@ const (
 // General Warns (WARN_###
 const (
 	WarnDeprecatedField = "WARN_001"
+	WarnNodeNoWorker    = "WARN_002"
+	WarnPlainCredential = "WARN_003"
 )
@@ -40,6 +40,14 @@ func flowDetectCycle(r *ValidationResult, flow models.Flow) {
 		}
 	}
 
+	// A node waits for the nodes whose results its flags use, too.
+	for _, node := range flow.Nodes {
+		for _, ref := range node.ResultRefs() {
+			adjacencyList[ref.Node] = append(adjacencyList[ref.Node], node.ID)
+			inDegree[node.ID]++
+		}
+	}
+
 	q := make([]string, 0)
 	for _, node := range flow.Nodes {
 		if inDegree[node.ID] == 0 {
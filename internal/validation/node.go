@@ -2,9 +2,16 @@ package validation
 
 import (
 	"fmt"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
 
+	"github.com/pupload/pupload/internal/imagepolicy"
+	"github.com/pupload/pupload/internal/labels"
 	"github.com/pupload/pupload/internal/models"
 	"github.com/pupload/pupload/internal/resources"
+	"github.com/pupload/pupload/internal/secrets"
 )
 
 func getNodeDef(node models.Node, defs []models.NodeDef) *models.NodeDef {
@@ -89,17 +96,17 @@ func nodeMissingOutput(r *ValidationResult, node models.Node, defs []models.Node
 	}
 }
 
-func nodeInvalidTier(r *ValidationResult, node models.Node, defs []models.NodeDef) {
+func nodeInvalidTier(r *ValidationResult, node models.Node, defs []models.NodeDef, tiers resources.TierSet) {
 	def := getNodeDef(node, defs)
 	if def == nil {
 		return
 	}
 
-	_, tierValid := resources.StandardTierMap[def.Tier]
+	_, tierValid := tiers.Get(def.Tier)
 	if !tierValid {
 		r.AddError(ValidationEntry{
 			ValidationError,
-			ErrNodeMissingInput,
+			ErrNodeInvalidTier,
 			"NodeInvalidTier",
 			fmt.Sprintf("Node %s uses invalid tier %s", node.ID, def.Tier),
 		})
@@ -115,7 +122,7 @@ func nodeMissingFlag(r *ValidationResult, node models.Node, defs []models.NodeDe
 	for _, flagDef := range def.Flags {
 		found := false
 		for _, flagNode := range node.Flags {
-			if !flagDef.Required || flagNode.Name == flagDef.Name {
+			if !flagDef.Required || flagDef.Default != "" || flagNode.Name == flagDef.Name {
 				found = true
 				break
 			}
@@ -162,6 +169,62 @@ func nodeUnknownFlag(r *ValidationResult, node models.Node, defs []models.NodeDe
 	}
 }
 
+func nodeInvalidFlag(r *ValidationResult, node models.Node, defs []models.NodeDef) {
+	def := getNodeDef(node, defs)
+	if def == nil {
+		return
+	}
+
+	for _, flagDef := range def.Flags {
+		err := flagDef.CheckDef()
+		if err == nil {
+			i := slices.IndexFunc(node.Flags, func(f models.NodeFlag) bool { return f.Name == flagDef.Name })
+
+			// Values using results are checked by the worker once they're known.
+			if i < 0 || models.HasResultRefs(node.Flags[i].Value) {
+				continue
+			}
+
+			if err = flagDef.Check(node.Flags[i].Value); err == nil {
+				continue
+			}
+		}
+
+		r.AddError(ValidationEntry{
+			ValidationError,
+			ErrNodeInvalidFlag,
+			"NodeInvalidFlag",
+			fmt.Sprintf("Node %s: %s", node.ID, err),
+		})
+	}
+}
+
+func nodeInvalidCommand(r *ValidationResult, node models.Node, defs []models.NodeDef) {
+	def := getNodeDef(node, defs)
+	if def == nil {
+		return
+	}
+
+	if def.Command.Exec != "" && len(def.Command.Args) > 0 {
+		r.AddError(ValidationEntry{
+			ValidationError,
+			ErrNodeInvalidCommand,
+			"NodeInvalidCommand",
+			fmt.Sprintf("Node %s uses %s, whose command sets both Exec and Args", node.ID, node.Uses),
+		})
+		return
+	}
+
+	if err := def.CheckCommand(); err != nil {
+		r.AddError(ValidationEntry{
+			ValidationError,
+			ErrNodeInvalidCommand,
+			"NodeInvalidCommand",
+			fmt.Sprintf("Node %s uses %s: %s", node.ID, node.Uses, err),
+		})
+	}
+}
+
 func nodeMissingID(r *ValidationResult, node models.Node) {
 	if node.ID == "" {
 		r.AddError(ValidationEntry{
@@ -191,3 +254,265 @@ func nodeDuplicateID(r *ValidationResult, nodes []models.Node) {
 	}
 
 }
+
+func nodeImageDenied(r *ValidationResult, node models.Node, defs []models.NodeDef, policy *imagepolicy.Policy) {
+	def := getNodeDef(node, defs)
+	if def == nil {
+		return
+	}
+
+	// Only containers pull an image.
+	if def.ExecutorName() != models.ExecutorContainer {
+		return
+	}
+
+	if _, err := policy.Check(def.Image); err != nil {
+		r.AddError(ValidationEntry{
+			ValidationError,
+			ErrNodeImageDenied,
+			"NodeImageDenied",
+			fmt.Sprintf("Node %s uses image %s which is not allowed: %s", node.ID, def.Image, err),
+		})
+	}
+}
+
+func nodeInvalidExecutor(r *ValidationResult, node models.Node, defs []models.NodeDef) {
+	def := getNodeDef(node, defs)
+	if def == nil {
+		return
+	}
+
+	if !slices.Contains(models.Executors, def.ExecutorName()) {
+		r.AddError(ValidationEntry{
+			ValidationError,
+			ErrNodeInvalidExecutor,
+			"NodeInvalidExecutor",
+			fmt.Sprintf("Node %s uses unknown executor %s, expected one of %v", node.ID, def.Executor, models.Executors),
+		})
+	}
+}
+
+func nodeInvalidPullPolicy(r *ValidationResult, node models.Node, defs []models.NodeDef) {
+	def := getNodeDef(node, defs)
+	if def == nil || def.PullPolicy == "" || slices.Contains(models.PullPolicies, def.PullPolicy) {
+		return
+	}
+
+	r.AddError(ValidationEntry{
+		ValidationError,
+		ErrNodePullPolicy,
+		"NodeInvalidPullPolicy",
+		fmt.Sprintf("Node %s uses unknown pull policy %s, expected one of %v", node.ID, def.PullPolicy, models.PullPolicies),
+	})
+}
+
+func nodeInvalidModule(r *ValidationResult, node models.Node, defs []models.NodeDef, stores []models.StoreInput) {
+	def := getNodeDef(node, defs)
+	if def == nil || def.ExecutorName() != models.ExecutorWASI {
+		return
+	}
+
+	storeName, key, isStore := def.ModuleStore()
+
+	var problem string
+	switch {
+	case def.Module == "":
+		problem = "sets no module"
+	case isStore && key == "":
+		problem = fmt.Sprintf("its module %s has no key", def.Module)
+	case isStore && !slices.ContainsFunc(stores, func(s models.StoreInput) bool { return s.Name == storeName }):
+		problem = fmt.Sprintf("its module references nonexistant store %s", storeName)
+	default:
+		return
+	}
+
+	r.AddError(ValidationEntry{
+		ValidationError,
+		ErrNodeInvalidModule,
+		"NodeInvalidModule",
+		fmt.Sprintf("Node %s uses the wasi executor but %s", node.ID, problem),
+	})
+}
+
+func nodeInvalidSize(r *ValidationResult, node models.Node, defs []models.NodeDef) {
+	def := getNodeDef(node, defs)
+	if def == nil {
+		return
+	}
+
+	edges := slices.Concat(def.Inputs, def.Outputs)
+	for _, edge := range edges {
+		var problem string
+		switch {
+		case edge.MinSize < 0 || edge.MaxSize < 0:
+			problem = "a negative size limit"
+		case edge.MaxSize > 0 && edge.MinSize > edge.MaxSize:
+			problem = fmt.Sprintf("a minimum size of %d above its maximum of %d", edge.MinSize, edge.MaxSize)
+		default:
+			continue
+		}
+
+		r.AddError(ValidationEntry{
+			ValidationError,
+			ErrNodeInvalidSize,
+			"NodeInvalidSize",
+			fmt.Sprintf("Node %s edge %s has %s", node.ID, edge.Name, problem),
+		})
+	}
+}
+
+func nodeInvalidResult(r *ValidationResult, node models.Node, defs []models.NodeDef) {
+	def := getNodeDef(node, defs)
+	if def == nil {
+		return
+	}
+
+	seen := make(map[string]bool, len(def.Results))
+	for _, result := range def.Results {
+		var problem string
+		switch {
+		case result.Name == "":
+			problem = "a result without a name"
+		case seen[result.Name]:
+			problem = fmt.Sprintf("result %s declared twice", result.Name)
+		case result.Type != "" && !slices.Contains(models.ResultTypes, result.Type):
+			problem = fmt.Sprintf("result %s of unknown type %s, expected one of %v", result.Name, result.Type, models.ResultTypes)
+		default:
+			seen[result.Name] = true
+			continue
+		}
+
+		r.AddError(ValidationEntry{
+			ValidationError,
+			ErrNodeInvalidResult,
+			"NodeInvalidResult",
+			fmt.Sprintf("Node %s has %s", node.ID, problem),
+		})
+	}
+}
+
+func nodeBadResultRef(r *ValidationResult, node models.Node, nodes []models.Node, defs []models.NodeDef) {
+	for _, ref := range node.ResultRefs() {
+		var problem string
+
+		i := slices.IndexFunc(nodes, func(n models.Node) bool { return n.ID == ref.Node })
+		switch {
+		case ref.Node == node.ID:
+			problem = "its own result"
+		case i < 0:
+			problem = fmt.Sprintf("unknown node %s", ref.Node)
+		default:
+			def := getNodeDef(nodes[i], defs)
+			if def == nil || slices.ContainsFunc(def.Results, func(d models.NodeResultDef) bool { return d.Name == ref.Name }) {
+				continue
+			}
+
+			problem = fmt.Sprintf("result %s, which node %s doesn't declare", ref.Name, ref.Node)
+		}
+
+		r.AddError(ValidationEntry{
+			ValidationError,
+			ErrNodeBadResultRef,
+			"NodeBadResultRef",
+			fmt.Sprintf("Node %s flags refer to %s", node.ID, problem),
+		})
+	}
+}
+
+var envNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+func nodeInvalidSecret(r *ValidationResult, node models.Node) {
+	envs := make(map[string]bool, len(node.Secrets))
+	files := make(map[string]bool, len(node.Secrets))
+
+	for _, s := range node.Secrets {
+		var problem string
+		switch {
+		case !secrets.ValidName(s.Secret):
+			problem = fmt.Sprintf("a secret with invalid name %q", s.Secret)
+		case (s.Env == "") == (s.File == ""):
+			problem = fmt.Sprintf("secret %s without exactly one of Env or File", s.Secret)
+		case s.Env != "" && !envNamePattern.MatchString(s.Env):
+			problem = fmt.Sprintf("secret %s with invalid variable name %q", s.Secret, s.Env)
+		case strings.HasPrefix(s.Env, "PUPLOAD_"):
+			problem = fmt.Sprintf("secret %s in reserved variable %s", s.Secret, s.Env)
+		case s.File != "" && (!filepath.IsLocal(s.File) || strings.ContainsAny(s.File, `/\`)):
+			problem = fmt.Sprintf("secret %s with invalid file name %q", s.Secret, s.File)
+		case envs[s.Env] || files[s.File]:
+			problem = fmt.Sprintf("secret %s delivered to the same place as another", s.Secret)
+		default:
+			if s.Env != "" {
+				envs[s.Env] = true
+			} else {
+				files[s.File] = true
+			}
+			continue
+		}
+
+		r.AddError(ValidationEntry{
+			ValidationError,
+			ErrNodeInvalidSecret,
+			"NodeInvalidSecret",
+			fmt.Sprintf("Node %s has %s", node.ID, problem),
+		})
+	}
+}
+
+// nodeSecretInFlag rejects secret references in flags, which end up in the
+// command line and the run state; secrets go in the node's Secrets instead.
+func nodeSecretInFlag(r *ValidationResult, node models.Node) {
+	for _, f := range node.Flags {
+		if len(secrets.Refs(f.Value)) == 0 {
+			continue
+		}
+
+		r.AddError(ValidationEntry{
+			ValidationError,
+			ErrNodeSecretInFlag,
+			"NodeSecretInFlag",
+			fmt.Sprintf("Node %s flag %s refers to a secret; pass it through the node's Secrets", node.ID, f.Name),
+		})
+	}
+}
+
+func nodeBadSelector(r *ValidationResult, node models.Node, defs []models.NodeDef) {
+	def := getNodeDef(node, defs)
+	if def == nil {
+		return
+	}
+
+	sel := labels.NodeSelector(*def, node)
+	if err := sel.Validate(); err != nil {
+		r.AddError(ValidationEntry{
+			ValidationError,
+			ErrNodeBadSelector,
+			"NodeBadSelector",
+			fmt.Sprintf("Node %s has an invalid selector: %s", node.ID, err),
+		})
+	}
+}
+
+func nodeNoWorker(r *ValidationResult, node models.Node, defs []models.NodeDef, workers []labels.Set) {
+	def := getNodeDef(node, defs)
+	if def == nil {
+		return
+	}
+
+	sel := labels.NodeSelector(*def, node)
+	if len(sel) == 0 {
+		return
+	}
+
+	for _, w := range workers {
+		if sel.Matches(w) {
+			return
+		}
+	}
+
+	r.AddWarning(ValidationEntry{
+		ValidationWarning,
+		WarnNodeNoWorker,
+		"NodeNoWorker",
+		fmt.Sprintf("No registered worker matches node %s selector %s; it will wait until one joins", node.ID, sel),
+	})
+}
@@ -1,16 +1,21 @@
 package validation
 
 import (
+	"encoding/json"
 	"fmt"
 	"slices"
+	"strings"
 
 	"github.com/pupload/pupload/internal/models"
+	"github.com/pupload/pupload/internal/secrets"
+	"github.com/pupload/pupload/internal/stores"
 )
 
-func storeInvalidType(r *ValidationResult, store models.StoreInput) {
-	validTypes := []string{"s3"}
+// credentialParams are the store params holding credentials, lower case.
+var credentialParams = []string{"accesskey", "secretkey", "sessiontoken", "password", "token"}
 
-	if !slices.Contains(validTypes, store.Type) {
+func storeInvalidType(r *ValidationResult, store models.StoreInput) {
+	if !slices.Contains(models.StoreTypes, store.Type) {
 		r.AddError(ValidationEntry{
 			ValidationError,
 			ErrStoreInvalidType,
@@ -19,3 +24,47 @@ func storeInvalidType(r *ValidationResult, store models.StoreInput) {
 		})
 	}
 }
+
+// storeInvalidParams checks a store's params against its type. S3 stores
+// without params are left to fail when used.
+func storeInvalidParams(r *ValidationResult, store models.StoreInput) {
+	if !slices.Contains(models.StoreTypes, store.Type) || (store.Type == models.StoreS3 && len(store.Params) == 0) {
+		return
+	}
+
+	if err := stores.CheckParams(store); err != nil {
+		r.AddError(ValidationEntry{
+			ValidationError,
+			ErrStoreInvalidParams,
+			"StoreInvalidParams",
+			fmt.Sprintf("Store %s has invalid params: %s", store.Name, err),
+		})
+	}
+}
+
+// storePlainCredential warns about credentials written into the flow, which
+// is saved with every run; ${secret:name} keeps them out of it.
+func storePlainCredential(r *ValidationResult, store models.StoreInput) {
+	var params map[string]any
+	if err := json.Unmarshal(store.Params, &params); err != nil {
+		return
+	}
+
+	for key, value := range params {
+		s, ok := value.(string)
+		if !ok || s == "" || !slices.Contains(credentialParams, strings.ToLower(key)) {
+			continue
+		}
+
+		if len(secrets.Refs(s)) > 0 {
+			continue
+		}
+
+		r.AddWarning(ValidationEntry{
+			ValidationWarning,
+			WarnPlainCredential,
+			"StorePlainCredential",
+			fmt.Sprintf("Store %s param %s is a plain credential; use ${secret:name} instead", store.Name, key),
+		})
+	}
+}
@@ -1,6 +1,11 @@
 package validation
 
-import "github.com/pupload/pupload/internal/models"
+import (
+	"github.com/pupload/pupload/internal/imagepolicy"
+	"github.com/pupload/pupload/internal/labels"
+	"github.com/pupload/pupload/internal/models"
+	"github.com/pupload/pupload/internal/resources"
+)
 
 type ValidationSeverity string
 
@@ -21,12 +26,16 @@ type ValidationResult struct {
 	Warnings []ValidationEntry
 }
 
-func Validate(flow models.Flow, defs []models.NodeDef) *ValidationResult {
+// Validate checks a flow against its node definitions. A nil tier set means
+// the standard tiers.
+func Validate(flow models.Flow, defs []models.NodeDef, tiers resources.TierSet) *ValidationResult {
 	res := &ValidationResult{}
 
 	// Store errors and warnings
 	for _, store := range flow.Stores {
 		storeInvalidType(res, store)
+		storeInvalidParams(res, store)
+		storePlainCredential(res, store)
 	}
 
 	// Node errors and warnings
@@ -35,10 +44,21 @@ func Validate(flow models.Flow, defs []models.NodeDef) *ValidationResult {
 		nodeNoDefFound(res, node, defs)
 		nodeMissingInput(res, node, defs)
 		// nodeMissingOutput(res, node, defs)
-		nodeInvalidTier(res, node, defs)
+		nodeInvalidTier(res, node, defs, tiers)
 		nodeMissingFlag(res, node, defs)
 		nodeUnknownFlag(res, node, defs)
 		nodeMissingID(res, node)
+		nodeBadSelector(res, node, defs)
+		nodeInvalidExecutor(res, node, defs)
+		nodeInvalidModule(res, node, defs, flow.Stores)
+		nodeInvalidSize(res, node, defs)
+		nodeInvalidResult(res, node, defs)
+		nodeBadResultRef(res, node, flow.Nodes, defs)
+		nodeInvalidSecret(res, node)
+		nodeSecretInFlag(res, node)
+		nodeInvalidFlag(res, node, defs)
+		nodeInvalidCommand(res, node, defs)
+		nodeInvalidPullPolicy(res, node, defs)
 	}
 
 	// Edge errors and warnings
@@ -65,6 +85,22 @@ func Validate(flow models.Flow, defs []models.NodeDef) *ValidationResult {
 	return res
 }
 
+// ValidateImages checks every node's image against the policy workers enforce,
+// so disallowed images are rejected before a run starts.
+func ValidateImages(res *ValidationResult, flow models.Flow, defs []models.NodeDef, policy *imagepolicy.Policy) {
+	for _, node := range flow.Nodes {
+		nodeImageDenied(res, node, defs, policy)
+	}
+}
+
+// ValidateWorkers warns about nodes whose selector no registered worker
+// satisfies, since those would wait in their queue indefinitely.
+func ValidateWorkers(res *ValidationResult, flow models.Flow, defs []models.NodeDef, workers []labels.Set) {
+	for _, node := range flow.Nodes {
+		nodeNoWorker(res, node, defs, workers)
+	}
+}
+
 func (r *ValidationResult) HasError() bool {
 	return len(r.Errors) > 0
 }
@@ -1,9 +1,13 @@
 package validation
 
 import (
+	"strings"
 	"testing"
 
+	"github.com/pupload/pupload/internal/imagepolicy"
+	"github.com/pupload/pupload/internal/labels"
 	"github.com/pupload/pupload/internal/models"
+	"github.com/pupload/pupload/internal/resources"
 )
 
 func ptr(s string) *string {
@@ -59,7 +63,7 @@ func TestValidation_NormalFlow(t *testing.T) {
 		},
 	}}
 
-	res := Validate(flow, defs)
+	res := Validate(flow, defs, nil)
 	if res.HasError() {
 		t.Errorf("expected flow to have no errors: %v", *res)
 	}
@@ -122,7 +126,7 @@ func TestValidation_MultiNodeFlow(t *testing.T) {
 		},
 	}}
 
-	res := Validate(flow, defs)
+	res := Validate(flow, defs, nil)
 	if res.HasError() {
 		t.Errorf("expected flow to have no errors: %v", *res)
 	}
@@ -192,7 +196,7 @@ func TestValidation_CycleFlow(t *testing.T) {
 		},
 	}}
 
-	res := Validate(flow, defs)
+	res := Validate(flow, defs, nil)
 	if res.HasError() {
 		if len(res.Errors) != 1 {
 			t.Errorf("expected flow to have only one errors: %v", *res)
@@ -204,3 +208,453 @@ func TestValidation_CycleFlow(t *testing.T) {
 	}
 
 }
+
+func TestValidation_ImagePolicy(t *testing.T) {
+	flow := models.Flow{
+		Name: "imageflow",
+		Nodes: []models.Node{
+			{ID: "allowed", Uses: "pupload/ffmpeg"},
+			{ID: "denied", Uses: "pupload/miner"},
+		},
+	}
+
+	defs := []models.NodeDef{
+		{Publisher: "pupload", Name: "ffmpeg", Image: "pupload/ffmpeg:6"},
+		{Publisher: "pupload", Name: "miner", Image: "quay.io/someone/miner"},
+	}
+
+	policy := imagepolicy.New(imagepolicy.Settings{AllowedRegistries: []string{"docker.io"}})
+
+	res := &ValidationResult{}
+	ValidateImages(res, flow, defs, policy)
+
+	if len(res.Errors) != 1 {
+		t.Fatalf("expected one error: %v", *res)
+	}
+
+	if res.Errors[0].Code != ErrNodeImageDenied {
+		t.Errorf("expected error to be ErrNodeImageDenied: %v", *res)
+	}
+}
+
+func TestValidation_CustomTier(t *testing.T) {
+	flow := models.Flow{
+		Name:  "tierflow",
+		Nodes: []models.Node{{ID: "meta", Uses: "pupload/exif"}},
+	}
+
+	defs := []models.NodeDef{
+		{Publisher: "pupload", Name: "exif", Image: "pupload/exif", Tier: "tiny"},
+	}
+
+	res := &ValidationResult{}
+	nodeInvalidTier(res, flow.Nodes[0], defs, nil)
+	if len(res.Errors) != 1 || res.Errors[0].Code != ErrNodeInvalidTier {
+		t.Fatalf("expected ErrNodeInvalidTier with standard tiers: %v", *res)
+	}
+
+	tiers, err := resources.Tiers(map[string]resources.ResourceDefinition{
+		"tiny": {CPU: 1, Memory: "128mb", Storage: "1gb"},
+	})
+	if err != nil {
+		t.Fatalf("Tiers() error = %v", err)
+	}
+
+	res = &ValidationResult{}
+	nodeInvalidTier(res, flow.Nodes[0], defs, tiers)
+	if res.HasError() {
+		t.Fatalf("expected custom tier to be valid: %v", *res)
+	}
+}
+
+func TestValidation_Selectors(t *testing.T) {
+	flow := models.Flow{
+		Name: "labelflow",
+		Nodes: []models.Node{
+			{ID: "encode", Uses: "pupload/encode", Selector: map[string]string{"pool": "acme"}},
+			{ID: "plain", Uses: "pupload/plain"},
+		},
+	}
+
+	defs := []models.NodeDef{
+		{Publisher: "pupload", Name: "encode", Tier: "c-small", Selector: map[string]string{"codec.hevc": "licensed"}},
+		{Publisher: "pupload", Name: "plain", Tier: "c-small"},
+	}
+
+	res := &ValidationResult{}
+	ValidateWorkers(res, flow, defs, []labels.Set{{"codec.hevc": "licensed"}})
+	if len(res.Warnings) != 1 || res.Warnings[0].Code != WarnNodeNoWorker {
+		t.Fatalf("expected one WarnNodeNoWorker: %v", *res)
+	}
+
+	res = &ValidationResult{}
+	ValidateWorkers(res, flow, defs, []labels.Set{{"codec.hevc": "licensed", "pool": "acme"}})
+	if len(res.Warnings) != 0 {
+		t.Fatalf("expected matching worker to satisfy the node: %v", *res)
+	}
+
+	defs[0].Selector = map[string]string{"Bad Key": "x"}
+	res = &ValidationResult{}
+	nodeBadSelector(res, flow.Nodes[0], defs)
+	if len(res.Errors) != 1 || res.Errors[0].Code != ErrNodeBadSelector {
+		t.Fatalf("expected ErrNodeBadSelector: %v", *res)
+	}
+}
+
+func TestValidation_Executor(t *testing.T) {
+	flow := models.Flow{
+		Name: "execflow",
+		Nodes: []models.Node{
+			{ID: "meta", Uses: "pupload/exif"},
+			{ID: "bad", Uses: "pupload/vm"},
+		},
+	}
+
+	defs := []models.NodeDef{
+		{Publisher: "pupload", Name: "exif", Executor: models.ExecutorProcess},
+		{Publisher: "pupload", Name: "vm", Executor: "firecracker"},
+	}
+
+	res := &ValidationResult{}
+	nodeInvalidExecutor(res, flow.Nodes[0], defs)
+	nodeInvalidExecutor(res, flow.Nodes[1], defs)
+	if len(res.Errors) != 1 || res.Errors[0].Code != ErrNodeInvalidExecutor {
+		t.Fatalf("expected one ErrNodeInvalidExecutor: %v", *res)
+	}
+
+	// Process nodes have no image to check.
+	res = &ValidationResult{}
+	nodeImageDenied(res, flow.Nodes[0], defs, imagepolicy.New(imagepolicy.Settings{AllowedRegistries: []string{"docker.io"}}))
+	if res.HasError() {
+		t.Fatalf("expected process node to skip the image policy: %v", *res)
+	}
+
+	res = &ValidationResult{}
+	ValidateWorkers(res, flow, defs[:1], []labels.Set{{"executor.container": "true"}})
+	if len(res.Warnings) != 1 || res.Warnings[0].Code != WarnNodeNoWorker {
+		t.Fatalf("expected a warning without a process worker: %v", *res)
+	}
+}
+
+func TestValidation_WASIModule(t *testing.T) {
+	stores := []models.StoreInput{{Name: "modules", Type: "s3"}}
+
+	tests := []struct {
+		name   string
+		module string
+		valid  bool
+	}{
+		{"local path", "acme/rename.wasm", true},
+		{"store", "store://modules/acme/rename.wasm", true},
+		{"missing", "", false},
+		{"unknown store", "store://elsewhere/rename.wasm", false},
+		{"store without key", "store://modules", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			node := models.Node{ID: "rename", Uses: "acme/rename"}
+			defs := []models.NodeDef{{Publisher: "acme", Name: "rename", Executor: models.ExecutorWASI, Module: tt.module}}
+
+			res := &ValidationResult{}
+			nodeInvalidModule(res, node, defs, stores)
+
+			if tt.valid != !res.HasError() {
+				t.Fatalf("expected valid=%v: %v", tt.valid, *res)
+			}
+			if !tt.valid && res.Errors[0].Code != ErrNodeInvalidModule {
+				t.Fatalf("expected ErrNodeInvalidModule: %v", *res)
+			}
+		})
+	}
+}
+
+func TestValidation_SizeLimits(t *testing.T) {
+	tests := []struct {
+		name     string
+		min, max int64
+		valid    bool
+	}{
+		{"none", 0, 0, true},
+		{"min only", 1 << 20, 0, true},
+		{"range", 1, 1 << 30, true},
+		{"negative", -1, 0, false},
+		{"min above max", 10, 5, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			node := models.Node{ID: "thumb", Uses: "acme/thumb"}
+			defs := []models.NodeDef{{
+				Publisher: "acme",
+				Name:      "thumb",
+				Inputs:    []models.NodeEdgeDef{{Name: "video", MinSize: tt.min, MaxSize: tt.max}},
+			}}
+
+			res := &ValidationResult{}
+			nodeInvalidSize(res, node, defs)
+
+			if tt.valid != !res.HasError() {
+				t.Fatalf("expected valid=%v: %v", tt.valid, *res)
+			}
+			if !tt.valid && res.Errors[0].Code != ErrNodeInvalidSize {
+				t.Fatalf("expected ErrNodeInvalidSize: %v", *res)
+			}
+		})
+	}
+}
+
+func TestValidation_ResultRefs(t *testing.T) {
+	defs := []models.NodeDef{
+		{Publisher: "acme", Name: "detect", Results: []models.NodeResultDef{{Name: "language", Type: models.ResultString}}},
+		{Publisher: "acme", Name: "transcribe", Flags: []models.NodeFlagDef{{Name: "lang"}}},
+	}
+
+	tests := []struct {
+		name  string
+		value string
+		code  string
+	}{
+		{"declared result", "${results.detect.language}", ""},
+		{"unknown node", "${results.missing.language}", ErrNodeBadResultRef},
+		{"undeclared result", "${results.detect.pages}", ErrNodeBadResultRef},
+		{"own result", "${results.transcribe.language}", ErrNodeBadResultRef},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			nodes := []models.Node{
+				{ID: "detect", Uses: "acme/detect"},
+				{ID: "transcribe", Uses: "acme/transcribe", Flags: []models.NodeFlag{{Name: "lang", Value: tt.value}}},
+			}
+
+			res := &ValidationResult{}
+			nodeBadResultRef(res, nodes[1], nodes, defs)
+
+			if tt.code == "" && res.HasError() {
+				t.Fatalf("expected no errors: %v", *res)
+			}
+			if tt.code != "" && (!res.HasError() || res.Errors[0].Code != tt.code) {
+				t.Fatalf("expected %s: %v", tt.code, *res)
+			}
+		})
+	}
+
+	// transcribe feeds detect through an edge while using its result
+	flow := models.Flow{Nodes: []models.Node{
+		{ID: "detect", Uses: "acme/detect", Inputs: []models.NodeEdge{{Name: "in", Edge: "text"}}},
+		{ID: "transcribe", Uses: "acme/transcribe", Outputs: []models.NodeEdge{{Name: "out", Edge: "text"}}, Flags: []models.NodeFlag{{Name: "lang", Value: "${results.detect.language}"}}},
+	}}
+
+	res := &ValidationResult{}
+	flowDetectCycle(res, flow)
+
+	if !res.HasError() || res.Errors[0].Code != ErrFlowCycle {
+		t.Fatalf("expected ErrFlowCycle: %v", *res)
+	}
+}
+
+func TestValidation_Secrets(t *testing.T) {
+	tests := []struct {
+		name   string
+		secret models.NodeSecret
+		code   string
+	}{
+		{"env", models.NodeSecret{Secret: "api-key", Env: "API_KEY"}, ""},
+		{"file", models.NodeSecret{Secret: "kv/db#password", File: "db-password"}, ""},
+		{"invalid name", models.NodeSecret{Secret: "api key", Env: "API_KEY"}, ErrNodeInvalidSecret},
+		{"env and file", models.NodeSecret{Secret: "api-key", Env: "API_KEY", File: "api-key"}, ErrNodeInvalidSecret},
+		{"reserved env", models.NodeSecret{Secret: "api-key", Env: "PUPLOAD_RESULTS"}, ErrNodeInvalidSecret},
+		{"nested file", models.NodeSecret{Secret: "api-key", File: "../api-key"}, ErrNodeInvalidSecret},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := &ValidationResult{}
+			nodeInvalidSecret(res, models.Node{ID: "n", Secrets: []models.NodeSecret{tt.secret}})
+
+			if tt.code == "" && res.HasError() {
+				t.Fatalf("expected no errors: %v", *res)
+			}
+			if tt.code != "" && (!res.HasError() || res.Errors[0].Code != tt.code) {
+				t.Fatalf("expected %s: %v", tt.code, *res)
+			}
+		})
+	}
+
+	res := &ValidationResult{}
+	nodeSecretInFlag(res, models.Node{ID: "n", Flags: []models.NodeFlag{{Name: "token", Value: "${secret:api-key}"}}})
+	if !res.HasError() || res.Errors[0].Code != ErrNodeSecretInFlag {
+		t.Fatalf("expected ErrNodeSecretInFlag: %v", *res)
+	}
+
+	res = &ValidationResult{}
+	storePlainCredential(res, models.StoreInput{Name: "s", Type: "s3", Params: []byte(`{"AccessKey": "${secret:s3-access}", "SecretKey": "hunter2"}`)})
+	if len(res.Warnings) != 1 || res.Warnings[0].Code != WarnPlainCredential {
+		t.Fatalf("expected one WarnPlainCredential: %v", *res)
+	}
+}
+
+func TestValidation_TypedFlags(t *testing.T) {
+	lo, hi := 1.0, 10.0
+	defs := []models.NodeDef{{Publisher: "acme", Name: "encode", Flags: []models.NodeFlagDef{
+		{Name: "threads", Type: models.FlagInt, Min: &lo, Max: &hi, Default: "2"},
+		{Name: "scale", Type: models.FlagFloat},
+		{Name: "fast", Type: models.FlagBool},
+		{Name: "preset", Type: models.FlagEnum, Values: []string{"slow", "fast"}},
+		{Name: "codec", Type: models.FlagRegex, Pattern: `[a-z0-9]+`},
+		{Name: "quality", Type: models.FlagRange, Min: &lo, Max: &hi},
+	}}}
+
+	tests := []struct {
+		name  string
+		flag  string
+		value string
+		valid bool
+	}{
+		{"int", "threads", "4", true},
+		{"int out of bounds", "threads", "11", false},
+		{"not an int", "threads", "4; rm -rf /", false},
+		{"float", "scale", "0.5", true},
+		{"not a float", "scale", "NaN", false},
+		{"bool", "fast", "true", true},
+		{"not a bool", "fast", "yes", false},
+		{"enum", "preset", "slow", true},
+		{"not in enum", "preset", "medium", false},
+		{"regex", "codec", "h264", true},
+		{"partial regex match", "codec", "h264 --output=/etc/passwd", false},
+		{"range", "quality", "7.5", true},
+		{"out of range", "quality", "0", false},
+		{"result ref", "threads", "${results.probe.cores}", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			node := models.Node{ID: "n", Uses: "acme/encode", Flags: []models.NodeFlag{{Name: tt.flag, Value: tt.value}}}
+
+			res := &ValidationResult{}
+			nodeInvalidFlag(res, node, defs)
+
+			if tt.valid && res.HasError() {
+				t.Fatalf("expected no errors: %v", *res)
+			}
+			if !tt.valid && (!res.HasError() || res.Errors[0].Code != ErrNodeInvalidFlag) {
+				t.Fatalf("expected ErrNodeInvalidFlag: %v", *res)
+			}
+		})
+	}
+
+	bad := []models.NodeDef{{Publisher: "acme", Name: "bad", Flags: []models.NodeFlagDef{
+		{Name: "a", Type: "false"},
+		{Name: "b", Type: models.FlagEnum},
+		{Name: "c", Type: models.FlagRange, Min: &lo},
+		{Name: "d", Type: models.FlagInt, Default: "two"},
+	}}}
+
+	res := &ValidationResult{}
+	nodeInvalidFlag(res, models.Node{ID: "n", Uses: "acme/bad"}, bad)
+	if len(res.Errors) != 4 {
+		t.Fatalf("expected 4 errors: %v", *res)
+	}
+}
+
+func TestValidation_CommandRefs(t *testing.T) {
+	flags := []models.NodeFlagDef{
+		{Name: "Title", Required: true},
+		{Name: "Preset", Default: "fast"},
+		{Name: "Scale"},
+	}
+
+	tests := []struct {
+		name  string
+		args  []string
+		valid bool
+	}{
+		{"known refs", []string{"encode", "--title", "${Title}", "--preset", "${Preset}", "--scale=${Scale}", "${In}"}, true},
+		{"literal dollars", []string{"sh", "-c", "echo $1 $$ $HOME $${Title}"}, true},
+		{"unknown ref", []string{"encode", "${Tilte}"}, false},
+		{"results without results", []string{"encode", "${PUPLOAD_RESULTS}"}, false},
+		{"optional flag after its option", []string{"encode", "--scale", "${Scale}"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defs := []models.NodeDef{{
+				Publisher: "acme",
+				Name:      "encode",
+				Flags:     flags,
+				Inputs:    []models.NodeEdgeDef{{Name: "In"}},
+				Command:   models.NodeCommandDef{Args: tt.args},
+			}}
+
+			res := &ValidationResult{}
+			nodeInvalidCommand(res, models.Node{ID: "n", Uses: "acme/encode"}, defs)
+
+			if tt.valid && res.HasError() {
+				t.Fatalf("expected no errors: %v", *res)
+			}
+			if !tt.valid && (!res.HasError() || res.Errors[0].Code != ErrNodeInvalidCommand) {
+				t.Fatalf("expected ErrNodeInvalidCommand: %v", *res)
+			}
+		})
+	}
+}
+
+func TestValidation_PullPolicy(t *testing.T) {
+	defs := []models.NodeDef{
+		{Publisher: "acme", Name: "pinned", Image: "registry.example.com:5000/acme/pinned:v2", PullPolicy: models.PullNever},
+		{Publisher: "acme", Name: "bad", Image: "acme/bad", PullPolicy: "Sometimes"},
+	}
+
+	res := &ValidationResult{}
+	nodeInvalidPullPolicy(res, models.Node{ID: "a", Uses: "acme/pinned"}, defs)
+	nodeInvalidPullPolicy(res, models.Node{ID: "b", Uses: "acme/bad"}, defs)
+	if len(res.Errors) != 1 || res.Errors[0].Code != ErrNodePullPolicy {
+		t.Fatalf("expected one ErrNodePullPolicy: %v", *res)
+	}
+
+	defaults := map[string]string{
+		"ubuntu":                              models.PullAlways,
+		"ubuntu:latest":                       models.PullAlways,
+		"ubuntu:24.04":                        models.PullIfNotPresent,
+		"registry.example.com:5000/acme/node": models.PullAlways,
+		"ghcr.io/acme/node@sha256:" + strings.Repeat("a", 64): models.PullIfNotPresent,
+	}
+
+	for image, want := range defaults {
+		if got := (models.NodeDef{Image: image}).PullPolicyName(); got != want {
+			t.Fatalf("%s: got %s, expected %s", image, got, want)
+		}
+	}
+}
+
+func TestValidation_StoreParams(t *testing.T) {
+	tests := []struct {
+		name  string
+		store models.StoreInput
+		code  string
+	}{
+		{"s3 without params", models.StoreInput{Name: "s", Type: models.StoreS3}, ""},
+		{"s3 unknown param", models.StoreInput{Name: "s", Type: models.StoreS3, Params: []byte(`{"Bucket": "b"}`)}, ErrStoreInvalidParams},
+		{"memory", models.StoreInput{Name: "s", Type: models.StoreMemory, Params: []byte(`{"BucketName": "uploads"}`)}, ""},
+		{"memory without bucket", models.StoreInput{Name: "s", Type: models.StoreMemory, Params: []byte(`{}`)}, ErrStoreInvalidParams},
+		{"filesystem", models.StoreInput{Name: "s", Type: models.StoreFilesystem, Params: []byte(`{"BucketName": "uploads", "MountPath": "./data"}`)}, ""},
+		{"filesystem without path", models.StoreInput{Name: "s", Type: models.StoreFilesystem, Params: []byte(`{"BucketName": "uploads"}`)}, ErrStoreInvalidParams},
+		{"invalid bucket", models.StoreInput{Name: "s", Type: models.StoreMemory, Params: []byte(`{"BucketName": "My Uploads"}`)}, ErrStoreInvalidParams},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := &ValidationResult{}
+			storeInvalidType(res, tt.store)
+			storeInvalidParams(res, tt.store)
+
+			if tt.code == "" && res.HasError() {
+				t.Fatalf("expected no errors: %v", *res)
+			}
+			if tt.code != "" && (!res.HasError() || res.Errors[0].Code != tt.code) {
+				t.Fatalf("expected %s: %v", tt.code, *res)
+			}
+		})
+	}
+}
@@ -1,7 +1,9 @@
 package config
 
 import (
+	"github.com/pupload/pupload/internal/imagepolicy"
 	"github.com/pupload/pupload/internal/resources"
+	"github.com/pupload/pupload/internal/secrets"
 	"github.com/pupload/pupload/internal/syncplane"
 	"github.com/pupload/pupload/internal/telemetry"
 )
@@ -15,25 +17,102 @@ type WorkerConfig struct {
 
 	Logging  LoggingSettings
 	Security SecuritySettings
+	Secrets  secrets.Settings // where node secrets are looked up
 }
 
 type WorkerSettings struct {
-	ID string
+	ID     string            // unique per worker, defaults to the hostname plus a random suffix
+	Labels map[string]string `json:"labels"` // matched against node selectors, eg. region: eu-west-1
 }
 
 type RuntimeSettings struct {
-	ContainerEngine  string `json:"container_engine"`  // docker, podman, runsc, auto
+	Executors []string `json:"executors"` // container, process, wasi; the executors NodeDefs may pick
+
+	ContainerEngine  string `json:"container_engine"`  // docker, podman, auto
 	ContainerRuntime string `json:"container_runtime"` // runc, gvisor, auto
 
 	EnableGPUSupport bool `json:"enable_gpu_support"`
 
+	// IOMode is how node files reach containers. volume downloads them to a
+	// per-task host directory bind-mounted into the container, which needs the
+	// engine on this host. copy streams them in and out with the engine's tar
+	// copy. auto picks volume for a local engine and copy otherwise.
+	IOMode  string `json:"io_mode"`  // auto, volume, copy
+	WorkDir string `json:"work_dir"` // per-task directories are created here, defaults to the system temp dir
+
 	Gvisor GvisorSettings `json:"gvisor"`
+
+	Registries RegistrySettings   `json:"registries"`
+	Images     ImageCacheSettings `json:"images"`
+
+	Process ProcessSettings `json:"process"`
+	WASI    WASISettings    `json:"wasi"`
+}
+
+// RegistrySettings holds the credentials node images are pulled with. Auths
+// take precedence over the docker config file.
+type RegistrySettings struct {
+	DockerConfig string         `json:"docker_config"` // path to a docker config.json; its auths are used, credential helpers are not
+	Auths        []RegistryAuth `json:"auths"`
+}
+
+// ImageCacheSettings keeps node images warm on the worker. Pulling a large
+// image inside a task counts against its timeout, so images can be pulled
+// ahead of time, and unused ones removed when the engine's disk fills up.
+type ImageCacheSettings struct {
+	Prewarm      []string `json:"prewarm"`       // pulled at startup and on every refresh, never collected
+	PrewarmTiers bool     `json:"prewarm_tiers"` // also pull the images recently routed to the worker's tiers
+	Refresh      string   `json:"refresh"`       // how often images are pulled again, eg. 1h, defaults to 30m
+
+	// Once the engine's filesystem is GCHighPercent full, the least recently
+	// used images no container uses are removed until it is under
+	// GCLowPercent. 0 disables collection.
+	GCHighPercent int `json:"gc_high_percent"`
+	GCLowPercent  int `json:"gc_low_percent"` // defaults to 10 under GCHighPercent
+
+	// WarmWait is how long after dispatch this worker hands a node back when
+	// its image isn't cached here but another worker has it. 0s to never.
+	WarmWait string `json:"warm_wait"` // defaults to 30s
+}
+
+// RegistryAuth logs in to one registry with a username and password, or
+// sends a token instead.
+type RegistryAuth struct {
+	Registry string `json:"registry"` // host, eg. ghcr.io or docker.io
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Token    string `json:"token"` // registry bearer token
 }
 
 type GvisorSettings struct {
 	Platform string `json:"platform"` // systrap, kvm, ptrace
 }
 
+// ProcessSettings configures the process executor, which runs a node's
+// command directly on the host. Only allow it on workers that trust the
+// NodeDefs they run.
+type ProcessSettings struct {
+	WorkDir string `json:"work_dir"` // per-task directories are created here, defaults to the system temp dir
+
+	// CgroupParent is a cgroup v2 directory delegated to the worker. Each task
+	// gets a child cgroup limited to its reservation. Empty to rely on rlimits.
+	CgroupParent string `json:"cgroup_parent"`
+
+	Rlimits map[string]int64 `json:"rlimits"` // soft and hard limit, eg. nofile, nproc, fsize
+	Env     []string         `json:"env"`     // worker environment variables passed through, PATH always is
+}
+
+// WASISettings configures the wasi executor, which runs a NodeDef's
+// WebAssembly module in-process with no network and only the node's files.
+type WASISettings struct {
+	ModuleDir string `json:"module_dir"` // module paths in NodeDefs are resolved here, empty to only allow store modules
+	WorkDir   string `json:"work_dir"`   // per-task directories are created here, defaults to the system temp dir
+
+	// TimeoutPerCPU is how long a module may run per core of its tier, eg.
+	// 30s. It is wall-clock time; wazero can't meter instructions.
+	TimeoutPerCPU string `json:"timeout_per_cpu"`
+}
+
 type LoggingSettings struct {
 	LogLevel string `json:"log_level"` // debug, info, warn, error
 
@@ -43,12 +122,41 @@ type SecuritySettings struct {
 	AllowedRegistries []string `json:"allowed_registries"` // all if empty
 	ImageAllowlist    []string `json:"image_allowlist"`    //
 	ImageBlocklist    []string `json:"image_blocklist"`
+
+	Sandbox SandboxSettings `json:"sandbox"`
+}
+
+// SandboxSettings restricts every node container. NodeDefs can only opt into
+// networking; everything else is set per worker.
+type SandboxSettings struct {
+	User            string   `json:"user"` // uid:gid, empty keeps the image's user
+	CapDrop         []string `json:"cap_drop"`
+	CapAdd          []string `json:"cap_add"`
+	NoNewPrivileges bool     `json:"no_new_privileges"`
+	ReadOnlyRootfs  bool     `json:"read_only_rootfs"`
+
+	PidsLimit int64            `json:"pids_limit"` // 0 for unlimited
+	Ulimits   map[string]int64 `json:"ulimits"`    // soft and hard limit, eg. nofile
+
+	SeccompProfile  string `json:"seccomp_profile"`  // path to a profile, "unconfined", or empty for the engine default
+	AppArmorProfile string `json:"apparmor_profile"` // name of a loaded profile, empty for the engine default
+
+	NetworkMode string `json:"network_mode"` // used for nodes that need the network, empty for the engine default
+}
+
+// ImagePolicy returns the image restrictions enforced before pulling.
+func (s SecuritySettings) ImagePolicy() imagepolicy.Settings {
+	return imagepolicy.Settings{
+		AllowedRegistries: s.AllowedRegistries,
+		ImageAllowlist:    s.ImageAllowlist,
+		ImageBlocklist:    s.ImageBlocklist,
+	}
 }
 
 func DefaultConfig() *WorkerConfig {
 	return &WorkerConfig{
 		Worker: WorkerSettings{
-			ID: "0",
+			Labels: map[string]string{},
 		},
 
 		SyncPlane: syncplane.SyncPlaneSettings{
@@ -70,9 +178,23 @@ func DefaultConfig() *WorkerConfig {
 		},
 
 		Runtime: RuntimeSettings{
+			Executors: []string{"container"},
+
 			ContainerEngine:  "auto",
 			ContainerRuntime: "auto",
 			EnableGPUSupport: false,
+			IOMode:           "auto",
+
+			Process: ProcessSettings{
+				Rlimits: map[string]int64{
+					"nofile": 4096,
+					"core":   0,
+				},
+			},
+
+			WASI: WASISettings{
+				TimeoutPerCPU: "1m",
+			},
 		},
 
 		Logging: LoggingSettings{
@@ -93,6 +215,17 @@ func DefaultConfig() *WorkerConfig {
 
 			ImageAllowlist: []string{},
 			ImageBlocklist: []string{},
+
+			Sandbox: SandboxSettings{
+				User:            "65534:65534",
+				CapDrop:         []string{"ALL"},
+				NoNewPrivileges: true,
+				ReadOnlyRootfs:  true,
+				PidsLimit:       512,
+				Ulimits: map[string]int64{
+					"nofile": 4096,
+				},
+			},
 		},
 	}
 }
@@ -0,0 +1,241 @@
+package container
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/pupload/pupload/internal/worker/config"
+
+	"github.com/moby/moby/api/types/system"
+	"github.com/moby/moby/client"
+)
+
+const (
+	EngineDocker = "docker"
+	EnginePodman = "podman"
+
+	RuntimeRunc   = "runc"
+	RuntimeGvisor = "gvisor"
+
+	runscRuntime = "runsc"
+
+	IOModeVolume = "volume"
+	IOModeCopy   = "copy"
+)
+
+var gvisorPlatforms = []string{"systrap", "kvm", "ptrace"}
+
+// connectEngine returns a client for the requested engine. "auto" tries
+// Docker first and falls back to the Podman socket.
+func connectEngine(ctx context.Context, engine string) (*client.Client, string, error) {
+	switch engine {
+	case EngineDocker:
+		c, err := connectDocker(ctx)
+		if err != nil {
+			return nil, "", fmt.Errorf("container engine docker is not available: %w", err)
+		}
+		return c, EngineDocker, nil
+
+	case EnginePodman:
+		c, err := connectPodman(ctx)
+		if err != nil {
+			return nil, "", fmt.Errorf("container engine podman is not available: %w", err)
+		}
+		return c, EnginePodman, nil
+
+	case "", "auto":
+		c, dockerErr := connectDocker(ctx)
+		if dockerErr == nil {
+			return c, EngineDocker, nil
+		}
+
+		c, podmanErr := connectPodman(ctx)
+		if podmanErr == nil {
+			return c, EnginePodman, nil
+		}
+
+		return nil, "", fmt.Errorf("no container engine found: docker: %v; podman: %v", dockerErr, podmanErr)
+
+	default:
+		return nil, "", fmt.Errorf("unknown container engine %q, expected docker, podman or auto", engine)
+	}
+}
+
+// selectIOMode resolves the configured IO mode. Bind mounts name a path on
+// the engine's host, so "auto" only picks volume when the engine is reached
+// over a local socket.
+func selectIOMode(mode, host string) (string, error) {
+	switch mode {
+	case IOModeVolume, IOModeCopy:
+		return mode, nil
+
+	case "", "auto":
+		if strings.HasPrefix(host, "unix://") || strings.HasPrefix(host, "npipe://") {
+			return IOModeVolume, nil
+		}
+		return IOModeCopy, nil
+
+	default:
+		return "", fmt.Errorf("unknown io mode %q, expected volume, copy or auto", mode)
+	}
+}
+
+func connectDocker(ctx context.Context) (*client.Client, error) {
+	return connect(ctx, client.FromEnv)
+}
+
+func connectPodman(ctx context.Context) (*client.Client, error) {
+	hosts := podmanHosts()
+
+	var lastErr error
+	for _, host := range hosts {
+		c, err := connect(ctx, client.WithHost(host))
+		if err == nil {
+			return c, nil
+		}
+		lastErr = err
+	}
+
+	if lastErr == nil {
+		return nil, fmt.Errorf("no podman socket found (set CONTAINER_HOST or enable podman.socket)")
+	}
+
+	return nil, lastErr
+}
+
+// podmanHosts lists CONTAINER_HOST followed by the rootless and rootful
+// sockets that exist on this machine.
+func podmanHosts() []string {
+	hosts := make([]string, 0, 3)
+	if h := os.Getenv("CONTAINER_HOST"); h != "" {
+		hosts = append(hosts, h)
+	}
+
+	runtimeDir := os.Getenv("XDG_RUNTIME_DIR")
+	if runtimeDir == "" {
+		runtimeDir = fmt.Sprintf("/run/user/%d", os.Getuid())
+	}
+
+	for _, sock := range []string{
+		filepath.Join(runtimeDir, "podman", "podman.sock"),
+		"/run/podman/podman.sock",
+	} {
+		if _, err := os.Stat(sock); err == nil {
+			hosts = append(hosts, "unix://"+sock)
+		}
+	}
+
+	return hosts
+}
+
+func connect(ctx context.Context, opt client.Opt) (*client.Client, error) {
+	c, err := client.New(opt, client.WithAPIVersionNegotiation())
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	if _, err := c.Ping(ctx, client.PingOptions{NegotiateAPIVersion: true}); err != nil {
+		c.Close()
+		return nil, fmt.Errorf("%s: %w", c.DaemonHost(), err)
+	}
+
+	return c, nil
+}
+
+// selectRuntime picks the OCI runtime passed to the engine for every
+// container. "auto" prefers gVisor when it is installed, unless GPU support is
+// enabled, and otherwise uses runc or the engine's default.
+func selectRuntime(info system.Info, cfg config.RuntimeSettings) (string, error) {
+	switch cfg.ContainerRuntime {
+	case RuntimeRunc:
+		if _, ok := info.Runtimes[RuntimeRunc]; !ok {
+			return "", fmt.Errorf("container runtime runc is not installed, available runtimes: %s", runtimeNames(info))
+		}
+		return RuntimeRunc, nil
+
+	case RuntimeGvisor:
+		return gvisorRuntime(info, cfg.Gvisor.Platform)
+
+	case "", "auto":
+		if !cfg.EnableGPUSupport {
+			if rt, err := gvisorRuntime(info, cfg.Gvisor.Platform); err == nil {
+				return rt, nil
+			}
+		}
+
+		if _, ok := info.Runtimes[RuntimeRunc]; ok {
+			return RuntimeRunc, nil
+		}
+
+		if info.DefaultRuntime == "" {
+			return "", fmt.Errorf("no OCI runtime reported by the container engine")
+		}
+		return info.DefaultRuntime, nil
+
+	default:
+		return "", fmt.Errorf("unknown container runtime %q, expected runc, gvisor or auto", cfg.ContainerRuntime)
+	}
+}
+
+// gvisorRuntime finds the runsc runtime for platform. The platform can't be
+// set per container, so it has to be baked into the engine's runtime config:
+// either as a "runsc-<platform>" runtime or runsc registered with
+// --platform=<platform>.
+func gvisorRuntime(info system.Info, platform string) (string, error) {
+	rt, ok := info.Runtimes[runscRuntime]
+
+	if platform == "" {
+		if !ok {
+			return "", fmt.Errorf("container runtime gvisor requested but runsc is not installed, available runtimes: %s", runtimeNames(info))
+		}
+		return runscRuntime, nil
+	}
+
+	if !slices.Contains(gvisorPlatforms, platform) {
+		return "", fmt.Errorf("unknown gvisor platform %q, expected one of %s", platform, strings.Join(gvisorPlatforms, ", "))
+	}
+
+	named := runscRuntime + "-" + platform
+	if _, ok := info.Runtimes[named]; ok {
+		return named, nil
+	}
+
+	if ok {
+		configured := ""
+		for _, arg := range rt.Args {
+			if p, found := strings.CutPrefix(arg, "--platform="); found {
+				configured = p
+			}
+		}
+
+		// systrap is runsc's default platform
+		if configured == platform || (configured == "" && platform == "systrap") {
+			return runscRuntime, nil
+		}
+	}
+
+	return "", fmt.Errorf("gvisor platform %s requested but neither a %s runtime nor runsc with --platform=%s is registered, available runtimes: %s",
+		platform, named, platform, runtimeNames(info))
+}
+
+func runtimeNames(info system.Info) string {
+	names := make([]string, 0, len(info.Runtimes))
+	for name := range info.Runtimes {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	if len(names) == 0 {
+		return "none"
+	}
+
+	return strings.Join(names, ", ")
+}
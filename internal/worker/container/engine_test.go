@@ -0,0 +1,139 @@
+package container
+
+import (
+	"testing"
+
+	"github.com/pupload/pupload/internal/worker/config"
+
+	"github.com/moby/moby/api/types/system"
+)
+
+func runtimes(names ...string) map[string]system.RuntimeWithStatus {
+	m := make(map[string]system.RuntimeWithStatus, len(names))
+	for _, n := range names {
+		m[n] = system.RuntimeWithStatus{}
+	}
+	return m
+}
+
+func TestSelectRuntime(t *testing.T) {
+	runscKVM := runtimes("runc", "runsc")
+	runscKVM["runsc"] = system.RuntimeWithStatus{Runtime: system.Runtime{Args: []string{"--platform=kvm"}}}
+
+	tests := []struct {
+		name    string
+		info    system.Info
+		cfg     config.RuntimeSettings
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "runc",
+			info: system.Info{Runtimes: runtimes("runc", "runsc")},
+			cfg:  config.RuntimeSettings{ContainerRuntime: "runc"},
+			want: "runc",
+		},
+		{
+			name:    "runc missing",
+			info:    system.Info{Runtimes: runtimes("crun"), DefaultRuntime: "crun"},
+			cfg:     config.RuntimeSettings{ContainerRuntime: "runc"},
+			wantErr: true,
+		},
+		{
+			name: "auto prefers gvisor",
+			info: system.Info{Runtimes: runtimes("runc", "runsc")},
+			cfg:  config.RuntimeSettings{ContainerRuntime: "auto"},
+			want: "runsc",
+		},
+		{
+			name: "auto skips gvisor with gpus",
+			info: system.Info{Runtimes: runtimes("runc", "runsc")},
+			cfg:  config.RuntimeSettings{ContainerRuntime: "auto", EnableGPUSupport: true},
+			want: "runc",
+		},
+		{
+			name: "auto falls back to engine default",
+			info: system.Info{Runtimes: runtimes("crun"), DefaultRuntime: "crun"},
+			cfg:  config.RuntimeSettings{ContainerRuntime: "auto"},
+			want: "crun",
+		},
+		{
+			name:    "gvisor missing",
+			info:    system.Info{Runtimes: runtimes("runc")},
+			cfg:     config.RuntimeSettings{ContainerRuntime: "gvisor"},
+			wantErr: true,
+		},
+		{
+			name: "gvisor platform runtime",
+			info: system.Info{Runtimes: runtimes("runc", "runsc", "runsc-kvm")},
+			cfg:  config.RuntimeSettings{ContainerRuntime: "gvisor", Gvisor: config.GvisorSettings{Platform: "kvm"}},
+			want: "runsc-kvm",
+		},
+		{
+			name: "gvisor platform from runtime args",
+			info: system.Info{Runtimes: runscKVM},
+			cfg:  config.RuntimeSettings{ContainerRuntime: "gvisor", Gvisor: config.GvisorSettings{Platform: "kvm"}},
+			want: "runsc",
+		},
+		{
+			name:    "gvisor platform not configured",
+			info:    system.Info{Runtimes: runtimes("runc", "runsc")},
+			cfg:     config.RuntimeSettings{ContainerRuntime: "gvisor", Gvisor: config.GvisorSettings{Platform: "kvm"}},
+			wantErr: true,
+		},
+		{
+			name:    "unknown runtime",
+			info:    system.Info{Runtimes: runtimes("runc")},
+			cfg:     config.RuntimeSettings{ContainerRuntime: "kata"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := selectRuntime(tt.info, tt.cfg)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got runtime %q", got)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("selectRuntime() error = %v", err)
+			}
+			if got != tt.want {
+				t.Fatalf("expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestSelectIOMode(t *testing.T) {
+	tests := []struct {
+		mode, host string
+		want       string
+		wantErr    bool
+	}{
+		{mode: "auto", host: "unix:///var/run/docker.sock", want: IOModeVolume},
+		{mode: "auto", host: "tcp://10.0.0.5:2376", want: IOModeCopy},
+		{mode: "", host: "npipe:////./pipe/docker_engine", want: IOModeVolume},
+		{mode: "copy", host: "unix:///var/run/docker.sock", want: IOModeCopy},
+		{mode: "volume", host: "tcp://10.0.0.5:2376", want: IOModeVolume},
+		{mode: "nfs", host: "unix:///var/run/docker.sock", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := selectIOMode(tt.mode, tt.host)
+		if tt.wantErr {
+			if err == nil {
+				t.Fatalf("selectIOMode(%q, %q): expected error, got %q", tt.mode, tt.host, got)
+			}
+			continue
+		}
+
+		if err != nil || got != tt.want {
+			t.Fatalf("selectIOMode(%q, %q) = %q, %v, expected %q", tt.mode, tt.host, got, err, tt.want)
+		}
+	}
+}
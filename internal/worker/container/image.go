@@ -2,7 +2,14 @@ package container
 
 import (
 	"context"
+	"fmt"
+	"time"
 
+	"github.com/pupload/pupload/internal/imagepolicy"
+	"github.com/pupload/pupload/internal/logging"
+	"github.com/pupload/pupload/internal/models"
+
+	"github.com/distribution/reference"
 	"github.com/moby/moby/client"
 )
 
@@ -13,10 +20,22 @@ type IImageManager interface {
 
 type ImageManager struct {
 	client *client.Client
+	auth   *registryAuth
+}
+
+// Image is a local image resolved from a reference.
+type Image struct {
+	ID     string // content-addressable ID, what the container is created from
+	Digest string // repo@sha256:... the image was pulled by, or ID for images never pulled
 }
 
 func (im *ImageManager) Pull(ctx context.Context, refStr string) error {
-	res, err := im.client.ImagePull(context.Background(), refStr, client.ImagePullOptions{})
+	auth, err := im.auth.header(refStr)
+	if err != nil {
+		return fmt.Errorf("Pull: %w", err)
+	}
+
+	res, err := im.client.ImagePull(ctx, refStr, client.ImagePullOptions{RegistryAuth: auth})
 	if err != nil {
 		return err
 	}
@@ -42,3 +61,103 @@ func (im *ImageManager) Validate(ctx context.Context, refStr string) (bool, erro
 
 	return len(res.Items) > 0, nil
 }
+
+// Ensure makes the image available as the pull policy says and resolves it,
+// so the node runs the exact image that is recorded even if the tag moves.
+func (im *ImageManager) Ensure(ctx context.Context, refStr, policy string) (Image, error) {
+	present, err := im.Validate(ctx, refStr)
+	if err != nil {
+		return Image{}, err
+	}
+
+	switch {
+	case policy == models.PullAlways, policy == models.PullIfNotPresent && !present:
+		logging.LoggerFromCtx(ctx).Info("pulling image", "image", refStr, "pull_policy", policy)
+		if err := im.Pull(ctx, refStr); err != nil {
+			return Image{}, fmt.Errorf("pulling %s: %w", refStr, err)
+		}
+
+	case policy == models.PullNever && !present:
+		return Image{}, fmt.Errorf("image %s is not on this worker and its pull policy is Never", refStr)
+	}
+
+	return im.Inspect(ctx, refStr)
+}
+
+// Inspect resolves a local image.
+func (im *ImageManager) Inspect(ctx context.Context, refStr string) (Image, error) {
+	res, err := im.client.ImageInspect(ctx, refStr)
+	if err != nil {
+		return Image{}, err
+	}
+
+	img := Image{ID: res.ID, Digest: res.ID}
+
+	named, err := reference.ParseNormalizedNamed(refStr)
+	if err != nil {
+		return img, nil
+	}
+
+	for _, d := range res.RepoDigests {
+		repo, err := reference.ParseNormalizedNamed(d)
+		if err == nil && repo.Name() == named.Name() {
+			img.Digest = repo.String()
+			break
+		}
+	}
+
+	return img, nil
+}
+
+// LocalImage is an image in the engine's store.
+type LocalImage struct {
+	ID      string
+	Refs    []string // normalized tags and digests
+	Size    int64
+	Created time.Time
+}
+
+// List returns the images in the engine's store.
+func (im *ImageManager) List(ctx context.Context) ([]LocalImage, error) {
+	res, err := im.client.ImageList(ctx, client.ImageListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	images := make([]LocalImage, 0, len(res.Items))
+	for _, item := range res.Items {
+		img := LocalImage{ID: item.ID, Size: item.Size, Created: time.Unix(item.Created, 0)}
+
+		for _, ref := range append(item.RepoTags, item.RepoDigests...) {
+			if ref != "<none>:<none>" && ref != "<none>@<none>" {
+				img.Refs = append(img.Refs, imagepolicy.Normalize(ref))
+			}
+		}
+
+		images = append(images, img)
+	}
+
+	return images, nil
+}
+
+// InUse returns the IDs of the images any container, running or not, was
+// created from.
+func (im *ImageManager) InUse(ctx context.Context) (map[string]bool, error) {
+	res, err := im.client.ContainerList(ctx, client.ContainerListOptions{All: true})
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make(map[string]bool, len(res.Items))
+	for _, c := range res.Items {
+		ids[c.ImageID] = true
+	}
+
+	return ids, nil
+}
+
+// Remove deletes an image and all its tags. Images a container uses are kept.
+func (im *ImageManager) Remove(ctx context.Context, id string) error {
+	_, err := im.client.ImageRemove(ctx, id, client.ImageRemoveOptions{PruneChildren: true})
+	return err
+}
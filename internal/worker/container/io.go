@@ -5,138 +5,113 @@ import (
 	"context"
 	"fmt"
 	"io"
-	"net/http"
-	"time"
+	"os"
 
 	"github.com/moby/moby/client"
 )
 
 type IContainerIO interface {
-	CopyTo()
-	CopyFrom()
-	DownloadIntoContainer()
-	UploadFromContainer()
+	CopyFileInto()
+	CopyFileOut()
 }
 
+// ContainerIO copies files in and out of containers with the engine's tar
+// copy, for engines that can't bind-mount the task directory. Files are staged
+// on the host so their size is known up front and transfers can resume.
 type ContainerIO struct {
 	client *client.Client
 }
 
-func (c *ContainerIO) DownloadIntoContainer(ctx context.Context, containerID, url, path, filename string) error {
-
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+// CopyFileInto streams the host file src into the container as dir/filename.
+func (c *ContainerIO) CopyFileInto(ctx context.Context, containerID, src, dir, filename string) error {
+	f, err := os.Open(src)
 	if err != nil {
-		return fmt.Errorf("DownloadIntoContainer: %w", err)
+		return fmt.Errorf("CopyFileInto: %w", err)
 	}
+	defer f.Close()
 
-	resp, err := http.DefaultClient.Do(req)
+	info, err := f.Stat()
 	if err != nil {
-		return fmt.Errorf("DownloadIntoContainer: %w", err)
-	}
-
-	if resp.ContentLength < 0 {
-		return fmt.Errorf("DownloadIntoContainer: content length is less than 0")
-	}
-
-	if resp.StatusCode < 200 || resp.StatusCode > 299 {
-		b, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
-		return fmt.Errorf("DownloadIntoContainer: GET %s returned %d: %s", url, resp.StatusCode, string(b))
+		return fmt.Errorf("CopyFileInto: %w", err)
 	}
 
-	size := resp.ContentLength
-
 	pr, pw := io.Pipe()
-	tarDone := make(chan error, 1)
 	go func() {
-		defer resp.Body.Close()
-		defer pw.Close()
 		tw := tar.NewWriter(pw)
-		defer tw.Close()
 
 		hdr := &tar.Header{
 			Name: filename,
-			Mode: 0600,
-			Size: size,
+			Mode: 0o644,
+			Size: info.Size(),
 		}
 
 		if err := tw.WriteHeader(hdr); err != nil {
-			tarDone <- err
 			pw.CloseWithError(err)
 			return
 		}
 
-		if _, err := io.Copy(tw, resp.Body); err != nil {
-			tarDone <- err
+		if _, err := io.Copy(tw, f); err != nil {
 			pw.CloseWithError(err)
 			return
 		}
+
+		pw.CloseWithError(tw.Close())
 	}()
 
-	_, copyErr := c.client.CopyToContainer(ctx, containerID, client.CopyToContainerOptions{
+	_, err = c.client.CopyToContainer(ctx, containerID, client.CopyToContainerOptions{
 		Content:         pr,
-		DestinationPath: path,
+		DestinationPath: dir,
+		CopyUIDGID:      true, // owned by the container's user so a non-root sandbox can read it
 	})
+	pr.Close()
 
-	if copyErr != nil {
-		return copyErr
+	if err != nil {
+		return fmt.Errorf("CopyFileInto: %w", err)
 	}
 
 	return nil
 }
 
-func (c *ContainerIO) UploadFromContainer(ctx context.Context, containerID, url, path, filename string) error {
+// CopyFileOut streams the container file at path, named filename, to the
+// host file dst.
+func (c *ContainerIO) CopyFileOut(ctx context.Context, containerID, path, filename, dst string) error {
 	res, err := c.client.CopyFromContainer(ctx, containerID, client.CopyFromContainerOptions{
 		SourcePath: path,
 	})
-
 	if err != nil {
-		return err
+		return fmt.Errorf("CopyFileOut: %w", err)
 	}
-
 	defer res.Content.Close()
 
 	tr := tar.NewReader(res.Content)
-	var hdr *tar.Header
-
 	for {
 		h, err := tr.Next()
-
 		if err == io.EOF {
-			return fmt.Errorf("file %s not found in tar", filename)
+			return fmt.Errorf("CopyFileOut: file %s not found in tar", filename)
 		}
 
 		if err != nil {
-			return err
+			return fmt.Errorf("CopyFileOut: %w", err)
 		}
 
 		if h.Typeflag == tar.TypeReg && h.Name == filename {
-			hdr = h
 			break
 		}
 	}
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodPut, url, tr)
+	f, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
 	if err != nil {
-		return err
+		return fmt.Errorf("CopyFileOut: %w", err)
 	}
 
-	req.ContentLength = hdr.Size
-
-	client := http.Client{
-		Timeout: 10 * time.Minute,
+	_, err = io.Copy(f, tr)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
 	}
-	resp, err := client.Do(req)
 
 	if err != nil {
-		return err
-	}
-
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
-		return fmt.Errorf("unexpected status: %s", resp.Status)
+		return fmt.Errorf("CopyFileOut: %w", err)
 	}
 
 	return nil
-
 }
@@ -0,0 +1,106 @@
+package container
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"time"
+
+	"github.com/moby/moby/api/pkg/stdcopy"
+	"github.com/moby/moby/client"
+)
+
+// maxLogLine caps one line of container output; the rest of a longer line is
+// dropped.
+const maxLogLine = 16 << 10
+
+// LogLine is one line a container wrote.
+type LogLine struct {
+	Time   time.Time
+	Stream string // stdout or stderr
+	Text   string
+}
+
+// FollowLogs calls emit with each line the container writes, in order, until
+// it exits or ctx is done. Lines written before the call are included.
+func (c *ContainerRuntime) FollowLogs(ctx context.Context, containerID string, emit func(LogLine)) error {
+	stream, err := c.client.ContainerLogs(ctx, containerID, client.ContainerLogsOptions{
+		ShowStdout: true,
+		ShowStderr: true,
+		Follow:     true,
+		Timestamps: true,
+	})
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+
+	stdout := &lineWriter{stream: "stdout", emit: emit}
+	stderr := &lineWriter{stream: "stderr", emit: emit}
+
+	_, err = stdcopy.StdCopy(stdout, stderr, stream)
+
+	stdout.flush()
+	stderr.flush()
+
+	return err
+}
+
+// lineWriter splits a container's output stream into lines.
+type lineWriter struct {
+	stream string
+	emit   func(LogLine)
+	buf    []byte
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	n := len(p)
+
+	for len(p) > 0 {
+		i := bytes.IndexByte(p, '\n')
+		if i < 0 {
+			w.add(p)
+			break
+		}
+
+		w.add(p[:i])
+		w.flush()
+		p = p[i+1:]
+	}
+
+	return n, nil
+}
+
+func (w *lineWriter) add(p []byte) {
+	// Leaves room for the engine's timestamp prefix.
+	room := maxLogLine + len(time.RFC3339Nano) + 1 - len(w.buf)
+	if room > 0 {
+		w.buf = append(w.buf, p[:min(len(p), room)]...)
+	}
+}
+
+func (w *lineWriter) flush() {
+	if len(w.buf) == 0 {
+		return
+	}
+
+	w.emit(parseLogLine(w.stream, string(w.buf)))
+	w.buf = w.buf[:0]
+}
+
+// parseLogLine splits the timestamp the engine prefixes each line with from
+// its text.
+func parseLogLine(stream, line string) LogLine {
+	line = strings.TrimSuffix(line, "\r")
+
+	ts, text, ok := strings.Cut(line, " ")
+	if t, err := time.Parse(time.RFC3339Nano, ts); ok && err == nil {
+		return LogLine{Time: t, Stream: stream, Text: truncate(text)}
+	}
+
+	return LogLine{Time: time.Now(), Stream: stream, Text: truncate(line)}
+}
+
+func truncate(s string) string {
+	return s[:min(len(s), maxLogLine)]
+}
@@ -0,0 +1,46 @@
+package container
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLineWriter(t *testing.T) {
+	var lines []LogLine
+	w := &lineWriter{stream: "stderr", emit: func(l LogLine) { lines = append(lines, l) }}
+
+	w.Write([]byte("2025-01-02T03:04:05.123456789Z first\r\n2025-01-02T03:04:06Z sec"))
+	w.Write([]byte("ond\nno timestamp\n"))
+	w.Write([]byte("2025-01-02T03:04:07Z " + strings.Repeat("x", 2*maxLogLine) + "\n"))
+	w.Write([]byte("2025-01-02T03:04:08Z unterminated"))
+	w.flush()
+
+	if len(lines) != 5 {
+		t.Fatalf("expected 5 lines, got %d", len(lines))
+	}
+
+	if lines[0].Text != "first" || lines[0].Stream != "stderr" {
+		t.Fatalf("unexpected first line %+v", lines[0])
+	}
+
+	if want := time.Date(2025, 1, 2, 3, 4, 5, 123456789, time.UTC); !lines[0].Time.Equal(want) {
+		t.Fatalf("expected time %s, got %s", want, lines[0].Time)
+	}
+
+	if lines[1].Text != "second" {
+		t.Fatalf("expected a line split across writes to be joined, got %q", lines[1].Text)
+	}
+
+	if lines[2].Text != "no timestamp" {
+		t.Fatalf("expected a line without a timestamp to be kept whole, got %q", lines[2].Text)
+	}
+
+	if len(lines[3].Text) != maxLogLine {
+		t.Fatalf("expected a long line to be cut to %d bytes, got %d", maxLogLine, len(lines[3].Text))
+	}
+
+	if lines[4].Text != "unterminated" {
+		t.Fatalf("expected the last partial line on flush, got %q", lines[4].Text)
+	}
+}
@@ -0,0 +1,109 @@
+package container
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/pupload/pupload/internal/worker/config"
+
+	"github.com/distribution/reference"
+	"github.com/moby/moby/api/types/registry"
+)
+
+// registryAuth holds the credentials for each registry host.
+type registryAuth struct {
+	byHost map[string]registry.AuthConfig
+}
+
+type dockerConfigFile struct {
+	Auths map[string]registry.AuthConfig `json:"auths"`
+}
+
+func newRegistryAuth(cfg config.RegistrySettings) (*registryAuth, error) {
+	a := &registryAuth{byHost: make(map[string]registry.AuthConfig)}
+
+	if cfg.DockerConfig != "" {
+		b, err := os.ReadFile(cfg.DockerConfig)
+		if err != nil {
+			return nil, fmt.Errorf("reading docker config: %w", err)
+		}
+
+		var f dockerConfigFile
+		if err := json.Unmarshal(b, &f); err != nil {
+			return nil, fmt.Errorf("parsing docker config %s: %w", cfg.DockerConfig, err)
+		}
+
+		for host, auth := range f.Auths {
+			// The engine wants the username and password rather than the
+			// combined auth field config.json keeps them in.
+			if auth.Auth != "" && auth.Username == "" {
+				decoded, err := base64.StdEncoding.DecodeString(auth.Auth)
+				if err != nil {
+					return nil, fmt.Errorf("docker config auth for %s: %w", host, err)
+				}
+				auth.Username, auth.Password, _ = strings.Cut(string(decoded), ":")
+				auth.Auth = ""
+			}
+
+			auth.ServerAddress = host
+			a.byHost[registryHost(host)] = auth
+		}
+	}
+
+	for _, r := range cfg.Auths {
+		if r.Registry == "" {
+			return nil, fmt.Errorf("registry auth without a registry")
+		}
+
+		a.byHost[registryHost(r.Registry)] = registry.AuthConfig{
+			Username:      r.Username,
+			Password:      r.Password,
+			RegistryToken: r.Token,
+			ServerAddress: r.Registry,
+		}
+	}
+
+	return a, nil
+}
+
+// header returns the encoded credentials for the registry image is pulled
+// from, or "" when there are none.
+func (a *registryAuth) header(image string) (string, error) {
+	if a == nil || len(a.byHost) == 0 {
+		return "", nil
+	}
+
+	named, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		return "", err
+	}
+
+	auth, ok := a.byHost[registryHost(reference.Domain(named))]
+	if !ok {
+		return "", nil
+	}
+
+	b, err := json.Marshal(auth)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.URLEncoding.EncodeToString(b), nil
+}
+
+// registryHost normalizes the ways a registry is written, like
+// https://index.docker.io/v1/, to the host of its image references.
+func registryHost(s string) string {
+	s = strings.TrimPrefix(strings.TrimPrefix(s, "https://"), "http://")
+	s, _, _ = strings.Cut(s, "/")
+
+	switch s {
+	case "index.docker.io", "registry-1.docker.io":
+		return "docker.io"
+	}
+
+	return s
+}
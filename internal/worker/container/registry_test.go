@@ -0,0 +1,67 @@
+package container
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pupload/pupload/internal/worker/config"
+
+	"github.com/moby/moby/api/types/registry"
+)
+
+func TestRegistryAuth(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	dockerConfig := `{"auths": {
+		"https://index.docker.io/v1/": {"auth": "` + base64.StdEncoding.EncodeToString([]byte("hub:hubpass")) + `"},
+		"registry.example.com:5000": {"auth": "` + base64.StdEncoding.EncodeToString([]byte("old:oldpass")) + `"}
+	}}`
+	if err := os.WriteFile(path, []byte(dockerConfig), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	a, err := newRegistryAuth(config.RegistrySettings{
+		DockerConfig: path,
+		Auths: []config.RegistryAuth{
+			{Registry: "registry.example.com:5000", Username: "ci", Password: "secret"},
+			{Registry: "ghcr.io", Token: "tok"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		image string
+		want  registry.AuthConfig
+	}{
+		{"ubuntu:24.04", registry.AuthConfig{Username: "hub", Password: "hubpass", ServerAddress: "https://index.docker.io/v1/"}},
+		{"registry.example.com:5000/team/node:v1", registry.AuthConfig{Username: "ci", Password: "secret", ServerAddress: "registry.example.com:5000"}},
+		{"ghcr.io/org/node", registry.AuthConfig{RegistryToken: "tok", ServerAddress: "ghcr.io"}},
+		{"quay.io/org/node", registry.AuthConfig{}},
+	}
+
+	for _, tt := range tests {
+		header, err := a.header(tt.image)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.image, err)
+		}
+
+		var got registry.AuthConfig
+		if header != "" {
+			b, err := base64.URLEncoding.DecodeString(header)
+			if err != nil {
+				t.Fatalf("%s: %v", tt.image, err)
+			}
+			if err := json.Unmarshal(b, &got); err != nil {
+				t.Fatalf("%s: %v", tt.image, err)
+			}
+		}
+
+		if got != tt.want {
+			t.Fatalf("%s: got %+v, expected %+v", tt.image, got, tt.want)
+		}
+	}
+}
@@ -1,12 +1,10 @@
 package container
 
 import (
-	"bytes"
 	"context"
-	"strings"
 
-	"github.com/moby/moby/api/pkg/stdcopy"
 	"github.com/moby/moby/api/types/container"
+	"github.com/moby/moby/api/types/mount"
 	"github.com/moby/moby/client"
 )
 
@@ -20,6 +18,7 @@ type IContainerRuntime interface {
 type ContainerRuntime struct {
 	client  *client.Client
 	runtime string
+	sandbox *sandbox
 }
 
 type ContainerConfig struct {
@@ -28,6 +27,11 @@ type ContainerConfig struct {
 	Cmd   []string
 	Env   []string
 
+	NeedsNetwork bool
+
+	// TaskDir is a host directory bind-mounted at WorkDir, set in volume mode.
+	TaskDir string
+
 	HostConfig *container.HostConfig
 }
 
@@ -39,10 +43,21 @@ func (c *ContainerRuntime) CreateContainer(ctx context.Context, cfg ContainerCon
 
 	cfg.HostConfig.Runtime = c.runtime
 
+	if cfg.TaskDir != "" {
+		cfg.HostConfig.Mounts = append(cfg.HostConfig.Mounts, mount.Mount{
+			Type:   mount.TypeBind,
+			Source: cfg.TaskDir,
+			Target: WorkDir,
+		})
+	}
+
+	user := c.sandbox.apply(cfg.HostConfig, cfg.NeedsNetwork)
+
 	res, err := c.client.ContainerCreate(ctx, client.ContainerCreateOptions{
 		Config: &container.Config{
-			Env: cfg.Env,
-			Cmd: cfg.Cmd,
+			Env:  cfg.Env,
+			Cmd:  cfg.Cmd,
+			User: user,
 		},
 
 		HostConfig: cfg.HostConfig,
@@ -80,7 +95,8 @@ func (c *ContainerRuntime) WaitContainer(ctx context.Context, containerID string
 
 func (c *ContainerRuntime) RemoveContainer(ctx context.Context, containerID string) error {
 	_, err := c.client.ContainerRemove(ctx, containerID, client.ContainerRemoveOptions{
-		Force: true,
+		Force:         true,
+		RemoveVolumes: true,
 	})
 
 	return err
@@ -90,24 +106,3 @@ func (c *ContainerRuntime) KillContainer(ctx context.Context, containerID string
 	_, err := c.client.ContainerKill(ctx, containerID, client.ContainerKillOptions{})
 	return err
 }
-
-func (c *ContainerRuntime) GetLogs(ctx context.Context, containerID string) ([]string, error) {
-	stream, err := c.client.ContainerLogs(ctx, containerID, client.ContainerLogsOptions{
-		ShowStdout: true,
-		ShowStderr: true,
-	})
-	if err != nil {
-		return nil, err
-	}
-	defer stream.Close()
-
-	var logBuf bytes.Buffer
-	if _, err := stdcopy.StdCopy(&logBuf, &logBuf, stream); err != nil {
-		return nil, err
-	}
-
-	logString := logBuf.String()
-	logArray := strings.Split(strings.TrimSpace(logString), "\n")
-
-	return logArray, nil
-}
@@ -0,0 +1,122 @@
+package container
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/pupload/pupload/internal/worker/config"
+
+	"github.com/moby/moby/api/types/container"
+	"github.com/moby/moby/api/types/mount"
+)
+
+// WorkDir is where node inputs and outputs live. In volume mode it is the
+// task's host directory bind-mounted in. In copy mode it is backed by an
+// anonymous volume rather than tmpfs so it stays writable under a read-only
+// root, accepts inputs before the container starts and keeps outputs after it
+// exits. The volume is removed together with the container.
+const WorkDir = "/tmp"
+
+type sandbox struct {
+	cfg         config.SandboxSettings
+	securityOpt []string
+}
+
+func newSandbox(cfg config.SandboxSettings) (*sandbox, error) {
+	s := &sandbox{cfg: cfg}
+
+	if cfg.NoNewPrivileges {
+		s.securityOpt = append(s.securityOpt, "no-new-privileges")
+	}
+
+	switch cfg.SeccompProfile {
+	case "":
+	case "unconfined", "builtin":
+		s.securityOpt = append(s.securityOpt, "seccomp="+cfg.SeccompProfile)
+	default:
+		// the engine API takes the profile itself, not a path
+		profile, err := os.ReadFile(cfg.SeccompProfile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read seccomp profile: %w", err)
+		}
+		s.securityOpt = append(s.securityOpt, "seccomp="+string(profile))
+	}
+
+	if cfg.AppArmorProfile != "" {
+		s.securityOpt = append(s.securityOpt, "apparmor="+cfg.AppArmorProfile)
+	}
+
+	return s, nil
+}
+
+// owner returns the host uid and gid a bind-mounted task dir is handed to, -1
+// for one the user leaves unset. Only numeric users can be resolved outside
+// the image; with no user the dir stays with the worker's own.
+func (s *sandbox) owner() (uid, gid int, err error) {
+	if s.cfg.User == "" {
+		return -1, -1, nil
+	}
+
+	u, g, hasGroup := strings.Cut(s.cfg.User, ":")
+
+	uid, err = strconv.Atoi(u)
+	if err != nil {
+		return 0, 0, fmt.Errorf("sandbox user %q must be uid or uid:gid to own bind-mounted task dirs", s.cfg.User)
+	}
+
+	gid = -1
+	if hasGroup {
+		gid, err = strconv.Atoi(g)
+		if err != nil {
+			return 0, 0, fmt.Errorf("sandbox user %q must be uid or uid:gid to own bind-mounted task dirs", s.cfg.User)
+		}
+	}
+
+	return uid, gid, nil
+}
+
+// apply restricts hc and returns the user the container should run as.
+func (s *sandbox) apply(hc *container.HostConfig, needsNetwork bool) string {
+	hc.CapDrop = append(hc.CapDrop, s.cfg.CapDrop...)
+	hc.CapAdd = append(hc.CapAdd, s.cfg.CapAdd...)
+	hc.SecurityOpt = append(hc.SecurityOpt, s.securityOpt...)
+
+	if s.cfg.ReadOnlyRootfs {
+		hc.ReadonlyRootfs = true
+
+		mounted := slices.ContainsFunc(hc.Mounts, func(m mount.Mount) bool { return m.Target == WorkDir })
+		if !mounted {
+			// Not tmpfs: a tmpfs only exists while the container runs, so
+			// CopyToContainer couldn't write inputs before it starts, nor
+			// outputs be copied out after it exits.
+			hc.Mounts = append(hc.Mounts, mount.Mount{Type: mount.TypeVolume, Target: WorkDir})
+		}
+	}
+
+	if s.cfg.PidsLimit > 0 {
+		limit := s.cfg.PidsLimit
+		hc.PidsLimit = &limit
+	}
+
+	names := make([]string, 0, len(s.cfg.Ulimits))
+	for name := range s.cfg.Ulimits {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	for _, name := range names {
+		limit := s.cfg.Ulimits[name]
+		hc.Ulimits = append(hc.Ulimits, &container.Ulimit{Name: name, Soft: limit, Hard: limit})
+	}
+
+	if needsNetwork {
+		hc.NetworkMode = container.NetworkMode(s.cfg.NetworkMode)
+	} else {
+		hc.NetworkMode = "none"
+	}
+
+	return s.cfg.User
+}
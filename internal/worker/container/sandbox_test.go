@@ -0,0 +1,134 @@
+package container
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"syscall"
+	"testing"
+
+	"github.com/pupload/pupload/internal/worker/config"
+
+	"github.com/moby/moby/api/types/container"
+	"github.com/moby/moby/api/types/mount"
+)
+
+func TestSandbox_Apply(t *testing.T) {
+	cfg := config.DefaultConfig().Security.Sandbox
+	cfg.NetworkMode = "bridge"
+	cfg.AppArmorProfile = "pupload-node"
+
+	sb, err := newSandbox(cfg)
+	if err != nil {
+		t.Fatalf("newSandbox() error = %v", err)
+	}
+
+	hc := &container.HostConfig{}
+	user := sb.apply(hc, false)
+
+	if user != "65534:65534" {
+		t.Errorf("expected non-root user, got %q", user)
+	}
+	if !slices.Equal(hc.CapDrop, []string{"ALL"}) {
+		t.Errorf("expected all capabilities dropped, got %v", hc.CapDrop)
+	}
+	if !slices.Contains(hc.SecurityOpt, "no-new-privileges") || !slices.Contains(hc.SecurityOpt, "apparmor=pupload-node") {
+		t.Errorf("unexpected security options %v", hc.SecurityOpt)
+	}
+	if !hc.ReadonlyRootfs || len(hc.Mounts) != 1 || hc.Mounts[0].Target != WorkDir {
+		t.Errorf("expected read-only root with a writable workdir, got %v %v", hc.ReadonlyRootfs, hc.Mounts)
+	}
+	if hc.PidsLimit == nil || *hc.PidsLimit != cfg.PidsLimit {
+		t.Errorf("expected pids limit %d, got %v", cfg.PidsLimit, hc.PidsLimit)
+	}
+	if len(hc.Ulimits) != 1 || hc.Ulimits[0].Name != "nofile" {
+		t.Errorf("unexpected ulimits %v", hc.Ulimits)
+	}
+	if !hc.NetworkMode.IsNone() {
+		t.Errorf("expected no network, got %q", hc.NetworkMode)
+	}
+
+	networked := &container.HostConfig{}
+	sb.apply(networked, true)
+	if networked.NetworkMode != "bridge" {
+		t.Errorf("expected configured network mode for networked node, got %q", networked.NetworkMode)
+	}
+}
+
+func TestSandbox_ApplyKeepsTaskDirMount(t *testing.T) {
+	sb, err := newSandbox(config.DefaultConfig().Security.Sandbox)
+	if err != nil {
+		t.Fatalf("newSandbox() error = %v", err)
+	}
+
+	bind := mount.Mount{Type: mount.TypeBind, Source: "/var/lib/pupload/task", Target: WorkDir}
+	hc := &container.HostConfig{Mounts: []mount.Mount{bind}}
+	sb.apply(hc, false)
+
+	if !hc.ReadonlyRootfs || len(hc.Mounts) != 1 || hc.Mounts[0] != bind {
+		t.Errorf("expected only the task dir mounted at the workdir, got %v", hc.Mounts)
+	}
+}
+
+func TestNewSandbox_MissingSeccompProfile(t *testing.T) {
+	if _, err := newSandbox(config.SandboxSettings{SeccompProfile: "/does/not/exist.json"}); err == nil {
+		t.Fatalf("expected error for missing seccomp profile")
+	}
+}
+
+func TestSandbox_Owner(t *testing.T) {
+	cases := []struct {
+		user     string
+		uid, gid int
+		wantErr  bool
+	}{
+		{"", -1, -1, false},
+		{"65534:65534", 65534, 65534, false},
+		{"1000", 1000, -1, false},
+		{"nobody", 0, 0, true},
+		{"1000:staff", 0, 0, true},
+	}
+
+	for _, tc := range cases {
+		sb, err := newSandbox(config.SandboxSettings{User: tc.user})
+		if err != nil {
+			t.Fatalf("newSandbox() error = %v", err)
+		}
+
+		uid, gid, err := sb.owner()
+		if (err != nil) != tc.wantErr {
+			t.Fatalf("owner() for %q error = %v, want error %v", tc.user, err, tc.wantErr)
+		}
+		if !tc.wantErr && (uid != tc.uid || gid != tc.gid) {
+			t.Errorf("owner() for %q = %d:%d, expected %d:%d", tc.user, uid, gid, tc.uid, tc.gid)
+		}
+	}
+}
+
+func TestContainerService_TaskDirIsPrivate(t *testing.T) {
+	user := fmt.Sprintf("%d:%d", os.Getuid(), os.Getgid())
+	sb, err := newSandbox(config.SandboxSettings{User: user})
+	if err != nil {
+		t.Fatalf("newSandbox() error = %v", err)
+	}
+
+	cs := &ContainerService{IOMode: IOModeVolume, WorkDir: t.TempDir(), RT: &ContainerRuntime{sandbox: sb}}
+
+	dir, err := cs.TaskDir("task")
+	if err != nil {
+		t.Fatalf("TaskDir() error = %v", err)
+	}
+
+	info, err := os.Stat(dir)
+	if err != nil {
+		t.Fatalf("Stat() error = %v", err)
+	}
+	if perm := info.Mode().Perm(); perm&0o007 != 0 {
+		t.Errorf("expected no access for other users, got %v", perm)
+	}
+
+	st := info.Sys().(*syscall.Stat_t)
+	if int(st.Uid) != os.Getuid() || int(st.Gid) != os.Getgid() {
+		t.Errorf("expected the dir to belong to %s, got %d:%d", user, st.Uid, st.Gid)
+	}
+}
@@ -2,40 +2,127 @@ package container
 
 import (
 	"context"
-	"log"
+	"fmt"
+	"os"
+	"syscall"
+
+	"github.com/pupload/pupload/internal/logging"
+	"github.com/pupload/pupload/internal/worker/config"
 
 	"github.com/moby/moby/client"
 )
 
 type ContainerService struct {
 	DockerClient *client.Client
+	Engine       string // docker or podman
+	DataRoot     string // where the engine stores images and containers
+	IOMode       string // volume or copy
+	WorkDir      string // where per-task directories are created
 	RT           *ContainerRuntime
 	IO           *ContainerIO
 	IM           *ImageManager
+	ImageCache   config.ImageCacheSettings
 }
 
-func CreateContainerService() ContainerService {
-	client, err := client.New(client.FromEnv, client.WithAPIVersionNegotiation())
+// CreateContainerService connects to the configured container engine and
+// resolves the OCI runtime, failing if either isn't available. Every container
+// it creates is restricted by the sandbox settings.
+func CreateContainerService(cfg config.RuntimeSettings, sandboxCfg config.SandboxSettings) (ContainerService, error) {
+	ctx := context.Background()
+
+	sb, err := newSandbox(sandboxCfg)
+	if err != nil {
+		return ContainerService{}, err
+	}
+
+	cli, engine, err := connectEngine(ctx, cfg.ContainerEngine)
+	if err != nil {
+		return ContainerService{}, err
+	}
+
+	info, err := cli.Info(ctx, client.InfoOptions{})
+	if err != nil {
+		cli.Close()
+		return ContainerService{}, fmt.Errorf("unable to query %s for installed runtimes: %w", engine, err)
+	}
+
+	runtime, err := selectRuntime(info.Info, cfg)
+	if err != nil {
+		cli.Close()
+		return ContainerService{}, err
+	}
+
+	ioMode, err := selectIOMode(cfg.IOMode, cli.DaemonHost())
+	if err != nil {
+		cli.Close()
+		return ContainerService{}, err
+	}
+
+	if ioMode == IOModeVolume {
+		if _, _, err := sb.owner(); err != nil {
+			cli.Close()
+			return ContainerService{}, err
+		}
+	}
+
+	auth, err := newRegistryAuth(cfg.Registries)
 	if err != nil {
-		log.Fatalf("Can't create container service\n%s", err.Error())
+		cli.Close()
+		return ContainerService{}, err
 	}
 
+	log := logging.ForService("container")
+	log.Info("container engine selected", "engine", engine, "host", cli.DaemonHost(), "runtime", runtime, "io_mode", ioMode)
+	log.Info("container sandbox", "user", sandboxCfg.User, "read_only_rootfs", sandboxCfg.ReadOnlyRootfs, "cap_drop", sandboxCfg.CapDrop, "cap_add", sandboxCfg.CapAdd,
+		"no_new_privileges", sandboxCfg.NoNewPrivileges, "pids_limit", sandboxCfg.PidsLimit, "network_mode", sandboxCfg.NetworkMode)
+
 	return ContainerService{
-		DockerClient: client,
+		DockerClient: cli,
+		Engine:       engine,
+		DataRoot:     info.Info.DockerRootDir,
+		IOMode:       ioMode,
+		WorkDir:      cfg.WorkDir,
 		RT: &ContainerRuntime{
-			client:  client,
-			runtime: "runc",
+			client:  cli,
+			runtime: runtime,
+			sandbox: sb,
 		},
 
 		IO: &ContainerIO{
-			client: client,
+			client: cli,
 		},
 
 		IM: &ImageManager{
-			client: client,
+			client: cli,
+			auth:   auth,
 		},
+		ImageCache: cfg.Images,
+	}, nil
+
+}
+
+// TaskDir creates an empty directory for one task's files. The caller removes
+// it with os.RemoveAll once the outputs are uploaded.
+func (cs *ContainerService) TaskDir(name string) (string, error) {
+	dir, err := os.MkdirTemp(cs.WorkDir, name+"-")
+	if err != nil {
+		return "", err
 	}
 
+	// In volume mode the sandbox user writes outputs here, so the dir is
+	// handed to it rather than opened to every local user.
+	if cs.IOMode == IOModeVolume {
+		uid, gid, err := cs.RT.sandbox.owner()
+		if err == nil {
+			err = os.Chown(dir, uid, gid)
+		}
+		if err != nil {
+			os.RemoveAll(dir)
+			return "", fmt.Errorf("unable to hand task dir to the sandbox user: %w", err)
+		}
+	}
+
+	return dir, nil
 }
 
 func (cs *ContainerService) ListImages() ([]string, error) {
@@ -53,3 +140,18 @@ func (cs *ContainerService) ListImages() ([]string, error) {
 	return imageNames, nil
 
 }
+
+// DiskUsedPercent is how full the filesystem holding the engine's images is.
+// It needs the engine to run on this host.
+func (cs *ContainerService) DiskUsedPercent() (float64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(cs.DataRoot, &st); err != nil {
+		return 0, fmt.Errorf("DiskUsedPercent: %w", err)
+	}
+
+	if st.Blocks == 0 {
+		return 0, nil
+	}
+
+	return 100 * float64(st.Blocks-st.Bavail) / float64(st.Blocks), nil
+}
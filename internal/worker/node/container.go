@@ -0,0 +1,246 @@
+package node
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/pupload/pupload/internal/logging"
+	"github.com/pupload/pupload/internal/models"
+	"github.com/pupload/pupload/internal/resources"
+	"github.com/pupload/pupload/internal/syncplane"
+
+	cont "github.com/pupload/pupload/internal/worker/container"
+
+	"github.com/moby/moby/api/types/container"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/sync/errgroup"
+)
+
+// containerExecutor runs the node's command in a container of its image.
+type containerExecutor struct {
+	ns *NodeService
+	cs *cont.ContainerService
+}
+
+func (e *containerExecutor) Execute(ctx context.Context, payload syncplane.NodeExecutePayload, r *resources.Reservation) (Result, error) {
+	var img cont.Image
+	res, err := e.execute(ctx, payload, r, &img)
+
+	// Recorded for failed attempts too, since the image may be the cause.
+	res.ImageDigest = img.Digest
+	return res, err
+}
+
+// execute runs the node, setting img once its image is resolved.
+func (e *containerExecutor) execute(ctx context.Context, payload syncplane.NodeExecutePayload, r *resources.Reservation, img *cont.Image) (Result, error) {
+	l := logging.LoggerFromCtx(ctx)
+	span := trace.SpanFromContext(ctx)
+
+	resource, err := e.ns.ResourceManger.GenerateContainerResource(r)
+	if err != nil {
+		return Result{}, err
+	}
+
+	in, out, err := e.ns.prepareIO(payload, cont.WorkDir)
+	if err != nil {
+		return Result{}, err
+	}
+
+	command, err := e.ns.generateCommand(payload.Node, payload.NodeDef, in, out, cont.WorkDir)
+	if err != nil {
+		return Result{}, err
+	}
+
+	image, err := e.ns.ImagePolicy.Check(payload.NodeDef.Image)
+	if err != nil {
+		l.Error("container image rejected", "err", err)
+		return Result{}, fmt.Errorf("%w: %w", err, syncplane.ErrSkipRetry)
+	}
+
+	*img, err = e.cs.IM.Ensure(ctx, payload.NodeDef.Image, payload.NodeDef.PullPolicyName())
+	if err != nil {
+		l.Error("error resolving image", "image", image, "err", err)
+		return Result{}, err
+	}
+
+	l.Info("image resolved", "image", image, "digest", img.Digest)
+	e.ns.images.used(*img, payload.NodeDef.Image)
+
+	name := fmt.Sprintf("pupload-%s-%s", payload.RunID, payload.Node.ID)
+	dir, err := e.cs.TaskDir(name)
+	if err != nil {
+		return Result{}, err
+	}
+	defer os.RemoveAll(dir)
+
+	if err := downloadInputs(ctx, dir, in); err != nil {
+		return Result{}, err
+	}
+
+	secrets, err := e.ns.prepareSecrets(ctx, payload.Node, dir, cont.WorkDir)
+	if err != nil {
+		return Result{}, err
+	}
+
+	l.Info("files downloaded to task dir", "dir", dir, "io_mode", e.cs.IOMode)
+
+	cfg := cont.ContainerConfig{
+		Image: img.ID,
+		Name:  name,
+		Cmd:   command,
+		Env:   secrets.env,
+
+		NeedsNetwork: payload.NodeDef.NeedsNetwork,
+
+		HostConfig: &container.HostConfig{
+			AutoRemove: false,
+			Resources:  resource,
+		},
+	}
+
+	if e.cs.IOMode == cont.IOModeVolume {
+		cfg.TaskDir = dir
+	}
+
+	containerID, err := e.cs.RT.CreateContainer(ctx, cfg)
+	if err != nil {
+		return Result{}, err
+	}
+
+	l = l.With("container_id", containerID)
+	l.Info("container created")
+	span.AddEvent("container created")
+
+	defer e.cs.RT.RemoveContainer(ctx, containerID)
+
+	if e.cs.IOMode == cont.IOModeCopy {
+		if err := e.copyInputs(ctx, containerID, dir, in); err != nil {
+			return Result{}, err
+		}
+
+		if err := e.copySecrets(ctx, containerID, dir, secrets); err != nil {
+			return Result{}, err
+		}
+
+		l.Info("files copied into container")
+	}
+
+	if err := e.cs.RT.StartContainer(ctx, containerID); err != nil {
+		return Result{}, err
+	}
+
+	l.Info("container started")
+	span.AddEvent("container started")
+
+	followCtx, stopFollow := context.WithCancel(ctx)
+	defer stopFollow()
+
+	emit := nodeOutput(ctx, secrets)
+	followed := make(chan error, 1)
+	go func() {
+		followed <- e.cs.RT.FollowLogs(followCtx, containerID, func(line cont.LogLine) { emit(line.Stream, line.Text, line.Time) })
+	}()
+
+	res, err := e.cs.RT.WaitContainer(ctx, containerID)
+	if err != nil {
+		return Result{}, err
+	}
+
+	// The log stream ends once the engine has delivered the last line.
+	if err := <-followed; err != nil {
+		l.Warn("following container logs failed", "err", err)
+	}
+
+	l = l.With(
+		"exit_code", res.ExitCode,
+		"exit_message", res.Error,
+	)
+	l.Info("container finished")
+	span.AddEvent("container finished")
+
+	if res.ExitCode != 0 {
+		return Result{}, fmt.Errorf("contained exited with non-0 exit code")
+	}
+
+	if e.cs.IOMode == cont.IOModeCopy {
+		if err := e.copyOutputs(ctx, containerID, dir, out); err != nil {
+			return Result{}, err
+		}
+
+		l.Info("files copied out of container")
+	}
+
+	results, err := e.readResults(ctx, containerID, dir, payload.NodeDef)
+	if err != nil {
+		return Result{}, err
+	}
+
+	outputs, err := uploadOutputs(ctx, dir, out, payload.OutputMultipart)
+	if err != nil {
+		return Result{}, err
+	}
+
+	l.Info("files uploaded from task dir")
+
+	return Result{Outputs: outputs, Results: results}, nil
+}
+
+// readResults reads the results the container wrote, copying them out first
+// for engines that can't bind-mount the task dir.
+func (e *containerExecutor) readResults(ctx context.Context, containerID, dir string, nodeDef models.NodeDef) (map[string]json.RawMessage, error) {
+	if len(nodeDef.Results) == 0 {
+		return nil, nil
+	}
+
+	path := filepath.Join(dir, resultsFilename)
+
+	if e.cs.IOMode == cont.IOModeCopy {
+		// A node without required results may not have written any.
+		if err := e.cs.IO.CopyFileOut(ctx, containerID, filepath.Join(cont.WorkDir, resultsFilename), resultsFilename, path); err != nil {
+			logging.LoggerFromCtx(ctx).Debug("no results copied out of container", "err", err)
+		}
+	}
+
+	return readResults(path, nodeDef.Results)
+}
+
+// copyInputs copies the downloaded inputs from the task dir into the
+// container, for engines that can't bind-mount it.
+func (e *containerExecutor) copyInputs(ctx context.Context, containerID, dir string, inputs []preparedIO) error {
+	g, gctx := errgroup.WithContext(ctx)
+	for _, i := range inputs {
+		g.Go(func() error {
+			return e.cs.IO.CopyFileInto(gctx, containerID, filepath.Join(dir, i.filename), i.base_path, i.filename)
+		})
+	}
+
+	return g.Wait()
+}
+
+// copyOutputs copies the outputs out of the container into the task dir.
+func (e *containerExecutor) copyOutputs(ctx context.Context, containerID, dir string, outputs []preparedIO) error {
+	g, gctx := errgroup.WithContext(ctx)
+	for _, o := range outputs {
+		g.Go(func() error {
+			return e.cs.IO.CopyFileOut(gctx, containerID, o.path, o.filename, filepath.Join(dir, o.filename))
+		})
+	}
+
+	return g.Wait()
+}
+
+// copySecrets copies the file secrets into the container's secrets dir, for
+// engines that can't bind-mount the task dir.
+func (e *containerExecutor) copySecrets(ctx context.Context, containerID, dir string, secrets preparedSecrets) error {
+	for _, name := range secrets.files {
+		src := filepath.Join(dir, secretsDirName, name)
+		if err := e.cs.IO.CopyFileInto(ctx, containerID, src, cont.WorkDir, secretsDirName+"/"+name); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
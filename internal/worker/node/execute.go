@@ -2,23 +2,33 @@ package node
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
-	"os"
-	"strings"
 
 	"github.com/pupload/pupload/internal/logging"
 	"github.com/pupload/pupload/internal/models"
+	"github.com/pupload/pupload/internal/resources"
 	"github.com/pupload/pupload/internal/syncplane"
 	"github.com/pupload/pupload/internal/telemetry"
 
-	cont "github.com/pupload/pupload/internal/worker/container"
-
-	"github.com/moby/moby/api/types/container"
 	"go.opentelemetry.io/otel/attribute"
-	"golang.org/x/sync/errgroup"
 )
 
-func (n *NodeService) NodeExecute(ctx context.Context, payload syncplane.NodeExecutePayload, resource container.Resources) error {
+// Executor runs one attempt of a node with the resources reserved for it.
+type Executor interface {
+	Execute(ctx context.Context, payload syncplane.NodeExecutePayload, r *resources.Reservation) (Result, error)
+}
+
+// Result is what an attempt reports back to the controller.
+type Result struct {
+	Outputs map[string]models.ArtifactInfo // by output name
+	Results map[string]json.RawMessage     // by result name
+
+	ImageDigest string // set by the container executor once the image is resolved, even if the attempt fails
+}
+
+// NodeExecute runs the node with the executor its definition picks.
+func (n *NodeService) NodeExecute(ctx context.Context, payload syncplane.NodeExecutePayload, r *resources.Reservation) (Result, error) {
 
 	l := logging.LoggerFromCtx(ctx)
 	ctx, span := telemetry.Tracer("pupload.worker").Start(ctx, "NodeExecute")
@@ -27,127 +37,25 @@ func (n *NodeService) NodeExecute(ctx context.Context, payload syncplane.NodeExe
 		attribute.String("node_id", payload.Node.ID),
 		attribute.String("container_image", payload.NodeDef.Image),
 		attribute.String("tier", payload.NodeDef.Tier),
+		attribute.String("executor", payload.NodeDef.ExecutorName()),
 	)
 	l.With("span_id", span.SpanContext().SpanID().String())
 
-	// handle worker capabiliites
-
-	in, out, err := n.prepareIO(payload.InputURLs, payload.OutputURLs, payload.NodeDef, "/tmp")
-	if err != nil {
-		return err
-	}
-
-	command, err := n.generateCommand(payload.Node, payload.NodeDef, in, out)
-	if err != nil {
-		return err
-	}
-
-	l.Info("validating container image")
-	ok, err := n.CS.IM.Validate(ctx, payload.NodeDef.Image)
-	if err != nil {
-		l.Error("error validating image", "err", err)
-		return err
-	}
+	executor, ok := n.executors[payload.NodeDef.ExecutorName()]
 	if !ok {
-		l.Warn("image not found, attempting pull")
-		err := n.CS.IM.Pull(ctx, payload.NodeDef.Image)
-		if err != nil {
-			l.Error("error pulling image", "err", err)
-			return err
-		}
+		// Routing should keep the node away from this worker; another one may
+		// still take it on retry.
+		return Result{}, fmt.Errorf("executor %s is not allowed on this worker", payload.NodeDef.ExecutorName())
 	}
 
-	containerID, err := n.CS.RT.CreateContainer(ctx, cont.ContainerConfig{
-		Image: payload.NodeDef.Image,
-		Name:  fmt.Sprintf("pupload-%s-%s", payload.RunID, payload.Node.ID),
-		Cmd:   command,
-
-		HostConfig: &container.HostConfig{
-			AutoRemove: false,
-			Resources:  resource,
-		},
-	})
-
-	if err != nil {
-		return err
-	}
-
-	l.With("container_id", containerID)
-	l.Info("container created")
-	span.AddEvent("container created")
-
-	defer n.CS.RT.RemoveContainer(ctx, containerID)
-
-	if err := n.downloadAllInputsToContainer(ctx, containerID, in); err != nil {
-		return err
-	}
-
-	l.Info("files downloaded to container")
-
-	if err := n.CS.RT.StartContainer(ctx, containerID); err != nil {
-		return err
-	}
-
-	l.Info("container started")
-	span.AddEvent("container started")
-
-	res, err := n.CS.RT.WaitContainer(ctx, containerID)
-	if err != nil {
-		return err
-	}
-
-	l.With(
-		"exit_code", res.ExitCode,
-		"exit_message", res.Error,
-	)
-	l.Info("container finished")
-	span.AddEvent("container finished")
-
-	logs, err := n.CS.RT.GetLogs(ctx, containerID)
-	if err != nil {
-		return err
-	}
-
-	l.Debug("container logs", "logs", logs)
-
-	if res.ExitCode != 0 {
-		return fmt.Errorf("contained exited with non-0 exit code")
-	}
-
-	if err := n.uploadAllOutputsFromContainer(ctx, containerID, out); err != nil {
-		return err
-	}
-
-	l.Info("files uploaded from container")
-
-	return nil
+	return executor.Execute(ctx, payload, r)
 }
 
-func (n *NodeService) downloadAllInputsToContainer(ctx context.Context, containerID string, inputs []preparedIO) error {
-	inGroup, errCtx := errgroup.WithContext(ctx)
-	for _, i := range inputs {
-		i := i
-		inGroup.Go(func() error {
-			return n.CS.IO.DownloadIntoContainer(errCtx, containerID, i.url, i.base_path, i.filename)
-		})
-	}
-
-	return inGroup.Wait()
-}
-
-func (n *NodeService) uploadAllOutputsFromContainer(ctx context.Context, containerID string, outputs []preparedIO) error {
-	outGroup, errCtx := errgroup.WithContext(ctx)
-	for _, o := range outputs {
-		o := o
-		outGroup.Go(func() error {
-			return n.CS.IO.UploadFromContainer(errCtx, containerID, o.url, o.path, o.filename)
-		})
+func (n *NodeService) generateCommand(node models.Node, nodeDef models.NodeDef, in, out []preparedIO, basePath string) ([]string, error) {
+	if err := nodeDef.CheckCommand(); err != nil {
+		return nil, err
 	}
 
-	return outGroup.Wait()
-}
-
-func (n *NodeService) generateCommand(node models.Node, nodeDef models.NodeDef, in, out []preparedIO) ([]string, error) {
 	envMap := make(map[string]string)
 
 	if err := n.addEnvFlagMap(envMap, nodeDef, node); err != nil {
@@ -157,11 +65,30 @@ func (n *NodeService) generateCommand(node models.Node, nodeDef models.NodeDef,
 	// prep inputs
 	n.addIOToEnvMap(envMap, in)
 	n.addIOToEnvMap(envMap, out)
+	n.addResultsToEnvMap(envMap, nodeDef, basePath)
+	n.addSecretsDirToEnvMap(envMap, node, basePath)
+
+	// Each template argument expands to exactly one argument, whatever the
+	// values contain. Arguments referring to an optional flag, input or
+	// output that is unset are left out.
+	template := nodeDef.Command.Template()
+	command := make([]string, 0, len(template))
+	for _, arg := range template {
+		expanded, unset := models.ExpandArg(arg, func(name string) (string, bool) {
+			v, ok := envMap[name]
+			return v, ok
+		})
+
+		for _, name := range unset {
+			if !nodeDef.OptionalRef(name) {
+				return nil, fmt.Errorf("command argument %q refers to ${%s}, which isn't set", arg, name)
+			}
+		}
 
-	expand := os.Expand(nodeDef.Command.Exec, func(s string) string {
-		return envMap[s]
-	})
+		if len(unset) == 0 {
+			command = append(command, expanded)
+		}
+	}
 
-	command := strings.Fields(expand)
 	return command, nil
 }
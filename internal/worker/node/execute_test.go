@@ -0,0 +1,75 @@
+package node
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/pupload/pupload/internal/models"
+)
+
+func TestGenerateCommand(t *testing.T) {
+	def := models.NodeDef{
+		Flags: []models.NodeFlagDef{
+			{Name: "Title", Required: true},
+			{Name: "Preset", Type: models.FlagEnum, Values: []string{"slow", "fast"}, Default: "fast"},
+			{Name: "Scale", Type: models.FlagFloat},
+		},
+		Inputs:  []models.NodeEdgeDef{{Name: "In"}},
+		Command: models.NodeCommandDef{Args: []string{"encode", "--title=${Title}", "--preset", "${Preset}", "--scale=${Scale}", "${In}"}},
+	}
+	node := models.Node{Flags: []models.NodeFlag{{Name: "Title", Value: "a b --delete-all"}}}
+	in := []preparedIO{{name: "In", path: "/work/in file.mp4"}}
+
+	ns := &NodeService{}
+	got, err := ns.generateCommand(node, def, in, nil, "/work")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"encode", "--title=a b --delete-all", "--preset", "fast", "/work/in file.mp4"}
+	if !slices.Equal(got, want) {
+		t.Fatalf("got %q, expected %q", got, want)
+	}
+
+	// Exec is split before expansion, so values stay single arguments too.
+	def.Command = models.NodeCommandDef{Exec: "encode --title ${Title} ${In}"}
+	got, err = ns.generateCommand(node, def, in, nil, "/work")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want = []string{"encode", "--title", "a b --delete-all", "/work/in file.mp4"}
+	if !slices.Equal(got, want) {
+		t.Fatalf("got %q, expected %q", got, want)
+	}
+
+	node.Flags = append(node.Flags, models.NodeFlag{Name: "Preset", Value: "medium"})
+	if _, err := ns.generateCommand(node, def, in, nil, "/work"); err == nil {
+		t.Fatalf("expected an error for a value outside the enum")
+	}
+
+	// Only ${Name} is a reference; other dollars are the command's own.
+	def.Command = models.NodeCommandDef{Args: []string{"sh", "-c", "echo $1 $$ $HOME $${Title}", "${Title}"}}
+	node.Flags = node.Flags[:1]
+	got, err = ns.generateCommand(node, def, in, nil, "/work")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want = []string{"sh", "-c", "echo $1 $$ $HOME ${Title}", "a b --delete-all"}
+	if !slices.Equal(got, want) {
+		t.Fatalf("got %q, expected %q", got, want)
+	}
+
+	def.Command = models.NodeCommandDef{Args: []string{"encode", "${Tilte}"}}
+	if _, err := ns.generateCommand(node, def, in, nil, "/work"); err == nil {
+		t.Fatalf("expected an error for an unknown reference")
+	}
+
+	// An unset optional flag as its own argument would leave its option
+	// dangling in front of the next argument.
+	def.Command = models.NodeCommandDef{Args: []string{"encode", "--scale", "${Scale}", "${In}"}}
+	if _, err := ns.generateCommand(node, def, in, nil, "/work"); err == nil {
+		t.Fatalf("expected an error for an optional flag split from its option")
+	}
+}
@@ -0,0 +1,353 @@
+package node
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/pupload/pupload/internal/imagepolicy"
+	"github.com/pupload/pupload/internal/logging"
+	"github.com/pupload/pupload/internal/models"
+	"github.com/pupload/pupload/internal/syncplane"
+	"github.com/pupload/pupload/internal/worker/config"
+	"github.com/pupload/pupload/internal/worker/container"
+)
+
+const (
+	defaultImageRefresh = 30 * time.Minute
+	defaultWarmWait     = 30 * time.Second
+	imageGCInterval     = time.Minute
+)
+
+// imageCache pulls node images ahead of time, removes unused ones when the
+// engine's disk fills up and tracks which images the worker has, so they can
+// be advertised to other workers.
+type imageCache struct {
+	ns *NodeService
+	cs *container.ContainerService
+
+	prewarm      []string
+	prewarmTiers bool
+	refresh      time.Duration
+	warmWait     time.Duration
+	gcHigh       float64
+	gcLow        float64
+
+	mu       sync.Mutex
+	lastUsed map[string]time.Time // by image ID
+	cached   []string             // normalized refs of the local images
+}
+
+func newImageCache(ns *NodeService, cs *container.ContainerService, cfg config.ImageCacheSettings) (*imageCache, error) {
+	c := &imageCache{
+		ns:           ns,
+		cs:           cs,
+		prewarm:      cfg.Prewarm,
+		prewarmTiers: cfg.PrewarmTiers,
+		refresh:      defaultImageRefresh,
+		warmWait:     defaultWarmWait,
+		gcHigh:       float64(cfg.GCHighPercent),
+		gcLow:        float64(cfg.GCLowPercent),
+		lastUsed:     make(map[string]time.Time),
+	}
+
+	if cfg.Refresh != "" {
+		d, err := time.ParseDuration(cfg.Refresh)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid images refresh %q", cfg.Refresh)
+		}
+		c.refresh = d
+	}
+
+	if cfg.WarmWait != "" {
+		d, err := time.ParseDuration(cfg.WarmWait)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("invalid images warm_wait %q", cfg.WarmWait)
+		}
+		c.warmWait = d
+	}
+
+	if c.gcHigh < 0 || c.gcHigh > 100 {
+		return nil, fmt.Errorf("images gc_high_percent must be between 0 and 100")
+	}
+
+	if c.gcLow <= 0 || c.gcLow >= c.gcHigh {
+		c.gcLow = max(c.gcHigh-10, 0)
+	}
+
+	return c, nil
+}
+
+// run pre-pulls images and collects unused ones until ctx is done.
+func (c *imageCache) run(ctx context.Context) {
+	c.pullAll(ctx)
+	c.collect(ctx)
+
+	refresh := time.NewTicker(c.refresh)
+	defer refresh.Stop()
+
+	gc := time.NewTicker(imageGCInterval)
+	defer gc.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-refresh.C:
+			c.pullAll(ctx)
+		case <-gc.C:
+			c.collect(ctx)
+		}
+	}
+}
+
+// pullAll ensures the configured images, and the images recently routed to
+// the worker's tiers, are on the worker.
+func (c *imageCache) pullAll(ctx context.Context) {
+	log := logging.ForService("node")
+
+	policies := make(map[string]string)
+	usedAt := make(map[string]time.Time)
+
+	for _, image := range c.prewarm {
+		policies[image] = models.NodeDef{Image: image}.PullPolicyName()
+	}
+
+	if c.prewarmTiers {
+		images, err := c.ns.SyncLayer.ListTierImages(ctx)
+		if err != nil {
+			log.Warn("unable to list tier images", "err", err)
+		}
+
+		c.ns.mu.Lock()
+		tiers := c.ns.ResourceManger.GetValidTierMap()
+		c.ns.mu.Unlock()
+
+		for _, ti := range images {
+			if _, ok := tiers[ti.Tier]; !ok || ti.PullPolicy == models.PullNever {
+				continue
+			}
+
+			if _, ok := policies[ti.Image]; !ok {
+				policies[ti.Image] = ti.PullPolicy
+			}
+
+			if ti.UsedAt.After(usedAt[ti.Image]) {
+				usedAt[ti.Image] = ti.UsedAt
+			}
+		}
+	}
+
+	for image, policy := range policies {
+		if ctx.Err() != nil {
+			return
+		}
+
+		if _, err := c.ns.ImagePolicy.Check(image); err != nil {
+			log.Warn("not pre-pulling image", "image", image, "err", err)
+			continue
+		}
+
+		img, err := c.cs.IM.Ensure(ctx, image, policy)
+		if err != nil {
+			log.Warn("unable to pre-pull image", "image", image, "err", err)
+			continue
+		}
+
+		c.touch(img.ID, usedAt[image])
+	}
+
+	c.scan(ctx)
+}
+
+// collect removes the least recently used images no container uses until the
+// disk is under the low mark, once it has passed the high one.
+func (c *imageCache) collect(ctx context.Context) {
+	log := logging.ForService("node")
+
+	defer c.scan(ctx)
+
+	if c.gcHigh == 0 {
+		return
+	}
+
+	used, err := c.cs.DiskUsedPercent()
+	if err != nil {
+		log.Warn("unable to check image disk usage", "err", err)
+		return
+	}
+
+	if used < c.gcHigh {
+		return
+	}
+
+	images, err := c.cs.IM.List(ctx)
+	if err != nil {
+		log.Warn("unable to list images", "err", err)
+		return
+	}
+
+	inUse, err := c.cs.IM.InUse(ctx)
+	if err != nil {
+		log.Warn("unable to list containers", "err", err)
+		return
+	}
+
+	keep := make(map[string]bool, len(c.prewarm))
+	for _, image := range c.prewarm {
+		keep[imagepolicy.Normalize(image)] = true
+	}
+
+	c.mu.Lock()
+	candidates := gcCandidates(images, inUse, keep, c.lastUsed)
+	c.mu.Unlock()
+
+	for _, img := range candidates {
+		if used < c.gcLow {
+			return
+		}
+
+		if err := c.cs.IM.Remove(ctx, img.ID); err != nil {
+			log.Warn("unable to remove image", "image_id", img.ID, "err", err)
+			continue
+		}
+
+		log.Info("removed unused image", "image_id", img.ID, "refs", img.Refs, "size", img.Size)
+
+		c.mu.Lock()
+		delete(c.lastUsed, img.ID)
+		c.mu.Unlock()
+
+		if used, err = c.cs.DiskUsedPercent(); err != nil {
+			log.Warn("unable to check image disk usage", "err", err)
+			return
+		}
+	}
+}
+
+// gcCandidates returns the images that may be removed, least recently used
+// first. Images never used by a node count as used when they were created.
+func gcCandidates(images []container.LocalImage, inUse, keep map[string]bool, lastUsed map[string]time.Time) []container.LocalImage {
+	var candidates []container.LocalImage
+	for _, img := range images {
+		if inUse[img.ID] || slices.ContainsFunc(img.Refs, func(ref string) bool { return keep[ref] }) {
+			continue
+		}
+
+		candidates = append(candidates, img)
+	}
+
+	usedAt := func(img container.LocalImage) time.Time {
+		if t, ok := lastUsed[img.ID]; ok {
+			return t
+		}
+		return img.Created
+	}
+
+	slices.SortStableFunc(candidates, func(a, b container.LocalImage) int {
+		return usedAt(a).Compare(usedAt(b))
+	})
+
+	return candidates
+}
+
+// scan refreshes the refs the worker advertises.
+func (c *imageCache) scan(ctx context.Context) {
+	images, err := c.cs.IM.List(ctx)
+	if err != nil {
+		logging.ForService("node").Warn("unable to list images", "err", err)
+		return
+	}
+
+	var refs []string
+	for _, img := range images {
+		refs = append(refs, img.Refs...)
+	}
+
+	c.mu.Lock()
+	c.cached = refs
+	c.mu.Unlock()
+}
+
+// touch records that an image was used at t, or now for a zero t.
+func (c *imageCache) touch(id string, t time.Time) {
+	if t.IsZero() {
+		t = time.Now()
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if t.After(c.lastUsed[id]) {
+		c.lastUsed[id] = t
+	}
+}
+
+// used records that a node ran in img, pulled by ref.
+func (c *imageCache) used(img container.Image, ref string) {
+	c.touch(img.ID, time.Time{})
+
+	ref = imagepolicy.Normalize(ref)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !slices.Contains(c.cached, ref) {
+		c.cached = append(c.cached, ref)
+	}
+}
+
+func (c *imageCache) has(ref string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return slices.Contains(c.cached, ref)
+}
+
+func (c *imageCache) refs() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return slices.Clone(c.cached)
+}
+
+// ManageImages pre-pulls node images and collects unused ones until ctx is
+// done. It returns at once on workers without the container executor.
+func (ns *NodeService) ManageImages(ctx context.Context) {
+	if ns.images != nil {
+		ns.images.run(ctx)
+	}
+}
+
+// handBack reports whether a container node is better left to another worker
+// that has its image cached, while the node is fresh and has attempts to
+// spare.
+func (ns *NodeService) handBack(payload syncplane.NodeExecutePayload) bool {
+	if ns.images == nil || payload.NodeDef.ExecutorName() != models.ExecutorContainer {
+		return false
+	}
+
+	if payload.Attempt >= payload.MaxAttempts || payload.DispatchedAt.IsZero() || time.Since(payload.DispatchedAt) >= ns.images.warmWait {
+		return false
+	}
+
+	image := imagepolicy.Normalize(payload.NodeDef.Image)
+	if ns.images.has(image) {
+		return false
+	}
+
+	queue := syncplane.ExecuteQueue(payload)
+
+	ns.mu.Lock()
+	defer ns.mu.Unlock()
+
+	for _, w := range ns.peers {
+		if slices.Contains(w.Queues, queue) && slices.Contains(w.Images, image) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,79 @@
+package node
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/pupload/pupload/internal/syncplane"
+	"github.com/pupload/pupload/internal/worker/container"
+)
+
+func TestGCCandidates(t *testing.T) {
+	now := time.Now()
+
+	images := []container.LocalImage{
+		{ID: "running", Refs: []string{"docker.io/library/nginx:latest"}, Created: now.Add(-10 * time.Hour)},
+		{ID: "prewarmed", Refs: []string{"docker.io/library/ubuntu:24.04"}, Created: now.Add(-9 * time.Hour)},
+		{ID: "recent", Refs: []string{"docker.io/library/ffmpeg:latest"}, Created: now.Add(-8 * time.Hour)},
+		{ID: "stale", Refs: []string{"docker.io/library/alpine:latest"}, Created: now.Add(-time.Hour)},
+		{ID: "unused", Created: now.Add(-2 * time.Hour)},
+	}
+	inUse := map[string]bool{"running": true}
+	keep := map[string]bool{"docker.io/library/ubuntu:24.04": true}
+	lastUsed := map[string]time.Time{
+		"recent": now.Add(-time.Minute),
+		"stale":  now.Add(-5 * time.Hour),
+	}
+
+	var got []string
+	for _, img := range gcCandidates(images, inUse, keep, lastUsed) {
+		got = append(got, img.ID)
+	}
+
+	want := []string{"stale", "unused", "recent"}
+	if !slices.Equal(got, want) {
+		t.Fatalf("got %q, expected %q", got, want)
+	}
+}
+
+func TestHandBack(t *testing.T) {
+	ns := &NodeService{
+		WorkerID: "w-1",
+		images:   &imageCache{warmWait: time.Minute, cached: []string{"docker.io/library/alpine:latest"}},
+		peers: []syncplane.WorkerInfo{
+			{ID: "w-2", Queues: []string{"c-small"}, Images: []string{"docker.io/library/ffmpeg:7"}},
+		},
+	}
+
+	payload := func(image string) syncplane.NodeExecutePayload {
+		p := syncplane.NodeExecutePayload{Attempt: 1, MaxAttempts: 3, DispatchedAt: time.Now()}
+		p.NodeDef.Tier = "c-small"
+		p.NodeDef.Image = image
+		return p
+	}
+
+	if !ns.handBack(payload("ffmpeg:7")) {
+		t.Fatalf("expected node to be handed to the warm worker")
+	}
+
+	if ns.handBack(payload("alpine")) {
+		t.Fatalf("expected node with a cached image to run here")
+	}
+
+	if ns.handBack(payload("redis:7")) {
+		t.Fatalf("expected node no worker has the image for to run here")
+	}
+
+	last := payload("ffmpeg:7")
+	last.Attempt = 3
+	if ns.handBack(last) {
+		t.Fatalf("expected last attempt to run here")
+	}
+
+	stale := payload("ffmpeg:7")
+	stale.DispatchedAt = time.Now().Add(-time.Hour)
+	if ns.handBack(stale) {
+		t.Fatalf("expected node past warm_wait to run here")
+	}
+}
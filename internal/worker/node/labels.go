@@ -0,0 +1,121 @@
+package node
+
+import (
+	"context"
+	"maps"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/pupload/pupload/internal/logging"
+	"github.com/pupload/pupload/internal/models"
+	"github.com/pupload/pupload/internal/syncplane"
+
+	"github.com/google/uuid"
+)
+
+func defaultWorkerID() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "worker"
+	}
+
+	return host + "-" + uuid.NewString()[:8]
+}
+
+// queueMap is the tier queues the worker has capacity for, plus the labelled
+// queues of those tiers whose selectors its labels satisfy. The plain tier
+// queues carry container nodes, so a worker without the container executor
+// only serves labelled queues. Callers must hold ns.mu.
+func (ns *NodeService) queueMap() map[string]int {
+	tiers := ns.ResourceManger.GetValidTierMap()
+
+	queues := make(map[string]int, len(tiers))
+	if _, ok := ns.executors[models.ExecutorContainer]; ok {
+		maps.Copy(queues, tiers)
+	}
+
+	for _, qs := range ns.selectors {
+		priority, ok := tiers[qs.Tier]
+		if ok && ns.serves(qs) {
+			queues[qs.Queue] = priority
+		}
+	}
+
+	return queues
+}
+
+func (ns *NodeService) serves(qs syncplane.QueueSelector) bool {
+	executor := qs.Executor
+	if executor == "" {
+		executor = models.ExecutorContainer
+	}
+
+	_, ok := ns.executors[executor]
+	return ok && qs.Selector.Matches(ns.Labels)
+}
+
+// refreshQueues subscribes to any labelled queues that appeared since the
+// last call and re-registers the worker with the queues and images it has.
+func (ns *NodeService) refreshQueues(ctx context.Context) error {
+	selectors, err := ns.SyncLayer.ListSelectors(ctx)
+	if err != nil {
+		return err
+	}
+
+	ns.mu.Lock()
+	ns.selectors = selectors
+	queues := ns.queueMap()
+	err = ns.SyncLayer.UpdateSubscribedQueues(queues)
+	ns.mu.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	info := syncplane.WorkerInfo{
+		ID:     ns.WorkerID,
+		Labels: ns.Labels,
+		Queues: slices.Sorted(maps.Keys(queues)),
+	}
+
+	if ns.images != nil {
+		info.Images = ns.images.refs()
+	}
+
+	if err := ns.SyncLayer.RegisterWorker(ctx, info); err != nil {
+		return err
+	}
+
+	workers, err := ns.SyncLayer.ListWorkers(ctx)
+	if err != nil {
+		return err
+	}
+
+	workers = slices.DeleteFunc(workers, func(w syncplane.WorkerInfo) bool { return w.ID == ns.WorkerID })
+
+	ns.mu.Lock()
+	ns.peers = workers
+	ns.mu.Unlock()
+
+	return nil
+}
+
+// Heartbeat keeps the worker registered until ctx is done.
+func (ns *NodeService) Heartbeat(ctx context.Context) {
+	log := logging.ForService("node")
+
+	ticker := time.NewTicker(syncplane.WorkerHeartbeat)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := ns.refreshQueues(ctx); err != nil {
+				log.Warn("unable to refresh worker registration", "err", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package node
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pupload/pupload/internal/logging"
+	"github.com/pupload/pupload/internal/models"
+	"github.com/pupload/pupload/internal/syncplane"
+)
+
+const (
+	// A node's logs ship to the sync plane once logBatchSize records are
+	// waiting or every logFlushInterval, whichever comes first.
+	logBatchSize     = 100
+	logFlushInterval = time.Second
+
+	// logTailSize is how many of the last records also land in the node's
+	// state when it finishes, so failures show their cause.
+	logTailSize = 200
+)
+
+// maxLogBytes caps the log text one attempt ships; the rest is dropped.
+var maxLogBytes = 16 << 20
+
+// logShipper ships a node's own output to the sync plane while it runs.
+type logShipper struct {
+	sync    syncplane.SyncLayer
+	runID   string
+	nodeID  string
+	attempt string
+
+	sendMu  sync.Mutex // keeps batches in order
+	mu      sync.Mutex
+	pending []models.LogRecord
+	tail    []models.LogRecord
+	shipped int
+	limited bool
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func newLogShipper(s syncplane.SyncLayer, runID, nodeID string, attempt int) *logShipper {
+	ls := &logShipper{
+		sync:    s,
+		runID:   runID,
+		nodeID:  nodeID,
+		attempt: strconv.Itoa(attempt),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+
+	go ls.run()
+
+	return ls
+}
+
+func (ls *logShipper) run() {
+	defer close(ls.done)
+
+	ticker := time.NewTicker(logFlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ls.stop:
+			ls.flush()
+			return
+		case <-ticker.C:
+			ls.flush()
+		}
+	}
+}
+
+// Add queues one line of output.
+func (ls *logShipper) Add(stream, text string, at time.Time) {
+	ls.mu.Lock()
+
+	if ls.limited {
+		ls.mu.Unlock()
+		return
+	}
+
+	rec := models.LogRecord{
+		Time:   at,
+		Level:  "INFO",
+		Msg:    text,
+		Stream: stream,
+		Fields: map[string]string{"attempt": ls.attempt},
+	}
+
+	ls.shipped += len(text)
+	if ls.shipped > maxLogBytes {
+		ls.limited = true
+		rec = models.LogRecord{
+			Time:   at,
+			Level:  "WARN",
+			Msg:    "log limit reached, dropping further output",
+			Fields: map[string]string{"attempt": ls.attempt, "limit_bytes": strconv.Itoa(maxLogBytes)},
+		}
+	}
+
+	ls.pending = append(ls.pending, rec)
+
+	ls.tail = append(ls.tail, rec)
+	if len(ls.tail) > logTailSize {
+		ls.tail = ls.tail[len(ls.tail)-logTailSize:]
+	}
+
+	full := len(ls.pending) >= logBatchSize
+	ls.mu.Unlock()
+
+	if full {
+		ls.flush()
+	}
+}
+
+func (ls *logShipper) flush() {
+	ls.sendMu.Lock()
+	defer ls.sendMu.Unlock()
+
+	ls.mu.Lock()
+	batch := ls.pending
+	ls.pending = nil
+	ls.mu.Unlock()
+
+	if len(batch) == 0 {
+		return
+	}
+
+	// Logs are best effort; a lost batch mustn't fail the node.
+	if err := ls.sync.AppendNodeLogs(context.Background(), ls.runID, ls.nodeID, batch); err != nil {
+		logging.ForService("worker").Warn("could not ship node logs", "run_id", ls.runID, "node_id", ls.nodeID, "err", err)
+	}
+}
+
+// Close ships whatever is left and returns the last records, oldest first.
+func (ls *logShipper) Close() []models.LogRecord {
+	close(ls.stop)
+	<-ls.done
+
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	return ls.tail
+}
+
+type ctxKeyLogShipper struct{}
+
+func ctxWithLogShipper(ctx context.Context, ls *logShipper) context.Context {
+	return context.WithValue(ctx, ctxKeyLogShipper{}, ls)
+}
+
+// logShipperFromCtx returns the shipper for the running node, or nil.
+func logShipperFromCtx(ctx context.Context) *logShipper {
+	ls, _ := ctx.Value(ctxKeyLogShipper{}).(*logShipper)
+	return ls
+}
+
+// nodeOutput returns a func that ships each line a node writes, or logs it
+// when the node has no shipper, with secret values redacted. Progress reports
+// are forwarded instead of logged. Every executor passes its output through
+// one.
+func nodeOutput(ctx context.Context, secrets preparedSecrets) func(stream, text string, at time.Time) {
+	l := logging.LoggerFromCtx(ctx)
+	pr := progressReporterFromCtx(ctx)
+
+	log := func(stream, text string, at time.Time) { l.Debug("node output", "stream", stream, "line", text) }
+	if ls := logShipperFromCtx(ctx); ls != nil {
+		log = ls.Add
+	}
+
+	return func(stream, text string, at time.Time) {
+		text = secrets.redact(text)
+
+		if pr != nil {
+			p, ok, err := parseProgress(text, at)
+			if ok && err == nil {
+				pr.Report(p)
+				return
+			}
+
+			if ok {
+				l.Warn("ignoring malformed progress report", "err", err)
+			}
+		}
+
+		log(stream, text, at)
+	}
+}
+
+// lineOutput adapts nodeOutput to executors that pass on lines as they are
+// written, without a time of their own.
+func lineOutput(emit func(stream, text string, at time.Time)) func(stream, line string) {
+	return func(stream, line string) { emit(stream, line, time.Now()) }
+}
@@ -2,11 +2,13 @@ package node
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
 
 	"github.com/pupload/pupload/internal/logging"
 	"github.com/pupload/pupload/internal/models"
+	"github.com/pupload/pupload/internal/resources"
 	"github.com/pupload/pupload/internal/syncplane"
 	"github.com/pupload/pupload/internal/telemetry"
 )
@@ -32,21 +34,51 @@ func (ns *NodeService) FinishedMiddleware(ctx context.Context, payload syncplane
 
 	ctx = logging.CtxWithLogger(ctx, jobLog)
 
-	if err := ns.tryReserve(payload.NodeDef.Tier); err != nil {
-
+	if ns.handBack(payload) {
+		// Pulling the image here would cost the node more than waiting for
+		// a worker that has it.
+		jobLog.Info("handing node to a worker with its image cached", "attempt", payload.Attempt)
+		return syncplane.ErrHandBack
 	}
 
-	res, genErr := ns.ResourceManger.GenerateContainerResource(payload.NodeDef.Tier)
-	if genErr != nil {
-		return genErr
+	reservation, err := ns.tryReserve(payload.NodeDef.Tier)
+	if err != nil {
+		// Another task took the capacity before our queue subscription was
+		// narrowed. Losing that race isn't the node's fault, so the task is
+		// handed back without using up an attempt.
+		jobLog.Info("no capacity for node, handing it back", "err", err)
+		return fmt.Errorf("%w: %w", err, syncplane.ErrHandBack)
 	}
 
-	err := ns.NodeExecute(ctx, payload, res)
+	shipper := newLogShipper(ns.SyncLayer, payload.RunID, payload.Node.ID, payload.Attempt)
+	ctx = ctxWithLogShipper(ctx, shipper)
+
+	reporter := newProgressReporter(ns.SyncLayer, payload)
+	ctx = ctxWithProgressReporter(ctx, reporter)
+
+	res, err := ns.NodeExecute(ctx, payload, reservation)
+
+	// Sent before the result, though the controller drops it if it arrives
+	// after the node has finished.
+	reporter.Close()
+
+	// The tail of the node's own output goes into its state, after the
+	// worker's records, so the cause of a failure is visible there too.
+	logs = append(logs, shipper.Close()...)
+
 	if err == nil {
 		if err := ns.SyncLayer.EnqueueNodeFinished(syncplane.NodeFinishedPayload{
-			RunID:  payload.RunID,
-			NodeID: payload.Node.ID,
-			Logs:   logs,
+			RunID:   payload.RunID,
+			NodeID:  payload.Node.ID,
+			Logs:    logs,
+			Outputs: res.Outputs,
+			Results: res.Results,
+
+			ImageDigest: res.ImageDigest,
+
+			ExecutionID: payload.ExecutionID,
+			AttemptID:   payload.AttemptID,
+			Attempt:     payload.Attempt,
 		}); err != nil {
 
 		}
@@ -58,6 +90,10 @@ func (ns *NodeService) FinishedMiddleware(ctx context.Context, payload syncplane
 			Attempt:     payload.Attempt,
 			MaxAttempts: payload.MaxAttempts,
 			Error:       err.Error(),
+			SkipRetry:   errors.Is(err, syncplane.ErrSkipRetry),
+			ImageDigest: res.ImageDigest,
+			ExecutionID: payload.ExecutionID,
+			AttemptID:   payload.AttemptID,
 			TraceParent: payload.TraceParent,
 		}); enqueueErr != nil {
 
@@ -69,37 +105,34 @@ func (ns *NodeService) FinishedMiddleware(ctx context.Context, payload syncplane
 		jobLog.Error(err.Error())
 	}
 
-	if err := ns.tryRelease(payload.NodeDef.Tier); err != nil {
-
+	if err := ns.tryRelease(reservation); err != nil {
+		jobLog.Error(err.Error())
 	}
 
 	return err
 }
 
-func (ns *NodeService) tryReserve(s string) error {
+func (ns *NodeService) tryReserve(s string) (*resources.Reservation, error) {
 	ns.mu.Lock()
 	defer ns.mu.Unlock()
-	if err := ns.ResourceManger.Reserve(s); err != nil {
-		return fmt.Errorf("ExecuteNodeHandler: Could not reserve resource %s: %w", s, err)
+	reservation, err := ns.ResourceManger.Reserve(s)
+	if err != nil {
+		return nil, fmt.Errorf("ExecuteNodeHandler: Could not reserve resource %s: %w", s, err)
 	}
 
-	queueMap := ns.ResourceManger.GetValidTierMap()
-
-	ns.SyncLayer.UpdateSubscribedQueues(queueMap)
+	ns.SyncLayer.UpdateSubscribedQueues(ns.queueMap())
 
-	return nil
+	return reservation, nil
 }
 
-func (ns *NodeService) tryRelease(s string) error {
+func (ns *NodeService) tryRelease(r *resources.Reservation) error {
 	ns.mu.Lock()
 	defer ns.mu.Unlock()
-	if err := ns.ResourceManger.Release(s); err != nil {
-		return fmt.Errorf("ExecuteNodeHandler: Could not release resource %s: %w", s, err)
+	if err := ns.ResourceManger.Release(r); err != nil {
+		return fmt.Errorf("ExecuteNodeHandler: Could not release resource %s: %w", r.Tier, err)
 	}
 
-	queueMap := ns.ResourceManger.GetValidTierMap()
-
-	ns.SyncLayer.UpdateSubscribedQueues(queueMap)
+	ns.SyncLayer.UpdateSubscribedQueues(ns.queueMap())
 
 	return nil
 }
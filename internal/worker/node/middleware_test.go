@@ -0,0 +1,141 @@
+package node
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pupload/pupload/internal/models"
+	"github.com/pupload/pupload/internal/resources"
+	"github.com/pupload/pupload/internal/syncplane"
+
+	"github.com/nats-io/nats-server/v2/server"
+)
+
+type stubExecutor struct {
+	calls atomic.Int32
+}
+
+func (e *stubExecutor) Execute(ctx context.Context, payload syncplane.NodeExecutePayload, r *resources.Reservation) (Result, error) {
+	e.calls.Add(1)
+	return Result{}, nil
+}
+
+// A worker that keeps losing the race for capacity hands the node back past
+// its retry limit, and the node still finishes once capacity frees up.
+func TestFinishedMiddleware_LostReservationsDontUseAttempts(t *testing.T) {
+	defer func(d time.Duration) { syncplane.HandBackDelay = d }(syncplane.HandBackDelay)
+	syncplane.HandBackDelay = 50 * time.Millisecond
+
+	srv, err := server.NewServer(&server.Options{
+		Host:      "127.0.0.1",
+		Port:      -1,
+		JetStream: true,
+		StoreDir:  t.TempDir(),
+		NoLog:     true,
+		NoSigs:    true,
+	})
+	if err != nil {
+		t.Fatalf("server.NewServer() error = %v", err)
+	}
+	go srv.Start()
+	if !srv.ReadyForConnections(5 * time.Second) {
+		t.Fatalf("nats server not ready")
+	}
+	t.Cleanup(srv.Shutdown)
+
+	cfg := syncplane.SyncPlaneSettings{
+		SelectedSyncPlane:      "nats",
+		Nats:                   syncplane.NatsSettings{URL: srv.ClientURL()},
+		ControllerStepInterval: "@every 1h",
+	}
+
+	controller, err := syncplane.NewControllerNatsSyncLayer(cfg)
+	if err != nil {
+		t.Fatalf("NewControllerNatsSyncLayer() error = %v", err)
+	}
+	t.Cleanup(func() { controller.Close() })
+
+	rCfg := resources.ResourceSettings{
+		MaxCPU: "1", MaxMemory: "64mb", MaxStorage: "64mb",
+		Tiers: map[string]resources.ResourceDefinition{"t-test": {CPU: 1, Memory: "64mb", Storage: "64mb"}},
+	}
+
+	worker, err := syncplane.NewWorkerNatsSyncLayer(cfg, rCfg)
+	if err != nil {
+		t.Fatalf("NewWorkerNatsSyncLayer() error = %v", err)
+	}
+	t.Cleanup(func() { worker.Close() })
+
+	rm, err := resources.CreateResourceManager(rCfg)
+	if err != nil {
+		t.Fatalf("CreateResourceManager() error = %v", err)
+	}
+
+	// Another task holds all the capacity.
+	held, err := rm.Reserve("t-test")
+	if err != nil {
+		t.Fatalf("Reserve() error = %v", err)
+	}
+
+	executor := &stubExecutor{}
+	ns := &NodeService{
+		SyncLayer:      worker,
+		ResourceManger: rm,
+		executors:      map[string]Executor{models.ExecutorContainer: executor},
+	}
+
+	var deliveries atomic.Int32
+	worker.RegisterExecuteNodeHandler(func(ctx context.Context, p syncplane.NodeExecutePayload) error {
+		if deliveries.Add(1) == 3 {
+			if err := ns.tryRelease(held); err != nil {
+				t.Errorf("tryRelease() error = %v", err)
+			}
+		}
+		return ns.FinishedMiddleware(ctx, p)
+	})
+	worker.UpdateSubscribedQueues(map[string]int{"t-test": 1})
+
+	finished := make(chan syncplane.NodeFinishedPayload, 1)
+	failed := make(chan syncplane.NodeFailedPayload, 1)
+	controller.RegisterNodeFinishedHandler(func(ctx context.Context, p syncplane.NodeFinishedPayload) error {
+		finished <- p
+		return nil
+	})
+	controller.RegisterNodeFailedHandler(func(ctx context.Context, p syncplane.NodeFailedPayload) error {
+		failed <- p
+		return nil
+	})
+
+	if err := controller.Start(); err != nil {
+		t.Fatalf("controller Start() error = %v", err)
+	}
+	if err := worker.Start(); err != nil {
+		t.Fatalf("worker Start() error = %v", err)
+	}
+
+	if err := controller.EnqueueExecuteNode(syncplane.NodeExecutePayload{
+		RunID:       "run-1",
+		Node:        models.Node{ID: "n"},
+		NodeDef:     models.NodeDef{Tier: "t-test"},
+		MaxAttempts: 1,
+	}); err != nil {
+		t.Fatalf("EnqueueExecuteNode() error = %v", err)
+	}
+
+	select {
+	case p := <-finished:
+		if p.Attempt != 1 {
+			t.Fatalf("expected the node to finish on attempt 1, got %d", p.Attempt)
+		}
+	case p := <-failed:
+		t.Fatalf("node failed after lost reservations: %s", p.Error)
+	case <-time.After(20 * time.Second):
+		t.Fatalf("node never finished after %d deliveries", deliveries.Load())
+	}
+
+	if got := executor.calls.Load(); got != 1 {
+		t.Fatalf("expected the node to run once, ran %d times", got)
+	}
+}
@@ -1,41 +1,111 @@
 package node
 
 import (
+	"context"
 	"fmt"
 	"io"
+	"maps"
 	"net/http"
 	"path/filepath"
 	"sync"
 
+	"github.com/pupload/pupload/internal/imagepolicy"
+	"github.com/pupload/pupload/internal/labels"
 	mimetypes "github.com/pupload/pupload/internal/mimetype"
 	"github.com/pupload/pupload/internal/models"
 	"github.com/pupload/pupload/internal/resources"
+	"github.com/pupload/pupload/internal/secrets"
 	"github.com/pupload/pupload/internal/syncplane"
+	"github.com/pupload/pupload/internal/worker/config"
 	"github.com/pupload/pupload/internal/worker/container"
+	"github.com/pupload/pupload/internal/worker/process"
+	"github.com/pupload/pupload/internal/worker/transfer"
+	"github.com/pupload/pupload/internal/worker/wasi"
 
 	"github.com/google/uuid"
 )
 
 type NodeService struct {
 	SyncLayer      syncplane.SyncLayer
-	CS             *container.ContainerService
 	ResourceManger *resources.ResourceManager
+	ImagePolicy    *imagepolicy.Policy
+	Secrets        *secrets.Resolver
+
+	WorkerID string
+	Labels   labels.Set
+
+	executors map[string]Executor
+	selectors []syncplane.QueueSelector // labelled queues this worker's labels satisfy
+	images    *imageCache               // nil without the container executor
+	peers     []syncplane.WorkerInfo    // other workers, as of the last heartbeat
 
 	mu sync.Mutex
 }
 
-func CreateNodeService(cs *container.ContainerService, s syncplane.SyncLayer, rm *resources.ResourceManager) (NodeService, error) {
+// Executors holds the service behind each executor a worker allows. A nil
+// service disables its executor.
+type Executors struct {
+	Container *container.ContainerService
+	Process   *process.ProcessService
+	WASI      *wasi.WASIService
+}
 
-	err := s.UpdateSubscribedQueues(rm.GetValidTierMap())
-	if err != nil {
-		return NodeService{}, err
+// CreateNodeService sets up node execution with the executors given.
+func CreateNodeService(ex Executors, s syncplane.SyncLayer, rm *resources.ResourceManager, policy *imagepolicy.Policy, resolver *secrets.Resolver, cfg config.WorkerSettings) (*NodeService, error) {
+	l := labels.Set(cfg.Labels)
+	if err := l.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid worker labels: %w", err)
+	}
+
+	id := cfg.ID
+	if id == "" {
+		id = defaultWorkerID()
 	}
 
-	return NodeService{
-		CS:             cs,
+	ns := &NodeService{
 		SyncLayer:      s,
 		ResourceManger: rm,
-	}, nil
+		ImagePolicy:    policy,
+		Secrets:        resolver,
+
+		WorkerID:  id,
+		Labels:    maps.Clone(l),
+		executors: make(map[string]Executor),
+	}
+
+	if ex.Container != nil {
+		ns.executors[models.ExecutorContainer] = &containerExecutor{ns: ns, cs: ex.Container}
+
+		images, err := newImageCache(ns, ex.Container, ex.Container.ImageCache)
+		if err != nil {
+			return nil, err
+		}
+		ns.images = images
+	}
+
+	if ex.Process != nil {
+		ns.executors[models.ExecutorProcess] = &processExecutor{ns: ns, ps: ex.Process}
+	}
+
+	if ex.WASI != nil {
+		ns.executors[models.ExecutorWASI] = &wasiExecutor{ns: ns, ws: ex.WASI}
+	}
+
+	if len(ns.executors) == 0 {
+		return nil, fmt.Errorf("no executors allowed")
+	}
+
+	// Advertised so non-container nodes are routed only to workers allowing
+	// their executor.
+	for name := range ns.executors {
+		ns.Labels[labels.ExecutorKey(name)] = "true"
+	}
+
+	if err := ns.refreshQueues(context.Background()); err != nil {
+		return nil, err
+	}
+
+	return ns, nil
 }
 
 func (ns *NodeService) addEnvFlagMap(m map[string]string, nodeDef models.NodeDef, node models.Node) error {
@@ -45,10 +115,8 @@ func (ns *NodeService) addEnvFlagMap(m map[string]string, nodeDef models.NodeDef
 		return err
 	}
 
-	// TODO: ensure this can't be escaped
-	for key, val := range flags {
-		m[key] = val
-	}
+	// Values are only ever expanded into single arguments, see generateCommand.
+	maps.Copy(m, flags)
 
 	return nil
 }
@@ -59,14 +127,17 @@ type preparedIO struct {
 	path      string
 	filename  string
 	url       string
+
+	def  models.NodeEdgeDef
+	info models.ArtifactInfo // recorded by the input's producer
 }
 
-func (ns *NodeService) prepareIO(inputs, outputs map[string]string, nodeDef models.NodeDef, basePath string) ([]preparedIO, []preparedIO, error) {
-	in := make([]preparedIO, 0, len(inputs))
-	out := make([]preparedIO, 0, len(outputs))
+func (ns *NodeService) prepareIO(payload syncplane.NodeExecutePayload, basePath string) ([]preparedIO, []preparedIO, error) {
+	in := make([]preparedIO, 0, len(payload.InputURLs))
+	out := make([]preparedIO, 0, len(payload.OutputURLs))
 
-	for _, inputDef := range nodeDef.Inputs {
-		inputURL, ok := inputs[inputDef.Name]
+	for _, inputDef := range payload.NodeDef.Inputs {
+		inputURL, ok := payload.InputURLs[inputDef.Name]
 		if !ok {
 			switch inputDef.Required {
 			case true:
@@ -76,12 +147,19 @@ func (ns *NodeService) prepareIO(inputs, outputs map[string]string, nodeDef mode
 			}
 		}
 
+		info := payload.InputInfo[inputDef.Name]
+		if info.Size > 0 {
+			if err := transfer.CheckSize(info.Size, inputDef); err != nil {
+				return nil, nil, fmt.Errorf("PrepareInputs: input %s: %w: %w", inputDef.Name, err, syncplane.ErrSkipRetry)
+			}
+		}
+
 		typeSet, err := mimetypes.CreateMimeSet(inputDef.Type)
 		if err != nil {
 			return nil, nil, fmt.Errorf("PrepareInputs: error creating mimeset: %w", err)
 		}
 
-		ext, err := ns.validateInput(inputURL, *typeSet)
+		ext, err := ns.validateInput(inputURL, info.MimeType, *typeSet)
 		if err != nil {
 			return nil, nil, fmt.Errorf("PrepareInputs: error validating inputs: %w", err)
 		}
@@ -94,12 +172,14 @@ func (ns *NodeService) prepareIO(inputs, outputs map[string]string, nodeDef mode
 			base_path: basePath,
 			path:      path,
 			filename:  filename,
+			def:       inputDef,
+			info:      info,
 		})
 
 	}
 
-	for _, outputDef := range nodeDef.Outputs {
-		outputURL, ok := outputs[outputDef.Name]
+	for _, outputDef := range payload.NodeDef.Outputs {
+		outputURL, ok := payload.OutputURLs[outputDef.Name]
 		if !ok {
 			return nil, nil, fmt.Errorf("no output URL for output %s", outputDef.Name)
 		}
@@ -113,6 +193,7 @@ func (ns *NodeService) prepareIO(inputs, outputs map[string]string, nodeDef mode
 			base_path: basePath,
 			path:      path,
 			filename:  filename,
+			def:       outputDef,
 		})
 	}
 
@@ -135,20 +216,24 @@ func (ns *NodeService) getOutputExtension(types []models.MimeType) string {
 }
 
 // Validates a given uploaded file against the qualified allowed mime types.
-// Returns the appoprriate file extension
-func (ns *NodeService) validateInput(url string, mimeSet mimetypes.MimeSet) (ext string, err error) {
-
-	resp, err := http.Get(url)
+// The type recorded by the input's producer is used when there is one;
+// otherwise the first 512 bytes are sniffed. Returns the appoprriate file
+// extension
+func (ns *NodeService) validateInput(url string, recorded models.MimeType, mimeSet mimetypes.MimeSet) (ext string, err error) {
+	mime := string(recorded)
+	if mime == "" {
+		resp, err := http.Get(url)
 
-	if err != nil {
-		return "", fmt.Errorf("error getting content from %s", url)
-	}
+		if err != nil {
+			return "", fmt.Errorf("error getting content from %s", url)
+		}
 
-	defer resp.Body.Close()
-	mimeBytes := make([]byte, 512)
+		defer resp.Body.Close()
+		mimeBytes := make([]byte, 512)
 
-	io.ReadFull(resp.Body, mimeBytes)
-	mime := http.DetectContentType(mimeBytes)
+		io.ReadFull(resp.Body, mimeBytes)
+		mime = http.DetectContentType(mimeBytes)
+	}
 
 	if !mimeSet.Contains(models.MimeType(mime)) {
 		return "", fmt.Errorf("invalid content type uploaded")
@@ -175,10 +260,23 @@ func (ns *NodeService) getFlags(nodeDef models.NodeDef, node models.Node) (map[s
 			}
 		}
 
-		if _, ok := flagMap[flagDef.Name]; !ok && flagDef.Required {
+		if _, ok := flagMap[flagDef.Name]; !ok && flagDef.Default != "" {
+			flagMap[flagDef.Name] = flagDef.Default
+		}
+
+		value, ok := flagMap[flagDef.Name]
+		if !ok && flagDef.Required {
 			return flagMap, fmt.Errorf("Flag %s is required", flagDef.Name)
 		}
 
+		// Flags are checked by validation too, but values filled in from
+		// results are only known now.
+		if ok {
+			if err := flagDef.Check(value); err != nil {
+				return flagMap, fmt.Errorf("%w: %w", err, syncplane.ErrSkipRetry)
+			}
+		}
+
 	}
 
 	return flagMap, nil
@@ -0,0 +1,97 @@
+package node
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/pupload/pupload/internal/logging"
+	"github.com/pupload/pupload/internal/resources"
+	"github.com/pupload/pupload/internal/syncplane"
+	"github.com/pupload/pupload/internal/worker/process"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// processExecutor runs the node's command directly on the host, skipping
+// container startup for small trusted nodes. The NodeDef's image is unused.
+type processExecutor struct {
+	ns *NodeService
+	ps *process.ProcessService
+}
+
+func (e *processExecutor) Execute(ctx context.Context, payload syncplane.NodeExecutePayload, r *resources.Reservation) (Result, error) {
+	l := logging.LoggerFromCtx(ctx)
+	span := trace.SpanFromContext(ctx)
+
+	limits, err := e.ns.ResourceManger.GenerateProcessLimits(r)
+	if err != nil {
+		return Result{}, err
+	}
+
+	name := fmt.Sprintf("pupload-%s-%s", payload.RunID, payload.Node.ID)
+	dir, err := e.ps.TaskDir(name)
+	if err != nil {
+		return Result{}, err
+	}
+	defer os.RemoveAll(dir)
+
+	in, out, err := e.ns.prepareIO(payload, dir)
+	if err != nil {
+		return Result{}, err
+	}
+
+	command, err := e.ns.generateCommand(payload.Node, payload.NodeDef, in, out, dir)
+	if err != nil {
+		return Result{}, err
+	}
+
+	if err := downloadInputs(ctx, dir, in); err != nil {
+		return Result{}, err
+	}
+
+	secrets, err := e.ns.prepareSecrets(ctx, payload.Node, dir, dir)
+	if err != nil {
+		return Result{}, err
+	}
+
+	l.Info("files downloaded to task dir", "dir", dir)
+
+	span.AddEvent("process started")
+	res, err := e.ps.Run(ctx, process.ProcessConfig{
+		Name:   filepath.Base(dir),
+		Dir:    dir,
+		Cmd:    command,
+		Env:    secrets.env,
+		Limits: limits,
+		Output: lineOutput(nodeOutput(ctx, secrets)),
+	})
+	if err != nil {
+		return Result{}, err
+	}
+
+	l = l.With("exit_code", res.ExitCode)
+	l.Info("process finished")
+	span.AddEvent("process finished")
+
+	l.Debug("process logs", "logs", secrets.redactLines(res.Logs))
+
+	if res.ExitCode != 0 {
+		return Result{}, fmt.Errorf("process exited with non-0 exit code %d", res.ExitCode)
+	}
+
+	results, err := readResults(filepath.Join(dir, resultsFilename), payload.NodeDef.Results)
+	if err != nil {
+		return Result{}, err
+	}
+
+	outputs, err := uploadOutputs(ctx, dir, out, payload.OutputMultipart)
+	if err != nil {
+		return Result{}, err
+	}
+
+	l.Info("files uploaded from task dir")
+
+	return Result{Outputs: outputs, Results: results}, nil
+}
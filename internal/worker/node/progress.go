@@ -0,0 +1,173 @@
+package node
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pupload/pupload/internal/logging"
+	"github.com/pupload/pupload/internal/models"
+	"github.com/pupload/pupload/internal/syncplane"
+)
+
+// ProgressPrefix marks a line of a node's output as a progress report rather
+// than a log line. The rest of the line is a JSON object:
+//
+//	PUPLOAD_PROGRESS {"percent": 42.5, "stage": "encode", "message": "pass 1 of 2", "metrics": {"fps": 31.2}}
+//
+// Every field is optional. A node reports as often as it likes; the worker
+// forwards the latest report every progressReportInterval.
+const ProgressPrefix = "PUPLOAD_PROGRESS "
+
+const (
+	progressReportInterval = 2 * time.Second
+
+	// Reports are cut to these limits so they stay small in the run state.
+	maxProgressText    = 256
+	maxProgressMetrics = 16
+)
+
+type progressReport struct {
+	Percent *float64           `json:"percent"`
+	Stage   string             `json:"stage"`
+	Message string             `json:"message"`
+	Metrics map[string]float64 `json:"metrics"`
+}
+
+// parseProgress returns the progress a line of output reports. ok is false
+// for lines that aren't reports; err is set for reports that don't parse.
+func parseProgress(line string, at time.Time) (p models.NodeProgress, ok bool, err error) {
+	data, ok := strings.CutPrefix(line, ProgressPrefix)
+	if !ok {
+		return models.NodeProgress{}, false, nil
+	}
+
+	var r progressReport
+	if err := json.Unmarshal([]byte(data), &r); err != nil {
+		return models.NodeProgress{}, true, err
+	}
+
+	p = models.NodeProgress{
+		Percent: -1,
+		Stage:   cut(r.Stage, maxProgressText),
+		Message: cut(r.Message, maxProgressText),
+		Time:    at,
+	}
+
+	if r.Percent != nil {
+		p.Percent = min(max(*r.Percent, 0), 100)
+	}
+
+	for k, v := range r.Metrics {
+		if len(p.Metrics) == maxProgressMetrics {
+			break
+		}
+
+		if p.Metrics == nil {
+			p.Metrics = make(map[string]float64, min(len(r.Metrics), maxProgressMetrics))
+		}
+
+		p.Metrics[cut(k, maxProgressText)] = v
+	}
+
+	return p, true, nil
+}
+
+func cut(s string, n int) string {
+	return s[:min(len(s), n)]
+}
+
+// progressReporter forwards the latest progress of a running node to the
+// controller.
+type progressReporter struct {
+	sync    syncplane.SyncLayer
+	payload syncplane.NodeProgressPayload
+
+	mu     sync.Mutex
+	latest *models.NodeProgress
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func newProgressReporter(s syncplane.SyncLayer, payload syncplane.NodeExecutePayload) *progressReporter {
+	pr := &progressReporter{
+		sync: s,
+		payload: syncplane.NodeProgressPayload{
+			RunID:       payload.RunID,
+			NodeID:      payload.Node.ID,
+			ExecutionID: payload.ExecutionID,
+			AttemptID:   payload.AttemptID,
+			Attempt:     payload.Attempt,
+		},
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+
+	go pr.run()
+
+	return pr
+}
+
+func (pr *progressReporter) run() {
+	defer close(pr.done)
+
+	ticker := time.NewTicker(progressReportInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-pr.stop:
+			pr.send()
+			return
+		case <-ticker.C:
+			pr.send()
+		}
+	}
+}
+
+// Report replaces the progress waiting to be sent.
+func (pr *progressReporter) Report(p models.NodeProgress) {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+
+	pr.latest = &p
+}
+
+func (pr *progressReporter) send() {
+	pr.mu.Lock()
+	latest := pr.latest
+	pr.latest = nil
+	pr.mu.Unlock()
+
+	if latest == nil {
+		return
+	}
+
+	payload := pr.payload
+	payload.Progress = *latest
+
+	if err := pr.sync.EnqueueNodeProgress(payload); err != nil {
+		logging.ForService("worker").Warn("could not report node progress", "run_id", payload.RunID, "node_id", payload.NodeID, "err", err)
+	}
+}
+
+// Close sends the last report, if it hasn't been sent yet.
+func (pr *progressReporter) Close() {
+	close(pr.stop)
+	<-pr.done
+}
+
+type ctxKeyProgressReporter struct{}
+
+func ctxWithProgressReporter(ctx context.Context, pr *progressReporter) context.Context {
+	return context.WithValue(ctx, ctxKeyProgressReporter{}, pr)
+}
+
+// progressReporterFromCtx returns the reporter for the running node, or nil.
+func progressReporterFromCtx(ctx context.Context) *progressReporter {
+	pr, _ := ctx.Value(ctxKeyProgressReporter{}).(*progressReporter)
+	return pr
+}
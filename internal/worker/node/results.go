@@ -0,0 +1,99 @@
+package node
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/pupload/pupload/internal/models"
+	"github.com/pupload/pupload/internal/syncplane"
+)
+
+// resultsFilename is the file in the node's work dir that ${PUPLOAD_RESULTS}
+// points at.
+const resultsFilename = "pupload-results.json"
+
+// addResultsToEnvMap points ${PUPLOAD_RESULTS} into basePath for nodes that
+// declare results.
+func (ns *NodeService) addResultsToEnvMap(env map[string]string, nodeDef models.NodeDef, basePath string) {
+	if len(nodeDef.Results) > 0 {
+		env[models.ResultsVar] = filepath.Join(basePath, resultsFilename)
+	}
+}
+
+// readResults reads the results the node wrote to path and checks them
+// against its definition. A node that declares no required results may skip
+// writing the file. A node that breaks its definition would break it again, so
+// those errors skip retries.
+func readResults(path string, defs []models.NodeResultDef) (map[string]json.RawMessage, error) {
+	if len(defs) == 0 {
+		return nil, nil
+	}
+
+	results, err := readResultsFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("results: %w: %w", err, syncplane.ErrSkipRetry)
+	}
+
+	for name, raw := range results {
+		i := findResultDef(defs, name)
+		if i < 0 {
+			return nil, fmt.Errorf("results: unknown result %s: %w", name, syncplane.ErrSkipRetry)
+		}
+
+		if err := defs[i].Check(raw); err != nil {
+			return nil, fmt.Errorf("results: %w: %w", err, syncplane.ErrSkipRetry)
+		}
+	}
+
+	for _, def := range defs {
+		if _, ok := results[def.Name]; def.Required && !ok {
+			return nil, fmt.Errorf("results: missing required result %s: %w", def.Name, syncplane.ErrSkipRetry)
+		}
+	}
+
+	return results, nil
+}
+
+func readResultsFile(path string) (map[string]json.RawMessage, error) {
+	// Written by the node, like outputs, so it mustn't point elsewhere.
+	f, err := os.OpenFile(path, os.O_RDONLY|syscall.O_NOFOLLOW, 0)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	b, err := io.ReadAll(io.LimitReader(f, models.MaxResultsSize+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(b) > models.MaxResultsSize {
+		return nil, fmt.Errorf("larger than %d bytes", models.MaxResultsSize)
+	}
+
+	var results map[string]json.RawMessage
+	if err := json.Unmarshal(b, &results); err != nil {
+		return nil, fmt.Errorf("not a JSON object: %w", err)
+	}
+
+	return results, nil
+}
+
+func findResultDef(defs []models.NodeResultDef, name string) int {
+	for i, def := range defs {
+		if def.Name == name {
+			return i
+		}
+	}
+
+	return -1
+}
@@ -0,0 +1,128 @@
+package node
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/pupload/pupload/internal/models"
+)
+
+// secretsDirName is the directory in the node's work dir that file secrets
+// are written to. It goes away with the task dir.
+const secretsDirName = ".secrets"
+
+// redacted replaces secret values in the node's logs.
+const redacted = "[REDACTED]"
+
+// preparedSecrets are a node's secrets resolved for one attempt.
+type preparedSecrets struct {
+	env    []string // KEY=value, including ${PUPLOAD_SECRETS_DIR} when there are files
+	files  []string // names written to the secrets dir in the task dir
+	values []string
+}
+
+// prepareSecrets looks the node's secrets up in the worker's providers and
+// writes file secrets into dir, the task dir on the host. basePath is where
+// the node sees its work dir.
+func (ns *NodeService) prepareSecrets(ctx context.Context, node models.Node, dir, basePath string) (preparedSecrets, error) {
+	var ps preparedSecrets
+
+	for _, s := range node.Secrets {
+		value, err := ns.Secrets.Lookup(ctx, s.Secret)
+		if err != nil {
+			return preparedSecrets{}, err
+		}
+
+		ps.values = append(ps.values, value)
+
+		if s.Env != "" {
+			ps.env = append(ps.env, s.Env+"="+value)
+		}
+
+		if s.File != "" {
+			if err := writeSecretFile(filepath.Join(dir, secretsDirName), s.File, value); err != nil {
+				return preparedSecrets{}, fmt.Errorf("secret %s: %w", s.Secret, err)
+			}
+
+			ps.files = append(ps.files, s.File)
+		}
+	}
+
+	if len(ps.files) > 0 {
+		ps.env = append(ps.env, models.SecretsDirVar+"="+filepath.Join(basePath, secretsDirName))
+	}
+
+	return ps, nil
+}
+
+// addSecretsDirToEnvMap points ${PUPLOAD_SECRETS_DIR} into basePath for nodes
+// with file secrets. Secret values themselves are never put in the command.
+func (ns *NodeService) addSecretsDirToEnvMap(env map[string]string, node models.Node, basePath string) {
+	if slices.ContainsFunc(node.Secrets, func(s models.NodeSecret) bool { return s.File != "" }) {
+		env[models.SecretsDirVar] = filepath.Join(basePath, secretsDirName)
+	}
+}
+
+// writeSecretFile writes one secret. The files are readable by anyone who can
+// reach the task dir, since the container user may differ from the worker's.
+func writeSecretFile(dir, name, value string) error {
+	if !filepath.IsLocal(name) || strings.ContainsRune(name, filepath.Separator) {
+		return fmt.Errorf("invalid secret file name %q", name)
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(filepath.Join(dir, name), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o444)
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.WriteString(value); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// envMap returns the secret variables as a map, for executors that take one.
+func (ps preparedSecrets) envMap() map[string]string {
+	m := make(map[string]string, len(ps.env))
+	for _, kv := range ps.env {
+		k, v, _ := strings.Cut(kv, "=")
+		m[k] = v
+	}
+
+	return m
+}
+
+// redact hides every secret value in s.
+func (ps preparedSecrets) redact(s string) string {
+	for _, v := range ps.values {
+		if v != "" {
+			s = strings.ReplaceAll(s, v, redacted)
+		}
+	}
+
+	return s
+}
+
+// redactLines hides every secret value in lines.
+func (ps preparedSecrets) redactLines(lines []string) []string {
+	if len(ps.values) == 0 {
+		return lines
+	}
+
+	out := make([]string, len(lines))
+	for i, line := range lines {
+		out[i] = ps.redact(line)
+	}
+
+	return out
+}
@@ -0,0 +1,118 @@
+package node
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/pupload/pupload/internal/logging"
+	"github.com/pupload/pupload/internal/models"
+	"github.com/pupload/pupload/internal/syncplane"
+	"github.com/pupload/pupload/internal/worker/transfer"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// progressInterval is how often a running transfer logs its progress.
+const progressInterval = 10 * time.Second
+
+// downloadInputs downloads every input into dir, named by its filename, and
+// checks it against its producer's record and its size limits.
+func downloadInputs(ctx context.Context, dir string, inputs []preparedIO) error {
+	l := logging.LoggerFromCtx(ctx)
+
+	g, gctx := errgroup.WithContext(ctx)
+	for _, i := range inputs {
+		g.Go(func() error {
+			path := filepath.Join(dir, i.filename)
+			if err := transfer.DownloadFile(gctx, i.url, path, logProgress(l, "downloading input", i.name)); err != nil {
+				return err
+			}
+
+			info, err := transfer.Verify(path, i.info)
+			if err != nil {
+				return fmt.Errorf("input %s: %w", i.name, err)
+			}
+
+			if err := transfer.CheckSize(info.Size, i.def); err != nil {
+				return fmt.Errorf("input %s: %w: %w", i.name, err, syncplane.ErrSkipRetry)
+			}
+
+			return nil
+		})
+	}
+
+	return g.Wait()
+}
+
+// uploadOutputs checks every output in dir against its size limits and
+// uploads it, in parts when the controller presigned a multipart upload for
+// it. It returns what it recorded about each output, by name.
+func uploadOutputs(ctx context.Context, dir string, outputs []preparedIO, multipart map[string]models.MultipartUpload) (map[string]models.ArtifactInfo, error) {
+	l := logging.LoggerFromCtx(ctx)
+
+	var mu sync.Mutex
+	infos := make(map[string]models.ArtifactInfo, len(outputs))
+
+	g, gctx := errgroup.WithContext(ctx)
+	for _, o := range outputs {
+		target := transfer.Target{URL: o.url}
+		if mp, ok := multipart[o.name]; ok {
+			target.Multipart = &mp
+		}
+
+		g.Go(func() error {
+			path := filepath.Join(dir, o.filename)
+
+			info, err := transfer.Inspect(path)
+			if err != nil {
+				return fmt.Errorf("output %s: %w", o.name, err)
+			}
+
+			if err := transfer.CheckSize(info.Size, o.def); err != nil {
+				return fmt.Errorf("output %s: %w: %w", o.name, err, syncplane.ErrSkipRetry)
+			}
+
+			if err := transfer.UploadFile(gctx, target, path, logProgress(l, "uploading output", o.name)); err != nil {
+				return err
+			}
+
+			mu.Lock()
+			infos[o.name] = info
+			mu.Unlock()
+
+			return nil
+		})
+	}
+
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+
+	return infos, nil
+}
+
+// logProgress logs a transfer's progress at most every progressInterval, so
+// large files show they're moving without flooding the job log.
+func logProgress(l *slog.Logger, msg, name string) transfer.Progress {
+	var (
+		mu   sync.Mutex
+		last time.Time
+	)
+
+	return func(done, total int64) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		finished := total >= 0 && done == total
+		if !finished && time.Since(last) < progressInterval {
+			return
+		}
+
+		last = time.Now()
+		l.Info(msg, "name", name, "bytes", done, "total_bytes", total)
+	}
+}
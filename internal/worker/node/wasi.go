@@ -0,0 +1,120 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+
+	"github.com/pupload/pupload/internal/logging"
+	"github.com/pupload/pupload/internal/resources"
+	"github.com/pupload/pupload/internal/syncplane"
+	"github.com/pupload/pupload/internal/worker/wasi"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// wasiExecutor runs the NodeDef's WebAssembly module in-process. Inputs and
+// outputs are files in a task dir the module sees at wasi.GuestDir; flags and
+// file paths are passed both in its arguments and its environment.
+type wasiExecutor struct {
+	ns *NodeService
+	ws *wasi.WASIService
+}
+
+func (e *wasiExecutor) Execute(ctx context.Context, payload syncplane.NodeExecutePayload, r *resources.Reservation) (Result, error) {
+	l := logging.LoggerFromCtx(ctx)
+	span := trace.SpanFromContext(ctx)
+
+	module, err := e.ws.LoadModule(ctx, payload.NodeDef.Module, payload.ModuleURL)
+	if err != nil {
+		return Result{}, err
+	}
+
+	dir, err := e.ws.TaskDir(fmt.Sprintf("pupload-%s-%s", payload.RunID, payload.Node.ID))
+	if err != nil {
+		return Result{}, err
+	}
+	defer os.RemoveAll(dir)
+
+	in, out, err := e.ns.prepareIO(payload, wasi.GuestDir)
+	if err != nil {
+		return Result{}, err
+	}
+
+	args, err := e.ns.generateCommand(payload.Node, payload.NodeDef, in, out, wasi.GuestDir)
+	if err != nil {
+		return Result{}, err
+	}
+
+	if len(args) == 0 {
+		args = []string{payload.NodeDef.Name}
+	}
+
+	env := make(map[string]string)
+	if err := e.ns.addEnvFlagMap(env, payload.NodeDef, payload.Node); err != nil {
+		return Result{}, err
+	}
+	e.ns.addIOToEnvMap(env, in)
+	e.ns.addIOToEnvMap(env, out)
+	e.ns.addResultsToEnvMap(env, payload.NodeDef, wasi.GuestDir)
+
+	if err := downloadInputs(ctx, dir, in); err != nil {
+		return Result{}, err
+	}
+
+	secrets, err := e.ns.prepareSecrets(ctx, payload.Node, dir, wasi.GuestDir)
+	if err != nil {
+		return Result{}, err
+	}
+	maps.Copy(env, secrets.envMap())
+
+	l.Info("files downloaded to task dir", "dir", dir)
+
+	span.AddEvent("module started")
+	res, err := e.ws.Run(ctx, wasi.WASIConfig{
+		Module: module,
+		Dir:    dir,
+		Args:   args,
+		Env:    env,
+
+		MemoryBytes: int64(r.Memory) * 1024 * 1024,
+		CPUs:        len(r.CPUs),
+
+		Output: lineOutput(nodeOutput(ctx, secrets)),
+	})
+
+	l.Debug("module logs", "logs", secrets.redactLines(res.Logs))
+
+	if errors.Is(err, wasi.ErrTimeout) {
+		// Another attempt gets the same time.
+		return Result{}, fmt.Errorf("%w: %w", err, syncplane.ErrSkipRetry)
+	}
+	if err != nil {
+		return Result{}, err
+	}
+
+	l = l.With("exit_code", res.ExitCode)
+	l.Info("module finished")
+	span.AddEvent("module finished")
+
+	if res.ExitCode != 0 {
+		return Result{}, fmt.Errorf("module exited with non-0 exit code %d", res.ExitCode)
+	}
+
+	results, err := readResults(filepath.Join(dir, resultsFilename), payload.NodeDef.Results)
+	if err != nil {
+		return Result{}, err
+	}
+
+	outputs, err := uploadOutputs(ctx, dir, out, payload.OutputMultipart)
+	if err != nil {
+		return Result{}, err
+	}
+
+	l.Info("files uploaded from task dir")
+
+	return Result{Outputs: outputs, Results: results}, nil
+}
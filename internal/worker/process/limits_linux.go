@@ -0,0 +1,258 @@
+package process
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/pupload/pupload/internal/logging"
+	"github.com/pupload/pupload/internal/resources"
+
+	"golang.org/x/sys/unix"
+)
+
+// cgroupControllers are enabled for task cgroups when the parent allows it.
+var cgroupControllers = []string{"cpu", "cpuset", "memory", "pids"}
+
+var rlimitNames = map[string]int{
+	"as":      unix.RLIMIT_AS,
+	"core":    unix.RLIMIT_CORE,
+	"cpu":     unix.RLIMIT_CPU,
+	"data":    unix.RLIMIT_DATA,
+	"fsize":   unix.RLIMIT_FSIZE,
+	"memlock": unix.RLIMIT_MEMLOCK,
+	"nofile":  unix.RLIMIT_NOFILE,
+	"nproc":   unix.RLIMIT_NPROC,
+	"stack":   unix.RLIMIT_STACK,
+}
+
+type rlimit struct {
+	resource int
+	value    uint64
+}
+
+func parseRlimits(m map[string]int64) ([]rlimit, error) {
+	out := make([]rlimit, 0, len(m))
+	for name, v := range m {
+		res, ok := rlimitNames[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown rlimit %q", name)
+		}
+
+		if v < 0 {
+			return nil, fmt.Errorf("rlimit %s can't be negative", name)
+		}
+
+		out = append(out, rlimit{res, uint64(v)})
+	}
+
+	return out, nil
+}
+
+// rlimitShim is the argv[0] the worker re-executes itself with to set a
+// task's rlimits in the child, before exec'ing the command. Setting them on
+// the started command instead would leave it running unlimited for a moment.
+const rlimitShim = "pupload-rlimit-shim"
+
+func init() {
+	if len(os.Args) > 0 && os.Args[0] == rlimitShim {
+		runRlimitShim(os.Args[1:])
+	}
+}
+
+// withRlimits makes cmd start through the shim, which sets limits and then
+// execs the command cmd would have run. cmd.Path must already be resolved.
+func withRlimits(cmd *exec.Cmd, limits []rlimit) {
+	if len(limits) == 0 {
+		return
+	}
+
+	args := []string{rlimitShim}
+	for _, l := range limits {
+		args = append(args, fmt.Sprintf("%d=%d", l.resource, l.value))
+	}
+
+	args = append(args, "--", cmd.Path)
+	cmd.Args = append(args, cmd.Args...)
+	cmd.Path = "/proc/self/exe"
+}
+
+// runRlimitShim takes [resource=value ...] -- path argv... and never
+// returns. It runs during package init, before the worker does anything.
+func runRlimitShim(args []string) {
+	sep := slices.Index(args, "--")
+	if sep < 0 || len(args) < sep+3 {
+		shimFail(fmt.Errorf("malformed arguments"))
+	}
+
+	for _, arg := range args[:sep] {
+		res, value, _ := strings.Cut(arg, "=")
+
+		r, err := strconv.Atoi(res)
+		if err != nil {
+			shimFail(fmt.Errorf("invalid rlimit %q", arg))
+		}
+
+		v, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			shimFail(fmt.Errorf("invalid rlimit %q", arg))
+		}
+
+		if err := syscall.Setrlimit(r, &syscall.Rlimit{Cur: v, Max: v}); err != nil {
+			shimFail(fmt.Errorf("unable to apply rlimit %q: %w", arg, err))
+		}
+	}
+
+	path, argv := args[sep+1], args[sep+2:]
+	shimFail(syscall.Exec(path, argv, os.Environ()))
+}
+
+func shimFail(err error) {
+	fmt.Fprintf(os.Stderr, "%s: %v\n", rlimitShim, err)
+	os.Exit(127)
+}
+
+// prepareCgroupParent enables the controllers task cgroups need. Missing ones
+// are only logged since the parent's owner may have left them off on purpose.
+func prepareCgroupParent(parent string) error {
+	if _, err := os.Stat(filepath.Join(parent, "cgroup.controllers")); err != nil {
+		return fmt.Errorf("not a cgroup v2 directory: %w", err)
+	}
+
+	control := filepath.Join(parent, "cgroup.subtree_control")
+	for _, c := range cgroupControllers {
+		os.WriteFile(control, []byte("+"+c), 0)
+	}
+
+	data, err := os.ReadFile(control)
+	if err != nil {
+		return err
+	}
+
+	enabled := strings.Fields(string(data))
+	for _, c := range cgroupControllers {
+		if !slices.Contains(enabled, c) {
+			logging.ForService("process").Warn("cgroup controller unavailable, its limit won't be enforced", "controller", c, "cgroup", parent)
+		}
+	}
+
+	return nil
+}
+
+type taskCgroup struct {
+	dir string
+	fd  *os.File
+}
+
+// createCgroup makes the task's cgroup and writes its limits. The process is
+// started directly inside it, so no limit is ever missing.
+func (ps *ProcessService) createCgroup(name string, l resources.ProcessLimits) (*taskCgroup, error) {
+	if ps.cgroup == "" {
+		return &taskCgroup{}, nil
+	}
+
+	dir := filepath.Join(ps.cgroup, name)
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("unable to create task cgroup: %w", err)
+	}
+
+	cg := &taskCgroup{dir: dir}
+
+	limits := map[string]string{
+		"memory.swap.max": "0",
+	}
+
+	if n := len(l.CPUs); n > 0 {
+		cpus := make([]string, 0, n)
+		for _, c := range l.CPUs {
+			cpus = append(cpus, strconv.Itoa(c))
+		}
+
+		limits["cpuset.cpus"] = strings.Join(cpus, ",")
+		limits["cpu.max"] = fmt.Sprintf("%d 100000", n*100000)
+	}
+
+	if l.MemoryBytes > 0 {
+		limits["memory.max"] = strconv.FormatInt(l.MemoryBytes, 10)
+	}
+
+	for file, value := range limits {
+		path := filepath.Join(dir, file)
+		if _, err := os.Stat(path); err != nil {
+			continue // controller not enabled
+		}
+
+		if err := os.WriteFile(path, []byte(value), 0); err != nil {
+			cg.remove()
+			return nil, fmt.Errorf("unable to set %s: %w", file, err)
+		}
+	}
+
+	fd, err := os.Open(dir)
+	if err != nil {
+		cg.remove()
+		return nil, err
+	}
+
+	cg.fd = fd
+	return cg, nil
+}
+
+func (cg *taskCgroup) procAttr() *syscall.SysProcAttr {
+	attr := &syscall.SysProcAttr{
+		Setpgid:   true,
+		Pdeathsig: syscall.SIGKILL,
+	}
+
+	if cg.fd != nil {
+		attr.UseCgroupFD = true
+		attr.CgroupFD = int(cg.fd.Fd())
+	}
+
+	return attr
+}
+
+// remove kills anything still running in the cgroup and deletes it.
+func (cg *taskCgroup) remove() {
+	if cg.fd != nil {
+		cg.fd.Close()
+	}
+
+	if cg.dir == "" {
+		return
+	}
+
+	os.WriteFile(filepath.Join(cg.dir, "cgroup.kill"), []byte("1"), 0)
+
+	// The kernel refuses to remove the cgroup until its processes are gone.
+	for range 20 {
+		if err := os.Remove(cg.dir); err == nil || os.IsNotExist(err) {
+			return
+		}
+
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	logging.ForService("process").Warn("unable to remove task cgroup", "cgroup", cg.dir)
+}
+
+// killGroup kills the process and everything it started in its process
+// group.
+func killGroup(p *os.Process) error {
+	if p == nil {
+		return nil
+	}
+
+	err := syscall.Kill(-p.Pid, syscall.SIGKILL)
+	if err == syscall.ESRCH {
+		return os.ErrProcessDone
+	}
+
+	return err
+}
@@ -0,0 +1,48 @@
+//go:build !linux
+
+package process
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"syscall"
+
+	"github.com/pupload/pupload/internal/resources"
+)
+
+// errUnsupported is returned when creating the process executor, which relies
+// on cgroups, rlimits and process groups as Linux has them.
+var errUnsupported = errors.New("the process executor is unsupported on this platform")
+
+type rlimit struct{}
+
+func parseRlimits(m map[string]int64) ([]rlimit, error) {
+	return nil, errUnsupported
+}
+
+func withRlimits(cmd *exec.Cmd, limits []rlimit) {}
+
+func prepareCgroupParent(parent string) error {
+	return errUnsupported
+}
+
+type taskCgroup struct{}
+
+func (ps *ProcessService) createCgroup(name string, l resources.ProcessLimits) (*taskCgroup, error) {
+	return nil, errUnsupported
+}
+
+func (cg *taskCgroup) procAttr() *syscall.SysProcAttr {
+	return nil
+}
+
+func (cg *taskCgroup) remove() {}
+
+func killGroup(p *os.Process) error {
+	if p == nil {
+		return nil
+	}
+
+	return p.Kill()
+}
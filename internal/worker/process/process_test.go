@@ -0,0 +1,139 @@
+package process
+
+import (
+	"context"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pupload/pupload/internal/worker/config"
+)
+
+func newTestProcessService(t *testing.T, rlimits map[string]int64) *ProcessService {
+	t.Helper()
+
+	ps, err := CreateProcessService(config.ProcessSettings{
+		WorkDir: t.TempDir(),
+		Rlimits: rlimits,
+	})
+	if err != nil {
+		t.Fatalf("CreateProcessService() error = %v", err)
+	}
+
+	return ps
+}
+
+func run(t *testing.T, ps *ProcessService, ctx context.Context, script string) (ProcessResult, string, error) {
+	t.Helper()
+
+	dir, err := ps.TaskDir("test")
+	if err != nil {
+		t.Fatalf("TaskDir() error = %v", err)
+	}
+
+	res, err := ps.Run(ctx, ProcessConfig{
+		Name: filepath.Base(dir),
+		Dir:  dir,
+		Cmd:  []string{"sh", "-c", script},
+	})
+
+	return res, dir, err
+}
+
+func TestRun_CapturesOutputInTaskDir(t *testing.T) {
+	t.Setenv("PUPLOAD_TEST_SECRET", "hunter2")
+	ps := newTestProcessService(t, nil)
+
+	res, dir, err := run(t, ps, context.Background(), `pwd; echo "home=$HOME"; echo "secret=$PUPLOAD_TEST_SECRET" >&2`)
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	want := []string{dir, "home=" + dir, "secret="}
+	if res.ExitCode != 0 || !slices.Equal(res.Logs, want) {
+		t.Fatalf("expected exit 0 and logs %q, got %d %q", want, res.ExitCode, res.Logs)
+	}
+}
+
+func TestRun_StreamsOutput(t *testing.T) {
+	ps := newTestProcessService(t, nil)
+
+	dir, err := ps.TaskDir("test")
+	if err != nil {
+		t.Fatalf("TaskDir() error = %v", err)
+	}
+
+	var mu sync.Mutex
+	var lines []string
+
+	_, err = ps.Run(context.Background(), ProcessConfig{
+		Name: filepath.Base(dir),
+		Dir:  dir,
+		Cmd:  []string{"sh", "-c", "echo one; echo two >&2; printf three"},
+		Output: func(stream, line string) {
+			mu.Lock()
+			defer mu.Unlock()
+			lines = append(lines, stream+": "+line)
+		},
+	})
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	slices.Sort(lines)
+	want := []string{"stderr: two", "stdout: one", "stdout: three"}
+	if !slices.Equal(lines, want) {
+		t.Fatalf("expected lines %q, got %q", want, lines)
+	}
+}
+
+func TestRun_NonZeroExit(t *testing.T) {
+	ps := newTestProcessService(t, nil)
+
+	res, _, err := run(t, ps, context.Background(), "echo failing; exit 3")
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	if res.ExitCode != 3 || !slices.Equal(res.Logs, []string{"failing"}) {
+		t.Fatalf("unexpected result %+v", res)
+	}
+}
+
+func TestRun_AppliesRlimits(t *testing.T) {
+	ps := newTestProcessService(t, map[string]int64{"nofile": 64})
+
+	// The limit is in place before the command's first instruction.
+	res, _, err := run(t, ps, context.Background(), "ulimit -n")
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	if !slices.Equal(res.Logs, []string{"64"}) {
+		t.Fatalf("expected nofile limit 64, got %q", res.Logs)
+	}
+}
+
+func TestRun_CancelKillsProcessGroup(t *testing.T) {
+	ps := newTestProcessService(t, nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	if _, _, err := run(t, ps, ctx, "sleep 30 & sleep 30; wait"); err == nil {
+		t.Fatalf("expected an error for a cancelled task")
+	}
+
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("cancelled task took %s to stop", elapsed)
+	}
+}
+
+func TestCreateProcessService_UnknownRlimit(t *testing.T) {
+	if _, err := CreateProcessService(config.ProcessSettings{WorkDir: t.TempDir(), Rlimits: map[string]int64{"files": 1}}); err == nil {
+		t.Fatalf("expected an error for an unknown rlimit")
+	}
+}
@@ -0,0 +1,85 @@
+package process
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"time"
+
+	"github.com/pupload/pupload/internal/logging"
+	"github.com/pupload/pupload/internal/resources"
+)
+
+// waitDelay is how long a task's stdout and stderr may stay open after it is
+// killed, since processes it started can hold them.
+const waitDelay = 10 * time.Second
+
+type ProcessConfig struct {
+	Name string // unique per task, names the cgroup
+	Dir  string
+	Cmd  []string
+	Env  []string
+
+	Limits resources.ProcessLimits
+
+	Output func(stream, line string) // called with each line as it is written, may be nil
+}
+
+type ProcessResult struct {
+	ExitCode int
+	Logs     []string
+}
+
+// Run starts the command in its task dir, waits for it and returns its exit
+// code and combined output. A non-zero exit is reported in the result rather
+// than as an error.
+func (ps *ProcessService) Run(ctx context.Context, cfg ProcessConfig) (ProcessResult, error) {
+	if len(cfg.Cmd) == 0 {
+		return ProcessResult{}, fmt.Errorf("empty command")
+	}
+
+	cg, err := ps.createCgroup(cfg.Name, cfg.Limits)
+	if err != nil {
+		return ProcessResult{}, err
+	}
+	defer cg.remove()
+
+	out := &logging.OutputBuffer{}
+
+	cmd := exec.CommandContext(ctx, cfg.Cmd[0], cfg.Cmd[1:]...)
+	cmd.Dir = cfg.Dir
+	cmd.Env = ps.environ(cfg.Dir, append(cfg.Limits.Env, cfg.Env...))
+	stdout, stderr, flush := logging.StreamWriters(out, cfg.Output)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	cmd.SysProcAttr = cg.procAttr()
+	cmd.Cancel = func() error { return killGroup(cmd.Process) }
+	cmd.WaitDelay = waitDelay
+
+	withRlimits(cmd, ps.rlimits)
+
+	if err := cmd.Start(); err != nil {
+		return ProcessResult{}, err
+	}
+
+	err = cmd.Wait()
+	flush()
+
+	// Anything the command left running in the background goes with it.
+	killGroup(cmd.Process)
+
+	res := ProcessResult{Logs: out.Lines()}
+
+	var exitErr *exec.ExitError
+	switch {
+	case ctx.Err() != nil:
+		return res, ctx.Err()
+	case errors.As(err, &exitErr):
+		res.ExitCode = exitErr.ExitCode()
+	case err != nil:
+		return res, err
+	}
+
+	return res, nil
+}
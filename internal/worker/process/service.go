@@ -0,0 +1,83 @@
+package process
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/pupload/pupload/internal/logging"
+	"github.com/pupload/pupload/internal/worker/config"
+)
+
+// ProcessService runs node commands as host subprocesses. Each task gets its
+// own working directory and, when a cgroup is delegated to the worker, its
+// own cgroup limited to the task's reservation.
+type ProcessService struct {
+	WorkDir string
+
+	cgroup  string // delegated cgroup v2 directory, empty when unused
+	rlimits []rlimit
+	env     []string // names of worker variables passed through
+}
+
+// CreateProcessService checks the process settings, failing on limits that
+// can't be enforced on this host.
+func CreateProcessService(cfg config.ProcessSettings) (*ProcessService, error) {
+	log := logging.ForService("process")
+
+	workDir := cfg.WorkDir
+	if workDir == "" {
+		workDir = filepath.Join(os.TempDir(), "pupload")
+	}
+
+	if err := os.MkdirAll(workDir, 0o700); err != nil {
+		return nil, fmt.Errorf("unable to create process work dir: %w", err)
+	}
+
+	rlimits, err := parseRlimits(cfg.Rlimits)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.CgroupParent != "" {
+		if err := prepareCgroupParent(cfg.CgroupParent); err != nil {
+			return nil, fmt.Errorf("unable to use cgroup %s: %w", cfg.CgroupParent, err)
+		}
+	} else {
+		log.Warn("no cgroup delegated to the process executor, tasks are only bound by rlimits")
+	}
+
+	env := slices.Clone(cfg.Env)
+	if !slices.Contains(env, "PATH") {
+		env = append(env, "PATH")
+	}
+
+	log.Info("process executor ready", "work_dir", workDir, "cgroup", cfg.CgroupParent)
+
+	return &ProcessService{
+		WorkDir: workDir,
+		cgroup:  cfg.CgroupParent,
+		rlimits: rlimits,
+		env:     env,
+	}, nil
+}
+
+// TaskDir creates an empty working directory for one task. The caller removes
+// it with os.RemoveAll once the outputs are uploaded.
+func (ps *ProcessService) TaskDir(name string) (string, error) {
+	return os.MkdirTemp(ps.WorkDir, name+"-")
+}
+
+func (ps *ProcessService) environ(dir string, extra []string) []string {
+	env := make([]string, 0, len(ps.env)+len(extra)+2)
+	for _, name := range ps.env {
+		if v, ok := os.LookupEnv(name); ok {
+			env = append(env, name+"="+v)
+		}
+	}
+
+	env = append(env, "HOME="+dir, "TMPDIR="+dir)
+
+	return append(env, extra...)
+}
@@ -1,21 +1,27 @@
 package server
 
 import (
+	"context"
 	"fmt"
 
+	"github.com/pupload/pupload/internal/imagepolicy"
 	"github.com/pupload/pupload/internal/resources"
+	"github.com/pupload/pupload/internal/secrets"
 	"github.com/pupload/pupload/internal/syncplane"
-	"github.com/pupload/pupload/internal/worker/container"
+	"github.com/pupload/pupload/internal/worker/config"
 	"github.com/pupload/pupload/internal/worker/node"
 )
 
-func NewWorkerServer(s syncplane.SyncLayer, cs *container.ContainerService, rm *resources.ResourceManager) {
+func NewWorkerServer(ctx context.Context, s syncplane.SyncLayer, ex node.Executors, rm *resources.ResourceManager, policy *imagepolicy.Policy, resolver *secrets.Resolver, cfg config.WorkerSettings) {
 
-	ns, err := node.CreateNodeService(cs, s, rm)
+	ns, err := node.CreateNodeService(ex, s, rm, policy, resolver, cfg)
 	if err != nil {
 		panic(fmt.Sprintf("Unable to create node service: %s", err))
 	}
 
+	go ns.Heartbeat(ctx)
+	go ns.ManageImages(ctx)
+
 	s.RegisterExecuteNodeHandler(ns.FinishedMiddleware)
 	s.Start()
 }
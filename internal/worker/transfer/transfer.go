@@ -0,0 +1,321 @@
+// Package transfer moves node inputs and outputs between presigned store URLs
+// and files on the worker. Transfers stream straight to and from disk, so
+// their size is only bounded by the disk.
+package transfer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const maxAttempts = 5
+
+var (
+	// idleTimeout fails a transfer when no bytes move for this long. Large
+	// transfers may take as long as they need as long as they make progress.
+	idleTimeout = 2 * time.Minute
+
+	// retryBackoff is the wait before the first retry; it doubles after each.
+	retryBackoff = time.Second
+)
+
+// ErrStalled is the cause of a transfer cancelled for making no progress.
+var ErrStalled = errors.New("transfer stalled")
+
+// Progress is called as bytes move. total is -1 when the size isn't known.
+type Progress func(done, total int64)
+
+// DownloadFile fetches url into path, which must not exist yet. Chunked
+// responses are fine. An interrupted download resumes with a range request
+// when the server supports it and starts over when it doesn't.
+func DownloadFile(ctx context.Context, url, path string, progress Progress) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return fmt.Errorf("DownloadFile: %w", err)
+	}
+
+	d := &download{url: url, f: f, total: -1, progress: progress}
+
+	err = retry(ctx, d.fetch)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		return fmt.Errorf("DownloadFile: %w", err)
+	}
+
+	return nil
+}
+
+type download struct {
+	url      string
+	f        *os.File
+	written  int64
+	total    int64
+	etag     string
+	progress Progress
+}
+
+// fetch requests whatever is missing from the file. The returned bool is
+// whether the error is worth another attempt.
+func (d *download) fetch(ctx context.Context) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, d.url, nil)
+	if err != nil {
+		return false, err
+	}
+
+	if d.written > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", d.written))
+
+		// Without a match the server sends the whole, changed object.
+		if d.etag != "" {
+			req.Header.Set("If-Range", d.etag)
+		}
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusPartialContent && d.written > 0:
+		start, total, ok := parseContentRange(resp.Header.Get("Content-Range"))
+		if !ok || start != d.written {
+			d.restart()
+			return true, fmt.Errorf("unexpected Content-Range %q", resp.Header.Get("Content-Range"))
+		}
+
+		d.total = total
+
+	case resp.StatusCode >= 200 && resp.StatusCode <= 299:
+		// The first request, or the server ignored the range.
+		if err := d.restart(); err != nil {
+			return false, err
+		}
+
+		d.total = resp.ContentLength
+		d.etag = resp.Header.Get("ETag")
+
+	default:
+		return retryable(resp.StatusCode), statusError(resp)
+	}
+
+	if _, err := d.f.Seek(d.written, io.SeekStart); err != nil {
+		return false, err
+	}
+
+	m := newMeter(resp.Body, d.written, d.total, d.progress)
+	stop := m.watch(ctx, resp.Body)
+	defer stop()
+
+	n, err := io.Copy(d.f, m)
+	d.written += n
+
+	if err != nil {
+		return true, m.err(err)
+	}
+
+	if d.total >= 0 && d.written != d.total {
+		return true, fmt.Errorf("got %d of %d bytes: %w", d.written, d.total, io.ErrUnexpectedEOF)
+	}
+
+	return false, nil
+}
+
+func (d *download) restart() error {
+	d.written = 0
+	return d.f.Truncate(0)
+}
+
+// parseContentRange parses "bytes <start>-<end>/<total>", where total may be
+// "*".
+func parseContentRange(s string) (start, total int64, ok bool) {
+	rng, ok := strings.CutPrefix(s, "bytes ")
+	if !ok {
+		return 0, 0, false
+	}
+
+	rng, size, ok := strings.Cut(rng, "/")
+	if !ok {
+		return 0, 0, false
+	}
+
+	first, _, ok := strings.Cut(rng, "-")
+	if !ok {
+		return 0, 0, false
+	}
+
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+
+	if size == "*" {
+		return start, -1, true
+	}
+
+	total, err = strconv.ParseInt(size, 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+
+	return start, total, true
+}
+
+// ReadURL fetches url into memory, failing if the body is larger than limit
+// bytes.
+func ReadURL(ctx context.Context, url string, limit int64) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("ReadURL: %w", err)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("ReadURL: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("ReadURL: %w", statusError(resp))
+	}
+
+	b, err := io.ReadAll(io.LimitReader(resp.Body, limit+1))
+	if err != nil {
+		return nil, fmt.Errorf("ReadURL: %w", err)
+	}
+
+	if int64(len(b)) > limit {
+		return nil, fmt.Errorf("ReadURL: body larger than %d bytes", limit)
+	}
+
+	return b, nil
+}
+
+// retry runs attempt until it succeeds, fails for good or runs out of
+// attempts, backing off between tries.
+func retry(ctx context.Context, attempt func(context.Context) (bool, error)) error {
+	backoff := retryBackoff
+
+	var err error
+	for i := range maxAttempts {
+		if i > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(backoff):
+			}
+
+			backoff *= 2
+		}
+
+		var again bool
+		again, err = attempt(ctx)
+		if err == nil {
+			return nil
+		}
+
+		if !again || ctx.Err() != nil {
+			return err
+		}
+	}
+
+	return fmt.Errorf("giving up after %d attempts: %w", maxAttempts, err)
+}
+
+func retryable(status int) bool {
+	return status == http.StatusTooManyRequests || status >= 500
+}
+
+func statusError(resp *http.Response) error {
+	b, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	return fmt.Errorf("%s %s returned %d: %s", resp.Request.Method, redact(resp.Request.URL.String()), resp.StatusCode, string(b))
+}
+
+// redact drops the query from a presigned URL so its signature isn't logged.
+func redact(url string) string {
+	base, _, _ := strings.Cut(url, "?")
+	return base
+}
+
+// meter counts the bytes read through it, reports progress and notes when
+// bytes last moved so a stalled transfer can be failed.
+type meter struct {
+	r        io.Reader
+	done     int64
+	total    int64
+	progress Progress
+	idle     time.Duration
+
+	last    atomic.Int64 // unix nanos
+	stalled atomic.Bool
+}
+
+func newMeter(r io.Reader, done, total int64, progress Progress) *meter {
+	m := &meter{r: r, done: done, total: total, progress: progress, idle: idleTimeout}
+	m.last.Store(time.Now().UnixNano())
+
+	return m
+}
+
+func (m *meter) Read(p []byte) (int, error) {
+	n, err := m.r.Read(p)
+	if n > 0 {
+		m.done += int64(n)
+		m.last.Store(time.Now().UnixNano())
+
+		if m.progress != nil {
+			m.progress(m.done, m.total)
+		}
+	}
+
+	return n, err
+}
+
+// watch closes c once no bytes have moved for the idle timeout, which
+// unblocks a read or write stuck on it. Call the returned func when the transfer ends.
+func (m *meter) watch(ctx context.Context, c io.Closer) func() {
+	done := make(chan struct{})
+
+	go func() {
+		ticker := time.NewTicker(max(m.idle/4, 10*time.Millisecond))
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if time.Since(time.Unix(0, m.last.Load())) > m.idle {
+					m.stalled.Store(true)
+					c.Close()
+					return
+				}
+			}
+		}
+	}()
+
+	return func() { close(done) }
+}
+
+// err explains a failed transfer, which is ErrStalled when watch closed it.
+func (m *meter) err(err error) error {
+	if m.stalled.Load() {
+		return fmt.Errorf("%w: no data for %s", ErrStalled, m.idle)
+	}
+
+	return err
+}
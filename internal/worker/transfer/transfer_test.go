@@ -0,0 +1,254 @@
+package transfer
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pupload/pupload/internal/stores/hosted"
+	locals3 "github.com/pupload/pupload/internal/stores/local_s3"
+)
+
+func init() {
+	retryBackoff = time.Millisecond
+}
+
+func TestFileIO_RoundTrip(t *testing.T) {
+	var uploaded, contentMD5 string
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			io.WriteString(w, "input data")
+		case http.MethodPut:
+			b, _ := io.ReadAll(r.Body)
+			uploaded = string(b)
+			contentMD5 = r.Header.Get("Content-MD5")
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "in.txt")
+
+	if err := DownloadFile(ctx, srv.URL, path, nil); err != nil {
+		t.Fatalf("DownloadFile() error = %v", err)
+	}
+
+	if b, _ := os.ReadFile(path); string(b) != "input data" {
+		t.Fatalf("unexpected download %q", b)
+	}
+
+	var done, total int64
+	progress := func(d, t int64) { done, total = d, t }
+
+	if err := UploadFile(ctx, Target{URL: srv.URL}, path, progress); err != nil {
+		t.Fatalf("UploadFile() error = %v", err)
+	}
+
+	if uploaded != "input data" {
+		t.Fatalf("unexpected upload %q", uploaded)
+	}
+
+	if sum := md5.Sum([]byte("input data")); contentMD5 != base64.StdEncoding.EncodeToString(sum[:]) {
+		t.Fatalf("unexpected Content-MD5 %q", contentMD5)
+	}
+
+	if done != 10 || total != 10 {
+		t.Fatalf("expected progress 10/10, got %d/%d", done, total)
+	}
+
+	if err := UploadFile(ctx, Target{URL: srv.URL}, path+".missing", nil); err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Fatalf("expected a missing output error, got %v", err)
+	}
+}
+
+func TestDownloadFile_Chunked(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for i := range 3 {
+			fmt.Fprintf(w, "chunk %d;", i)
+			w.(http.Flusher).Flush()
+		}
+	}))
+	defer srv.Close()
+
+	path := filepath.Join(t.TempDir(), "in.txt")
+
+	var total int64
+	progress := func(_, t int64) { total = t }
+
+	if err := DownloadFile(context.Background(), srv.URL, path, progress); err != nil {
+		t.Fatalf("DownloadFile() error = %v", err)
+	}
+
+	if b, _ := os.ReadFile(path); string(b) != "chunk 0;chunk 1;chunk 2;" {
+		t.Fatalf("unexpected download %q", b)
+	}
+
+	if total != -1 {
+		t.Fatalf("expected an unknown total, got %d", total)
+	}
+}
+
+// flakyServer serves data but drops the connection halfway through the first
+// response. With ranges it answers range requests like S3 does.
+func flakyServer(t *testing.T, data []byte, ranges bool) (*httptest.Server, *[]string) {
+	t.Helper()
+
+	var rangeHeaders []string
+	first := true
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rangeHeaders = append(rangeHeaders, r.Header.Get("Range"))
+		w.Header().Set("ETag", `"v1"`)
+
+		start := 0
+		if rng := r.Header.Get("Range"); ranges && rng != "" {
+			fmt.Sscanf(rng, "bytes=%d-", &start)
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, len(data)-1, len(data)))
+			w.Header().Set("Content-Length", fmt.Sprint(len(data)-start))
+			w.WriteHeader(http.StatusPartialContent)
+		} else {
+			w.Header().Set("Content-Length", fmt.Sprint(len(data)))
+		}
+
+		if first {
+			first = false
+			w.Write(data[:len(data)/2])
+			w.(http.Flusher).Flush()
+			panic(http.ErrAbortHandler)
+		}
+
+		w.Write(data[start:])
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv, &rangeHeaders
+}
+
+func TestDownloadFile_ResumesWithRange(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789"), 1000)
+	srv, rangeHeaders := flakyServer(t, data, true)
+
+	path := filepath.Join(t.TempDir(), "in.bin")
+	if err := DownloadFile(context.Background(), srv.URL, path, nil); err != nil {
+		t.Fatalf("DownloadFile() error = %v", err)
+	}
+
+	if b, _ := os.ReadFile(path); !bytes.Equal(b, data) {
+		t.Fatalf("download differs from source: %d bytes", len(b))
+	}
+
+	if len(*rangeHeaders) != 2 || (*rangeHeaders)[1] != fmt.Sprintf("bytes=%d-", len(data)/2) {
+		t.Fatalf("expected a resumed range request, got %q", *rangeHeaders)
+	}
+}
+
+func TestDownloadFile_RestartsWhenRangeIgnored(t *testing.T) {
+	data := bytes.Repeat([]byte("abcdefghij"), 1000)
+	srv, rangeHeaders := flakyServer(t, data, false)
+
+	path := filepath.Join(t.TempDir(), "in.bin")
+	if err := DownloadFile(context.Background(), srv.URL, path, nil); err != nil {
+		t.Fatalf("DownloadFile() error = %v", err)
+	}
+
+	if b, _ := os.ReadFile(path); !bytes.Equal(b, data) {
+		t.Fatalf("download differs from source: %d bytes", len(b))
+	}
+
+	if len(*rangeHeaders) != 2 {
+		t.Fatalf("expected two requests, got %q", *rangeHeaders)
+	}
+}
+
+func TestDownloadFile_Stalled(t *testing.T) {
+	defer func(d time.Duration) { idleTimeout = d }(idleTimeout)
+	idleTimeout = 50 * time.Millisecond
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "100")
+		w.Write([]byte("partial"))
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+
+	path := filepath.Join(t.TempDir(), "in.bin")
+	err := DownloadFile(context.Background(), srv.URL, path, nil)
+	if err == nil || !strings.Contains(err.Error(), ErrStalled.Error()) {
+		t.Fatalf("expected a stalled transfer, got %v", err)
+	}
+}
+
+func TestUploadFile_Multipart(t *testing.T) {
+	defer func(threshold, part int64) { multipartThreshold, minPartSize = threshold, part }(multipartThreshold, minPartSize)
+	multipartThreshold = 1 << 20
+	minPartSize = 5 << 20 // S3's minimum for all but the last part
+
+	srv := httptest.NewServer(hosted.Handler())
+	defer srv.Close()
+
+	if err := hosted.Configure(hosted.Settings{PublicURL: srv.URL}); err != nil {
+		t.Fatalf("Configure() error = %v", err)
+	}
+
+	store, err := locals3.NewLocalS3Store(locals3.LocalS3StoreInput{BucketName: "test-bucket"})
+	if err != nil {
+		t.Fatalf("NewLocalS3Store() error = %v", err)
+	}
+
+	ctx := context.Background()
+
+	data := bytes.Repeat([]byte("pupload!"), (12<<20)/8)
+	path := filepath.Join(t.TempDir(), "out.bin")
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	putURL, err := store.PutURL(ctx, "out.bin", time.Minute)
+	if err != nil {
+		t.Fatalf("PutURL() error = %v", err)
+	}
+
+	mp, err := store.PresignMultipart(ctx, "out.bin", 16, time.Minute)
+	if err != nil {
+		t.Fatalf("PresignMultipart() error = %v", err)
+	}
+
+	var done int64
+	progress := func(d, _ int64) { done = d }
+
+	if err := UploadFile(ctx, Target{URL: putURL.String(), Multipart: mp}, path, progress); err != nil {
+		t.Fatalf("UploadFile() error = %v", err)
+	}
+
+	if done != int64(len(data)) {
+		t.Fatalf("expected progress to reach %d, got %d", len(data), done)
+	}
+
+	getURL, err := store.GetURL(ctx, "out.bin", time.Minute)
+	if err != nil {
+		t.Fatalf("GetURL() error = %v", err)
+	}
+
+	got := filepath.Join(t.TempDir(), "got.bin")
+	if err := DownloadFile(ctx, getURL.String(), got, nil); err != nil {
+		t.Fatalf("DownloadFile() error = %v", err)
+	}
+
+	if b, _ := os.ReadFile(got); !bytes.Equal(b, data) {
+		t.Fatalf("stored object differs from upload: %d bytes", len(b))
+	}
+}
@@ -0,0 +1,278 @@
+package transfer
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"syscall"
+
+	"github.com/pupload/pupload/internal/models"
+
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	maxPartSize     = 5 << 30 // S3's limit
+	partConcurrency = 4
+)
+
+var (
+	// Files from multipartThreshold up are uploaded in parts when the target
+	// allows it.
+	multipartThreshold int64 = 64 << 20
+
+	// minPartSize keeps parts well above S3's 5MiB minimum.
+	minPartSize int64 = 16 << 20
+)
+
+// Target is where an output goes: a presigned PUT, and optionally a presigned
+// multipart upload used for large files.
+type Target struct {
+	URL       string
+	Multipart *models.MultipartUpload
+}
+
+// UploadFile uploads the file at path. Large files are split into parts when
+// the target has a multipart upload, so a failure only retries one part;
+// otherwise the whole file is retried.
+func UploadFile(ctx context.Context, t Target, path string, progress Progress) error {
+	// Outputs are written by the node, which mustn't be able to point the
+	// worker at another file on the host.
+	f, err := os.OpenFile(path, os.O_RDONLY|syscall.O_NOFOLLOW, 0)
+	if err != nil {
+		return fmt.Errorf("output %s not found: %w", path, err)
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("output %s is not a regular file", path)
+	}
+
+	size := info.Size()
+	tracker := newTracker(size, progress)
+
+	if t.Multipart != nil && size >= multipartThreshold {
+		if err := uploadParts(ctx, *t.Multipart, f, size, tracker); err != nil {
+			abort(*t.Multipart)
+			return fmt.Errorf("UploadFile: %w", err)
+		}
+
+		return nil
+	}
+
+	err = retry(ctx, func(ctx context.Context) (bool, error) {
+		_, again, err := put(ctx, t.URL, io.NewSectionReader(f, 0, size), tracker.part(0))
+		return again, err
+	})
+	if err != nil {
+		return fmt.Errorf("UploadFile: %w", err)
+	}
+
+	if t.Multipart != nil {
+		abort(*t.Multipart)
+	}
+
+	return nil
+}
+
+// uploadParts splits the file across as few of the presigned parts as it can
+// and completes the upload once every part is stored.
+func uploadParts(ctx context.Context, mp models.MultipartUpload, f *os.File, size int64, tracker *tracker) error {
+	if len(mp.PartURLs) == 0 {
+		return fmt.Errorf("multipart upload has no parts")
+	}
+
+	partSize := max(minPartSize, ceilDiv(size, int64(len(mp.PartURLs))))
+	if partSize > maxPartSize {
+		return fmt.Errorf("output of %d bytes doesn't fit in %d parts", size, len(mp.PartURLs))
+	}
+
+	n := int(ceilDiv(size, partSize))
+	etags := make([]string, n)
+
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(partConcurrency)
+
+	for i := range n {
+		off := int64(i) * partSize
+		length := min(partSize, size-off)
+
+		g.Go(func() error {
+			return retry(gctx, func(ctx context.Context) (bool, error) {
+				etag, again, err := put(ctx, mp.PartURLs[i], io.NewSectionReader(f, off, length), tracker.part(i))
+				etags[i] = etag
+				return again, err
+			})
+		})
+	}
+
+	if err := g.Wait(); err != nil {
+		return err
+	}
+
+	return retry(ctx, func(ctx context.Context) (bool, error) {
+		return complete(ctx, mp.CompleteURL, etags)
+	})
+}
+
+// put sends body with a single PUT and returns the ETag of what was stored.
+// The store checks the body against its Content-MD5.
+func put(ctx context.Context, url string, body *io.SectionReader, progress Progress) (string, bool, error) {
+	sum := md5.New()
+	if _, err := io.Copy(sum, body); err != nil {
+		return "", false, err
+	}
+
+	if _, err := body.Seek(0, io.SeekStart); err != nil {
+		return "", false, err
+	}
+
+	m := newMeter(body, 0, body.Size(), progress)
+	progress(0, body.Size())
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	stop := m.watch(ctx, closerFunc(cancel))
+	defer stop()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, url, m)
+	if err != nil {
+		return "", false, err
+	}
+
+	req.ContentLength = body.Size()
+	req.Header.Set("Content-MD5", base64.StdEncoding.EncodeToString(sum.Sum(nil)))
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", true, m.err(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		return "", retryable(resp.StatusCode), statusError(resp)
+	}
+
+	return resp.Header.Get("ETag"), false, nil
+}
+
+type completePart struct {
+	PartNumber int
+	ETag       string
+}
+
+type completeUpload struct {
+	XMLName xml.Name       `xml:"CompleteMultipartUpload"`
+	Parts   []completePart `xml:"Part"`
+}
+
+func complete(ctx context.Context, url string, etags []string) (bool, error) {
+	body := completeUpload{Parts: make([]completePart, 0, len(etags))}
+	for i, etag := range etags {
+		body.Parts = append(body.Parts, completePart{PartNumber: i + 1, ETag: etag})
+	}
+
+	b, err := xml.Marshal(body)
+	if err != nil {
+		return false, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(b))
+	if err != nil {
+		return false, err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return retryable(resp.StatusCode), statusError(resp)
+	}
+
+	// S3 can report a failed completion in the body of a 200.
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if err != nil {
+		return true, err
+	}
+
+	if bytes.Contains(respBody, []byte("<Error>")) {
+		return true, fmt.Errorf("completing multipart upload: %s", respBody)
+	}
+
+	return false, nil
+}
+
+// abort cancels the multipart upload so the store drops its parts. It is
+// best effort; buckets should also expire incomplete uploads.
+func abort(mp models.MultipartUpload) {
+	if mp.AbortURL == "" {
+		return
+	}
+
+	req, err := http.NewRequest(http.MethodDelete, mp.AbortURL, nil)
+	if err != nil {
+		return
+	}
+
+	if resp, err := http.DefaultClient.Do(req); err == nil {
+		resp.Body.Close()
+	}
+}
+
+// tracker sums the progress of parts uploaded at once, restarting a part's
+// count when it is retried.
+type tracker struct {
+	mu       sync.Mutex
+	total    int64
+	parts    map[int]int64
+	progress Progress
+}
+
+func newTracker(total int64, progress Progress) *tracker {
+	return &tracker{total: total, parts: make(map[int]int64), progress: progress}
+}
+
+func (t *tracker) part(i int) Progress {
+	return func(done, _ int64) {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+
+		t.parts[i] = done
+
+		if t.progress != nil {
+			var sum int64
+			for _, n := range t.parts {
+				sum += n
+			}
+
+			t.progress(sum, t.total)
+		}
+	}
+}
+
+type closerFunc func()
+
+func (f closerFunc) Close() error {
+	f()
+	return nil
+}
+
+func ceilDiv(a, b int64) int64 {
+	return (a + b - 1) / b
+}
@@ -0,0 +1,85 @@
+package transfer
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"syscall"
+
+	"github.com/pupload/pupload/internal/models"
+)
+
+var (
+	// ErrChecksumMismatch means a file isn't what its producer recorded.
+	ErrChecksumMismatch = errors.New("checksum mismatch")
+
+	// ErrSizeLimit means a file is outside its edge's size limits.
+	ErrSizeLimit = errors.New("size limit")
+)
+
+// Inspect reads the file at path once to record its size, SHA-256 and MIME
+// type, sniffed from its first 512 bytes like inputs are.
+func Inspect(path string) (models.ArtifactInfo, error) {
+	f, err := os.OpenFile(path, os.O_RDONLY|syscall.O_NOFOLLOW, 0)
+	if err != nil {
+		return models.ArtifactInfo{}, fmt.Errorf("Inspect: %w", err)
+	}
+	defer f.Close()
+
+	head := make([]byte, 512)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return models.ArtifactInfo{}, fmt.Errorf("Inspect: %w", err)
+	}
+	head = head[:n]
+
+	h := sha256.New()
+	h.Write(head)
+
+	rest, err := io.Copy(h, f)
+	if err != nil {
+		return models.ArtifactInfo{}, fmt.Errorf("Inspect: %w", err)
+	}
+
+	return models.ArtifactInfo{
+		Size:     int64(n) + rest,
+		SHA256:   hex.EncodeToString(h.Sum(nil)),
+		MimeType: models.MimeType(http.DetectContentType(head)),
+	}, nil
+}
+
+// Verify inspects the file at path and compares it with what its producer
+// recorded, skipping whatever want leaves empty.
+func Verify(path string, want models.ArtifactInfo) (models.ArtifactInfo, error) {
+	got, err := Inspect(path)
+	if err != nil {
+		return got, err
+	}
+
+	if want.Size > 0 && got.Size != want.Size {
+		return got, fmt.Errorf("%w: got %d bytes, expected %d", ErrChecksumMismatch, got.Size, want.Size)
+	}
+
+	if want.SHA256 != "" && got.SHA256 != want.SHA256 {
+		return got, fmt.Errorf("%w: got sha256 %s, expected %s", ErrChecksumMismatch, got.SHA256, want.SHA256)
+	}
+
+	return got, nil
+}
+
+// CheckSize enforces an edge's size limits.
+func CheckSize(size int64, def models.NodeEdgeDef) error {
+	if def.MinSize > 0 && size < def.MinSize {
+		return fmt.Errorf("%w: %d bytes is below the minimum of %d", ErrSizeLimit, size, def.MinSize)
+	}
+
+	if def.MaxSize > 0 && size > def.MaxSize {
+		return fmt.Errorf("%w: %d bytes is above the maximum of %d", ErrSizeLimit, size, def.MaxSize)
+	}
+
+	return nil
+}
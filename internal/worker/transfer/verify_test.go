@@ -0,0 +1,76 @@
+package transfer
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pupload/pupload/internal/models"
+)
+
+func TestVerify(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "frame.png")
+	png := append([]byte("\x89PNG\r\n\x1a\n"), make([]byte, 100)...)
+	if err := os.WriteFile(path, png, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := Inspect(path)
+	if err != nil {
+		t.Fatalf("Inspect() error = %v", err)
+	}
+
+	if info.Size != int64(len(png)) || info.MimeType != "image/png" || len(info.SHA256) != 64 {
+		t.Fatalf("unexpected info %+v", info)
+	}
+
+	if _, err := Verify(path, info); err != nil {
+		t.Fatalf("Verify() error = %v", err)
+	}
+
+	if _, err := Verify(path, models.ArtifactInfo{}); err != nil {
+		t.Fatalf("expected nothing to check without a record, got %v", err)
+	}
+
+	corrupt := info
+	corrupt.SHA256 = "00" + info.SHA256[2:]
+	if _, err := Verify(path, corrupt); !errors.Is(err, ErrChecksumMismatch) {
+		t.Fatalf("expected ErrChecksumMismatch, got %v", err)
+	}
+
+	truncated := info
+	truncated.Size++
+	if _, err := Verify(path, truncated); !errors.Is(err, ErrChecksumMismatch) {
+		t.Fatalf("expected ErrChecksumMismatch for a size mismatch, got %v", err)
+	}
+
+	link := filepath.Join(t.TempDir(), "link.png")
+	if err := os.Symlink(path, link); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Inspect(link); err == nil {
+		t.Fatalf("expected Inspect to refuse a symlink")
+	}
+}
+
+func TestCheckSize(t *testing.T) {
+	def := models.NodeEdgeDef{Name: "video", MinSize: 10, MaxSize: 100}
+
+	for _, size := range []int64{10, 50, 100} {
+		if err := CheckSize(size, def); err != nil {
+			t.Fatalf("CheckSize(%d) error = %v", size, err)
+		}
+	}
+
+	for _, size := range []int64{0, 9, 101} {
+		if err := CheckSize(size, def); !errors.Is(err, ErrSizeLimit) {
+			t.Fatalf("CheckSize(%d): expected ErrSizeLimit, got %v", size, err)
+		}
+	}
+
+	if err := CheckSize(1<<40, models.NodeEdgeDef{}); err != nil {
+		t.Fatalf("expected no limit by default, got %v", err)
+	}
+}
@@ -0,0 +1,124 @@
+package wasi
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/pupload/pupload/internal/logging"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+	"github.com/tetratelabs/wazero/sys"
+)
+
+// GuestDir is where the task dir is preopened inside the module.
+const GuestDir = "/work"
+
+const (
+	wasmPageBytes = 64 << 10
+	maxWasmPages  = 65536 // 4GiB, the most a 32-bit module can address
+)
+
+// ErrTimeout is returned when a module runs past its tier's time limit.
+var ErrTimeout = errors.New("module ran out of time")
+
+type WASIConfig struct {
+	Module []byte
+	Dir    string // mounted read-write at GuestDir
+	Args   []string
+	Env    map[string]string
+
+	MemoryBytes int64 // linear memory limit, zero for the 4GiB maximum
+	CPUs        int   // scales the time limit
+
+	Output func(stream, line string) // called with each line as it is written, may be nil
+}
+
+type WASIResult struct {
+	ExitCode int
+	Logs     []string
+}
+
+// Run instantiates the module, which runs its _start function to completion.
+// The module can only reach its own dir, has no network and no access to the
+// worker's environment. A non-zero exit is reported in the result rather than
+// as an error.
+func (ws *WASIService) Run(ctx context.Context, cfg WASIConfig) (WASIResult, error) {
+	// A runtime per task, since the memory limit is set per runtime. The
+	// shared cache keeps that cheap.
+	r := wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfig().
+		WithCompilationCache(ws.cache).
+		WithCloseOnContextDone(true).
+		WithMemoryLimitPages(memoryPages(cfg.MemoryBytes)))
+	defer r.Close(context.Background())
+
+	if _, err := wasi_snapshot_preview1.Instantiate(ctx, r); err != nil {
+		return WASIResult{}, err
+	}
+
+	compiled, err := r.CompileModule(ctx, cfg.Module)
+	if err != nil {
+		return WASIResult{}, fmt.Errorf("invalid module: %w", err)
+	}
+
+	// The clock only starts once the module runs, not while it compiles.
+	runCtx := ctx
+	if timeout := ws.timeout(cfg.CPUs); timeout > 0 {
+		var cancel context.CancelFunc
+		runCtx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	out := &logging.OutputBuffer{}
+	stdout, stderr, flush := logging.StreamWriters(out, cfg.Output)
+
+	mcfg := wazero.NewModuleConfig().
+		WithArgs(cfg.Args...).
+		WithStdout(stdout).
+		WithStderr(stderr).
+		WithFSConfig(wazero.NewFSConfig().WithDirMount(cfg.Dir, GuestDir)).
+		WithSysWalltime().
+		WithSysNanotime().
+		WithSysNanosleep().
+		WithRandSource(rand.Reader)
+
+	for _, k := range slices.Sorted(maps.Keys(cfg.Env)) {
+		mcfg = mcfg.WithEnv(k, cfg.Env[k])
+	}
+
+	_, err = r.InstantiateModule(runCtx, compiled, mcfg)
+	flush()
+
+	res := WASIResult{Logs: out.Lines()}
+
+	var exitErr *sys.ExitError
+	switch {
+	case ctx.Err() != nil:
+		return res, ctx.Err()
+	case errors.As(err, &exitErr) && exitErr.ExitCode() == sys.ExitCodeDeadlineExceeded:
+		return res, ErrTimeout
+	case errors.As(err, &exitErr):
+		res.ExitCode = int(exitErr.ExitCode())
+	case err != nil:
+		return res, fmt.Errorf("module trapped: %w", err)
+	}
+
+	return res, nil
+}
+
+func (ws *WASIService) timeout(cpus int) time.Duration {
+	return ws.timeoutPerCPU * time.Duration(max(cpus, 1))
+}
+
+func memoryPages(bytes int64) uint32 {
+	if bytes <= 0 {
+		return maxWasmPages
+	}
+
+	return uint32(min(max(bytes/wasmPageBytes, 1), maxWasmPages))
+}
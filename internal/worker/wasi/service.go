@@ -0,0 +1,115 @@
+package wasi
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pupload/pupload/internal/logging"
+	"github.com/pupload/pupload/internal/worker/config"
+	"github.com/pupload/pupload/internal/worker/transfer"
+
+	"github.com/tetratelabs/wazero"
+)
+
+// maxModuleBytes bounds the size of a module read from disk or a store.
+const maxModuleBytes = 64 << 20
+
+// WASIService runs NodeDefs' WebAssembly modules. Compiled code is cached by
+// module content, so repeated runs of a node skip compilation.
+type WASIService struct {
+	WorkDir string
+
+	moduleDir     string
+	timeoutPerCPU time.Duration // zero for no limit
+	cache         wazero.CompilationCache
+}
+
+func CreateWASIService(cfg config.WASISettings) (*WASIService, error) {
+	log := logging.ForService("wasi")
+
+	workDir := cfg.WorkDir
+	if workDir == "" {
+		workDir = filepath.Join(os.TempDir(), "pupload-wasi")
+	}
+
+	if err := os.MkdirAll(workDir, 0o700); err != nil {
+		return nil, fmt.Errorf("unable to create wasi work dir: %w", err)
+	}
+
+	var timeout time.Duration
+	if cfg.TimeoutPerCPU != "" {
+		d, err := time.ParseDuration(cfg.TimeoutPerCPU)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid wasi timeout_per_cpu %q", cfg.TimeoutPerCPU)
+		}
+
+		timeout = d
+	} else {
+		log.Warn("no time limit set, wasi modules may run until their node is cancelled")
+	}
+
+	if cfg.ModuleDir != "" {
+		if info, err := os.Stat(cfg.ModuleDir); err != nil || !info.IsDir() {
+			return nil, fmt.Errorf("wasi module dir %s is not a directory", cfg.ModuleDir)
+		}
+	}
+
+	log.Info("wasi executor ready", "work_dir", workDir, "module_dir", cfg.ModuleDir, "timeout_per_cpu", timeout)
+
+	return &WASIService{
+		WorkDir:       workDir,
+		moduleDir:     cfg.ModuleDir,
+		timeoutPerCPU: timeout,
+		cache:         wazero.NewCompilationCache(),
+	}, nil
+}
+
+// TaskDir creates an empty working directory for one task. The caller removes
+// it with os.RemoveAll once the outputs are uploaded.
+func (ws *WASIService) TaskDir(name string) (string, error) {
+	return os.MkdirTemp(ws.WorkDir, name+"-")
+}
+
+// LoadModule reads a module from url when it is set, and otherwise from path
+// inside the module dir. Paths can't escape the module dir.
+func (ws *WASIService) LoadModule(ctx context.Context, path, url string) ([]byte, error) {
+	if url != "" {
+		return transfer.ReadURL(ctx, url, maxModuleBytes)
+	}
+
+	if ws.moduleDir == "" {
+		return nil, fmt.Errorf("module %s is a local path but no module dir is configured", path)
+	}
+
+	root, err := os.OpenRoot(ws.moduleDir)
+	if err != nil {
+		return nil, err
+	}
+	defer root.Close()
+
+	f, err := root.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open module: %w", err)
+	}
+	defer f.Close()
+
+	b, err := io.ReadAll(io.LimitReader(f, maxModuleBytes+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(b) > maxModuleBytes {
+		return nil, fmt.Errorf("module %s is larger than %d bytes", path, maxModuleBytes)
+	}
+
+	return b, nil
+}
+
+// Close frees the compiled code cache.
+func (ws *WASIService) Close() error {
+	return ws.cache.Close(context.Background())
+}
@@ -0,0 +1,51 @@
+// guest is a WASI module for the executor tests, built with
+// GOOS=wasip1 GOARCH=wasm.
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+func main() {
+	switch os.Args[1] {
+	case "upper":
+		b, err := os.ReadFile(os.Args[2])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+
+		if err := os.WriteFile(os.Args[3], []byte(strings.ToUpper(string(b))), 0o644); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+
+		fmt.Println("mode=" + os.Getenv("mode"))
+
+	case "exit":
+		fmt.Println("failing")
+		os.Exit(3)
+
+	case "spin":
+		for {
+		}
+
+	case "alloc":
+		chunks := [][]byte{}
+		for range 64 {
+			chunks = append(chunks, make([]byte, 16<<20))
+		}
+		fmt.Println(len(chunks))
+
+	case "escape":
+		for _, path := range []string{"/etc/hostname", "/work/../../etc/hostname"} {
+			if _, err := os.ReadFile(path); err == nil {
+				fmt.Println("read " + path)
+			}
+		}
+
+		fmt.Println("home=" + os.Getenv("HOME"))
+	}
+}
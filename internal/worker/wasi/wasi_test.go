@@ -0,0 +1,190 @@
+package wasi
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/pupload/pupload/internal/worker/config"
+)
+
+var (
+	guestOnce   sync.Once
+	guestModule []byte
+	guestErr    error
+)
+
+// buildGuest compiles testdata/guest to WASI once per test binary.
+func buildGuest(t *testing.T) []byte {
+	t.Helper()
+
+	gobin, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go toolchain not available to build the test module")
+	}
+
+	guestOnce.Do(func() {
+		tmp, err := os.MkdirTemp("", "pupload-wasi-guest")
+		if err != nil {
+			guestErr = err
+			return
+		}
+		defer os.RemoveAll(tmp)
+
+		out := filepath.Join(tmp, "guest.wasm")
+
+		cmd := exec.Command(gobin, "build", "-o", out, ".")
+		cmd.Dir = filepath.Join("testdata", "guest")
+		cmd.Env = append(os.Environ(), "GOOS=wasip1", "GOARCH=wasm", "GOFLAGS=")
+		if b, err := cmd.CombinedOutput(); err != nil {
+			guestErr = errors.New(string(b))
+			return
+		}
+
+		guestModule, guestErr = os.ReadFile(out)
+	})
+
+	if guestErr != nil {
+		t.Fatalf("building test module: %v", guestErr)
+	}
+
+	return guestModule
+}
+
+func newTestWASIService(t *testing.T, timeout string) *WASIService {
+	t.Helper()
+
+	ws, err := CreateWASIService(config.WASISettings{WorkDir: t.TempDir(), TimeoutPerCPU: timeout})
+	if err != nil {
+		t.Fatalf("CreateWASIService() error = %v", err)
+	}
+	t.Cleanup(func() { ws.Close() })
+
+	return ws
+}
+
+func TestRun_PreopenedFiles(t *testing.T) {
+	module := buildGuest(t)
+	ws := newTestWASIService(t, "1m")
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "in.txt"), []byte("hello"), 0o644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	res, err := ws.Run(context.Background(), WASIConfig{
+		Module:      module,
+		Dir:         dir,
+		Args:        []string{"guest", "upper", GuestDir + "/in.txt", GuestDir + "/out.txt"},
+		Env:         map[string]string{"mode": "fast"},
+		MemoryBytes: 256 << 20,
+		CPUs:        1,
+	})
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	if res.ExitCode != 0 || !slices.Equal(res.Logs, []string{"mode=fast"}) {
+		t.Fatalf("unexpected result %+v", res)
+	}
+
+	if b, _ := os.ReadFile(filepath.Join(dir, "out.txt")); string(b) != "HELLO" {
+		t.Fatalf("unexpected output %q", b)
+	}
+}
+
+func TestRun_Sandboxed(t *testing.T) {
+	module := buildGuest(t)
+	ws := newTestWASIService(t, "1m")
+	t.Setenv("HOME", "/root")
+
+	res, err := ws.Run(context.Background(), WASIConfig{Module: module, Dir: t.TempDir(), Args: []string{"guest", "escape"}})
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	if !slices.Equal(res.Logs, []string{"home="}) {
+		t.Fatalf("module reached outside its sandbox: %q", res.Logs)
+	}
+}
+
+func TestRun_ExitCode(t *testing.T) {
+	module := buildGuest(t)
+	ws := newTestWASIService(t, "1m")
+
+	res, err := ws.Run(context.Background(), WASIConfig{Module: module, Dir: t.TempDir(), Args: []string{"guest", "exit"}})
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	if res.ExitCode != 3 || !slices.Equal(res.Logs, []string{"failing"}) {
+		t.Fatalf("unexpected result %+v", res)
+	}
+}
+
+func TestRun_Timeout(t *testing.T) {
+	module := buildGuest(t)
+	ws := newTestWASIService(t, "200ms")
+
+	_, err := ws.Run(context.Background(), WASIConfig{Module: module, Dir: t.TempDir(), Args: []string{"guest", "spin"}, CPUs: 1})
+	if !errors.Is(err, ErrTimeout) {
+		t.Fatalf("expected ErrTimeout, got %v", err)
+	}
+}
+
+func TestRun_MemoryLimit(t *testing.T) {
+	module := buildGuest(t)
+	ws := newTestWASIService(t, "1m")
+
+	res, err := ws.Run(context.Background(), WASIConfig{Module: module, Dir: t.TempDir(), Args: []string{"guest", "alloc"}, MemoryBytes: 128 << 20})
+	if err == nil && res.ExitCode == 0 {
+		t.Fatalf("expected allocating 1GiB to fail under a 128MiB limit: %+v", res)
+	}
+}
+
+func TestLoadModule_StaysInModuleDir(t *testing.T) {
+	root := t.TempDir()
+	moduleDir := filepath.Join(root, "modules")
+	os.MkdirAll(filepath.Join(moduleDir, "acme"), 0o755)
+	os.WriteFile(filepath.Join(moduleDir, "acme", "rename.wasm"), []byte("\x00asm"), 0o644)
+	os.WriteFile(filepath.Join(root, "secret.wasm"), []byte("secret"), 0o644)
+
+	ws, err := CreateWASIService(config.WASISettings{WorkDir: t.TempDir(), ModuleDir: moduleDir})
+	if err != nil {
+		t.Fatalf("CreateWASIService() error = %v", err)
+	}
+	defer ws.Close()
+
+	if b, err := ws.LoadModule(context.Background(), "acme/rename.wasm", ""); err != nil || string(b) != "\x00asm" {
+		t.Fatalf("LoadModule() = %q, %v", b, err)
+	}
+
+	for _, path := range []string{"../secret.wasm", "/etc/hostname"} {
+		if _, err := ws.LoadModule(context.Background(), path, ""); err == nil {
+			t.Fatalf("expected %s to be rejected", path)
+		}
+	}
+}
+
+func TestMemoryPages(t *testing.T) {
+	tests := []struct {
+		bytes int64
+		want  uint32
+	}{
+		{0, maxWasmPages},
+		{1, 1},
+		{128 << 20, 2048},
+		{64 << 30, maxWasmPages},
+	}
+
+	for _, tt := range tests {
+		if got := memoryPages(tt.bytes); got != tt.want {
+			t.Fatalf("memoryPages(%d) = %d, want %d", tt.bytes, got, tt.want)
+		}
+	}
+}
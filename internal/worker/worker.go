@@ -2,17 +2,24 @@ package worker
 
 import (
 	"context"
+	"fmt"
 	"io"
 	"log"
 	"log/slog"
 
+	"github.com/pupload/pupload/internal/imagepolicy"
 	"github.com/pupload/pupload/internal/logging"
+	"github.com/pupload/pupload/internal/models"
 	"github.com/pupload/pupload/internal/resources"
+	"github.com/pupload/pupload/internal/secrets"
 	"github.com/pupload/pupload/internal/syncplane"
 	"github.com/pupload/pupload/internal/telemetry"
 	"github.com/pupload/pupload/internal/worker/config"
 	"github.com/pupload/pupload/internal/worker/container"
+	"github.com/pupload/pupload/internal/worker/node"
+	"github.com/pupload/pupload/internal/worker/process"
 	"github.com/pupload/pupload/internal/worker/server"
+	"github.com/pupload/pupload/internal/worker/wasi"
 )
 
 func Run() error {
@@ -34,20 +41,32 @@ func RunWithConfig(ctx context.Context, cfg *config.WorkerConfig) error {
 
 	telemetry.Init(cfg.Telemetry, "pupload.worker")
 
-	s, err := syncplane.CreateWorkerSyncLayer(cfg.SyncPlane, cfg.Resources)
+	log.Info("Worker starting up...")
+	ex, err := createExecutors(cfg)
 	if err != nil {
 		return err
 	}
 
-	log.Info("Worker starting up...")
-	cs := container.CreateContainerService()
+	s, err := syncplane.CreateWorkerSyncLayer(cfg.SyncPlane, cfg.Resources)
+	if err != nil {
+		return err
+	}
 
 	rm, err := resources.CreateResourceManager(cfg.Resources)
 	if err != nil {
 		return err
 	}
 
-	server.NewWorkerServer(s, &cs, rm)
+	if err := syncTiers(ctx, s, rm, cfg.Resources.Tiers); err != nil {
+		return err
+	}
+
+	resolver, err := secrets.New(cfg.Secrets)
+	if err != nil {
+		return err
+	}
+
+	server.NewWorkerServer(ctx, s, ex, rm, imagepolicy.New(cfg.Security.ImagePolicy()), resolver, cfg.Worker)
 
 	<-ctx.Done()
 
@@ -70,19 +89,31 @@ func RunWithConfigSilent(ctx context.Context, cfg *config.WorkerConfig) error {
 
 	telemetry.Init(cfg.Telemetry, "pupload.worker")
 
-	s, err := syncplane.CreateWorkerSyncLayer(cfg.SyncPlane, cfg.Resources)
+	ex, err := createExecutors(cfg)
 	if err != nil {
 		return err
 	}
 
-	cs := container.CreateContainerService()
+	s, err := syncplane.CreateWorkerSyncLayer(cfg.SyncPlane, cfg.Resources)
+	if err != nil {
+		return err
+	}
 
 	rm, err := resources.CreateResourceManager(cfg.Resources)
 	if err != nil {
 		return err
 	}
 
-	server.NewWorkerServer(s, &cs, rm)
+	if err := syncTiers(ctx, s, rm, cfg.Resources.Tiers); err != nil {
+		return err
+	}
+
+	resolver, err := secrets.New(cfg.Secrets)
+	if err != nil {
+		return err
+	}
+
+	server.NewWorkerServer(ctx, s, ex, rm, imagepolicy.New(cfg.Security.ImagePolicy()), resolver, cfg.Worker)
 
 	<-ctx.Done()
 
@@ -91,3 +122,74 @@ func RunWithConfigSilent(ctx context.Context, cfg *config.WorkerConfig) error {
 	return s.Close()
 
 }
+
+// createExecutors starts the services behind the executors the worker allows.
+// The container engine is only required when the container executor is
+// allowed. Storage capacity is measured where the first of them keeps its
+// data unless configured.
+func createExecutors(cfg *config.WorkerConfig) (node.Executors, error) {
+	var ex node.Executors
+
+	for _, name := range cfg.Runtime.Executors {
+		var dataDir string
+
+		switch name {
+		case models.ExecutorContainer:
+			cs, err := container.CreateContainerService(cfg.Runtime, cfg.Security.Sandbox)
+			if err != nil {
+				return ex, err
+			}
+
+			ex.Container = &cs
+			dataDir = cs.DataRoot
+
+		case models.ExecutorProcess:
+			ps, err := process.CreateProcessService(cfg.Runtime.Process)
+			if err != nil {
+				return ex, err
+			}
+
+			ex.Process = ps
+			dataDir = ps.WorkDir
+
+		case models.ExecutorWASI:
+			ws, err := wasi.CreateWASIService(cfg.Runtime.WASI)
+			if err != nil {
+				return ex, err
+			}
+
+			ex.WASI = ws
+			dataDir = ws.WorkDir
+
+		default:
+			return ex, fmt.Errorf("unknown executor %q, expected one of %v", name, models.Executors)
+		}
+
+		if cfg.Resources.StoragePath == "" {
+			cfg.Resources.StoragePath = dataDir
+		}
+	}
+
+	if ex == (node.Executors{}) {
+		return ex, fmt.Errorf("no executors allowed, set runtime.executors")
+	}
+
+	return ex, nil
+}
+
+// syncTiers shares this worker's custom tiers and adopts the registry's, so
+// the worker serves every tier the controller may schedule.
+func syncTiers(ctx context.Context, s syncplane.SyncLayer, rm *resources.ResourceManager, local map[string]resources.ResourceDefinition) error {
+	if err := s.PublishTiers(ctx, local); err != nil {
+		return err
+	}
+
+	tiers, err := syncplane.SharedTiers(ctx, s, local)
+	if err != nil {
+		return fmt.Errorf("unable to load shared tiers: %w", err)
+	}
+
+	rm.SetTiers(tiers)
+
+	return nil
+}